@@ -0,0 +1,140 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgtype/pgxtype"
+	"go-spend/db"
+	"go-spend/expenses"
+	"time"
+)
+
+// recentSignInDuration is how long after signing in a session can delete the account without the password
+const recentSignInDuration = 5 * time.Minute
+
+var ErrReauthenticationRequired = errors.New("password or a recent sign in is required")
+
+// AccountService lets users take their personal data with them and leave
+type AccountService interface {
+	// Export returns personal data of the user
+	Export(ctx context.Context, userID uint) (expenses.AccountExport, error)
+	// Delete anonymises the user from the context if the password is correct or, without the password, if the
+	// session has just signed in
+	Delete(ctx context.Context, userContext UserContext, password expenses.Password) error
+}
+
+// DefaultAccountService never deletes users, as expenses of a user are part of balances of other members of the
+// group. A deleted user becomes an ex-member placeholder that nobody can log in as or claim.
+type DefaultAccountService struct {
+	db                db.TxQuerier
+	accountRepository expenses.AccountRepository
+	balanceRepository expenses.BalanceRepository
+	passwordEncoder   PasswordEncoderChecker
+	profileRepository expenses.ProfileRepository
+	sessionRemover    SessionRemover
+	sessionStorage    SessionStorage
+	userRepository    expenses.UserRepository
+	now               func() time.Time
+}
+
+// NewDefaultAccountService creates new instance of DefaultAccountService
+func NewDefaultAccountService(
+	db db.TxQuerier,
+	accountRepository expenses.AccountRepository,
+	balanceRepository expenses.BalanceRepository,
+	passwordEncoder PasswordEncoderChecker,
+	profileRepository expenses.ProfileRepository,
+	sessionRemover SessionRemover,
+	sessionStorage SessionStorage,
+	userRepository expenses.UserRepository,
+) *DefaultAccountService {
+	return &DefaultAccountService{
+		db:                db,
+		accountRepository: accountRepository,
+		balanceRepository: balanceRepository,
+		passwordEncoder:   passwordEncoder,
+		profileRepository: profileRepository,
+		sessionRemover:    sessionRemover,
+		sessionStorage:    sessionStorage,
+		userRepository:    userRepository,
+		now:               time.Now,
+	}
+}
+
+// Export reads balance from the storage and not from the cache, so that the export is consistent with expenses.
+// Returns expenses.ErrUserNotFound if there is no such user.
+func (s *DefaultAccountService) Export(ctx context.Context, userID uint) (expenses.AccountExport, error) {
+	profile, err := s.profileRepository.Find(ctx, s.db, userID)
+	if err != nil {
+		return expenses.AccountExport{}, err
+	}
+	allExpenses, err := s.accountRepository.FindExpenses(ctx, s.db, userID)
+	if err != nil {
+		return expenses.AccountExport{}, err
+	}
+	balance, err := s.balanceRepository.Get(ctx, s.db, userID)
+	if err != nil {
+		return expenses.AccountExport{}, err
+	}
+	export := expenses.AccountExport{
+		ExportedAt:  s.now().UTC(),
+		Profile:     profile,
+		Expenses:    make([]expenses.ExpenseResponse, 0),
+		Shares:      make([]expenses.ExpenseResponse, 0),
+		Settlements: balance,
+	}
+	for _, expense := range allExpenses {
+		if expense.UserID == userID {
+			export.Expenses = append(export.Expenses, expense)
+		} else {
+			export.Shares = append(export.Shares, expense)
+		}
+	}
+	return export, nil
+}
+
+// Delete revokes all sessions of the user after it was anonymised. Returns ErrCurrentPasswordIncorrect if the
+// password doesn't match. An empty password is accepted only within 5 minutes after the session has signed in,
+// so that users provisioned by an identity provider, who don't know their random password, sign in there again
+// instead. ErrReauthenticationRequired is returned for older sessions.
+func (s *DefaultAccountService) Delete(
+	ctx context.Context,
+	userContext UserContext,
+	password expenses.Password,
+) error {
+	user, err := s.userRepository.FindById(ctx, s.db, userContext.UserID)
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		if err = s.checkRecentSignIn(userContext); err != nil {
+			return err
+		}
+	} else if !s.passwordEncoder.Check(string(user.Password), string(password)) {
+		return ErrCurrentPasswordIncorrect
+	}
+	err = db.WithTx(ctx, s.db, func(tx pgxtype.Querier) error {
+		return s.accountRepository.Anonymise(ctx, tx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	return s.sessionRemover.RemoveAllSessions(user.ID)
+}
+
+// checkRecentSignIn looks for the session of the user context, personal access tokens don't have one
+func (s *DefaultAccountService) checkRecentSignIn(userContext UserContext) error {
+	if userContext.SessionID == "" {
+		return ErrReauthenticationRequired
+	}
+	sessions, err := s.sessionStorage.FindSessions(userContext.UserID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == userContext.SessionID && s.now().Sub(session.CreatedAt) <= recentSignInDuration {
+			return nil
+		}
+	}
+	return ErrReauthenticationRequired
+}
@@ -0,0 +1,310 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/db"
+	"go-spend/expenses"
+	"testing"
+	"time"
+)
+
+type mockAccountRepository struct {
+	mock.Mock
+}
+
+func (m *mockAccountRepository) FindExpenses(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+) ([]expenses.ExpenseResponse, error) {
+	args := m.Called(ctx, db, userID)
+	return args.Get(0).([]expenses.ExpenseResponse), args.Error(1)
+}
+
+func (m *mockAccountRepository) Anonymise(ctx context.Context, db pgxtype.Querier, userID uint) error {
+	args := m.Called(ctx, db, userID)
+	return args.Error(0)
+}
+
+type mockBalanceRepository struct {
+	mock.Mock
+}
+
+func (m *mockBalanceRepository) Get(ctx context.Context, db db.TxQuerier, userID uint) (expenses.Balance, error) {
+	args := m.Called(ctx, db, userID)
+	return args.Get(0).(expenses.Balance), args.Error(1)
+}
+
+type mockProfileRepository struct {
+	mock.Mock
+}
+
+func (m *mockProfileRepository) Find(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+) (expenses.ProfileResponse, error) {
+	args := m.Called(ctx, db, userID)
+	return args.Get(0).(expenses.ProfileResponse), args.Error(1)
+}
+
+func (m *mockProfileRepository) Update(
+	_ context.Context,
+	_ pgxtype.Querier,
+	_ uint,
+	_ expenses.UpdateProfileRequest,
+) (expenses.ProfileResponse, error) {
+	panic("implement me")
+}
+
+type accountServiceMocks struct {
+	db                *mockQuerier
+	accountRepository *mockAccountRepository
+	balanceRepository *mockBalanceRepository
+	profileRepository *mockProfileRepository
+	sessionRemover    *mockTokenRepository
+	sessionStorage    *mockTokenRepository
+	userRepository    *mockUserRepository
+}
+
+func newTestAccountService() (*authentication.DefaultAccountService, accountServiceMocks) {
+	mocks := accountServiceMocks{
+		db:                new(mockQuerier),
+		accountRepository: new(mockAccountRepository),
+		balanceRepository: new(mockBalanceRepository),
+		profileRepository: new(mockProfileRepository),
+		sessionRemover:    new(mockTokenRepository),
+		sessionStorage:    new(mockTokenRepository),
+		userRepository:    new(mockUserRepository),
+	}
+	service := authentication.NewDefaultAccountService(
+		mocks.db,
+		mocks.accountRepository,
+		mocks.balanceRepository,
+		simplePasswordChecker,
+		mocks.profileRepository,
+		mocks.sessionRemover,
+		mocks.sessionStorage,
+		mocks.userRepository,
+	)
+	return service, mocks
+}
+
+func TestAccountExport(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestAccountService()
+	profile := expenses.ProfileResponse{ID: 1, Email: "user@mail.com", DisplayName: "Alice"}
+	paid := expenses.ExpenseResponse{UserID: 1, Amount: 10, Shares: expenses.ExpenseShares{1: 50, 2: 50}}
+	shared := expenses.ExpenseResponse{UserID: 2, Amount: 20, Shares: expenses.ExpenseShares{1: 30, 2: 70}}
+	balance := expenses.Balance{2: -1}
+	mocks.profileRepository.On("Find", ctx, mocks.db, uint(1)).Return(profile, nil)
+	mocks.accountRepository.On("FindExpenses", ctx, mocks.db, uint(1)).
+		Return([]expenses.ExpenseResponse{paid, shared}, nil)
+	mocks.balanceRepository.On("Get", ctx, mocks.db, uint(1)).Return(balance, nil)
+
+	// when
+	export, err := service.Export(ctx, 1)
+
+	// then
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), export.ExportedAt, time.Minute)
+	assert.Equal(t, profile, export.Profile)
+	assert.Equal(t, []expenses.ExpenseResponse{paid}, export.Expenses)
+	assert.Equal(t, []expenses.ExpenseResponse{shared}, export.Shares)
+	assert.Equal(t, balance, export.Settlements)
+}
+
+func TestAccountExportErrors(t *testing.T) {
+	ctx := context.Background()
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name         string
+		expectedErr  error
+		prepareMocks func(mocks accountServiceMocks)
+	}{
+		{
+			name:        "user not found",
+			expectedErr: expenses.ErrUserNotFound,
+			prepareMocks: func(mocks accountServiceMocks) {
+				mocks.profileRepository.On("Find", ctx, mocks.db, uint(1)).
+					Return(expenses.ProfileResponse{}, expenses.ErrUserNotFound)
+			},
+		},
+		{
+			name:        "expenses error",
+			expectedErr: expectedErr,
+			prepareMocks: func(mocks accountServiceMocks) {
+				mocks.profileRepository.On("Find", ctx, mocks.db, uint(1)).Return(expenses.ProfileResponse{ID: 1}, nil)
+				mocks.accountRepository.On("FindExpenses", ctx, mocks.db, uint(1)).
+					Return([]expenses.ExpenseResponse(nil), expectedErr)
+			},
+		},
+		{
+			name:        "balance error",
+			expectedErr: expectedErr,
+			prepareMocks: func(mocks accountServiceMocks) {
+				mocks.profileRepository.On("Find", ctx, mocks.db, uint(1)).Return(expenses.ProfileResponse{ID: 1}, nil)
+				mocks.accountRepository.On("FindExpenses", ctx, mocks.db, uint(1)).
+					Return([]expenses.ExpenseResponse{}, nil)
+				mocks.balanceRepository.On("Get", ctx, mocks.db, uint(1)).Return(expenses.Balance(nil), expectedErr)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			service, mocks := newTestAccountService()
+			test.prepareMocks(mocks)
+
+			// when
+			export, err := service.Export(ctx, 1)
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+			assert.Zero(t, export)
+		})
+	}
+}
+
+func TestAccountDelete(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestAccountService()
+	tx := new(mockTx)
+	mocks.userRepository.On("FindById", ctx, mocks.db, uint(1)).
+		Return(expenses.User{ID: 1, Email: "user@mail.com", Password: "password"}, nil)
+	mocks.db.On("Begin", ctx).Return(tx, nil)
+	tx.On("Commit", ctx).Return(nil)
+	mocks.accountRepository.On("Anonymise", ctx, tx, uint(1)).Return(nil)
+	mocks.sessionRemover.On("RemoveAllSessions", uint(1)).Return(nil)
+
+	// when
+	err := service.Delete(ctx, authentication.UserContext{UserID: 1, SessionID: "session"}, "password")
+
+	// then
+	require.NoError(t, err)
+	tx.AssertExpectations(t)
+	mocks.accountRepository.AssertExpectations(t)
+	mocks.sessionRemover.AssertExpectations(t)
+}
+
+func TestAccountDeleteWithIncorrectPassword(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestAccountService()
+	mocks.userRepository.On("FindById", ctx, mocks.db, uint(1)).
+		Return(expenses.User{ID: 1, Email: "user@mail.com", Password: "password"}, nil)
+
+	// when
+	err := service.Delete(ctx, authentication.UserContext{UserID: 1}, "incorrect")
+
+	// then
+	assert.Equal(t, authentication.ErrCurrentPasswordIncorrect, err)
+	mocks.accountRepository.AssertNotCalled(t, "Anonymise", mock.Anything, mock.Anything, mock.Anything)
+	mocks.sessionRemover.AssertNotCalled(t, "RemoveAllSessions", mock.Anything)
+}
+
+func TestAccountDeleteWithoutPasswordAfterRecentSignIn(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestAccountService()
+	tx := new(mockTx)
+	mocks.userRepository.On("FindById", ctx, mocks.db, uint(1)).
+		Return(expenses.User{ID: 1, Email: "user@mail.com", Password: "unknown"}, nil)
+	mocks.sessionStorage.On("FindSessions", uint(1)).Return([]authentication.Session{
+		{ID: "old", CreatedAt: time.Now().Add(-time.Hour)},
+		{ID: "session", CreatedAt: time.Now().Add(-time.Minute)},
+	}, nil)
+	mocks.db.On("Begin", ctx).Return(tx, nil)
+	tx.On("Commit", ctx).Return(nil)
+	mocks.accountRepository.On("Anonymise", ctx, tx, uint(1)).Return(nil)
+	mocks.sessionRemover.On("RemoveAllSessions", uint(1)).Return(nil)
+
+	// when
+	err := service.Delete(ctx, authentication.UserContext{UserID: 1, SessionID: "session"}, "")
+
+	// then
+	require.NoError(t, err)
+	mocks.accountRepository.AssertExpectations(t)
+	mocks.sessionRemover.AssertExpectations(t)
+}
+
+func TestAccountDeleteWithoutPasswordRequiresRecentSignIn(t *testing.T) {
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name        string
+		sessionID   string
+		sessions    []authentication.Session
+		findErr     error
+		expectedErr error
+	}{
+		{
+			name:        "old session",
+			sessionID:   "session",
+			sessions:    []authentication.Session{{ID: "session", CreatedAt: time.Now().Add(-10 * time.Minute)}},
+			expectedErr: authentication.ErrReauthenticationRequired,
+		},
+		{
+			name:        "recent sign in of another session",
+			sessionID:   "session",
+			sessions:    []authentication.Session{{ID: "other", CreatedAt: time.Now()}},
+			expectedErr: authentication.ErrReauthenticationRequired,
+		},
+		{
+			name:        "personal access token",
+			expectedErr: authentication.ErrReauthenticationRequired,
+		},
+		{
+			name:        "sessions can't be found",
+			sessionID:   "session",
+			sessions:    []authentication.Session{},
+			findErr:     expectedErr,
+			expectedErr: expectedErr,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			service, mocks := newTestAccountService()
+			mocks.userRepository.On("FindById", ctx, mocks.db, uint(1)).
+				Return(expenses.User{ID: 1, Email: "user@mail.com", Password: "password"}, nil)
+			mocks.sessionStorage.On("FindSessions", uint(1)).Return(test.sessions, test.findErr)
+
+			// when
+			err := service.Delete(ctx, authentication.UserContext{UserID: 1, SessionID: test.sessionID}, "")
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+			mocks.accountRepository.AssertNotCalled(t, "Anonymise", mock.Anything, mock.Anything, mock.Anything)
+			mocks.sessionRemover.AssertNotCalled(t, "RemoveAllSessions", mock.Anything)
+		})
+	}
+}
+
+func TestAccountDeleteKeepsSessionsIfAnonymisingFailed(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestAccountService()
+	tx := new(mockTx)
+	expectedErr := errors.New("expected")
+	mocks.userRepository.On("FindById", ctx, mocks.db, uint(1)).
+		Return(expenses.User{ID: 1, Email: "user@mail.com", Password: "password"}, nil)
+	mocks.db.On("Begin", ctx).Return(tx, nil)
+	mocks.accountRepository.On("Anonymise", ctx, tx, uint(1)).Return(expectedErr)
+
+	// when
+	err := service.Delete(ctx, authentication.UserContext{UserID: 1}, "password")
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	tx.AssertNotCalled(t, "Commit", mock.Anything)
+	mocks.sessionRemover.AssertNotCalled(t, "RemoveAllSessions", mock.Anything)
+}
@@ -0,0 +1,122 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+const argon2idID = "argon2id"
+
+var (
+	ErrIncorrectArgon2idParams = errors.New("argon2id parameters are not valid")
+	errNotArgon2idHash         = errors.New("hash is not an argon2id hash in PHC format")
+)
+
+// Argon2idParams are the cost parameters of Argon2id. Memory is in KiB.
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams is the second recommended option of RFC 9106 for systems with less memory
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Argon2idPasswordEncoder is a PasswordEncoderChecker that uses Argon2id algorithm. Hashes are stored in PHC string
+// format, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>, so that they are checked with the parameters they were
+// created with even after the parameters are changed.
+type Argon2idPasswordEncoder struct {
+	params Argon2idParams
+}
+
+// NewArgon2idPasswordEncoder creates new instance of Argon2idPasswordEncoder. Returns ErrIncorrectArgon2idParams if
+// any of the parameters is zero or if there is less than 8 KiB of memory per thread.
+func NewArgon2idPasswordEncoder(params Argon2idParams) (*Argon2idPasswordEncoder, error) {
+	if params.Iterations == 0 || params.Parallelism == 0 || params.SaltLength == 0 || params.KeyLength == 0 ||
+		params.Memory < 8*uint32(params.Parallelism) {
+		return nil, ErrIncorrectArgon2idParams
+	}
+	return &Argon2idPasswordEncoder{params: params}, nil
+}
+
+func (e *Argon2idPasswordEncoder) Encode(password string) (string, error) {
+	salt := make([]byte, e.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(
+		[]byte(password),
+		salt,
+		e.params.Iterations,
+		e.params.Memory,
+		e.params.Parallelism,
+		e.params.KeyLength,
+	)
+	return fmt.Sprintf(
+		"$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idID,
+		argon2.Version,
+		e.params.Memory,
+		e.params.Iterations,
+		e.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Check returns false for hashes that are not argon2id hashes in PHC format
+func (e *Argon2idPasswordEncoder) Check(hashed string, toTest string) bool {
+	params, salt, key, err := parseArgon2idHash(hashed)
+	if err != nil {
+		return false
+	}
+	actual := argon2.IDKey([]byte(toTest), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, actual) == 1
+}
+
+// NeedsRehash returns true if the hash was created by another algorithm or with other parameters
+func (e *Argon2idPasswordEncoder) NeedsRehash(hashed string) bool {
+	params, _, _, err := parseArgon2idHash(hashed)
+	return err != nil || params != e.params
+}
+
+// parseArgon2idHash returns parameters, salt and key of the hash
+func parseArgon2idHash(hashed string) (Argon2idParams, []byte, []byte, error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != argon2idID {
+		return Argon2idParams{}, nil, nil, errNotArgon2idHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, errNotArgon2idHash
+	}
+	var params Argon2idParams
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return Argon2idParams{}, nil, nil, errNotArgon2idHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, errNotArgon2idHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idParams{}, nil, nil, errNotArgon2idHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"go-spend/expenses"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // AuthRequest represents JSON body of authentication request
@@ -36,3 +39,301 @@ func (a *AuthRequest) UnmarshalJSON(data []byte) error {
 	a.Password, err = expenses.ValidPassword(auth.Password)
 	return err
 }
+
+// RefreshRequest represents JSON body of token refresh request
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (r *RefreshRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type refreshRequest struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	var refresh refreshRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&refresh); err != nil {
+		return err
+	}
+	if refresh.RefreshToken == "" {
+		return ErrInvalidRefreshToken
+	}
+	r.RefreshToken = refresh.RefreshToken
+	return nil
+}
+
+// ForgotPasswordRequest represents JSON body of a request to send a password reset link
+type ForgotPasswordRequest struct {
+	Email expenses.Email `json:"email"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (f *ForgotPasswordRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type forgotPasswordRequest struct {
+		Email string `json:"email"`
+	}
+	var forgot forgotPasswordRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var err error
+	if err = decoder.Decode(&forgot); err != nil {
+		return err
+	}
+	f.Email, err = expenses.ValidEmail(forgot.Email)
+	return err
+}
+
+// ResendVerificationRequest represents JSON body of a request to send a new email verification link
+type ResendVerificationRequest struct {
+	Email expenses.Email `json:"email"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (v *ResendVerificationRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type resendVerificationRequest struct {
+		Email string `json:"email"`
+	}
+	var resend resendVerificationRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var err error
+	if err = decoder.Decode(&resend); err != nil {
+		return err
+	}
+	v.Email, err = expenses.ValidEmail(resend.Email)
+	return err
+}
+
+// ResetPasswordRequest represents JSON body of a request to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string            `json:"token"`
+	Password expenses.Password `json:"password"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (r *ResetPasswordRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type resetPasswordRequest struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	var reset resetPasswordRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var err error
+	if err = decoder.Decode(&reset); err != nil {
+		return err
+	}
+	if reset.Token == "" {
+		return ErrInvalidResetToken
+	}
+	r.Token = reset.Token
+	r.Password, err = expenses.ValidPassword(reset.Password)
+	return err
+}
+
+// ChangePasswordRequest represents JSON body of a request to replace the password of the current user
+type ChangePasswordRequest struct {
+	CurrentPassword expenses.Password `json:"currentPassword"`
+	NewPassword     expenses.Password `json:"newPassword"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (c *ChangePasswordRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type changePasswordRequest struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	var change changePasswordRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var err error
+	if err = decoder.Decode(&change); err != nil {
+		return err
+	}
+	if c.CurrentPassword, err = expenses.ValidPassword(change.CurrentPassword); err != nil {
+		return err
+	}
+	c.NewPassword, err = expenses.ValidPassword(change.NewPassword)
+	return err
+}
+
+// TwoFactorAuthRequest represents JSON body of the second step of authentication of users with two-factor
+// authentication. Code is either a TOTP code or a recovery code.
+type TwoFactorAuthRequest struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (t *TwoFactorAuthRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type twoFactorAuthRequest struct {
+		MFAToken string `json:"mfaToken"`
+		Code     string `json:"code"`
+	}
+	var auth twoFactorAuthRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&auth); err != nil {
+		return err
+	}
+	if auth.MFAToken == "" {
+		return ErrInvalidMFAToken
+	}
+	if auth.Code == "" {
+		return ErrTwoFactorCodeIncorrect
+	}
+	t.MFAToken = auth.MFAToken
+	t.Code = auth.Code
+	return nil
+}
+
+// TwoFactorCodeRequest represents JSON body of a request to confirm two-factor authentication with the first code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (t *TwoFactorCodeRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type twoFactorCodeRequest struct {
+		Code string `json:"code"`
+	}
+	var code twoFactorCodeRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&code); err != nil {
+		return err
+	}
+	if code.Code == "" {
+		return ErrTwoFactorCodeIncorrect
+	}
+	t.Code = code.Code
+	return nil
+}
+
+// DisableTwoFactorRequest represents JSON body of a request to disable two-factor authentication of the current user
+type DisableTwoFactorRequest struct {
+	Password expenses.Password `json:"password"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (d *DisableTwoFactorRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type disableTwoFactorRequest struct {
+		Password string `json:"password"`
+	}
+	var disable disableTwoFactorRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	var err error
+	if err = decoder.Decode(&disable); err != nil {
+		return err
+	}
+	d.Password, err = expenses.ValidPassword(disable.Password)
+	return err
+}
+
+// DeleteAccountRequest represents JSON body of a request to delete the account of the current user. Password can be
+// omitted right after signing in, see AccountService.
+type DeleteAccountRequest struct {
+	Password expenses.Password `json:"password"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (d *DeleteAccountRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type deleteAccountRequest struct {
+		Password string `json:"password"`
+	}
+	var deleteRequest deleteAccountRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&deleteRequest); err != nil {
+		return err
+	}
+	d.Password = expenses.Password(deleteRequest.Password)
+	return nil
+}
+
+// CreateAccessTokenRequest represents JSON body of a request to create a personal access token. Tokens without
+// ExpiresAt live until they are revoked.
+type CreateAccessTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// UnmarshalJSON performs unmarshalling and check of provided properties
+func (c *CreateAccessTokenRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type createAccessTokenRequest struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	var create createAccessTokenRequest
+	reader := bytes.NewReader(data)
+	decoder := json.NewDecoder(reader)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&create); err != nil {
+		return err
+	}
+	name := strings.TrimSpace(create.Name)
+	if name == "" || utf8.RuneCountInString(name) > 100 {
+		return ErrAccessTokenNameIncorrect
+	}
+	if len(create.Scopes) == 0 {
+		return ErrUnknownScope
+	}
+	scopes := make([]string, 0, len(create.Scopes))
+	seen := make(map[string]bool, len(create.Scopes))
+	for _, scope := range create.Scopes {
+		if !knownScopes[scope] {
+			return ErrUnknownScope
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	c.Name = name
+	c.Scopes = scopes
+	c.ExpiresAt = create.ExpiresAt
+	return nil
+}
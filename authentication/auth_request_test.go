@@ -6,7 +6,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"go-spend/authentication"
 	"go-spend/expenses"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAuthRequestUnmarshalJSON(t *testing.T) {
@@ -56,3 +58,332 @@ func TestAuthRequestUnmarshalJSONErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestForgotPasswordRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.ForgotPasswordRequest
+	err := json.Unmarshal([]byte(`{"email": "mail@mail.com"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Email("mail@mail.com"), req.Email)
+}
+
+func TestForgotPasswordRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"email": "mail@mail.com", "password": "password"}`,
+		},
+		{
+			name: "no email field",
+			json: `{}`,
+		},
+		{
+			name: "incorrect email",
+			json: `{"email": "somemail"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.ForgotPasswordRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestResendVerificationRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.ResendVerificationRequest
+	err := json.Unmarshal([]byte(`{"email": "mail@mail.com"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Email("mail@mail.com"), req.Email)
+}
+
+func TestResendVerificationRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"email": "mail@mail.com", "token": "token"}`,
+		},
+		{
+			name: "incorrect email",
+			json: `{"email": "somemail"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.ResendVerificationRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestResetPasswordRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.ResetPasswordRequest
+	err := json.Unmarshal([]byte(`{"token": "some-token", "password": "new"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, "some-token", req.Token)
+	assert.Equal(t, expenses.Password("new"), req.Password)
+}
+
+func TestResetPasswordRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"token": "some-token", "password": "new", "email": "mail@mail.com"}`,
+		},
+		{
+			name: "no token",
+			json: `{"password": "new"}`,
+		},
+		{
+			name: "no password",
+			json: `{"token": "some-token"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.ResetPasswordRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestChangePasswordRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.ChangePasswordRequest
+	err := json.Unmarshal([]byte(`{"currentPassword": "old", "newPassword": "new"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Password("old"), req.CurrentPassword)
+	assert.Equal(t, expenses.Password("new"), req.NewPassword)
+}
+
+func TestChangePasswordRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"currentPassword": "old", "newPassword": "new", "email": "mail@mail.com"}`,
+		},
+		{
+			name: "empty current password",
+			json: `{"currentPassword": "", "newPassword": "new"}`,
+		},
+		{
+			name: "no new password",
+			json: `{"currentPassword": "old"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.ChangePasswordRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestTwoFactorAuthRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.TwoFactorAuthRequest
+	err := json.Unmarshal([]byte(`{"mfaToken": "token", "code": "123456"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, authentication.TwoFactorAuthRequest{MFAToken: "token", Code: "123456"}, req)
+}
+
+func TestTwoFactorAuthRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		expectedErr error
+	}{
+		{
+			name:        "empty token",
+			json:        `{"mfaToken": "", "code": "123456"}`,
+			expectedErr: authentication.ErrInvalidMFAToken,
+		},
+		{
+			name:        "no code",
+			json:        `{"mfaToken": "token"}`,
+			expectedErr: authentication.ErrTwoFactorCodeIncorrect,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.TwoFactorAuthRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Equal(t, test.expectedErr, err)
+		})
+	}
+}
+
+func TestTwoFactorAuthRequestUnmarshalJSONUnexpectedFields(t *testing.T) {
+	var req authentication.TwoFactorAuthRequest
+	err := json.Unmarshal([]byte(`{"mfaToken": "token", "code": "123456", "email": "mail@mail.com"}`), &req)
+	require.Error(t, err)
+}
+
+func TestTwoFactorCodeRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.TwoFactorCodeRequest
+	err := json.Unmarshal([]byte(`{"code": "123456"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, "123456", req.Code)
+}
+
+func TestTwoFactorCodeRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"code": "123456", "secret": "SECRET"}`,
+		},
+		{
+			name: "empty code",
+			json: `{"code": ""}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.TwoFactorCodeRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDisableTwoFactorRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.DisableTwoFactorRequest
+	err := json.Unmarshal([]byte(`{"password": "password"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Password("password"), req.Password)
+}
+
+func TestDisableTwoFactorRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"password": "password", "code": "123456"}`,
+		},
+		{
+			name: "no password",
+			json: `{}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.DisableTwoFactorRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDeleteAccountRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.DeleteAccountRequest
+	err := json.Unmarshal([]byte(`{"password": "password"}`), &req)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Password("password"), req.Password)
+}
+
+func TestDeleteAccountRequestUnmarshalJSONWithoutPassword(t *testing.T) {
+	var req authentication.DeleteAccountRequest
+	err := json.Unmarshal([]byte(`{}`), &req)
+	require.NoError(t, err)
+	assert.Empty(t, req.Password)
+}
+
+func TestDeleteAccountRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"password": "password", "email": "user@mail.com"}`,
+		},
+		{
+			name: "password is not a string",
+			json: `{"password": 42}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.DeleteAccountRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestCreateAccessTokenRequestUnmarshalJSON(t *testing.T) {
+	var req authentication.CreateAccessTokenRequest
+	err := json.Unmarshal(
+		[]byte(`{"name": " script ", "scopes": ["read", "expenses:write", "read"], "expiresAt": "2030-10-01T12:00:00Z"}`),
+		&req,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "script", req.Name)
+	assert.Equal(t, []string{authentication.ScopeRead, authentication.ScopeExpensesWrite}, req.Scopes)
+	require.NotNil(t, req.ExpiresAt)
+	assert.True(t, time.Date(2030, 10, 1, 12, 0, 0, 0, time.UTC).Equal(*req.ExpiresAt))
+}
+
+func TestCreateAccessTokenRequestUnmarshalJSONWithoutExpiration(t *testing.T) {
+	var req authentication.CreateAccessTokenRequest
+	err := json.Unmarshal([]byte(`{"name": "script", "scopes": ["read"]}`), &req)
+	require.NoError(t, err)
+	assert.Nil(t, req.ExpiresAt)
+}
+
+func TestCreateAccessTokenRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"name": "script", "scopes": ["read"], "userId": 1}`,
+		},
+		{
+			name: "empty name",
+			json: `{"name": " ", "scopes": ["read"]}`,
+		},
+		{
+			name: "long name",
+			json: `{"name": "` + strings.Repeat("a", 101) + `", "scopes": ["read"]}`,
+		},
+		{
+			name: "no scopes",
+			json: `{"name": "script", "scopes": []}`,
+		},
+		{
+			name: "unknown scope",
+			json: `{"name": "script", "scopes": ["read", "admin"]}`,
+		},
+		{
+			name: "incorrect expiration",
+			json: `{"name": "script", "scopes": ["read"], "expiresAt": "tomorrow"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req authentication.CreateAccessTokenRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
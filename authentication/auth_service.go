@@ -5,6 +5,7 @@ import (
 	"errors"
 	"go-spend/db"
 	"go-spend/expenses"
+	"go-spend/log"
 )
 
 var (
@@ -22,32 +23,39 @@ type Authenticator interface {
 
 // AuthService is a default implementation of Authenticator
 type AuthService struct {
-	db              db.TxQuerier
-	tokenCreator    *TokenCreator
-	tokenSaver      TokenSaver
-	passwordChecker PasswordChecker
-	userRepository  expenses.UserRepository
+	db                 db.TxQuerier
+	sessionStarter     SessionStarter
+	passwordEncoder    RehashingPasswordEncoder
+	userRepository     expenses.UserRepository
+	twoFactor          TwoFactorChallenger
+	verificationPolicy VerificationPolicy
 }
 
-// NewAuthService creates new service to authenticate users and store their tokens for future calls
+// NewAuthService creates new service to authenticate users and start sessions for their future calls
 func NewAuthService(
 	db db.TxQuerier,
-	tokenCreator *TokenCreator,
-	tokenSaver TokenSaver,
-	passwordChecker PasswordChecker,
+	sessionStarter SessionStarter,
+	passwordEncoder RehashingPasswordEncoder,
 	userRepository expenses.UserRepository,
+	twoFactor TwoFactorChallenger,
+	verificationPolicy VerificationPolicy,
 ) *AuthService {
 	return &AuthService{
-		db:              db,
-		tokenCreator:    tokenCreator,
-		tokenSaver:      tokenSaver,
-		passwordChecker: passwordChecker,
-		userRepository:  userRepository,
+		db:                 db,
+		sessionStarter:     sessionStarter,
+		passwordEncoder:    passwordEncoder,
+		userRepository:     userRepository,
+		twoFactor:          twoFactor,
+		verificationPolicy: verificationPolicy,
 	}
 }
 
 // Authenticate performs user authentication. If user was not found or if password was incorrect -
-// ErrEmailOrPasswordIncorrect is returned.
+// ErrEmailOrPasswordIncorrect is returned. Every successful authentication starts a new session of the client
+// from the context, see WithClientInfo. ErrEmailNotVerified is returned for users with unverified emails if the
+// verification policy requires a verified email to authenticate. Outdated password hashes are replaced after
+// a successful check. Users with two-factor authentication get only an MFA token instead of a session, see
+// TwoFactorService.Verify.
 func (a *AuthService) Authenticate(
 	ctx context.Context,
 	email expenses.Email,
@@ -60,18 +68,37 @@ func (a *AuthService) Authenticate(
 		}
 		return TokenResponse{}, err
 	}
-	if ok := a.passwordChecker.Check(string(user.Password), string(password)); !ok {
+	if ok := a.passwordEncoder.Check(string(user.Password), string(password)); !ok {
 		return TokenResponse{}, ErrEmailOrPasswordIncorrect
 	}
-	tokenPair, err := a.tokenCreator.CreateTokenPair(user.ID, user.GroupID)
+	if a.passwordEncoder.NeedsRehash(string(user.Password)) {
+		a.rehashPassword(ctx, user.ID, password)
+	}
+	if !user.Verified && a.verificationPolicy.RequireForAuthentication {
+		return TokenResponse{}, ErrEmailNotVerified
+	}
+	mfaToken, err := a.twoFactor.Challenge(ctx, user.ID)
 	if err != nil {
 		return TokenResponse{}, err
 	}
-	if err = a.tokenSaver.Save(tokenPair, UserContext{UserID: user.ID, GroupID: user.GroupID}); err != nil {
-		return TokenResponse{}, err
+	if mfaToken != "" {
+		return TokenResponse{MFAToken: mfaToken}, nil
+	}
+	userContext := UserContext{UserID: user.ID, GroupID: user.GroupID}
+	return a.sessionStarter.Start(userContext, ExtractClientInfo(ctx))
+}
+
+// rehashPassword only logs a failure, the old hash still works and the next authentication will try again
+func (a *AuthService) rehashPassword(ctx context.Context, userID uint, password expenses.Password) {
+	encodedPassword, err := a.passwordEncoder.Encode(string(password))
+	if err != nil {
+		log.Warn("couldn't rehash password of user %d - %s", userID, err)
+		return
+	}
+	err = a.userRepository.UpdatePassword(ctx, a.db, userID, expenses.Password(encodedPassword))
+	if err != nil {
+		log.Warn("couldn't store rehashed password of user %d - %s", userID, err)
+		return
 	}
-	return TokenResponse{
-		AccessToken:  tokenPair.AccessToken.Encoded,
-		RefreshToken: tokenPair.RefreshToken.Encoded,
-	}, nil
+	log.Info("password of user %d was rehashed", userID)
 }
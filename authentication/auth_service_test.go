@@ -27,8 +27,9 @@ func (m *mockUserRepository) Create(
 	return args.Get(0).(expenses.User), args.Error(1)
 }
 
-func (m *mockUserRepository) FindById(_ context.Context, _ pgxtype.Querier, _ uint) (expenses.User, error) {
-	panic("implement me")
+func (m *mockUserRepository) FindById(ctx context.Context, db pgxtype.Querier, id uint) (expenses.User, error) {
+	args := m.Called(ctx, db, id)
+	return args.Get(0).(expenses.User), args.Error(1)
 }
 
 func (m *mockUserRepository) FindByEmail(
@@ -40,6 +41,56 @@ func (m *mockUserRepository) FindByEmail(
 	return args.Get(0).(expenses.User), args.Error(1)
 }
 
+func (m *mockUserRepository) CreatePlaceholder(
+	_ context.Context,
+	_ pgxtype.Querier,
+	_ string,
+	_ string,
+) (expenses.User, error) {
+	panic("implement me")
+}
+
+func (m *mockUserRepository) ClaimPlaceholder(
+	ctx context.Context,
+	db pgxtype.Querier,
+	req expenses.CreateUserRequest,
+) (expenses.User, error) {
+	args := m.Called(ctx, db, req)
+	return args.Get(0).(expenses.User), args.Error(1)
+}
+
+func (m *mockUserRepository) FindPlaceholderByClaimCode(
+	_ context.Context,
+	_ pgxtype.Querier,
+	_ string,
+) (expenses.User, error) {
+	panic("implement me")
+}
+
+func (m *mockUserRepository) MergePlaceholder(_ context.Context, _ pgxtype.Querier, _ uint, _ uint) error {
+	panic("implement me")
+}
+
+func (m *mockUserRepository) UpdatePassword(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	password expenses.Password,
+) error {
+	args := m.Called(ctx, db, userID, password)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) MarkVerified(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	email expenses.Email,
+) error {
+	args := m.Called(ctx, db, userID, email)
+	return args.Error(0)
+}
+
 type mockQuerier struct {
 	mock.Mock
 }
@@ -56,31 +107,58 @@ func (m *mockQuerier) QueryRow(_ context.Context, _ string, _ ...interface{}) pg
 	panic("implement me")
 }
 
-func (m *mockQuerier) Begin(_ context.Context) (pgx.Tx, error) {
-	panic("implement me")
+func (m *mockQuerier) Begin(ctx context.Context) (pgx.Tx, error) {
+	args := m.Called(ctx)
+	firstArg := args.Get(0)
+	if firstArg == nil {
+		return nil, args.Error(1)
+	}
+	return firstArg.(pgx.Tx), args.Error(1)
 }
 
-type mockTokeSaver struct {
+type mockSessionStarter struct {
 	mock.Mock
 }
 
-func (m *mockTokeSaver) Save(pair authentication.TokenPair, userContext authentication.UserContext) error {
-	args := m.Called(pair, userContext)
-	return args.Error(0)
+func (m *mockSessionStarter) Start(
+	userContext authentication.UserContext,
+	client authentication.ClientInfo,
+) (authentication.TokenResponse, error) {
+	args := m.Called(userContext, client)
+	return args.Get(0).(authentication.TokenResponse), args.Error(1)
+}
+
+type mockTwoFactorChallenger struct {
+	mock.Mock
+}
+
+func (m *mockTwoFactorChallenger) Challenge(ctx context.Context, userID uint) (string, error) {
+	args := m.Called(ctx, userID)
+	return args.String(0), args.Error(1)
+}
+
+// noTwoFactorChallenger is for users without two-factor authentication
+type noTwoFactorChallenger struct {
+}
+
+func (c noTwoFactorChallenger) Challenge(_ context.Context, _ uint) (string, error) {
+	return "", nil
 }
 
 var (
 	testTokenCreator      = authentication.NewTokenCreator(jwt.HmacSha256("acc"), jwt.HmacSha256("ref"))
 	simplePasswordChecker = &authentication.NoAcPasswordEncoder{}
+	noTwoFactor           = noTwoFactorChallenger{}
 )
 
 func TestNewAuthService(t *testing.T) {
 	auth := authentication.NewAuthService(
 		new(mockQuerier),
-		testTokenCreator,
-		new(mockTokeSaver),
+		new(mockSessionStarter),
 		simplePasswordChecker,
 		new(mockUserRepository),
+		noTwoFactor,
+		authentication.VerificationPolicy{},
 	)
 	require.NotNil(t, auth)
 }
@@ -89,13 +167,14 @@ func TestAuthReturnsTokensWhenCredsAreCorrect(t *testing.T) {
 	ctx := context.Background()
 	userRepository := new(mockUserRepository)
 	mockDB := new(mockQuerier)
-	mockSaver := new(mockTokeSaver)
+	mockStarter := new(mockSessionStarter)
 	auth := authentication.NewAuthService(
 		mockDB,
-		testTokenCreator,
-		mockSaver,
+		mockStarter,
 		simplePasswordChecker,
 		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
 	)
 
 	// given
@@ -103,8 +182,67 @@ func TestAuthReturnsTokensWhenCredsAreCorrect(t *testing.T) {
 	password := expenses.Password("password")
 	user := expenses.User{ID: 1, Email: email, Password: password}
 	userRepository.On("FindByEmail", ctx, mockDB, email).Return(user, nil)
-	mockSaver.On("Save", mock.Anything, authentication.UserContext{UserID: user.ID, GroupID: user.GroupID}).
-		Return(nil)
+	mockStarter.On("Start", authentication.UserContext{UserID: user.ID, GroupID: user.GroupID}, authentication.ClientInfo{}).
+		Return(authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}, nil)
+
+	// when
+	tokens, err := auth.Authenticate(ctx, email, password)
+
+	// then
+	require.NoError(t, err)
+	require.NotZero(t, tokens)
+}
+
+func TestAuthRejectsUnverifiedUserWhenVerificationIsRequired(t *testing.T) {
+	ctx := context.Background()
+	userRepository := new(mockUserRepository)
+	mockDB := new(mockQuerier)
+	mockStarter := new(mockSessionStarter)
+	auth := authentication.NewAuthService(
+		mockDB,
+		mockStarter,
+		simplePasswordChecker,
+		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{RequireForAuthentication: true},
+	)
+
+	// given
+	email := expenses.Email("some@mail.com")
+	password := expenses.Password("password")
+	user := expenses.User{ID: 1, Email: email, Password: password}
+	userRepository.On("FindByEmail", ctx, mockDB, email).Return(user, nil)
+
+	// when
+	tokens, err := auth.Authenticate(ctx, email, password)
+
+	// then
+	require.Equal(t, authentication.ErrEmailNotVerified, err)
+	require.Zero(t, tokens)
+	mockStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+}
+
+func TestAuthLetsVerifiedUserInWhenVerificationIsRequired(t *testing.T) {
+	ctx := context.Background()
+	userRepository := new(mockUserRepository)
+	mockDB := new(mockQuerier)
+	mockStarter := new(mockSessionStarter)
+	auth := authentication.NewAuthService(
+		mockDB,
+		mockStarter,
+		simplePasswordChecker,
+		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{RequireForAuthentication: true},
+	)
+
+	// given
+	email := expenses.Email("some@mail.com")
+	password := expenses.Password("password")
+	user := expenses.User{ID: 1, Email: email, Password: password, Verified: true}
+	userRepository.On("FindByEmail", ctx, mockDB, email).Return(user, nil)
+	mockStarter.On("Start", authentication.UserContext{UserID: user.ID}, authentication.ClientInfo{}).
+		Return(authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}, nil)
 
 	// when
 	tokens, err := auth.Authenticate(ctx, email, password)
@@ -118,13 +256,14 @@ func TestAuthReturnsErrorIfFailedToFindTheUser(t *testing.T) {
 	ctx := context.Background()
 	userRepository := new(mockUserRepository)
 	mockDB := new(mockQuerier)
-	mockSaver := new(mockTokeSaver)
+	mockStarter := new(mockSessionStarter)
 	auth := authentication.NewAuthService(
 		mockDB,
-		testTokenCreator,
-		mockSaver,
+		mockStarter,
 		simplePasswordChecker,
 		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
 	)
 
 	// given
@@ -143,13 +282,14 @@ func TestAuthReturnsErrEmailOrPasswordIncorrectIfUserNotFound(t *testing.T) {
 	ctx := context.Background()
 	userRepository := new(mockUserRepository)
 	mockDB := new(mockQuerier)
-	mockSaver := new(mockTokeSaver)
+	mockStarter := new(mockSessionStarter)
 	auth := authentication.NewAuthService(
 		mockDB,
-		testTokenCreator,
-		mockSaver,
+		mockStarter,
 		simplePasswordChecker,
 		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
 	)
 
 	// given
@@ -168,13 +308,14 @@ func TestAuthReturnsErrEmailOrPasswordIncorrectIfPasswordIsIncorrect(t *testing.
 	ctx := context.Background()
 	userRepository := new(mockUserRepository)
 	mockDB := new(mockQuerier)
-	mockSaver := new(mockTokeSaver)
+	mockStarter := new(mockSessionStarter)
 	auth := authentication.NewAuthService(
 		mockDB,
-		testTokenCreator,
-		mockSaver,
+		mockStarter,
 		simplePasswordChecker,
 		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
 	)
 
 	// given
@@ -189,17 +330,18 @@ func TestAuthReturnsErrEmailOrPasswordIncorrectIfPasswordIsIncorrect(t *testing.
 	require.Zero(t, tokens)
 }
 
-func TestAuthReturnsErrWhenTokenSavingFails(t *testing.T) {
+func TestAuthReturnsErrWhenSessionStartFails(t *testing.T) {
 	ctx := context.Background()
 	userRepository := new(mockUserRepository)
 	mockDB := new(mockQuerier)
-	mockSaver := new(mockTokeSaver)
+	mockStarter := new(mockSessionStarter)
 	auth := authentication.NewAuthService(
 		mockDB,
-		testTokenCreator,
-		mockSaver,
+		mockStarter,
 		simplePasswordChecker,
 		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
 	)
 
 	// given
@@ -207,8 +349,8 @@ func TestAuthReturnsErrWhenTokenSavingFails(t *testing.T) {
 	password := expenses.Password("password")
 	user := expenses.User{ID: 1, Email: email, Password: password}
 	userRepository.On("FindByEmail", ctx, mockDB, email).Return(user, nil)
-	mockSaver.On("Save", mock.Anything, authentication.UserContext{UserID: user.ID, GroupID: user.GroupID}).
-		Return(errors.New("expected"))
+	mockStarter.On("Start", authentication.UserContext{UserID: user.ID, GroupID: user.GroupID}, authentication.ClientInfo{}).
+		Return(authentication.TokenResponse{}, errors.New("expected"))
 
 	// when
 	_, err := auth.Authenticate(ctx, email, password)
@@ -216,3 +358,183 @@ func TestAuthReturnsErrWhenTokenSavingFails(t *testing.T) {
 	// then
 	require.Error(t, err)
 }
+
+func TestAuthStartsSessionOfClientFromContext(t *testing.T) {
+	userRepository := new(mockUserRepository)
+	mockDB := new(mockQuerier)
+	mockStarter := new(mockSessionStarter)
+	auth := authentication.NewAuthService(
+		mockDB,
+		mockStarter,
+		simplePasswordChecker,
+		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
+	)
+
+	// given
+	client := authentication.ClientInfo{IP: "10.0.0.1", UserAgent: "curl/7.74.0"}
+	ctx := authentication.WithClientInfo(context.Background(), client)
+	email := expenses.Email("some@mail.com")
+	password := expenses.Password("password")
+	user := expenses.User{ID: 1, Email: email, Password: password, GroupID: 2}
+	expected := authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}
+	userRepository.On("FindByEmail", ctx, mockDB, email).Return(user, nil)
+	mockStarter.On("Start", authentication.UserContext{UserID: user.ID, GroupID: user.GroupID}, client).
+		Return(expected, nil)
+
+	// when
+	tokens, err := auth.Authenticate(ctx, email, password)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, expected, tokens)
+}
+
+func TestAuthRehashesOutdatedPassword(t *testing.T) {
+	tests := []struct {
+		name      string
+		updateErr error
+	}{
+		{name: "rehashed password is stored"},
+		{name: "failure to store is ignored", updateErr: errors.New("expected")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			userRepository := new(mockUserRepository)
+			mockDB := new(mockQuerier)
+			mockStarter := new(mockSessionStarter)
+			argon2id, err := authentication.NewArgon2idPasswordEncoder(testArgon2idParams)
+			require.NoError(t, err)
+			bcrypt := authentication.NewBCryptPasswordEncoderWithCost(4)
+			auth := authentication.NewAuthService(
+				mockDB,
+				mockStarter,
+				authentication.NewMigratingPasswordEncoder(argon2id, bcrypt),
+				userRepository,
+				noTwoFactor,
+				authentication.VerificationPolicy{},
+			)
+
+			// given
+			email := expenses.Email("some@mail.com")
+			password := expenses.Password("password")
+			legacyHash, err := bcrypt.Encode(string(password))
+			require.NoError(t, err)
+			user := expenses.User{ID: 1, Email: email, Password: expenses.Password(legacyHash)}
+			userRepository.On("FindByEmail", ctx, mockDB, email).Return(user, nil)
+			userRepository.On("UpdatePassword", ctx, mockDB, user.ID, mock.MatchedBy(func(hashed expenses.Password) bool {
+				return argon2id.Check(string(hashed), string(password)) && !argon2id.NeedsRehash(string(hashed))
+			})).Return(test.updateErr)
+			mockStarter.On("Start", mock.Anything, mock.Anything).
+				Return(authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}, nil)
+
+			// when
+			tokens, err := auth.Authenticate(ctx, email, password)
+
+			// then
+			require.NoError(t, err)
+			require.NotZero(t, tokens)
+			userRepository.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthDoesNotRehashUpToDatePassword(t *testing.T) {
+	ctx := context.Background()
+	userRepository := new(mockUserRepository)
+	mockDB := new(mockQuerier)
+	mockStarter := new(mockSessionStarter)
+	argon2id, err := authentication.NewArgon2idPasswordEncoder(testArgon2idParams)
+	require.NoError(t, err)
+	auth := authentication.NewAuthService(
+		mockDB,
+		mockStarter,
+		authentication.NewMigratingPasswordEncoder(argon2id, authentication.NewBCryptPasswordEncoder()),
+		userRepository,
+		noTwoFactor,
+		authentication.VerificationPolicy{},
+	)
+
+	// given
+	email := expenses.Email("some@mail.com")
+	password := expenses.Password("password")
+	hashed, err := argon2id.Encode(string(password))
+	require.NoError(t, err)
+	userRepository.On("FindByEmail", ctx, mockDB, email).
+		Return(expenses.User{ID: 1, Email: email, Password: expenses.Password(hashed)}, nil)
+	mockStarter.On("Start", mock.Anything, mock.Anything).
+		Return(authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}, nil)
+
+	// when
+	tokens, err := auth.Authenticate(ctx, email, password)
+
+	// then
+	require.NoError(t, err)
+	require.NotZero(t, tokens)
+	userRepository.AssertNotCalled(t, "UpdatePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAuthReturnsOnlyMFATokenWhenTwoFactorIsEnabled(t *testing.T) {
+	ctx := context.Background()
+	userRepository := new(mockUserRepository)
+	mockDB := new(mockQuerier)
+	mockStarter := new(mockSessionStarter)
+	challenger := new(mockTwoFactorChallenger)
+	auth := authentication.NewAuthService(
+		mockDB,
+		mockStarter,
+		simplePasswordChecker,
+		userRepository,
+		challenger,
+		authentication.VerificationPolicy{},
+	)
+
+	// given
+	email := expenses.Email("some@mail.com")
+	password := expenses.Password("password")
+	userRepository.On("FindByEmail", ctx, mockDB, email).
+		Return(expenses.User{ID: 1, Email: email, Password: password}, nil)
+	challenger.On("Challenge", ctx, uint(1)).Return("mfa-token", nil)
+
+	// when
+	tokens, err := auth.Authenticate(ctx, email, password)
+
+	// then
+	require.NoError(t, err)
+	require.Equal(t, authentication.TokenResponse{MFAToken: "mfa-token"}, tokens)
+	mockStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+}
+
+func TestAuthReturnsErrorWhenTwoFactorChallengeFails(t *testing.T) {
+	ctx := context.Background()
+	userRepository := new(mockUserRepository)
+	mockDB := new(mockQuerier)
+	mockStarter := new(mockSessionStarter)
+	challenger := new(mockTwoFactorChallenger)
+	auth := authentication.NewAuthService(
+		mockDB,
+		mockStarter,
+		simplePasswordChecker,
+		userRepository,
+		challenger,
+		authentication.VerificationPolicy{},
+	)
+
+	// given
+	email := expenses.Email("some@mail.com")
+	password := expenses.Password("password")
+	expectedErr := errors.New("expected")
+	userRepository.On("FindByEmail", ctx, mockDB, email).
+		Return(expenses.User{ID: 1, Email: email, Password: password}, nil)
+	challenger.On("Challenge", ctx, uint(1)).Return("", expectedErr)
+
+	// when
+	tokens, err := auth.Authenticate(ctx, email, password)
+
+	// then
+	require.Equal(t, expectedErr, err)
+	require.Zero(t, tokens)
+	mockStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+}
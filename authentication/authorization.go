@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"go-spend/authentication/jwt"
+	"go-spend/log"
 	"net/http"
 	"strings"
 )
@@ -21,15 +22,15 @@ type Authorizer interface {
 	Authorize(http.HandlerFunc) http.HandlerFunc
 }
 
-// JWTAuthorizer extracts credentials from Authorization header and expects them to be a JWT.
+// JWTAuthorizer extracts credentials from Authorization header and expects them to be a valid JWT.
 // The accessUUIDClaim is check against the values stored in redis.
 type JWTAuthorizer struct {
-	accessAlgorithm *jwt.Algorithm
+	accessAlgorithm jwt.Codec
 	tokenRetriever  TokenRetriever
 }
 
 // NewJWTAuthorizer creates new instance of JWTAuthorizer
-func NewJWTAuthorizer(accessAlgorithm *jwt.Algorithm, tokenRetriever TokenRetriever) *JWTAuthorizer {
+func NewJWTAuthorizer(accessAlgorithm jwt.Codec, tokenRetriever TokenRetriever) *JWTAuthorizer {
 	return &JWTAuthorizer{accessAlgorithm: accessAlgorithm, tokenRetriever: tokenRetriever}
 }
 
@@ -41,7 +42,7 @@ func (a *JWTAuthorizer) Authorize(realHandler http.HandlerFunc) http.HandlerFunc
 			http.Error(w, NotAuthorized, http.StatusForbidden)
 			return
 		}
-		claims, err := a.accessAlgorithm.Decode(parts[1])
+		claims, err := a.accessAlgorithm.DecodeAndValidate(parts[1])
 		if err != nil {
 			http.Error(w, NotAuthorized, http.StatusForbidden)
 			return
@@ -67,6 +68,57 @@ func (a *JWTAuthorizer) Authorize(realHandler http.HandlerFunc) http.HandlerFunc
 	}
 }
 
+// StatelessJWTAuthorizer trusts UserContext from claims of a valid JWT in Authorization header, so that tokens don't
+// need to be looked up in a storage. Revoked sessions are checked in a short-lived denylist which lives only as long
+// as access tokens do. If the denylist is not available - the claims are trusted, that keeps the API working when
+// the storage is down at the cost of revoked tokens being accepted in the meantime.
+// Group membership changes become visible only after tokens are refreshed.
+type StatelessJWTAuthorizer struct {
+	accessAlgorithm jwt.Codec
+	denylist        SessionDenylist
+}
+
+// NewStatelessJWTAuthorizer creates new instance of StatelessJWTAuthorizer
+func NewStatelessJWTAuthorizer(accessAlgorithm jwt.Codec, denylist SessionDenylist) *StatelessJWTAuthorizer {
+	return &StatelessJWTAuthorizer{accessAlgorithm: accessAlgorithm, denylist: denylist}
+}
+
+func (a *StatelessJWTAuthorizer) Authorize(realHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		parts := strings.Split(auth, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		claims, err := a.accessAlgorithm.DecodeAndValidate(parts[1])
+		if err != nil {
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		if _, ok := claims[accessUUIDClaim].(string); !ok {
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		accessContext, err := userContextFromClaims(claims)
+		if err != nil {
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		denied, err := a.denylist.IsDenied(accessContext.SessionID)
+		if err != nil {
+			log.Warn("couldn't check session %s in denylist, trusting the token - %s", accessContext.SessionID, err)
+		}
+		if denied {
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		contextWithUser := context.WithValue(r.Context(), "user", accessContext)
+		requestWithUser := r.WithContext(contextWithUser)
+		realHandler.ServeHTTP(w, requestWithUser)
+	}
+}
+
 // ExtractUser from request. It should be put there by Authorizer
 func ExtractUser(r *http.Request) (UserContext, error) {
 	value := r.Context().Value("user")
@@ -9,8 +9,18 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
+type mockSessionDenylist struct {
+	mock.Mock
+}
+
+func (m *mockSessionDenylist) IsDenied(sessionID string) (bool, error) {
+	args := m.Called(sessionID)
+	return args.Bool(0), args.Error(1)
+}
+
 type mockTokenRetriever struct {
 	mock.Mock
 }
@@ -189,6 +199,175 @@ func TestJWTAuthorizerTokenIncorrectForbidden(t *testing.T) {
 	require.Equal(t, http.StatusForbidden, recorder.Code)
 }
 
+func TestJWTAuthorizerRevokedSessionForbidden(t *testing.T) {
+	clearRedis()
+	// given
+	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
+	authorizer := authentication.NewJWTAuthorizer(accessAlg, tokenRepository)
+	userContext := authentication.UserContext{UserID: 11, GroupID: 22, SessionID: "session"}
+	tokenPair, err := authentication.NewTokenCreator(accessAlg, refreshAlg).CreateTokenPair(userContext)
+	require.NoError(t, err)
+	require.NoError(t, tokenRepository.Save(tokenPair, userContext))
+	require.NoError(t, tokenRepository.RemoveSession(userContext.UserID, userContext.SessionID))
+
+	request := httptest.NewRequest(http.MethodGet, "/target", nil)
+	request.Header.Set("Authorization", "Bearer "+tokenPair.AccessToken.Encoded)
+	recorder := httptest.NewRecorder()
+
+	// when
+	authorizer.Authorize(func(w http.ResponseWriter, r *http.Request) {}).ServeHTTP(recorder, request)
+
+	// then
+	require.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestJWTAuthorizerSignedWithOtherKeyForbidden(t *testing.T) {
+	// given
+	tokenRetriever := new(mockTokenRetriever)
+	authorizer := authentication.NewJWTAuthorizer(accessAlg, tokenRetriever)
+	claims := jwt.NewClaims()
+	claims["access_uuid"] = "uuid-id"
+	accessJWT, err := jwt.HmacSha256("other key").Encode(claims)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/target", nil)
+	request.Header.Set("Authorization", "Bearer "+accessJWT)
+	recorder := httptest.NewRecorder()
+
+	// when
+	authorizer.Authorize(func(http.ResponseWriter, *http.Request) {}).ServeHTTP(recorder, request)
+
+	// then
+	require.Equal(t, http.StatusForbidden, recorder.Code)
+	tokenRetriever.AssertNotCalled(t, "Retrieve", mock.Anything)
+}
+
+func TestJWTAuthorizerExpiredTokenForbidden(t *testing.T) {
+	// given
+	tokenRetriever := new(mockTokenRetriever)
+	authorizer := authentication.NewJWTAuthorizer(accessAlg, tokenRetriever)
+	claims := jwt.NewClaims()
+	claims["access_uuid"] = "uuid-id"
+	claims.SetTime("exp", time.Now().Add(-time.Minute))
+	accessJWT, err := accessAlg.Encode(claims)
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/target", nil)
+	request.Header.Set("Authorization", "Bearer "+accessJWT)
+	recorder := httptest.NewRecorder()
+
+	// when
+	authorizer.Authorize(func(http.ResponseWriter, *http.Request) {}).ServeHTTP(recorder, request)
+
+	// then
+	require.Equal(t, http.StatusForbidden, recorder.Code)
+	tokenRetriever.AssertNotCalled(t, "Retrieve", mock.Anything)
+}
+
+func TestNewStatelessJWTAuthorizer(t *testing.T) {
+	require.NotNil(t, authentication.NewStatelessJWTAuthorizer(accessAlg, new(mockSessionDenylist)))
+}
+
+func TestStatelessJWTAuthorizerAuthorize(t *testing.T) {
+	expectedContext := authentication.UserContext{UserID: 11, GroupID: 22, SessionID: "session"}
+	tests := []struct {
+		name         string
+		denied       bool
+		denylistErr  error
+		expectedCode int
+	}{
+		{
+			name:         "not denied",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "denied",
+			denied:       true,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "denylist is not available",
+			denylistErr:  errors.New("expected"),
+			expectedCode: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			denylist := new(mockSessionDenylist)
+			authorizer := authentication.NewStatelessJWTAuthorizer(accessAlg, denylist)
+			tokenPair, err := authentication.NewTokenCreator(accessAlg, refreshAlg).CreateTokenPair(expectedContext)
+			require.NoError(t, err)
+			denylist.On("IsDenied", expectedContext.SessionID).Return(test.denied, test.denylistErr)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				userContext, err := authentication.ExtractUser(r)
+				require.NoError(t, err)
+				require.Equal(t, expectedContext, userContext)
+				w.WriteHeader(http.StatusOK)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/target", nil)
+			request.Header.Set("Authorization", "Bearer "+tokenPair.AccessToken.Encoded)
+			recorder := httptest.NewRecorder()
+
+			// when
+			authorizer.Authorize(handler).ServeHTTP(recorder, request)
+
+			// then
+			require.Equal(t, test.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestStatelessJWTAuthorizerForbidden(t *testing.T) {
+	refreshPair, err := authentication.NewTokenCreator(accessAlg, refreshAlg).
+		CreateTokenPair(authentication.UserContext{UserID: 11, SessionID: "session"})
+	require.NoError(t, err)
+	_, noContextJWT := prepareValidJWT(t)
+	tests := []struct {
+		name          string
+		authorization string
+	}{
+		{
+			name:          "no header",
+			authorization: "",
+		},
+		{
+			name:          "not bearer",
+			authorization: "Basic " + refreshPair.AccessToken.Encoded,
+		},
+		{
+			name:          "refresh token",
+			authorization: "Bearer " + refreshPair.RefreshToken.Encoded,
+		},
+		{
+			name:          "no user context in claims",
+			authorization: "Bearer " + noContextJWT,
+		},
+		{
+			name:          "malformed",
+			authorization: "Bearer something",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			denylist := new(mockSessionDenylist)
+			authorizer := authentication.NewStatelessJWTAuthorizer(accessAlg, denylist)
+			request := httptest.NewRequest(http.MethodGet, "/target", nil)
+			request.Header.Set("Authorization", test.authorization)
+			recorder := httptest.NewRecorder()
+
+			// when
+			authorizer.Authorize(func(http.ResponseWriter, *http.Request) {}).ServeHTTP(recorder, request)
+
+			// then
+			require.Equal(t, http.StatusForbidden, recorder.Code)
+			denylist.AssertNotCalled(t, "IsDenied", mock.Anything)
+		})
+	}
+}
+
 func prepareValidJWT(t *testing.T) (string, string) {
 	claims := jwt.NewClaims()
 	accessUUID := "uuid-id"
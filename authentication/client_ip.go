@@ -0,0 +1,126 @@
+package authentication
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseCIDRs parses IP networks in CIDR notation, single IPs are accepted as networks of one address
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect IP or CIDR %q", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIPResolver finds the IP of the client that has sent a request. Forwarded and X-Forwarded-For headers are
+// trusted only when the request came from one of the trusted proxies, otherwise anyone could choose an IP to be
+// limited by. Hops are checked from the closest one, the first hop that is not a trusted proxy is the client.
+// Forwarded takes precedence over X-Forwarded-For if both are present.
+type ClientIPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver creates new instance of ClientIPResolver. Without trusted proxies the client is always the
+// remote address of the connection.
+func NewClientIPResolver(trustedProxies []*net.IPNet) *ClientIPResolver {
+	return &ClientIPResolver{trustedProxies: trustedProxies}
+}
+
+// Resolve returns nil if the remote address of the request is not an IP
+func (c *ClientIPResolver) Resolve(r *http.Request) net.IP {
+	client := parseHost(r.RemoteAddr)
+	if client == nil || !containsIP(c.trustedProxies, client) {
+		return client
+	}
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHost(hops[i])
+		if hop == nil {
+			// unknown or obfuscated hop, the last trusted proxy is the best guess
+			return client
+		}
+		client = hop
+		if !containsIP(c.trustedProxies, client) {
+			return client
+		}
+	}
+	return client
+}
+
+// Handler replaces the remote address of requests with the IP of the client, so that everything that relies on
+// the remote address, like NewClientInfo, sees the client instead of a proxy
+func (c *ClientIPResolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(c.trustedProxies) > 0 {
+			if ip := c.Resolve(r); ip != nil {
+				r.RemoteAddr = ip.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns addresses of all hops in the order they were added, as the headers can be repeated
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	if forwarded := header.Values("Forwarded"); len(forwarded) > 0 {
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			hops = append(hops, forwardedElementFor(element))
+		}
+		return hops
+	}
+	for _, value := range strings.Split(strings.Join(header.Values("X-Forwarded-For"), ","), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			hops = append(hops, value)
+		}
+	}
+	return hops
+}
+
+// forwardedElementFor returns the for parameter of an element of Forwarded header (RFC 7239), empty if there is none
+func forwardedElementFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		name, value := pair, ""
+		if i := strings.Index(pair, "="); i >= 0 {
+			name, value = pair[:i], pair[i+1:]
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "for") {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+// parseHost parses an IP with an optional port, IPv6 with a port has to be in brackets
+func parseHost(address string) net.IP {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"))
+}
@@ -0,0 +1,155 @@
+package authentication_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	// when
+	networks, err := authentication.ParseCIDRs([]string{"10.0.0.0/8", " 192.0.2.1", "2001:db8::/32", "::1"})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, networks, 4)
+	assert.Equal(t, "10.0.0.0/8", networks[0].String())
+	assert.Equal(t, "192.0.2.1/32", networks[1].String())
+	assert.Equal(t, "2001:db8::/32", networks[2].String())
+	assert.Equal(t, "::1/128", networks[3].String())
+}
+
+func TestParseCIDRsError(t *testing.T) {
+	for _, cidr := range []string{"", "10.0.0.0/33", "localhost", "10.0.0"} {
+		t.Run(cidr, func(t *testing.T) {
+			// when
+			networks, err := authentication.ParseCIDRs([]string{cidr})
+
+			// then
+			assert.Error(t, err)
+			assert.Nil(t, networks)
+		})
+	}
+}
+
+func TestClientIPResolverResolve(t *testing.T) {
+	trustedProxies, err := authentication.ParseCIDRs([]string{"10.0.0.0/8", "2001:db8::1"})
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{
+			name:       "no headers",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "headers of untrusted client are ignored",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "X-Forwarded-For from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 10.0.0.2"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "IP chosen by client is ignored",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.1, 198.51.100.1"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "repeated X-Forwarded-For",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "only trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "Forwarded from trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https;by=10.0.0.1"}},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "Forwarded with IPv6 and port",
+			remoteAddr: "[2001:db8::1]:1234",
+			headers:    map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711", for=10.0.0.2`}},
+			expected:   "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded takes precedence",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Forwarded-For": {"203.0.113.1"},
+			},
+			expected: "198.51.100.1",
+		},
+		{
+			name:       "obfuscated hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.2"}},
+			expected:   "10.0.0.2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			resolver := authentication.NewClientIPResolver(trustedProxies)
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.RemoteAddr = test.remoteAddr
+			for name, values := range test.headers {
+				req.Header[name] = values
+			}
+
+			// when
+			ip := resolver.Resolve(req)
+
+			// then
+			assert.Equal(t, net.ParseIP(test.expected), ip)
+		})
+	}
+}
+
+func TestClientIPResolverHandler(t *testing.T) {
+	// given
+	trustedProxies, err := authentication.ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	var client authentication.ClientInfo
+	handler := authentication.NewClientIPResolver(trustedProxies).Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client = authentication.NewClientInfo(r)
+		}),
+	)
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	// when
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// then
+	assert.Equal(t, "198.51.100.1", client.IP)
+}
@@ -0,0 +1,166 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgtype/pgxtype"
+	"go-spend/expenses"
+	"go-spend/log"
+	"go-spend/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	emailVerificationPurpose    = "email_verification"
+	emailVerificationExpiration = 24 * time.Hour
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("email verification token is not valid")
+	ErrEmailNotVerified         = errors.New("email is not verified")
+)
+
+// VerificationPolicy defines what users need a verified email for. Zero value doesn't restrict unverified users.
+type VerificationPolicy struct {
+	// RequireForAuthentication doesn't let unverified users authenticate
+	RequireForAuthentication bool
+	// RequireForGroups doesn't let unverified users create, join or claim groups
+	RequireForGroups bool
+}
+
+// EmailVerifier confirms that users own their emails
+type EmailVerifier interface {
+	// SendVerification sends a verification link to the email of the user
+	SendVerification(ctx context.Context, userID uint, email expenses.Email) error
+	// Resend sends a new verification link if there is an unverified user with the email
+	Resend(ctx context.Context, email expenses.Email) error
+	// Verify marks email of the token owner as verified
+	Verify(ctx context.Context, token string) error
+}
+
+// DefaultEmailVerifier sends single-use verification tokens by email. Tokens expire in a day.
+type DefaultEmailVerifier struct {
+	db         pgxtype.Querier
+	mailer     mail.Mailer
+	repository expenses.UserRepository
+	tokenStore OneTimeTokenStore
+	verifyURL  string
+}
+
+// NewDefaultEmailVerifier creates new instance of DefaultEmailVerifier. The verification token is added to verifyURL
+// as token query parameter.
+func NewDefaultEmailVerifier(
+	db pgxtype.Querier,
+	mailer mail.Mailer,
+	repository expenses.UserRepository,
+	tokenStore OneTimeTokenStore,
+	verifyURL string,
+) *DefaultEmailVerifier {
+	return &DefaultEmailVerifier{
+		db:         db,
+		mailer:     mailer,
+		repository: repository,
+		tokenStore: tokenStore,
+		verifyURL:  verifyURL,
+	}
+}
+
+// SendVerification stores the email together with the user, so that the token can't verify an email the user
+// doesn't have anymore
+func (v *DefaultEmailVerifier) SendVerification(ctx context.Context, userID uint, email expenses.Email) error {
+	value := fmt.Sprintf("%d_%s", userID, email)
+	token, err := v.tokenStore.Create(emailVerificationPurpose, value, emailVerificationExpiration)
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(v.verifyURL, token)
+	if err != nil {
+		return err
+	}
+	return v.mailer.Send(mail.Message{
+		To:      string(email),
+		Subject: "Verify your go-spend email",
+		Body: fmt.Sprintf(
+			"Welcome to go-spend! Follow the link within a day to verify your email:\n\n%s\n\n"+
+				"If you haven't signed up, just ignore this email.",
+			link,
+		),
+	})
+}
+
+// Resend doesn't return an error for unknown emails, so that it is not possible to find out who is registered
+func (v *DefaultEmailVerifier) Resend(ctx context.Context, email expenses.Email) error {
+	user, err := v.repository.FindByEmail(ctx, v.db, email)
+	if err == expenses.ErrUserNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if user.Verified {
+		return nil
+	}
+	return v.SendVerification(ctx, user.ID, user.Email)
+}
+
+// Verify returns ErrInvalidVerificationToken if token is unknown, expired or was already used
+func (v *DefaultEmailVerifier) Verify(ctx context.Context, token string) error {
+	value, err := v.tokenStore.Consume(emailVerificationPurpose, token)
+	if err == ErrOneTimeTokenNotFound {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(value, "_", 2)
+	if len(parts) != 2 {
+		return ErrInvalidVerificationToken
+	}
+	userID, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	err = v.repository.MarkVerified(ctx, v.db, uint(userID), expenses.Email(parts[1]))
+	if err == expenses.ErrUserNotFound {
+		return ErrInvalidVerificationToken
+	}
+	return err
+}
+
+// VerifyingUserService is a UserService that sends a verification link to every new user
+type VerifyingUserService struct {
+	delegate      UserService
+	emailVerifier EmailVerifier
+	policy        VerificationPolicy
+}
+
+// NewVerifyingUserService creates new instance of VerifyingUserService
+func NewVerifyingUserService(
+	delegate UserService,
+	emailVerifier EmailVerifier,
+	policy VerificationPolicy,
+) *VerifyingUserService {
+	return &VerifyingUserService{delegate: delegate, emailVerifier: emailVerifier, policy: policy}
+}
+
+// Create rejects claiming a placeholder with ErrEmailNotVerified if verified email is required for groups, as a new
+// user can't be verified yet. A failure to send the link is only logged, the user can ask to resend it.
+func (s *VerifyingUserService) Create(
+	ctx context.Context,
+	request expenses.CreateUserRequest,
+) (expenses.UserResponse, error) {
+	if request.ClaimCode != "" && s.policy.RequireForGroups {
+		return expenses.UserResponse{}, ErrEmailNotVerified
+	}
+	user, err := s.delegate.Create(ctx, request)
+	if err != nil {
+		return expenses.UserResponse{}, err
+	}
+	if err := s.emailVerifier.SendVerification(ctx, user.ID, user.Email); err != nil {
+		log.Warn("couldn't send verification email to user %d - %s", user.ID, err)
+	}
+	return user, nil
+}
@@ -0,0 +1,320 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/expenses"
+	"go-spend/mail"
+	"testing"
+	"time"
+)
+
+const testVerifyURL = "https://go-spend.com/users/verify"
+
+type mockUserService struct {
+	mock.Mock
+}
+
+func (m *mockUserService) Create(
+	ctx context.Context,
+	request expenses.CreateUserRequest,
+) (expenses.UserResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(expenses.UserResponse), args.Error(1)
+}
+
+type mockEmailVerifier struct {
+	mock.Mock
+}
+
+func (m *mockEmailVerifier) SendVerification(ctx context.Context, userID uint, email expenses.Email) error {
+	args := m.Called(ctx, userID, email)
+	return args.Error(0)
+}
+
+func (m *mockEmailVerifier) Resend(ctx context.Context, email expenses.Email) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *mockEmailVerifier) Verify(ctx context.Context, token string) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+type emailVerifierMocks struct {
+	db         *mockQuerier
+	mailer     *mockMailer
+	repository *mockUserRepository
+	tokenStore *mockOneTimeTokenStore
+}
+
+func newTestEmailVerifier() (*authentication.DefaultEmailVerifier, emailVerifierMocks) {
+	mocks := emailVerifierMocks{
+		db:         new(mockQuerier),
+		mailer:     new(mockMailer),
+		repository: new(mockUserRepository),
+		tokenStore: new(mockOneTimeTokenStore),
+	}
+	verifier := authentication.NewDefaultEmailVerifier(
+		mocks.db,
+		mocks.mailer,
+		mocks.repository,
+		mocks.tokenStore,
+		testVerifyURL,
+	)
+	return verifier, mocks
+}
+
+func TestNewDefaultEmailVerifier(t *testing.T) {
+	verifier, _ := newTestEmailVerifier()
+	assert.NotNil(t, verifier)
+}
+
+func TestEmailVerifierSendVerification(t *testing.T) {
+	// given
+	verifier, mocks := newTestEmailVerifier()
+	mocks.tokenStore.On("Create", "email_verification", "42_user@mail.com", 24*time.Hour).
+		Return("verify-token", nil)
+	mocks.mailer.On("Send", mock.MatchedBy(func(message mail.Message) bool {
+		return message.To == "user@mail.com" &&
+			assert.Contains(t, message.Body, "https://go-spend.com/users/verify?token=verify-token")
+	})).Return(nil)
+
+	// when
+	err := verifier.SendVerification(context.Background(), 42, "user@mail.com")
+
+	// then
+	require.NoError(t, err)
+	mocks.mailer.AssertExpectations(t)
+}
+
+func TestEmailVerifierSendVerificationErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name        string
+		prepareMock func(mocks emailVerifierMocks)
+	}{
+		{
+			name: "token store fails",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Create", mock.Anything, mock.Anything, mock.Anything).Return("", expectedErr)
+			},
+		},
+		{
+			name: "mailer fails",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Create", mock.Anything, mock.Anything, mock.Anything).Return("token", nil)
+				mocks.mailer.On("Send", mock.Anything).Return(expectedErr)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			verifier, mocks := newTestEmailVerifier()
+			test.prepareMock(mocks)
+
+			// when
+			err := verifier.SendVerification(context.Background(), 42, "user@mail.com")
+
+			// then
+			assert.Equal(t, expectedErr, err)
+		})
+	}
+}
+
+func TestEmailVerifierResend(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         expenses.User
+		findErr      error
+		expectedSent bool
+	}{
+		{
+			name:         "unverified user gets a new link",
+			user:         expenses.User{ID: 42, Email: "user@mail.com"},
+			expectedSent: true,
+		},
+		{
+			name: "verified user is ignored",
+			user: expenses.User{ID: 42, Email: "user@mail.com", Verified: true},
+		},
+		{
+			name:    "unknown email is ignored",
+			findErr: expenses.ErrUserNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			verifier, mocks := newTestEmailVerifier()
+			mocks.repository.On("FindByEmail", ctx, mocks.db, expenses.Email("user@mail.com")).
+				Return(test.user, test.findErr)
+			mocks.tokenStore.On("Create", "email_verification", "42_user@mail.com", mock.Anything).
+				Return("verify-token", nil)
+			mocks.mailer.On("Send", mock.Anything).Return(nil)
+
+			// when
+			err := verifier.Resend(ctx, "user@mail.com")
+
+			// then
+			require.NoError(t, err)
+			if test.expectedSent {
+				mocks.mailer.AssertNumberOfCalls(t, "Send", 1)
+			} else {
+				mocks.mailer.AssertNotCalled(t, "Send", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestEmailVerifierVerify(t *testing.T) {
+	// given
+	ctx := context.Background()
+	verifier, mocks := newTestEmailVerifier()
+	mocks.tokenStore.On("Consume", "email_verification", "verify-token").Return("42_user@mail.com", nil)
+	mocks.repository.On("MarkVerified", ctx, mocks.db, uint(42), expenses.Email("user@mail.com")).Return(nil)
+
+	// when
+	err := verifier.Verify(ctx, "verify-token")
+
+	// then
+	require.NoError(t, err)
+	mocks.repository.AssertExpectations(t)
+}
+
+func TestEmailVerifierVerifyErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name        string
+		prepareMock func(mocks emailVerifierMocks)
+		expectedErr error
+	}{
+		{
+			name: "unknown token",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).
+					Return("", authentication.ErrOneTimeTokenNotFound)
+			},
+			expectedErr: authentication.ErrInvalidVerificationToken,
+		},
+		{
+			name: "token store fails",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("", expectedErr)
+			},
+			expectedErr: expectedErr,
+		},
+		{
+			name: "malformed value",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("user@mail.com", nil)
+			},
+			expectedErr: authentication.ErrInvalidVerificationToken,
+		},
+		{
+			name: "email has changed",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42_user@mail.com", nil)
+				mocks.repository.On("MarkVerified", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Return(expenses.ErrUserNotFound)
+			},
+			expectedErr: authentication.ErrInvalidVerificationToken,
+		},
+		{
+			name: "repository fails",
+			prepareMock: func(mocks emailVerifierMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42_user@mail.com", nil)
+				mocks.repository.On("MarkVerified", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+					Return(expectedErr)
+			},
+			expectedErr: expectedErr,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			verifier, mocks := newTestEmailVerifier()
+			test.prepareMock(mocks)
+
+			// when
+			err := verifier.Verify(context.Background(), "verify-token")
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+		})
+	}
+}
+
+func TestVerifyingUserServiceSendsVerification(t *testing.T) {
+	tests := []struct {
+		name    string
+		sendErr error
+	}{
+		{name: "link is sent"},
+		{name: "send failure is ignored", sendErr: errors.New("expected")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			delegate := new(mockUserService)
+			verifier := new(mockEmailVerifier)
+			service := authentication.NewVerifyingUserService(delegate, verifier, authentication.VerificationPolicy{})
+			request := expenses.CreateUserRequest{Email: validEmail, Password: "123"}
+			expected := expenses.UserResponse{ID: 1, Email: validEmail}
+			delegate.On("Create", ctx, request).Return(expected, nil)
+			verifier.On("SendVerification", ctx, expected.ID, expected.Email).Return(test.sendErr)
+
+			// when
+			actual, err := service.Create(ctx, request)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+			verifier.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyingUserServiceCreateError(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockUserService)
+	verifier := new(mockEmailVerifier)
+	service := authentication.NewVerifyingUserService(delegate, verifier, authentication.VerificationPolicy{})
+	request := expenses.CreateUserRequest{Email: validEmail, Password: "123"}
+	expectedErr := errors.New("expected")
+	delegate.On("Create", ctx, request).Return(expenses.UserResponse{}, expectedErr)
+
+	// when
+	_, err := service.Create(ctx, request)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	verifier.AssertNotCalled(t, "SendVerification", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestVerifyingUserServiceRejectsClaimWhenGroupsRequireVerification(t *testing.T) {
+	// given
+	delegate := new(mockUserService)
+	service := authentication.NewVerifyingUserService(
+		delegate,
+		new(mockEmailVerifier),
+		authentication.VerificationPolicy{RequireForGroups: true},
+	)
+	request := expenses.CreateUserRequest{Email: validEmail, Password: "123", ClaimCode: "code"}
+
+	// when
+	_, err := service.Create(context.Background(), request)
+
+	// then
+	assert.Equal(t, authentication.ErrEmailNotVerified, err)
+	delegate.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+}
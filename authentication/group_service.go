@@ -0,0 +1,163 @@
+package authentication
+
+import (
+	"context"
+	"github.com/jackc/pgtype/pgxtype"
+	"go-spend/expenses"
+	"go-spend/log"
+)
+
+// ContextUpdatingGroupService is an expenses.GroupService that updates UserContext of all live tokens of a user
+// after the user has become a member of a group, so that the user doesn't need to authenticate again.
+type ContextUpdatingGroupService struct {
+	delegate           expenses.GroupService
+	userContextUpdater UserContextUpdater
+}
+
+// NewContextUpdatingGroupService creates a new instance of ContextUpdatingGroupService
+func NewContextUpdatingGroupService(
+	delegate expenses.GroupService,
+	userContextUpdater UserContextUpdater,
+) *ContextUpdatingGroupService {
+	return &ContextUpdatingGroupService{delegate: delegate, userContextUpdater: userContextUpdater}
+}
+
+// Create delegates group creation and moves the creator into the new group
+func (c *ContextUpdatingGroupService) Create(
+	ctx context.Context,
+	request expenses.CreateGroupContext,
+) (expenses.GroupResponse, error) {
+	group, err := c.delegate.Create(ctx, request)
+	if err != nil {
+		return expenses.GroupResponse{}, err
+	}
+	c.updateUserContext(UserContext{UserID: request.CreatorID, GroupID: group.ID})
+	return group, nil
+}
+
+func (c *ContextUpdatingGroupService) FindByID(ctx context.Context, id uint) (expenses.GroupResponse, error) {
+	return c.delegate.FindByID(ctx, id)
+}
+
+// AddUserToGroup delegates the addition and moves the added user into the group
+func (c *ContextUpdatingGroupService) AddUserToGroup(ctx context.Context, addRequest expenses.AddToGroupRequest) error {
+	if err := c.delegate.AddUserToGroup(ctx, addRequest); err != nil {
+		return err
+	}
+	c.updateUserContext(UserContext{UserID: addRequest.UserID, GroupID: addRequest.GroupID})
+	return nil
+}
+
+func (c *ContextUpdatingGroupService) CreatePlaceholder(
+	ctx context.Context,
+	request expenses.CreatePlaceholderContext,
+) (expenses.PlaceholderResponse, error) {
+	return c.delegate.CreatePlaceholder(ctx, request)
+}
+
+// ClaimPlaceholder delegates the claim and moves the claiming user into the group of the placeholder
+func (c *ContextUpdatingGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request expenses.ClaimPlaceholderContext,
+) (expenses.GroupResponse, error) {
+	group, err := c.delegate.ClaimPlaceholder(ctx, request)
+	if err != nil {
+		return expenses.GroupResponse{}, err
+	}
+	c.updateUserContext(UserContext{UserID: request.UserID, GroupID: group.ID})
+	return group, nil
+}
+
+// updateUserContext only logs a failure as the membership change itself has already happened. The user will get the
+// correct context after the next authentication.
+func (c *ContextUpdatingGroupService) updateUserContext(userContext UserContext) {
+	if err := c.userContextUpdater.UpdateUserContext(userContext); err != nil {
+		log.Warn("couldn't update context of user %d - %s", userContext.UserID, err)
+	}
+}
+
+// VerificationCheckingGroupService is an expenses.GroupService that doesn't let users with unverified emails become
+// members of groups if the verification policy requires a verified email for groups
+type VerificationCheckingGroupService struct {
+	delegate       expenses.GroupService
+	db             pgxtype.Querier
+	userRepository expenses.UserRepository
+	policy         VerificationPolicy
+}
+
+// NewVerificationCheckingGroupService creates a new instance of VerificationCheckingGroupService
+func NewVerificationCheckingGroupService(
+	delegate expenses.GroupService,
+	db pgxtype.Querier,
+	userRepository expenses.UserRepository,
+	policy VerificationPolicy,
+) *VerificationCheckingGroupService {
+	return &VerificationCheckingGroupService{
+		delegate:       delegate,
+		db:             db,
+		userRepository: userRepository,
+		policy:         policy,
+	}
+}
+
+// Create returns ErrEmailNotVerified if the creator is not verified
+func (v *VerificationCheckingGroupService) Create(
+	ctx context.Context,
+	request expenses.CreateGroupContext,
+) (expenses.GroupResponse, error) {
+	if err := v.checkVerified(ctx, request.CreatorID); err != nil {
+		return expenses.GroupResponse{}, err
+	}
+	return v.delegate.Create(ctx, request)
+}
+
+func (v *VerificationCheckingGroupService) FindByID(ctx context.Context, id uint) (expenses.GroupResponse, error) {
+	return v.delegate.FindByID(ctx, id)
+}
+
+// AddUserToGroup returns ErrEmailNotVerified if the added user is not verified
+func (v *VerificationCheckingGroupService) AddUserToGroup(
+	ctx context.Context,
+	addRequest expenses.AddToGroupRequest,
+) error {
+	if err := v.checkVerified(ctx, addRequest.UserID); err != nil {
+		return err
+	}
+	return v.delegate.AddUserToGroup(ctx, addRequest)
+}
+
+func (v *VerificationCheckingGroupService) CreatePlaceholder(
+	ctx context.Context,
+	request expenses.CreatePlaceholderContext,
+) (expenses.PlaceholderResponse, error) {
+	return v.delegate.CreatePlaceholder(ctx, request)
+}
+
+// ClaimPlaceholder returns ErrEmailNotVerified if the claiming user is not verified
+func (v *VerificationCheckingGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request expenses.ClaimPlaceholderContext,
+) (expenses.GroupResponse, error) {
+	if err := v.checkVerified(ctx, request.UserID); err != nil {
+		return expenses.GroupResponse{}, err
+	}
+	return v.delegate.ClaimPlaceholder(ctx, request)
+}
+
+// checkVerified lets unknown users through, the delegate reports them
+func (v *VerificationCheckingGroupService) checkVerified(ctx context.Context, userID uint) error {
+	if !v.policy.RequireForGroups {
+		return nil
+	}
+	user, err := v.userRepository.FindById(ctx, v.db, userID)
+	if err == expenses.ErrUserNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.Verified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/expenses"
+	"go-spend/util"
+	"testing"
+)
+
+type mockGroupService struct {
+	mock.Mock
+}
+
+func (m *mockGroupService) Create(
+	ctx context.Context,
+	request expenses.CreateGroupContext,
+) (expenses.GroupResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(expenses.GroupResponse), args.Error(1)
+}
+
+func (m *mockGroupService) FindByID(_ context.Context, _ uint) (expenses.GroupResponse, error) {
+	panic("implement me")
+}
+
+func (m *mockGroupService) AddUserToGroup(ctx context.Context, addRequest expenses.AddToGroupRequest) error {
+	args := m.Called(ctx, addRequest)
+	return args.Error(0)
+}
+
+func (m *mockGroupService) CreatePlaceholder(
+	_ context.Context,
+	_ expenses.CreatePlaceholderContext,
+) (expenses.PlaceholderResponse, error) {
+	panic("implement me")
+}
+
+func (m *mockGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request expenses.ClaimPlaceholderContext,
+) (expenses.GroupResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(expenses.GroupResponse), args.Error(1)
+}
+
+type mockUserContextUpdater struct {
+	mock.Mock
+}
+
+func (m *mockUserContextUpdater) UpdateUserContext(userContext authentication.UserContext) error {
+	args := m.Called(userContext)
+	return args.Error(0)
+}
+
+func TestNewContextUpdatingGroupService(t *testing.T) {
+	require.NotNil(t, authentication.NewContextUpdatingGroupService(new(mockGroupService), new(mockUserContextUpdater)))
+}
+
+func TestContextUpdatingGroupServiceCreate(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	updater := new(mockUserContextUpdater)
+	service := authentication.NewContextUpdatingGroupService(delegate, updater)
+	request := expenses.CreateGroupContext{Name: util.NonEmptyString("group"), CreatorID: 1}
+	expected := expenses.GroupResponse{ID: 2, Name: "group"}
+	delegate.On("Create", ctx, request).Return(expected, nil)
+	updater.On("UpdateUserContext", authentication.UserContext{UserID: 1, GroupID: 2}).Return(nil)
+
+	// when
+	group, err := service.Create(ctx, request)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, expected, group)
+	updater.AssertExpectations(t)
+}
+
+func TestContextUpdatingGroupServiceCreateUpdateFailureIsIgnored(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	updater := new(mockUserContextUpdater)
+	service := authentication.NewContextUpdatingGroupService(delegate, updater)
+	request := expenses.CreateGroupContext{Name: util.NonEmptyString("group"), CreatorID: 1}
+	expected := expenses.GroupResponse{ID: 2, Name: "group"}
+	delegate.On("Create", ctx, request).Return(expected, nil)
+	updater.On("UpdateUserContext", authentication.UserContext{UserID: 1, GroupID: 2}).Return(errors.New("expected"))
+
+	// when
+	group, err := service.Create(ctx, request)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, expected, group)
+}
+
+func TestContextUpdatingGroupServiceCreateError(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	updater := new(mockUserContextUpdater)
+	service := authentication.NewContextUpdatingGroupService(delegate, updater)
+	request := expenses.CreateGroupContext{Name: util.NonEmptyString("group"), CreatorID: 1}
+	expectedErr := errors.New("expected")
+	delegate.On("Create", ctx, request).Return(expenses.GroupResponse{}, expectedErr)
+
+	// when
+	_, err := service.Create(ctx, request)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	updater.AssertNotCalled(t, "UpdateUserContext", mock.Anything)
+}
+
+func TestContextUpdatingGroupServiceAddUserToGroup(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	updater := new(mockUserContextUpdater)
+	service := authentication.NewContextUpdatingGroupService(delegate, updater)
+	request := expenses.AddToGroupRequest{UserID: 3, GroupID: 2}
+	delegate.On("AddUserToGroup", ctx, request).Return(nil)
+	updater.On("UpdateUserContext", authentication.UserContext{UserID: 3, GroupID: 2}).Return(nil)
+
+	// when
+	err := service.AddUserToGroup(ctx, request)
+
+	// then
+	require.NoError(t, err)
+	updater.AssertExpectations(t)
+}
+
+func TestContextUpdatingGroupServiceAddUserToGroupError(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	updater := new(mockUserContextUpdater)
+	service := authentication.NewContextUpdatingGroupService(delegate, updater)
+	request := expenses.AddToGroupRequest{UserID: 3, GroupID: 2}
+	delegate.On("AddUserToGroup", ctx, request).Return(expenses.ErrUserIsInAnotherGroup)
+
+	// when
+	err := service.AddUserToGroup(ctx, request)
+
+	// then
+	assert.Equal(t, expenses.ErrUserIsInAnotherGroup, err)
+	updater.AssertNotCalled(t, "UpdateUserContext", mock.Anything)
+}
+
+func TestContextUpdatingGroupServiceClaimPlaceholder(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	updater := new(mockUserContextUpdater)
+	service := authentication.NewContextUpdatingGroupService(delegate, updater)
+	request := expenses.ClaimPlaceholderContext{UserID: 4, ClaimCode: "code"}
+	expected := expenses.GroupResponse{ID: 2, Name: "group"}
+	delegate.On("ClaimPlaceholder", ctx, request).Return(expected, nil)
+	updater.On("UpdateUserContext", authentication.UserContext{UserID: 4, GroupID: 2}).Return(nil)
+
+	// when
+	group, err := service.ClaimPlaceholder(ctx, request)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, expected, group)
+	updater.AssertExpectations(t)
+}
+
+func TestNewVerificationCheckingGroupService(t *testing.T) {
+	require.NotNil(t, authentication.NewVerificationCheckingGroupService(
+		new(mockGroupService),
+		new(mockQuerier),
+		new(mockUserRepository),
+		authentication.VerificationPolicy{RequireForGroups: true},
+	))
+}
+
+func TestVerificationCheckingGroupServiceLetsVerifiedUsersIn(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	db := new(mockQuerier)
+	repository := new(mockUserRepository)
+	service := authentication.NewVerificationCheckingGroupService(
+		delegate,
+		db,
+		repository,
+		authentication.VerificationPolicy{RequireForGroups: true},
+	)
+	repository.On("FindById", ctx, db, uint(1)).Return(expenses.User{ID: 1, Verified: true}, nil)
+	createRequest := expenses.CreateGroupContext{Name: util.NonEmptyString("group"), CreatorID: 1}
+	addRequest := expenses.AddToGroupRequest{UserID: 1, GroupID: 2}
+	claimRequest := expenses.ClaimPlaceholderContext{UserID: 1, ClaimCode: "code"}
+	expected := expenses.GroupResponse{ID: 2, Name: "group"}
+	delegate.On("Create", ctx, createRequest).Return(expected, nil)
+	delegate.On("AddUserToGroup", ctx, addRequest).Return(nil)
+	delegate.On("ClaimPlaceholder", ctx, claimRequest).Return(expected, nil)
+
+	// when
+	created, createErr := service.Create(ctx, createRequest)
+	addErr := service.AddUserToGroup(ctx, addRequest)
+	claimed, claimErr := service.ClaimPlaceholder(ctx, claimRequest)
+
+	// then
+	require.NoError(t, createErr)
+	require.NoError(t, addErr)
+	require.NoError(t, claimErr)
+	assert.Equal(t, expected, created)
+	assert.Equal(t, expected, claimed)
+	delegate.AssertExpectations(t)
+}
+
+func TestVerificationCheckingGroupServiceRejectsUnverifiedUsers(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	db := new(mockQuerier)
+	repository := new(mockUserRepository)
+	service := authentication.NewVerificationCheckingGroupService(
+		delegate,
+		db,
+		repository,
+		authentication.VerificationPolicy{RequireForGroups: true},
+	)
+	repository.On("FindById", ctx, db, uint(1)).Return(expenses.User{ID: 1}, nil)
+
+	// when
+	_, createErr := service.Create(ctx, expenses.CreateGroupContext{Name: util.NonEmptyString("group"), CreatorID: 1})
+	addErr := service.AddUserToGroup(ctx, expenses.AddToGroupRequest{UserID: 1, GroupID: 2})
+	_, claimErr := service.ClaimPlaceholder(ctx, expenses.ClaimPlaceholderContext{UserID: 1, ClaimCode: "code"})
+
+	// then
+	assert.Equal(t, authentication.ErrEmailNotVerified, createErr)
+	assert.Equal(t, authentication.ErrEmailNotVerified, addErr)
+	assert.Equal(t, authentication.ErrEmailNotVerified, claimErr)
+	delegate.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+	delegate.AssertNotCalled(t, "AddUserToGroup", mock.Anything, mock.Anything)
+	delegate.AssertNotCalled(t, "ClaimPlaceholder", mock.Anything, mock.Anything)
+}
+
+func TestVerificationCheckingGroupServiceWithoutRequirementDoesNotCheck(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	repository := new(mockUserRepository)
+	service := authentication.NewVerificationCheckingGroupService(
+		delegate,
+		new(mockQuerier),
+		repository,
+		authentication.VerificationPolicy{},
+	)
+	addRequest := expenses.AddToGroupRequest{UserID: 1, GroupID: 2}
+	delegate.On("AddUserToGroup", ctx, addRequest).Return(nil)
+
+	// when
+	err := service.AddUserToGroup(ctx, addRequest)
+
+	// then
+	require.NoError(t, err)
+	repository.AssertNotCalled(t, "FindById", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestVerificationCheckingGroupServiceRepositoryError(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	repository := new(mockUserRepository)
+	service := authentication.NewVerificationCheckingGroupService(
+		delegate,
+		new(mockQuerier),
+		repository,
+		authentication.VerificationPolicy{RequireForGroups: true},
+	)
+	expectedErr := errors.New("expected")
+	repository.On("FindById", ctx, mock.Anything, uint(1)).Return(expenses.User{}, expectedErr)
+
+	// when
+	err := service.AddUserToGroup(ctx, expenses.AddToGroupRequest{UserID: 1, GroupID: 2})
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	delegate.AssertNotCalled(t, "AddUserToGroup", mock.Anything, mock.Anything)
+}
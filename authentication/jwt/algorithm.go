@@ -1,20 +1,23 @@
 package jwt
 
 import (
+	"crypto"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"hash"
 	"strings"
 	"time"
 )
 
 //Algorithm is used to sign and validate a token.
 type Algorithm struct {
-	signingHash hash.Hash
-	algorithm   string
+	algorithm string
+	sign      func(unsignedToken []byte) ([]byte, error)
+	verify    func(unsignedToken []byte, signature []byte) error
+	// publicKey is nil for symmetric algorithms, they can't be verified by anyone without the secret
+	publicKey crypto.PublicKey
 }
 
 // NewHeader returns a new Header object.
@@ -25,34 +28,20 @@ func (a *Algorithm) NewHeader() *Header {
 	}
 }
 
-func (a *Algorithm) sum(data []byte) []byte {
-	return a.signingHash.Sum(data)
-}
-
-func (a *Algorithm) reset() {
-	a.signingHash.Reset()
-}
-
-func (a *Algorithm) write(data []byte) (int, error) {
-	return a.signingHash.Write(data)
-}
-
-// Sign signs the token with the given hash, and key
+// Sign signs the token with the key of the algorithm. It is safe to use the same Algorithm from multiple goroutines.
 func (a *Algorithm) Sign(unsignedToken string) ([]byte, error) {
-	_, err := a.write([]byte(unsignedToken))
-	if err != nil {
-		return nil, errors.New("unable to write to HMAC-SHA256")
-	}
-
-	encodedToken := a.sum(nil)
-	a.reset()
-
-	return encodedToken, nil
+	return a.sign([]byte(unsignedToken))
 }
 
 // Encode returns an encoded JWT token from a header, payload, and secret
 func (a *Algorithm) Encode(payload Claims) (string, error) {
+	return a.EncodeWithKeyID("", payload)
+}
+
+// EncodeWithKeyID returns an encoded JWT token like Encode, with kid header identifying the key. Empty kid is omitted.
+func (a *Algorithm) EncodeWithKeyID(kid string, payload Claims) (string, error) {
 	header := a.NewHeader()
+	header.Kid = kid
 
 	jsonTokenHeader, err := json.Marshal(header)
 	if err != nil {
@@ -103,6 +92,26 @@ func (a *Algorithm) Decode(encoded string) (Claims, error) {
 	return claims, nil
 }
 
+// DecodeHeader returns the header of the token. DOESN'T validate the token.
+func DecodeHeader(encoded string) (*Header, error) {
+	encryptedComponents := strings.Split(encoded, ".")
+	if len(encryptedComponents) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	jsonHeader, err := base64.RawURLEncoding.DecodeString(encryptedComponents[0])
+	if err != nil {
+		return nil, errors.New("unable to decode base64 header")
+	}
+
+	var header Header
+	if err := json.Unmarshal(jsonHeader, &header); err != nil {
+		return nil, errors.New("unable to unmarshal header json")
+	}
+
+	return &header, nil
+}
+
 // Validate verifies a tokens validity. It returns nil if it is valid, and an error if invalid.
 func (a *Algorithm) Validate(encoded string) error {
 	_, err := a.DecodeAndValidate(encoded)
@@ -115,6 +124,9 @@ func (a *Algorithm) DecodeAndValidate(encoded string) (claims Claims, err error)
 	if err != nil {
 		return
 	}
+	if err = a.validateHeader(encoded); err != nil {
+		return
+	}
 	if err = a.validateSignature(encoded); err != nil {
 		err = errors.New("failed to validate signature")
 		return
@@ -130,6 +142,19 @@ func (a *Algorithm) DecodeAndValidate(encoded string) (claims Claims, err error)
 	return
 }
 
+// validateHeader makes sure that the token was signed with the same algorithm, so that a token can't pick
+// the algorithm it is verified with.
+func (a *Algorithm) validateHeader(encoded string) error {
+	header, err := DecodeHeader(encoded)
+	if err != nil {
+		return err
+	}
+	if header.Alg != a.algorithm {
+		return errors.New("unexpected algorithm")
+	}
+	return nil
+}
+
 func (a *Algorithm) validateSignature(encoded string) error {
 	encryptedComponents := strings.Split(encoded, ".")
 
@@ -137,19 +162,12 @@ func (a *Algorithm) validateSignature(encoded string) error {
 	b64Payload := encryptedComponents[1]
 	b64Signature := encryptedComponents[2]
 
-	unsignedAttempt := b64Header + "." + b64Payload
-	signedAttempt, err := a.Sign(unsignedAttempt)
+	signature, err := base64.RawURLEncoding.DecodeString(b64Signature)
 	if err != nil {
-		return errors.New("unable to sign token for validation")
+		return errors.New("unable to decode base64 signature")
 	}
 
-	b64SignedAttempt := base64.RawURLEncoding.EncodeToString(signedAttempt)
-
-	if !hmac.Equal([]byte(b64Signature), []byte(b64SignedAttempt)) {
-		return errors.New("invalid signature")
-	}
-
-	return nil
+	return a.verify([]byte(b64Header+"."+b64Payload), signature)
 }
 
 func (a *Algorithm) validateExp(claims Claims) error {
@@ -180,8 +198,26 @@ func (a *Algorithm) validateNbf(claims Claims) error {
 
 //HmacSha256 returns the SingingMethod for HMAC with SHA256
 func HmacSha256(key string) *Algorithm {
+	sign := func(unsignedToken []byte) ([]byte, error) {
+		// a new hash for every call keeps the algorithm safe for concurrent use
+		signingHash := hmac.New(sha256.New, []byte(key))
+		if _, err := signingHash.Write(unsignedToken); err != nil {
+			return nil, errors.New("unable to write to HMAC-SHA256")
+		}
+		return signingHash.Sum(nil), nil
+	}
 	return &Algorithm{
-		algorithm:   "HS256",
-		signingHash: hmac.New(sha256.New, []byte(key)),
+		algorithm: "HS256",
+		sign:      sign,
+		verify: func(unsignedToken []byte, signature []byte) error {
+			expected, err := sign(unsignedToken)
+			if err != nil {
+				return errors.New("unable to sign token for validation")
+			}
+			if !hmac.Equal(signature, expected) {
+				return errors.New("invalid signature")
+			}
+			return nil
+		},
 	}
 }
@@ -0,0 +1,146 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+)
+
+// es256CoordinateSize is the size of r and s in the signature and of x and y of a P-256 key
+const es256CoordinateSize = 32
+
+// RsaSha256 returns the SigningMethod for RSASSA-PKCS1-v1_5 with SHA256
+func RsaSha256(key *rsa.PrivateKey) *Algorithm {
+	return &Algorithm{
+		algorithm: "RS256",
+		sign: func(unsignedToken []byte) ([]byte, error) {
+			digest := sha256.Sum256(unsignedToken)
+			return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		},
+		verify:    verifyRsaSha256(&key.PublicKey),
+		publicKey: &key.PublicKey,
+	}
+}
+
+// EcdsaSha256 returns the SigningMethod for ECDSA with P-256 curve and SHA256
+func EcdsaSha256(key *ecdsa.PrivateKey) (*Algorithm, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New("ES256 requires a key on P-256 curve")
+	}
+	return &Algorithm{
+		algorithm: "ES256",
+		sign: func(unsignedToken []byte) ([]byte, error) {
+			digest := sha256.Sum256(unsignedToken)
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+			if err != nil {
+				return nil, err
+			}
+			// JWS expects r and s as fixed size big-endian values instead of ASN.1
+			signature := make([]byte, 2*es256CoordinateSize)
+			r.FillBytes(signature[:es256CoordinateSize])
+			s.FillBytes(signature[es256CoordinateSize:])
+			return signature, nil
+		},
+		verify:    verifyEcdsaSha256(&key.PublicKey),
+		publicKey: &key.PublicKey,
+	}, nil
+}
+
+// Ed25519 returns the SigningMethod for EdDSA with Ed25519 curve
+func Ed25519(key ed25519.PrivateKey) *Algorithm {
+	publicKey := key.Public().(ed25519.PublicKey)
+	return &Algorithm{
+		algorithm: "EdDSA",
+		sign: func(unsignedToken []byte) ([]byte, error) {
+			return ed25519.Sign(key, unsignedToken), nil
+		},
+		verify:    verifyEd25519(publicKey),
+		publicKey: publicKey,
+	}
+}
+
+func verifyRsaSha256(key *rsa.PublicKey) func(unsignedToken []byte, signature []byte) error {
+	return func(unsignedToken []byte, signature []byte) error {
+		digest := sha256.Sum256(unsignedToken)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+}
+
+func verifyEcdsaSha256(key *ecdsa.PublicKey) func(unsignedToken []byte, signature []byte) error {
+	return func(unsignedToken []byte, signature []byte) error {
+		if len(signature) != 2*es256CoordinateSize {
+			return errors.New("invalid signature")
+		}
+		digest := sha256.Sum256(unsignedToken)
+		r := new(big.Int).SetBytes(signature[:es256CoordinateSize])
+		s := new(big.Int).SetBytes(signature[es256CoordinateSize:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+}
+
+func verifyEd25519(key ed25519.PublicKey) func(unsignedToken []byte, signature []byte) error {
+	return func(unsignedToken []byte, signature []byte) error {
+		if !ed25519.Verify(key, unsignedToken, signature) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+}
+
+// ParsePrivateKeyPEM creates an asymmetric Algorithm from a PEM encoded private key. The algorithm is chosen by the
+// type of the key: RS256 for RSA, ES256 for ECDSA and EdDSA for Ed25519. PKCS #8, PKCS #1 and SEC 1 are supported.
+func ParsePrivateKeyPEM(data []byte) (*Algorithm, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.New("unsupported PEM block " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch typedKey := key.(type) {
+	case *rsa.PrivateKey:
+		return RsaSha256(typedKey), nil
+	case *ecdsa.PrivateKey:
+		return EcdsaSha256(typedKey)
+	case ed25519.PrivateKey:
+		return Ed25519(typedKey), nil
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+}
+
+// LoadPrivateKeyPEM reads a PEM file and creates an Algorithm from it, see ParsePrivateKeyPEM
+func LoadPrivateKeyPEM(path string) (*Algorithm, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKeyPEM(data)
+}
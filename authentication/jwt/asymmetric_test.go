@@ -0,0 +1,220 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication/jwt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAsymmetricAlgorithms(t *testing.T) {
+	rsaKey, ecKey, edKey := generateKeys(t)
+	ecAlgorithm, err := jwt.EcdsaSha256(ecKey)
+	require.NoError(t, err)
+	tests := []struct {
+		name      string
+		algorithm *jwt.Algorithm
+		other     *jwt.Algorithm
+	}{
+		{
+			name:      "RS256",
+			algorithm: jwt.RsaSha256(rsaKey),
+			other:     jwt.RsaSha256(mustGenerateRSA(t)),
+		},
+		{
+			name:      "ES256",
+			algorithm: ecAlgorithm,
+			other:     mustES256(t, mustGenerateEC(t)),
+		},
+		{
+			name:      "EdDSA",
+			algorithm: jwt.Ed25519(edKey),
+			other:     jwt.Ed25519(mustGenerateEd25519(t)),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			claims := jwt.NewClaims()
+			claims.SetTime("exp", time.Now().Add(time.Hour))
+			claims["sub"] = "someone"
+
+			// when
+			token, err := test.algorithm.Encode(claims)
+			require.NoError(t, err)
+
+			// then
+			header, err := jwt.DecodeHeader(token)
+			require.NoError(t, err)
+			assert.Equal(t, test.name, header.Alg)
+			decoded, err := test.algorithm.DecodeAndValidate(token)
+			require.NoError(t, err)
+			assert.Equal(t, "someone", decoded["sub"])
+			assert.Error(t, test.other.Validate(token))
+			assert.Error(t, jwt.HmacSha256(secret).Validate(token))
+
+			components := strings.Split(token, ".")
+			tampered := components[0] + "." + components[1] + "x." + components[2]
+			assert.Error(t, test.algorithm.Validate(tampered))
+		})
+	}
+}
+
+func TestAlgorithmRejectsTokenOfOtherAlgorithm(t *testing.T) {
+	// given
+	algorithm := jwt.RsaSha256(mustGenerateRSA(t))
+	token, err := jwt.HmacSha256(secret).Encode(jwt.NewClaims())
+	require.NoError(t, err)
+
+	// when
+	err = algorithm.Validate(token)
+
+	// then
+	assert.Error(t, err)
+}
+
+func TestEcdsaSha256RequiresP256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	_, err = jwt.EcdsaSha256(key)
+
+	assert.Error(t, err)
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	rsaKey, ecKey, edKey := generateKeys(t)
+	ecBytes, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	tests := []struct {
+		name        string
+		block       *pem.Block
+		expectedAlg string
+	}{
+		{
+			name:        "PKCS1 RSA",
+			block:       &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+			expectedAlg: "RS256",
+		},
+		{
+			name:        "PKCS8 RSA",
+			block:       &pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, rsaKey)},
+			expectedAlg: "RS256",
+		},
+		{
+			name:        "SEC1 EC",
+			block:       &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecBytes},
+			expectedAlg: "ES256",
+		},
+		{
+			name:        "PKCS8 EC",
+			block:       &pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, ecKey)},
+			expectedAlg: "ES256",
+		},
+		{
+			name:        "PKCS8 Ed25519",
+			block:       &pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, edKey)},
+			expectedAlg: "EdDSA",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// when
+			algorithm, err := jwt.ParsePrivateKeyPEM(pem.EncodeToMemory(test.block))
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedAlg, algorithm.NewHeader().Alg)
+			token, err := algorithm.Encode(jwt.NewClaims())
+			require.NoError(t, err)
+			assert.NoError(t, algorithm.Validate(token))
+		})
+	}
+}
+
+func TestParsePrivateKeyPEMErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "not PEM",
+			data: []byte("not a key"),
+		},
+		{
+			name: "public key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte{1, 2, 3}}),
+		},
+		{
+			name: "corrupted key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1, 2, 3}}),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := jwt.ParsePrivateKeyPEM(test.data)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadPrivateKeyPEM(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, mustGenerateEd25519(t))})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+
+	// when
+	algorithm, err := jwt.LoadPrivateKeyPEM(path)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "EdDSA", algorithm.NewHeader().Alg)
+	_, err = jwt.LoadPrivateKeyPEM(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
+
+func generateKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey) {
+	return mustGenerateRSA(t), mustGenerateEC(t), mustGenerateEd25519(t)
+}
+
+func mustGenerateRSA(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func mustGenerateEC(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func mustGenerateEd25519(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func mustES256(t *testing.T, key *ecdsa.PrivateKey) *jwt.Algorithm {
+	algorithm, err := jwt.EcdsaSha256(key)
+	require.NoError(t, err)
+	return algorithm
+}
+
+func mustMarshalPKCS8(t *testing.T, key interface{}) []byte {
+	bytes, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return bytes
+}
@@ -0,0 +1,164 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key")
+	ErrVerifyOnly     = errors.New("algorithm can only verify tokens")
+)
+
+// JWK is a public key in JSON Web Key format, see RFC 7517. Only fields of supported key types are present.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// KeySet is a JSON Web Key Set, served to let other services verify tokens without a shared secret
+type KeySet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKeyProvider gives public keys that can verify issued tokens
+type PublicKeyProvider interface {
+	PublicKeys() KeySet
+}
+
+// Find returns the key with the kid, false if there is no such key
+func (s KeySet) Find(kid string) (JWK, bool) {
+	for _, key := range s.Keys {
+		if key.Kid == kid {
+			return key, true
+		}
+	}
+	return JWK{}, false
+}
+
+// ParseJWK creates an Algorithm that verifies tokens signed by the private part of the key, for example tokens of
+// another issuer. The Algorithm can't sign tokens. Supported are RS256, ES256 and EdDSA, the same as for own keys.
+func ParseJWK(jwk JWK) (*Algorithm, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, ErrUnsupportedKey
+	}
+	algorithm := &Algorithm{
+		sign: func(unsignedToken []byte) ([]byte, error) {
+			return nil, ErrVerifyOnly
+		},
+	}
+	switch {
+	case jwk.Kty == "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 || e.Int64() < 3 {
+			return nil, ErrUnsupportedKey
+		}
+		key := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		algorithm.algorithm, algorithm.verify, algorithm.publicKey = "RS256", verifyRsaSha256(key), key
+	case jwk.Kty == "EC" && jwk.Crv == elliptic.P256().Params().Name:
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		algorithm.algorithm, algorithm.verify, algorithm.publicKey = "ES256", verifyEcdsaSha256(key), key
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		key := ed25519.PublicKey(x)
+		algorithm.algorithm, algorithm.verify, algorithm.publicKey = "EdDSA", verifyEd25519(key), key
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	// alg is optional, but if it is present it has to match the key
+	if jwk.Alg != "" && jwk.Alg != algorithm.algorithm {
+		return nil, ErrUnsupportedKey
+	}
+	return algorithm, nil
+}
+
+// PublicKeys returns the public key of the algorithm. KeySet is empty for symmetric algorithms.
+func (a *Algorithm) PublicKeys() KeySet {
+	keys := make([]JWK, 0, 1)
+	if jwk, ok := a.JWK(); ok {
+		keys = append(keys, jwk)
+	}
+	return KeySet{Keys: keys}
+}
+
+// JWK returns the public key of the algorithm, false if the algorithm is symmetric
+func (a *Algorithm) JWK() (JWK, bool) {
+	jwk := JWK{Use: "sig", Alg: a.algorithm}
+	switch key := a.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBigInt(key.N, 0)
+		jwk.E = encodeBigInt(big.NewInt(int64(key.E)), 0)
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = encodeBigInt(key.X, es256CoordinateSize)
+		jwk.Y = encodeBigInt(key.Y, es256CoordinateSize)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JWK{}, false
+	}
+	return jwk, true
+}
+
+// encodeBigInt as unsigned big-endian base64url value, padded to size if it is not 0
+func encodeBigInt(value *big.Int, size int) string {
+	bytes := value.Bytes()
+	if len(bytes) < size {
+		bytes = append(make([]byte, size-len(bytes)), bytes...)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// decodeBigInt from unsigned big-endian base64url value
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes) == 0 {
+		return nil, ErrUnsupportedKey
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
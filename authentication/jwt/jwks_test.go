@@ -0,0 +1,159 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication/jwt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestPublicKeysOfSymmetricAlgorithmIsEmpty(t *testing.T) {
+	encoded, err := json.Marshal(jwt.HmacSha256(secret).PublicKeys())
+
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"keys":[]}`, string(encoded))
+}
+
+func TestPublicKeysRSA(t *testing.T) {
+	// given
+	key := mustGenerateRSA(t)
+
+	// when
+	keySet := jwt.RsaSha256(key).PublicKeys()
+
+	// then
+	require.Len(t, keySet.Keys, 1)
+	jwk := keySet.Keys[0]
+	assert.Equal(t, "RSA", jwk.Kty)
+	assert.Equal(t, "sig", jwk.Use)
+	assert.Equal(t, "RS256", jwk.Alg)
+	assert.Equal(t, "AQAB", jwk.E)
+	publicKey := rsa.PublicKey{N: decodeBigInt(t, jwk.N), E: int(decodeBigInt(t, jwk.E).Int64())}
+	assert.Equal(t, key.PublicKey, publicKey)
+}
+
+func TestPublicKeysEC(t *testing.T) {
+	// given
+	key := mustGenerateEC(t)
+
+	// when
+	keySet := mustES256(t, key).PublicKeys()
+
+	// then
+	require.Len(t, keySet.Keys, 1)
+	jwk := keySet.Keys[0]
+	assert.Equal(t, "EC", jwk.Kty)
+	assert.Equal(t, "ES256", jwk.Alg)
+	assert.Equal(t, "P-256", jwk.Crv)
+	assert.Len(t, jwk.X, 43) // 32 bytes
+	assert.Len(t, jwk.Y, 43)
+	publicKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: decodeBigInt(t, jwk.X), Y: decodeBigInt(t, jwk.Y)}
+	assert.True(t, key.PublicKey.Equal(&publicKey))
+}
+
+func TestPublicKeysEd25519(t *testing.T) {
+	// given
+	key := mustGenerateEd25519(t)
+
+	// when
+	keySet := jwt.Ed25519(key).PublicKeys()
+
+	// then
+	require.Len(t, keySet.Keys, 1)
+	jwk := keySet.Keys[0]
+	assert.Equal(t, "OKP", jwk.Kty)
+	assert.Equal(t, "EdDSA", jwk.Alg)
+	assert.Equal(t, "Ed25519", jwk.Crv)
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	require.NoError(t, err)
+	assert.Equal(t, key.Public(), ed25519.PublicKey(x))
+}
+
+func TestParseJWK(t *testing.T) {
+	rsaKey, ecKey, edKey := generateKeys(t)
+	tests := []struct {
+		name      string
+		algorithm *jwt.Algorithm
+		other     *jwt.Algorithm
+	}{
+		{name: "RS256", algorithm: jwt.RsaSha256(rsaKey), other: jwt.RsaSha256(mustGenerateRSA(t))},
+		{name: "ES256", algorithm: mustES256(t, ecKey), other: mustES256(t, mustGenerateEC(t))},
+		{name: "EdDSA", algorithm: jwt.Ed25519(edKey), other: jwt.Ed25519(mustGenerateEd25519(t))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			jwk, ok := test.algorithm.JWK()
+			require.True(t, ok)
+			jwk.Alg = "" // optional in key sets of other issuers
+			claims := jwt.NewClaims()
+			claims.SetTime("exp", time.Now().Add(time.Hour))
+			claims["sub"] = "someone"
+			token, err := test.algorithm.Encode(claims)
+			require.NoError(t, err)
+			otherToken, err := test.other.Encode(claims)
+			require.NoError(t, err)
+
+			// when
+			verifier, err := jwt.ParseJWK(jwk)
+
+			// then
+			require.NoError(t, err)
+			decoded, err := verifier.DecodeAndValidate(token)
+			require.NoError(t, err)
+			assert.Equal(t, "someone", decoded["sub"])
+			assert.Error(t, verifier.Validate(otherToken))
+			_, err = verifier.Encode(claims)
+			assert.Error(t, err)
+			assert.Equal(t, test.algorithm.PublicKeys(), verifier.PublicKeys())
+		})
+	}
+}
+
+func TestParseJWKErrors(t *testing.T) {
+	rsaJWK, _ := jwt.RsaSha256(mustGenerateRSA(t)).JWK()
+	ecJWK, _ := mustES256(t, mustGenerateEC(t)).JWK()
+	tests := []struct {
+		name string
+		jwk  func() jwt.JWK
+	}{
+		{name: "symmetric key", jwk: func() jwt.JWK { return jwt.JWK{Kty: "oct"} }},
+		{name: "encryption key", jwk: func() jwt.JWK { jwk := rsaJWK; jwk.Use = "enc"; return jwk }},
+		{name: "other algorithm", jwk: func() jwt.JWK { jwk := rsaJWK; jwk.Alg = "RS512"; return jwk }},
+		{name: "malformed modulus", jwk: func() jwt.JWK { jwk := rsaJWK; jwk.N = "%"; return jwk }},
+		{name: "empty exponent", jwk: func() jwt.JWK { jwk := rsaJWK; jwk.E = ""; return jwk }},
+		{name: "other curve", jwk: func() jwt.JWK { jwk := ecJWK; jwk.Crv = "P-384"; return jwk }},
+		{name: "point not on curve", jwk: func() jwt.JWK { jwk := ecJWK; jwk.Y = jwk.X; return jwk }},
+		{name: "short Ed25519 key", jwk: func() jwt.JWK { return jwt.JWK{Kty: "OKP", Crv: "Ed25519", X: "AQAB"} }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := jwt.ParseJWK(test.jwk())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeySetFind(t *testing.T) {
+	keySet := jwt.KeySet{Keys: []jwt.JWK{{Kid: "first", Kty: "RSA"}, {Kid: "second", Kty: "EC"}}}
+
+	found, ok := keySet.Find("second")
+	require.True(t, ok)
+	assert.Equal(t, "EC", found.Kty)
+	_, ok = keySet.Find("third")
+	assert.False(t, ok)
+}
+
+func decodeBigInt(t *testing.T, encoded string) *big.Int {
+	bytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	return new(big.Int).SetBytes(bytes)
+}
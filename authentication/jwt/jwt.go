@@ -9,6 +9,13 @@ type Header struct {
 	Typ string `json:"typ"`
 	Alg string `json:"alg"`
 	Cty string `json:"cty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// Codec encodes and validates tokens. Implemented by a single Algorithm and by a KeyRing.
+type Codec interface {
+	Encode(payload Claims) (string, error)
+	DecodeAndValidate(encoded string) (Claims, error)
 }
 
 // Claims contains the claims of a jwt.
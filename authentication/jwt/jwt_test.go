@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go-spend/authentication/jwt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -87,3 +88,22 @@ func TestValidateExternalToken(t *testing.T) {
 	algorithm := jwt.HmacSha256("this-needs-a-test")
 	require.NoError(t, algorithm.Validate(token))
 }
+
+func TestAlgorithmConcurrentUse(t *testing.T) {
+	algorithm := jwt.HmacSha256(secret)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			claims := jwt.NewClaims()
+			claims["i"] = i
+			for j := 0; j < 50; j++ {
+				token, err := algorithm.Encode(claims)
+				require.NoError(t, err)
+				require.NoError(t, algorithm.Validate(token))
+			}
+		}(i)
+	}
+	wg.Wait()
+}
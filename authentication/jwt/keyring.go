@@ -0,0 +1,215 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyState defines what a key of a KeyRing can be used for
+type KeyState string
+
+const (
+	// KeyPending is published and accepted, but not used for signing yet, so that verifiers can fetch it in advance
+	KeyPending KeyState = "pending"
+	// KeyActive signs new tokens, there is always exactly one active key
+	KeyActive KeyState = "active"
+	// KeyInactive doesn't sign new tokens anymore, but is accepted until tokens signed with it expire
+	KeyInactive KeyState = "inactive"
+	// KeyRetired is neither published nor accepted
+	KeyRetired KeyState = "retired"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrNoActiveKey  = errors.New("key ring should have exactly one known active key")
+	ErrNoPendingKey = errors.New("key ring has no pending key to activate")
+	ErrEmptyKeyRing = errors.New("key ring has no keys")
+)
+
+// KeyStatus describes a key of a KeyRing and since when it is in its state
+type KeyStatus struct {
+	ID    string
+	State KeyState
+	Since time.Time
+}
+
+// KeyRing signs tokens with its active key, putting the ID of the key into kid header. Tokens signed with any
+// non-retired key are accepted. Keys are promoted in the order of their IDs, see Rotate.
+type KeyRing struct {
+	mutex      sync.RWMutex
+	ids        []string
+	algorithms map[string]*Algorithm
+	statuses   map[string]KeyStatus
+}
+
+// NewKeyRing creates a KeyRing with the first key by ID active and the rest pending
+func NewKeyRing(algorithms map[string]*Algorithm) (*KeyRing, error) {
+	if len(algorithms) == 0 {
+		return nil, ErrEmptyKeyRing
+	}
+	ids := make([]string, 0, len(algorithms))
+	for id := range algorithms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	now := time.Now()
+	statuses := make(map[string]KeyStatus, len(ids))
+	for i, id := range ids {
+		state := KeyPending
+		if i == 0 {
+			state = KeyActive
+		}
+		statuses[id] = KeyStatus{ID: id, State: state, Since: now}
+	}
+	return &KeyRing{ids: ids, algorithms: algorithms, statuses: statuses}, nil
+}
+
+// LoadKeyRing creates a KeyRing from all *.pem private keys in the directory. The name of a file without
+// the extension is the ID of its key.
+func LoadKeyRing(dir string) (*KeyRing, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	algorithms := make(map[string]*Algorithm, len(paths))
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		algorithm, err := ParsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse key %s - %w", id, err)
+		}
+		algorithms[id] = algorithm
+	}
+	return NewKeyRing(algorithms)
+}
+
+// Encode returns a token signed with the active key
+func (k *KeyRing) Encode(payload Claims) (string, error) {
+	k.mutex.RLock()
+	id := k.activeID()
+	algorithm := k.algorithms[id]
+	k.mutex.RUnlock()
+	return algorithm.EncodeWithKeyID(id, payload)
+}
+
+// DecodeAndValidate finds the key from kid header of the token and validates the token with it
+func (k *KeyRing) DecodeAndValidate(encoded string) (Claims, error) {
+	header, err := DecodeHeader(encoded)
+	if err != nil {
+		return nil, err
+	}
+	k.mutex.RLock()
+	status, ok := k.statuses[header.Kid]
+	algorithm := k.algorithms[header.Kid]
+	k.mutex.RUnlock()
+	if !ok || status.State == KeyRetired {
+		return nil, ErrUnknownKey
+	}
+	return algorithm.DecodeAndValidate(encoded)
+}
+
+// PublicKeys returns public keys of all non-retired keys
+func (k *KeyRing) PublicKeys() KeySet {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	keys := make([]JWK, 0, len(k.ids))
+	for _, id := range k.ids {
+		if k.statuses[id].State == KeyRetired {
+			continue
+		}
+		if jwk, ok := k.algorithms[id].JWK(); ok {
+			jwk.Kid = id
+			keys = append(keys, jwk)
+		}
+	}
+	return KeySet{Keys: keys}
+}
+
+// Statuses of all keys in the order of their IDs
+func (k *KeyRing) Statuses() []KeyStatus {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	statuses := make([]KeyStatus, 0, len(k.ids))
+	for _, id := range k.ids {
+		statuses = append(statuses, k.statuses[id])
+	}
+	return statuses
+}
+
+// SetStatuses replaces statuses of the keys, for example with the ones shared by other instances. Statuses of
+// unknown keys are ignored, keys without a status become pending. Exactly one known key should be active,
+// otherwise ErrNoActiveKey is returned and nothing is changed.
+func (k *KeyRing) SetStatuses(statuses []KeyStatus) error {
+	now := time.Now()
+	updated := make(map[string]KeyStatus, len(k.ids))
+	for _, id := range k.ids {
+		updated[id] = KeyStatus{ID: id, State: KeyPending, Since: now}
+	}
+	active := 0
+	for _, status := range statuses {
+		if _, ok := k.algorithms[status.ID]; !ok {
+			continue
+		}
+		if status.State == KeyActive {
+			active++
+		}
+		updated[status.ID] = status
+	}
+	if active != 1 {
+		return ErrNoActiveKey
+	}
+	k.mutex.Lock()
+	k.statuses = updated
+	k.mutex.Unlock()
+	return nil
+}
+
+// Rotate retires inactive keys that are inactive longer than gracePeriod, which should be at least the lifetime of
+// tokens. Then the first pending key becomes active and the active key becomes inactive. If there is no pending key
+// ErrNoPendingKey is returned, expired keys are retired anyway.
+func (k *KeyRing) Rotate(now time.Time, gracePeriod time.Duration) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	for id, status := range k.statuses {
+		if status.State == KeyInactive && !status.Since.Add(gracePeriod).After(now) {
+			k.statuses[id] = KeyStatus{ID: id, State: KeyRetired, Since: now}
+		}
+	}
+	for _, id := range k.ids {
+		if k.statuses[id].State != KeyPending {
+			continue
+		}
+		activeID := k.activeID()
+		k.statuses[activeID] = KeyStatus{ID: activeID, State: KeyInactive, Since: now}
+		k.statuses[id] = KeyStatus{ID: id, State: KeyActive, Since: now}
+		return nil
+	}
+	return ErrNoPendingKey
+}
+
+// Active returns status of the key that signs new tokens
+func (k *KeyRing) Active() KeyStatus {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.statuses[k.activeID()]
+}
+
+// activeID should be called holding the mutex
+func (k *KeyRing) activeID() string {
+	for _, id := range k.ids {
+		if k.statuses[id].State == KeyActive {
+			return id
+		}
+	}
+	return "" // unreachable, the ring always has an active key
+}
@@ -0,0 +1,268 @@
+package jwt_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication/jwt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewKeyRing(t *testing.T) {
+	// when
+	keyRing := newTestKeyRing(t)
+
+	// then
+	statuses := keyRing.Statuses()
+	require.Len(t, statuses, 3)
+	assert.Equal(t, "a", statuses[0].ID)
+	assert.Equal(t, jwt.KeyActive, statuses[0].State)
+	assert.Equal(t, "b", statuses[1].ID)
+	assert.Equal(t, jwt.KeyPending, statuses[1].State)
+	assert.Equal(t, "c", statuses[2].ID)
+	assert.Equal(t, jwt.KeyPending, statuses[2].State)
+	assert.Equal(t, "a", keyRing.Active().ID)
+}
+
+func TestNewKeyRingWithoutKeys(t *testing.T) {
+	_, err := jwt.NewKeyRing(map[string]*jwt.Algorithm{})
+	assert.Equal(t, jwt.ErrEmptyKeyRing, err)
+}
+
+func TestKeyRingEncodeWithActiveKey(t *testing.T) {
+	// given
+	keyRing := newTestKeyRing(t)
+
+	// when
+	token, err := keyRing.Encode(jwt.NewClaims())
+
+	// then
+	require.NoError(t, err)
+	header, err := jwt.DecodeHeader(token)
+	require.NoError(t, err)
+	assert.Equal(t, "a", header.Kid)
+	assert.NoError(t, jwt.HmacSha256("secret a").Validate(token))
+	_, err = keyRing.DecodeAndValidate(token)
+	assert.NoError(t, err)
+}
+
+func TestKeyRingRotate(t *testing.T) {
+	// given
+	keyRing := newTestKeyRing(t)
+	firstToken, err := keyRing.Encode(jwt.NewClaims())
+	require.NoError(t, err)
+	now := time.Now()
+
+	// when
+	require.NoError(t, keyRing.Rotate(now, time.Hour))
+
+	// then
+	statuses := keyRing.Statuses()
+	assert.Equal(t, jwt.KeyStatus{ID: "a", State: jwt.KeyInactive, Since: now}, statuses[0])
+	assert.Equal(t, jwt.KeyStatus{ID: "b", State: jwt.KeyActive, Since: now}, statuses[1])
+	assert.Equal(t, jwt.KeyPending, statuses[2].State)
+	secondToken, err := keyRing.Encode(jwt.NewClaims())
+	require.NoError(t, err)
+	header, err := jwt.DecodeHeader(secondToken)
+	require.NoError(t, err)
+	assert.Equal(t, "b", header.Kid)
+	_, err = keyRing.DecodeAndValidate(firstToken)
+	assert.NoError(t, err)
+
+	// when grace period is not over
+	require.NoError(t, keyRing.Rotate(now.Add(30*time.Minute), time.Hour))
+
+	// then
+	assert.Equal(t, jwt.KeyInactive, keyRing.Statuses()[0].State)
+	assert.Equal(t, jwt.KeyInactive, keyRing.Statuses()[1].State)
+	assert.Equal(t, "c", keyRing.Active().ID)
+
+	// when grace period of the first key is over
+	err = keyRing.Rotate(now.Add(time.Hour), time.Hour)
+
+	// then
+	assert.Equal(t, jwt.ErrNoPendingKey, err)
+	assert.Equal(t, jwt.KeyRetired, keyRing.Statuses()[0].State)
+	assert.Equal(t, jwt.KeyInactive, keyRing.Statuses()[1].State)
+	assert.Equal(t, "c", keyRing.Active().ID)
+	_, err = keyRing.DecodeAndValidate(firstToken)
+	assert.Equal(t, jwt.ErrUnknownKey, err)
+	_, err = keyRing.DecodeAndValidate(secondToken)
+	assert.NoError(t, err)
+}
+
+func TestKeyRingAcceptsPendingKey(t *testing.T) {
+	// given
+	keyRing := newTestKeyRing(t)
+	token, err := jwt.HmacSha256("secret c").EncodeWithKeyID("c", jwt.NewClaims())
+	require.NoError(t, err)
+
+	// when
+	_, err = keyRing.DecodeAndValidate(token)
+
+	// then
+	assert.NoError(t, err)
+}
+
+func TestKeyRingDecodeAndValidateErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm *jwt.Algorithm
+		kid       string
+	}{
+		{
+			name:      "no kid",
+			algorithm: jwt.HmacSha256("secret a"),
+			kid:       "",
+		},
+		{
+			name:      "unknown kid",
+			algorithm: jwt.HmacSha256("secret a"),
+			kid:       "d",
+		},
+		{
+			name:      "signed with other key",
+			algorithm: jwt.HmacSha256("secret b"),
+			kid:       "a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			keyRing := newTestKeyRing(t)
+			token, err := test.algorithm.EncodeWithKeyID(test.kid, jwt.NewClaims())
+			require.NoError(t, err)
+
+			// when
+			_, err = keyRing.DecodeAndValidate(token)
+
+			// then
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeyRingSetStatuses(t *testing.T) {
+	// given
+	keyRing := newTestKeyRing(t)
+	since := time.Unix(1000, 0)
+
+	// when
+	err := keyRing.SetStatuses([]jwt.KeyStatus{
+		{ID: "a", State: jwt.KeyRetired, Since: since},
+		{ID: "c", State: jwt.KeyActive, Since: since},
+		{ID: "unknown", State: jwt.KeyInactive, Since: since},
+	})
+
+	// then
+	require.NoError(t, err)
+	statuses := keyRing.Statuses()
+	assert.Equal(t, jwt.KeyStatus{ID: "a", State: jwt.KeyRetired, Since: since}, statuses[0])
+	assert.Equal(t, jwt.KeyPending, statuses[1].State)
+	assert.Equal(t, jwt.KeyStatus{ID: "c", State: jwt.KeyActive, Since: since}, statuses[2])
+}
+
+func TestKeyRingSetStatusesWithoutActiveKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []jwt.KeyStatus
+	}{
+		{
+			name:     "no active",
+			statuses: []jwt.KeyStatus{{ID: "a", State: jwt.KeyInactive}},
+		},
+		{
+			name:     "unknown active",
+			statuses: []jwt.KeyStatus{{ID: "d", State: jwt.KeyActive}},
+		},
+		{
+			name:     "two active",
+			statuses: []jwt.KeyStatus{{ID: "a", State: jwt.KeyActive}, {ID: "b", State: jwt.KeyActive}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			keyRing := newTestKeyRing(t)
+			before := keyRing.Statuses()
+
+			// when
+			err := keyRing.SetStatuses(test.statuses)
+
+			// then
+			assert.Equal(t, jwt.ErrNoActiveKey, err)
+			assert.Equal(t, before, keyRing.Statuses())
+		})
+	}
+}
+
+func TestKeyRingPublicKeys(t *testing.T) {
+	// given
+	first := jwt.Ed25519(mustGenerateEd25519(t))
+	second := jwt.Ed25519(mustGenerateEd25519(t))
+	third := jwt.Ed25519(mustGenerateEd25519(t))
+	keyRing, err := jwt.NewKeyRing(map[string]*jwt.Algorithm{"1": first, "2": second, "3": third})
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, keyRing.Rotate(now, 0))
+	require.NoError(t, keyRing.Rotate(now, 0))
+
+	// when
+	keySet := keyRing.PublicKeys()
+
+	// then
+	secondJWK, _ := second.JWK()
+	secondJWK.Kid = "2"
+	thirdJWK, _ := third.JWK()
+	thirdJWK.Kid = "3"
+	assert.Equal(t, []jwt.JWK{secondJWK, thirdJWK}, keySet.Keys)
+}
+
+func TestLoadKeyRing(t *testing.T) {
+	// given
+	dir := t.TempDir()
+	for _, id := range []string{"2021-02", "2021-01"} {
+		bytes, err := x509.MarshalPKCS8PrivateKey(mustGenerateEd25519(t))
+		require.NoError(t, err)
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: bytes})
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, id+".pem"), data, 0600))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a key"), 0600))
+
+	// when
+	keyRing, err := jwt.LoadKeyRing(dir)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "2021-01", keyRing.Active().ID)
+	assert.Len(t, keyRing.PublicKeys().Keys, 2)
+}
+
+func TestLoadKeyRingErrors(t *testing.T) {
+	// given
+	emptyDir := t.TempDir()
+	dirWithBrokenKey := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dirWithBrokenKey, "a.pem"), []byte("not a key"), 0600))
+
+	// when
+	_, emptyErr := jwt.LoadKeyRing(emptyDir)
+	_, brokenErr := jwt.LoadKeyRing(dirWithBrokenKey)
+
+	// then
+	assert.Equal(t, jwt.ErrEmptyKeyRing, emptyErr)
+	assert.Error(t, brokenErr)
+}
+
+func newTestKeyRing(t *testing.T) *jwt.KeyRing {
+	keyRing, err := jwt.NewKeyRing(map[string]*jwt.Algorithm{
+		"c": jwt.HmacSha256("secret c"),
+		"a": jwt.HmacSha256("secret a"),
+		"b": jwt.HmacSha256("secret b"),
+	})
+	require.NoError(t, err)
+	return keyRing
+}
@@ -0,0 +1,164 @@
+package authentication
+
+import (
+	"fmt"
+	"github.com/go-redis/redis"
+	"go-spend/authentication/jwt"
+	"go-spend/log"
+	"go-spend/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const keyStatusesKey = "access_key_statuses"
+
+// KeyStatusStorage shares statuses of signing keys between instances of the application
+type KeyStatusStorage interface {
+	// UpdateKeyStatuses calls update with the stored statuses and atomically stores the result. Nothing is stored
+	// if update returns nil.
+	UpdateKeyStatuses(update func(stored []jwt.KeyStatus) ([]jwt.KeyStatus, error)) error
+}
+
+// RedisKeyStatusStorage keeps statuses in a hash, with key ID as a field and "state_since" as a value
+type RedisKeyStatusStorage struct {
+	redis redis.UniversalClient
+}
+
+// NewRedisKeyStatusStorage creates new instance of RedisKeyStatusStorage
+func NewRedisKeyStatusStorage(redis redis.UniversalClient) *RedisKeyStatusStorage {
+	return &RedisKeyStatusStorage{redis: redis}
+}
+
+// UpdateKeyStatuses watches the hash, so the update fails with redis.TxFailedErr if another instance changed
+// statuses in the meantime
+func (s *RedisKeyStatusStorage) UpdateKeyStatuses(update func([]jwt.KeyStatus) ([]jwt.KeyStatus, error)) error {
+	return s.redis.Watch(func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(keyStatusesKey).Result()
+		if err != nil {
+			return err
+		}
+		stored := make([]jwt.KeyStatus, 0, len(values))
+		for id, value := range values {
+			status, err := parseKeyStatus(id, value)
+			if err != nil {
+				return err
+			}
+			stored = append(stored, status)
+		}
+		updated, err := update(stored)
+		if err != nil || updated == nil {
+			return err
+		}
+		fields := make(map[string]interface{}, len(updated))
+		for _, status := range updated {
+			fields[status.ID] = fmt.Sprintf("%s_%d", status.State, status.Since.Unix())
+		}
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.Del(keyStatusesKey)
+			pipe.HMSet(keyStatusesKey, fields)
+			return nil
+		})
+		return err
+	}, keyStatusesKey)
+}
+
+// MemoryKeyStatusStorage keeps statuses in memory of the instance, for deployments without redis where there are no
+// other instances to share them with
+type MemoryKeyStatusStorage struct {
+	mutex    sync.Mutex
+	statuses []jwt.KeyStatus
+}
+
+// NewMemoryKeyStatusStorage creates new instance of MemoryKeyStatusStorage
+func NewMemoryKeyStatusStorage() *MemoryKeyStatusStorage {
+	return &MemoryKeyStatusStorage{}
+}
+
+// UpdateKeyStatuses holds a lock during the update. Times are stored with a precision of seconds, like in redis.
+func (s *MemoryKeyStatusStorage) UpdateKeyStatuses(update func([]jwt.KeyStatus) ([]jwt.KeyStatus, error)) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored := make([]jwt.KeyStatus, len(s.statuses))
+	copy(stored, s.statuses)
+	updated, err := update(stored)
+	if err != nil || updated == nil {
+		return err
+	}
+	s.statuses = make([]jwt.KeyStatus, len(updated))
+	for i, status := range updated {
+		status.Since = time.Unix(status.Since.Unix(), 0)
+		s.statuses[i] = status
+	}
+	return nil
+}
+
+func parseKeyStatus(id string, value string) (jwt.KeyStatus, error) {
+	parts := strings.Split(value, "_")
+	if len(parts) != 2 {
+		return jwt.KeyStatus{}, ErrIncorrectValue
+	}
+	since, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return jwt.KeyStatus{}, ErrIncorrectValue
+	}
+	return jwt.KeyStatus{ID: id, State: jwt.KeyState(parts[0]), Since: time.Unix(since, 0)}, nil
+}
+
+// KeyRotator keeps statuses of keys in a KeyRing the same on all instances and rotates the keys on schedule.
+// Whichever instance notices first that the active key is older than the rotation period does the rotation,
+// the others pick it up during the next Sync. Pending keys are accepted everywhere, so tokens signed with a newly
+// activated key are valid even on instances that haven't synced yet.
+type KeyRotator struct {
+	keyRing        *jwt.KeyRing
+	storage        KeyStatusStorage
+	rotationPeriod time.Duration
+	gracePeriod    time.Duration
+}
+
+// NewKeyRotator creates new instance of KeyRotator. Zero rotationPeriod disables rotation, statuses are still
+// shared. Inactive keys are retired once access tokens signed with them expire.
+func NewKeyRotator(keyRing *jwt.KeyRing, storage KeyStatusStorage, rotationPeriod time.Duration) *KeyRotator {
+	return &KeyRotator{
+		keyRing:        keyRing,
+		storage:        storage,
+		rotationPeriod: rotationPeriod,
+		gracePeriod:    accessTokenExpiration,
+	}
+}
+
+// Sync applies stored statuses to the key ring, rotating keys first if it is time. The first instance stores
+// the initial statuses of its key ring.
+func (r *KeyRotator) Sync(now time.Time) error {
+	return r.storage.UpdateKeyStatuses(func(stored []jwt.KeyStatus) ([]jwt.KeyStatus, error) {
+		changed := len(stored) == 0
+		if !changed {
+			if err := r.keyRing.SetStatuses(stored); err != nil {
+				return nil, err
+			}
+		}
+		if r.rotationPeriod > 0 && !r.keyRing.Active().Since.Add(r.rotationPeriod).After(now) {
+			err := r.keyRing.Rotate(now, r.gracePeriod)
+			if err == jwt.ErrNoPendingKey {
+				log.Warn("signing key %s should be rotated, but there is no pending key", r.keyRing.Active().ID)
+			} else if err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+		if !changed {
+			return nil, nil
+		}
+		return r.keyRing.Statuses(), nil
+	})
+}
+
+// Start syncing every interval in background until the returned function is called, it can be called more than once
+func (r *KeyRotator) Start(interval time.Duration) (stop func()) {
+	return util.StartJanitor(interval, func() {
+		if err := r.Sync(time.Now()); err != nil {
+			log.Warn("couldn't sync signing keys - %s", err)
+		}
+	})
+}
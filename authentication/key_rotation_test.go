@@ -0,0 +1,207 @@
+package authentication_test
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/authentication/jwt"
+	"testing"
+	"time"
+)
+
+type mockKeyStatusStorage struct {
+	mock.Mock
+}
+
+func (m *mockKeyStatusStorage) UpdateKeyStatuses(update func([]jwt.KeyStatus) ([]jwt.KeyStatus, error)) error {
+	args := m.Called(update)
+	return args.Error(0)
+}
+
+func TestKeyRotatorSyncStoresInitialStatuses(t *testing.T) {
+	clearRedis()
+	// given
+	keyRing := newTestKeyRing(t)
+	rotator := authentication.NewKeyRotator(keyRing, authentication.NewRedisKeyStatusStorage(redisClient), time.Hour)
+
+	// when
+	err := rotator.Sync(time.Now())
+
+	// then
+	require.NoError(t, err)
+	stored, err := redisClient.HGetAll("access_key_statuses").Result()
+	require.NoError(t, err)
+	assert.Len(t, stored, 3)
+	assert.Regexp(t, "^active_[0-9]+$", stored["a"])
+	assert.Regexp(t, "^pending_[0-9]+$", stored["b"])
+	assert.Regexp(t, "^pending_[0-9]+$", stored["c"])
+}
+
+func TestKeyRotatorSyncSharesRotation(t *testing.T) {
+	clearRedis()
+	// given
+	storage := authentication.NewRedisKeyStatusStorage(redisClient)
+	firstRing := newTestKeyRing(t)
+	secondRing := newTestKeyRing(t)
+	first := authentication.NewKeyRotator(firstRing, storage, time.Hour)
+	second := authentication.NewKeyRotator(secondRing, storage, time.Hour)
+	now := time.Now()
+	require.NoError(t, first.Sync(now))
+	require.NoError(t, second.Sync(now))
+
+	// when
+	require.NoError(t, first.Sync(now.Add(2*time.Hour)))
+
+	// then
+	assert.Equal(t, "b", firstRing.Active().ID)
+	assert.Equal(t, "a", secondRing.Active().ID)
+	tokenOfNewKey, err := firstRing.Encode(jwt.NewClaims())
+	require.NoError(t, err)
+	_, err = secondRing.DecodeAndValidate(tokenOfNewKey)
+	assert.NoError(t, err, "newly activated key is still pending for instances that haven't synced")
+
+	// when
+	require.NoError(t, second.Sync(now.Add(2*time.Hour)))
+
+	// then
+	assert.Equal(t, "b", secondRing.Active().ID)
+	assert.Equal(t, jwt.KeyInactive, secondRing.Statuses()[0].State)
+}
+
+func TestKeyRotatorSyncWithoutRotation(t *testing.T) {
+	clearRedis()
+	// given
+	keyRing := newTestKeyRing(t)
+	rotator := authentication.NewKeyRotator(keyRing, authentication.NewRedisKeyStatusStorage(redisClient), 0)
+	now := time.Now()
+	require.NoError(t, rotator.Sync(now))
+
+	// when
+	err := rotator.Sync(now.Add(1000 * time.Hour))
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "a", keyRing.Active().ID)
+}
+
+func TestKeyRotatorSyncRetiresExpiredKeys(t *testing.T) {
+	clearRedis()
+	// given
+	keyRing := newTestKeyRing(t)
+	rotator := authentication.NewKeyRotator(keyRing, authentication.NewRedisKeyStatusStorage(redisClient), time.Hour)
+	now := time.Now()
+	require.NoError(t, rotator.Sync(now))
+	require.NoError(t, rotator.Sync(now.Add(time.Hour)))
+	require.NoError(t, rotator.Sync(now.Add(2*time.Hour)))
+
+	// when
+	err := rotator.Sync(now.Add(3 * time.Hour))
+
+	// then
+	require.NoError(t, err)
+	statuses := keyRing.Statuses()
+	assert.Equal(t, jwt.KeyRetired, statuses[0].State)
+	assert.Equal(t, jwt.KeyRetired, statuses[1].State)
+	assert.Equal(t, jwt.KeyActive, statuses[2].State)
+}
+
+func TestKeyRotatorSyncReturnsStorageError(t *testing.T) {
+	// given
+	storage := new(mockKeyStatusStorage)
+	rotator := authentication.NewKeyRotator(newTestKeyRing(t), storage, time.Hour)
+	expectedErr := errors.New("expected")
+	storage.On("UpdateKeyStatuses", mock.Anything).Return(expectedErr)
+
+	// when
+	err := rotator.Sync(time.Now())
+
+	// then
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestKeyRotatorSyncWithUnknownActiveKey(t *testing.T) {
+	clearRedis()
+	// given
+	require.NoError(t, redisClient.HSet("access_key_statuses", "z", "active_1000").Err())
+	rotator := authentication.NewKeyRotator(
+		newTestKeyRing(t),
+		authentication.NewRedisKeyStatusStorage(redisClient),
+		time.Hour,
+	)
+
+	// when
+	err := rotator.Sync(time.Now())
+
+	// then
+	assert.Equal(t, jwt.ErrNoActiveKey, err)
+}
+
+func TestKeyRotatorStartSyncsUntilStopped(t *testing.T) {
+	// given
+	storage := new(mockKeyStatusStorage)
+	synced := make(chan struct{}, 1)
+	storage.On("UpdateKeyStatuses", mock.Anything).
+		Run(func(mock.Arguments) {
+			select {
+			case synced <- struct{}{}:
+			default:
+			}
+		}).
+		Return(nil)
+	rotator := authentication.NewKeyRotator(newTestKeyRing(t), storage, time.Hour)
+
+	// when
+	stop := rotator.Start(time.Millisecond)
+
+	// then
+	select {
+	case <-synced:
+	case <-time.After(time.Second):
+		assert.Fail(t, "keys weren't synced")
+	}
+	assert.NotPanics(t, func() {
+		stop()
+		stop()
+	})
+}
+
+func newTestKeyRing(t *testing.T) *jwt.KeyRing {
+	keyRing, err := jwt.NewKeyRing(map[string]*jwt.Algorithm{
+		"a": jwt.HmacSha256("secret a"),
+		"b": jwt.HmacSha256("secret b"),
+		"c": jwt.HmacSha256("secret c"),
+	})
+	require.NoError(t, err)
+	return keyRing
+}
+
+func TestMemoryKeyStatusStorage(t *testing.T) {
+	// given
+	storage := authentication.NewMemoryKeyStatusStorage()
+	since := time.Now()
+	status := jwt.KeyStatus{ID: "a", State: jwt.KeyActive, Since: since}
+	require.NoError(t, storage.UpdateKeyStatuses(func(stored []jwt.KeyStatus) ([]jwt.KeyStatus, error) {
+		assert.Empty(t, stored)
+		return []jwt.KeyStatus{status}, nil
+	}))
+
+	// when
+	require.NoError(t, storage.UpdateKeyStatuses(func(stored []jwt.KeyStatus) ([]jwt.KeyStatus, error) {
+		return nil, nil
+	}))
+	err := storage.UpdateKeyStatuses(func(stored []jwt.KeyStatus) ([]jwt.KeyStatus, error) {
+		return []jwt.KeyStatus{{ID: "b", State: jwt.KeyPending, Since: since}}, errors.New("update failed")
+	})
+
+	// then
+	assert.Error(t, err)
+	require.NoError(t, storage.UpdateKeyStatuses(func(stored []jwt.KeyStatus) ([]jwt.KeyStatus, error) {
+		require.Len(t, stored, 1)
+		assert.Equal(t, "a", stored[0].ID)
+		assert.Equal(t, jwt.KeyActive, stored[0].State)
+		assert.True(t, time.Unix(since.Unix(), 0).Equal(stored[0].Since))
+		return nil, nil
+	}))
+}
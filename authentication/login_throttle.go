@@ -0,0 +1,306 @@
+package authentication
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis"
+	"go-spend/expenses"
+	"go-spend/log"
+	"go-spend/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBackoffShift keeps doubling of the delay from overflowing, the lockout duration is reached much earlier anyway
+	maxBackoffShift = 30
+	// blockedValue is stored in the key of a blocked email or IP, only the expiration of the key matters
+	blockedValue = "1"
+)
+
+// TooManyAttemptsError is returned instead of checking credentials while the email or the IP of the client have to
+// wait after failed attempts
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many failed authentication attempts, retry after %s", e.RetryAfter)
+}
+
+// BackoffPolicy defines how long to wait before the next attempt after failed ones
+type BackoffPolicy struct {
+	// FreeAttempts can fail without any delay
+	FreeAttempts uint
+	// Delay after the first failure over FreeAttempts, it doubles with every next failure
+	Delay time.Duration
+	// LockoutAfter failures the next attempt is possible only after LockoutDuration. Failures are forgotten
+	// LockoutDuration after the last one.
+	LockoutAfter    uint
+	LockoutDuration time.Duration
+}
+
+// delay after the failure with the number failures
+func (p BackoffPolicy) delay(failures uint) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	shift := failures - p.FreeAttempts - 1
+	if shift > maxBackoffShift || p.Delay<<shift > p.LockoutDuration {
+		return p.LockoutDuration
+	}
+	return p.Delay << shift
+}
+
+// FailedAttemptsClearer forgets failed authentication attempts of an email
+type FailedAttemptsClearer interface {
+	Clear(email expenses.Email)
+}
+
+// FailedAttemptsRecorder records failed authentication attempts and forgets them after a successful one
+type FailedAttemptsRecorder interface {
+	FailedAttemptsClearer
+	// Fail records a failed attempt
+	Fail(email expenses.Email, ip string)
+}
+
+// LoginThrottler tracks failed authentication attempts per email and per client IP
+type LoginThrottler interface {
+	FailedAttemptsRecorder
+	// Wait returns how long the client has to wait before the next attempt, zero if it can try right away
+	Wait(email expenses.Email, ip string) time.Duration
+}
+
+// RedisLoginThrottler is a LoginThrottler that uses redis as a storage. Emails and IPs have separate policies, so that
+// many users behind the same NAT don't lock each other out too early.
+// It is tolerant like RedisRateLimiter and lets clients try when something went wrong while trying to access redis.
+type RedisLoginThrottler struct {
+	emailPolicy BackoffPolicy
+	ipPolicy    BackoffPolicy
+	redis       redis.UniversalClient
+}
+
+// NewRedisLoginThrottler creates new instance of RedisLoginThrottler
+func NewRedisLoginThrottler(
+	emailPolicy BackoffPolicy,
+	ipPolicy BackoffPolicy,
+	redis redis.UniversalClient,
+) *RedisLoginThrottler {
+	return &RedisLoginThrottler{emailPolicy: emailPolicy, ipPolicy: ipPolicy, redis: redis}
+}
+
+func (t *RedisLoginThrottler) Wait(email expenses.Email, ip string) time.Duration {
+	var ttls []*redis.DurationCmd
+	_, err := t.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range throttleKeys(email, ip) {
+			ttls = append(ttls, pipe.PTTL(loginBlockedKey(key)))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn("failed to check failed authentication attempts of %s - %s", email, err)
+		return 0
+	}
+	var wait time.Duration
+	for _, ttl := range ttls {
+		if ttl.Val() > wait {
+			wait = ttl.Val()
+		}
+	}
+	return wait
+}
+
+// Fail starts a delay of the email and the IP if they failed more than their free attempts
+func (t *RedisLoginThrottler) Fail(email expenses.Email, ip string) {
+	t.fail(emailThrottleKey(email), t.emailPolicy)
+	if ip != "" {
+		t.fail(ipThrottleKey(ip), t.ipPolicy)
+	}
+}
+
+func (t *RedisLoginThrottler) fail(key string, policy BackoffPolicy) {
+	if err := t.incrementFailures(key, policy); err != nil {
+		log.Warn("failed to record failed authentication attempt of %s - %s", key, err)
+	}
+}
+
+func (t *RedisLoginThrottler) incrementFailures(key string, policy BackoffPolicy) error {
+	failuresKey := loginFailuresKey(key)
+	var failures *redis.IntCmd
+	_, err := t.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(failuresKey)
+		pipe.Expire(failuresKey, policy.LockoutDuration)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	delay := policy.delay(uint(failures.Val()))
+	if delay == 0 {
+		return nil
+	}
+	return t.redis.Set(loginBlockedKey(key), blockedValue, delay).Err()
+}
+
+// Clear doesn't touch the IP, otherwise anyone with one valid account could keep guessing passwords of others
+func (t *RedisLoginThrottler) Clear(email expenses.Email) {
+	key := emailThrottleKey(email)
+	if err := t.redis.Del(loginFailuresKey(key), loginBlockedKey(key)).Err(); err != nil {
+		log.Warn("failed to clear failed authentication attempts of %s - %s", email, err)
+	}
+}
+
+// MemoryLoginThrottler is a LoginThrottler that keeps failed attempts in memory of the instance, for deployments
+// without redis. Forgotten failures are removed by a janitor goroutine until Stop.
+type MemoryLoginThrottler struct {
+	emailPolicy BackoffPolicy
+	ipPolicy    BackoffPolicy
+	mutex       sync.Mutex
+	attempts    map[string]*failedAttempts
+	stop        func()
+}
+
+type failedAttempts struct {
+	failures uint
+	// forgetAt is when failures are forgotten, LockoutDuration after the last one
+	forgetAt     time.Time
+	blockedUntil time.Time
+}
+
+// NewMemoryLoginThrottler creates new instance of MemoryLoginThrottler that removes forgotten failures every
+// janitorInterval
+func NewMemoryLoginThrottler(
+	emailPolicy BackoffPolicy,
+	ipPolicy BackoffPolicy,
+	janitorInterval time.Duration,
+) *MemoryLoginThrottler {
+	t := &MemoryLoginThrottler{
+		emailPolicy: emailPolicy,
+		ipPolicy:    ipPolicy,
+		attempts:    make(map[string]*failedAttempts),
+	}
+	t.stop = util.StartJanitor(janitorInterval, t.removeForgotten)
+	return t
+}
+
+func (t *MemoryLoginThrottler) Wait(email expenses.Email, ip string) time.Duration {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var wait time.Duration
+	for _, key := range throttleKeys(email, ip) {
+		if attempts, ok := t.attempts[key]; ok && attempts.blockedUntil.Sub(now) > wait {
+			wait = attempts.blockedUntil.Sub(now)
+		}
+	}
+	return wait
+}
+
+// Fail starts a delay of the email and the IP if they failed more than their free attempts
+func (t *MemoryLoginThrottler) Fail(email expenses.Email, ip string) {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.fail(emailThrottleKey(email), t.emailPolicy, now)
+	if ip != "" {
+		t.fail(ipThrottleKey(ip), t.ipPolicy, now)
+	}
+}
+
+func (t *MemoryLoginThrottler) fail(key string, policy BackoffPolicy, now time.Time) {
+	attempts, ok := t.attempts[key]
+	if !ok || !attempts.forgetAt.After(now) {
+		attempts = &failedAttempts{blockedUntil: now}
+		t.attempts[key] = attempts
+	}
+	attempts.failures++
+	attempts.forgetAt = now.Add(policy.LockoutDuration)
+	if delay := policy.delay(attempts.failures); delay > 0 {
+		attempts.blockedUntil = now.Add(delay)
+	}
+}
+
+// Clear doesn't touch the IP, otherwise anyone with one valid account could keep guessing passwords of others
+func (t *MemoryLoginThrottler) Clear(email expenses.Email) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.attempts, emailThrottleKey(email))
+}
+
+// Stop the janitor
+func (t *MemoryLoginThrottler) Stop() {
+	t.stop()
+}
+
+func (t *MemoryLoginThrottler) removeForgotten() {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for key, attempts := range t.attempts {
+		if !attempts.forgetAt.After(now) && !attempts.blockedUntil.After(now) {
+			delete(t.attempts, key)
+		}
+	}
+}
+
+func throttleKeys(email expenses.Email, ip string) []string {
+	keys := []string{emailThrottleKey(email)}
+	if ip != "" {
+		keys = append(keys, ipThrottleKey(ip))
+	}
+	return keys
+}
+
+func emailThrottleKey(email expenses.Email) string {
+	return "email_" + strings.ToLower(string(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip_" + ip
+}
+
+func loginFailuresKey(key string) string {
+	return "login_failures_" + key
+}
+
+func loginBlockedKey(key string) string {
+	return "login_blocked_" + key
+}
+
+// ThrottlingAuthenticator is an Authenticator that slows down guessing of passwords. Credentials are not checked at
+// all while the email or the IP of the client from the context have to wait, TooManyAttemptsError is returned instead.
+// Failed attempts of the email are cleared only when tokens are issued. A correct password of a user with two-factor
+// authentication gives just an MFA token, so it doesn't clear them, and wrong codes are recorded by
+// DefaultTwoFactorService.
+type ThrottlingAuthenticator struct {
+	delegate  Authenticator
+	throttler LoginThrottler
+}
+
+// NewThrottlingAuthenticator creates new instance of ThrottlingAuthenticator
+func NewThrottlingAuthenticator(delegate Authenticator, throttler LoginThrottler) *ThrottlingAuthenticator {
+	return &ThrottlingAuthenticator{delegate: delegate, throttler: throttler}
+}
+
+func (a *ThrottlingAuthenticator) Authenticate(
+	ctx context.Context,
+	email expenses.Email,
+	password expenses.Password,
+) (TokenResponse, error) {
+	ip := ExtractClientInfo(ctx).IP
+	if wait := a.throttler.Wait(email, ip); wait > 0 {
+		return TokenResponse{}, TooManyAttemptsError{RetryAfter: wait}
+	}
+	tokenResponse, err := a.delegate.Authenticate(ctx, email, password)
+	switch {
+	case err == nil && tokenResponse.AccessToken != "":
+		a.throttler.Clear(email)
+	case err == ErrEmailOrPasswordIncorrect:
+		a.throttler.Fail(email, ip)
+	}
+	return tokenResponse, err
+}
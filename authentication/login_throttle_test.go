@@ -0,0 +1,261 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/expenses"
+	"testing"
+	"time"
+)
+
+var (
+	testEmailBackoff = authentication.BackoffPolicy{
+		FreeAttempts:    2,
+		Delay:           time.Second,
+		LockoutAfter:    5,
+		LockoutDuration: time.Minute,
+	}
+	testIPBackoff = authentication.BackoffPolicy{
+		FreeAttempts:    4,
+		Delay:           time.Second,
+		LockoutAfter:    8,
+		LockoutDuration: time.Hour,
+	}
+)
+
+// loginThrottlers create a LoginThrottler of every implementation without failed attempts, so that all of them pass
+// the same tests. IPs have testIPBackoff policy.
+var loginThrottlers = []struct {
+	name         string
+	newThrottler func(t *testing.T, emailPolicy authentication.BackoffPolicy) authentication.LoginThrottler
+}{
+	{
+		name: "redis",
+		newThrottler: func(t *testing.T, emailPolicy authentication.BackoffPolicy) authentication.LoginThrottler {
+			clearRedis()
+			return authentication.NewRedisLoginThrottler(emailPolicy, testIPBackoff, redisClient)
+		},
+	},
+	{
+		name: "memory",
+		newThrottler: func(t *testing.T, emailPolicy authentication.BackoffPolicy) authentication.LoginThrottler {
+			throttler := authentication.NewMemoryLoginThrottler(emailPolicy, testIPBackoff, time.Minute)
+			t.Cleanup(throttler.Stop)
+			return throttler
+		},
+	},
+}
+
+type mockLoginThrottler struct {
+	mock.Mock
+}
+
+func (m *mockLoginThrottler) Clear(email expenses.Email) {
+	m.Called(email)
+}
+
+func (m *mockLoginThrottler) Wait(email expenses.Email, ip string) time.Duration {
+	args := m.Called(email, ip)
+	return args.Get(0).(time.Duration)
+}
+
+func (m *mockLoginThrottler) Fail(email expenses.Email, ip string) {
+	m.Called(email, ip)
+}
+
+type mockAuthenticator struct {
+	mock.Mock
+}
+
+func (m *mockAuthenticator) Authenticate(
+	ctx context.Context,
+	email expenses.Email,
+	password expenses.Password,
+) (authentication.TokenResponse, error) {
+	args := m.Called(ctx, email, password)
+	return args.Get(0).(authentication.TokenResponse), args.Error(1)
+}
+
+func TestLoginThrottlerBacksOff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   authentication.BackoffPolicy
+		failures int
+		expected time.Duration
+	}{
+		{name: "free attempt", policy: testEmailBackoff, failures: 2, expected: 0},
+		{name: "first delay", policy: testEmailBackoff, failures: 3, expected: time.Second},
+		{name: "delay doubles", policy: testEmailBackoff, failures: 4, expected: 2 * time.Second},
+		{name: "lockout", policy: testEmailBackoff, failures: 5, expected: time.Minute},
+		{name: "after lockout", policy: testEmailBackoff, failures: 7, expected: time.Minute},
+		{
+			name: "delay doesn't exceed lockout",
+			policy: authentication.BackoffPolicy{
+				Delay:           time.Second,
+				LockoutAfter:    100,
+				LockoutDuration: 3 * time.Second,
+			},
+			failures: 3,
+			expected: 3 * time.Second,
+		},
+	}
+	for _, implementation := range loginThrottlers {
+		for _, test := range tests {
+			t.Run(implementation.name+" "+test.name, func(t *testing.T) {
+				// given
+				throttler := implementation.newThrottler(t, test.policy)
+				for i := 0; i < test.failures; i++ {
+					throttler.Fail("user@mail.com", "")
+				}
+
+				// when
+				wait := throttler.Wait("user@mail.com", "")
+
+				// then
+				assert.InDelta(t, float64(test.expected), float64(wait), float64(100*time.Millisecond))
+			})
+		}
+	}
+}
+
+func TestLoginThrottlerIgnoresCaseOfEmail(t *testing.T) {
+	for _, implementation := range loginThrottlers {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			throttler := implementation.newThrottler(t, testEmailBackoff)
+			for i := 0; i < 5; i++ {
+				throttler.Fail("User@Mail.com", "")
+			}
+
+			// when
+			wait := throttler.Wait("user@mail.com", "")
+
+			// then
+			assert.NotZero(t, wait)
+		})
+	}
+}
+
+func TestLoginThrottlerTracksIP(t *testing.T) {
+	for _, implementation := range loginThrottlers {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			throttler := implementation.newThrottler(t, testEmailBackoff)
+			emails := []expenses.Email{"a@mail.com", "b@mail.com", "c@mail.com", "d@mail.com", "e@mail.com"}
+			for _, email := range emails {
+				throttler.Fail(email, "10.0.0.1")
+			}
+
+			// when
+			sameIP := throttler.Wait("f@mail.com", "10.0.0.1")
+			otherIP := throttler.Wait("f@mail.com", "10.0.0.2")
+
+			// then
+			assert.InDelta(t, float64(time.Second), float64(sameIP), float64(100*time.Millisecond))
+			assert.Zero(t, otherIP)
+		})
+	}
+}
+
+func TestLoginThrottlerClearsOnlyEmail(t *testing.T) {
+	for _, implementation := range loginThrottlers {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			throttler := implementation.newThrottler(t, testEmailBackoff)
+			for i := 0; i < 8; i++ {
+				throttler.Fail("user@mail.com", "10.0.0.1")
+			}
+
+			// when
+			throttler.Clear("user@mail.com")
+
+			// then
+			assert.Zero(t, throttler.Wait("user@mail.com", ""))
+			assert.Zero(t, throttler.Wait("user@mail.com", "10.0.0.2"))
+			assert.NotZero(t, throttler.Wait("user@mail.com", "10.0.0.1"))
+			throttler.Fail("user@mail.com", "")
+			assert.Zero(t, throttler.Wait("user@mail.com", ""), "failures of the email should start from zero")
+		})
+	}
+}
+
+func TestThrottlingAuthenticator(t *testing.T) {
+	client := authentication.ClientInfo{IP: "10.0.0.1"}
+	ctx := authentication.WithClientInfo(context.Background(), client)
+	email := expenses.Email("user@mail.com")
+	password := expenses.Password("password")
+	tokens := authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name           string
+		expectedTokens authentication.TokenResponse
+		expectedErr    error
+		prepareMocks   func(*mockAuthenticator, *mockLoginThrottler)
+	}{
+		{
+			name:           "successful authentication clears failed attempts",
+			expectedTokens: tokens,
+			prepareMocks: func(delegate *mockAuthenticator, throttler *mockLoginThrottler) {
+				throttler.On("Wait", email, "10.0.0.1").Return(time.Duration(0))
+				delegate.On("Authenticate", ctx, email, password).Return(tokens, nil)
+				throttler.On("Clear", email).Return()
+			},
+		},
+		{
+			name:           "mfa token doesn't clear failed attempts",
+			expectedTokens: authentication.TokenResponse{MFAToken: "mfa"},
+			prepareMocks: func(delegate *mockAuthenticator, throttler *mockLoginThrottler) {
+				throttler.On("Wait", email, "10.0.0.1").Return(time.Duration(0))
+				delegate.On("Authenticate", ctx, email, password).
+					Return(authentication.TokenResponse{MFAToken: "mfa"}, nil)
+			},
+		},
+		{
+			name:        "incorrect password is recorded",
+			expectedErr: authentication.ErrEmailOrPasswordIncorrect,
+			prepareMocks: func(delegate *mockAuthenticator, throttler *mockLoginThrottler) {
+				throttler.On("Wait", email, "10.0.0.1").Return(time.Duration(0))
+				delegate.On("Authenticate", ctx, email, password).
+					Return(authentication.TokenResponse{}, authentication.ErrEmailOrPasswordIncorrect)
+				throttler.On("Fail", email, "10.0.0.1").Return()
+			},
+		},
+		{
+			name:        "other errors are not recorded",
+			expectedErr: expectedErr,
+			prepareMocks: func(delegate *mockAuthenticator, throttler *mockLoginThrottler) {
+				throttler.On("Wait", email, "10.0.0.1").Return(time.Duration(0))
+				delegate.On("Authenticate", ctx, email, password).Return(authentication.TokenResponse{}, expectedErr)
+			},
+		},
+		{
+			name:        "credentials are not checked while waiting",
+			expectedErr: authentication.TooManyAttemptsError{RetryAfter: 2 * time.Second},
+			prepareMocks: func(delegate *mockAuthenticator, throttler *mockLoginThrottler) {
+				throttler.On("Wait", email, "10.0.0.1").Return(2 * time.Second)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			delegate := new(mockAuthenticator)
+			throttler := new(mockLoginThrottler)
+			test.prepareMocks(delegate, throttler)
+			authenticator := authentication.NewThrottlingAuthenticator(delegate, throttler)
+
+			// when
+			tokenResponse, err := authenticator.Authenticate(ctx, email, password)
+
+			// then
+			require.Equal(t, test.expectedErr, err)
+			assert.Equal(t, test.expectedTokens, tokenResponse)
+			delegate.AssertExpectations(t)
+			throttler.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package authentication
+
+import (
+	"go-spend/util"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryRateLimiter is a RateLimiter that keeps a token bucket per limit of every context in memory of the instance,
+// for deployments without redis. A bucket holds Amount tokens and is refilled with Amount tokens per Duration, a
+// request takes one token from every bucket. Like RedisRateLimiter, a request is counted only if it is under all
+// limits. Full buckets are removed by a janitor goroutine until Stop.
+type MemoryRateLimiter struct {
+	mutex   sync.Mutex
+	limits  []Limit
+	buckets map[string][]tokenBucket
+	stop    func()
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewMemoryRateLimiter creates new instance of MemoryRateLimiter. User must ensure that passed limits are correct.
+func NewMemoryRateLimiter(limits []Limit, janitorInterval time.Duration) *MemoryRateLimiter {
+	l := &MemoryRateLimiter{limits: limits, buckets: make(map[string][]tokenBucket)}
+	l.stop = util.StartJanitor(janitorInterval, l.removeFull)
+	return l
+}
+
+// Check takes a token from every bucket of the context if all of them have one
+func (l *MemoryRateLimiter) Check(context LimitContext) LimitDecision {
+	if len(l.limits) == 0 {
+		return LimitDecision{Allowed: true}
+	}
+	key := context.AsKey("")
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	buckets, ok := l.buckets[key]
+	if !ok {
+		buckets = make([]tokenBucket, len(l.limits))
+		for i, limit := range l.limits {
+			buckets[i] = tokenBucket{tokens: float64(limit.Amount), updatedAt: now}
+		}
+		l.buckets[key] = buckets
+	}
+	for i, limit := range l.limits {
+		buckets[i].refill(limit, now)
+		if buckets[i].tokens < 1 {
+			return LimitDecision{
+				Limit:      limit,
+				Reset:      buckets[i].untilHas(limit, float64(limit.Amount)),
+				RetryAfter: buckets[i].untilHas(limit, 1),
+			}
+		}
+	}
+	decisive := 0
+	for i := range buckets {
+		buckets[i].tokens--
+		if buckets[i].tokens < buckets[decisive].tokens {
+			decisive = i
+		}
+	}
+	limit := l.limits[decisive]
+	return LimitDecision{
+		Allowed:   true,
+		Limit:     limit,
+		Remaining: uint64(buckets[decisive].tokens),
+		Reset:     buckets[decisive].untilHas(limit, float64(limit.Amount)),
+	}
+}
+
+// Stop the janitor
+func (l *MemoryRateLimiter) Stop() {
+	l.stop()
+}
+
+// removeFull forgets contexts with full buckets, they are the same as new ones
+func (l *MemoryRateLimiter) removeFull() {
+	now := time.Now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for key, buckets := range l.buckets {
+		full := true
+		for i, limit := range l.limits {
+			buckets[i].refill(limit, now)
+			full = full && buckets[i].tokens >= float64(limit.Amount)
+		}
+		if full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (b *tokenBucket) refill(limit Limit, now time.Time) {
+	elapsed := now.Sub(b.updatedAt)
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(float64(limit.Amount), b.tokens+float64(limit.Amount)*float64(elapsed)/float64(limit.Duration))
+	b.updatedAt = now
+}
+
+// untilHas returns how long it takes to refill the bucket to the amount of tokens
+func (b *tokenBucket) untilHas(limit Limit, tokens float64) time.Duration {
+	if b.tokens >= tokens {
+		return 0
+	}
+	return time.Duration(math.Ceil((tokens - b.tokens) * float64(limit.Duration) / float64(limit.Amount)))
+}
@@ -0,0 +1,334 @@
+package authentication
+
+import (
+	"go-spend/util"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryTokenRepository is a TokenRepository that keeps tokens and sessions in memory of the instance, for
+// deployments without redis. It is structured like RedisTokenRepository: tokens and marks of used refresh tokens
+// expire on their own, every session has a set of its tokens and metadata, every user has an index of sessions with
+// their creation and last use time. Expired entries are removed by a janitor goroutine until Stop.
+// All sessions are lost when the instance restarts.
+type MemoryTokenRepository struct {
+	mutex  sync.Mutex
+	tokens map[string]memoryToken
+	// usedTokens are refresh tokens that were consumed, until their expiration
+	usedTokens map[string]time.Time
+	sessions   map[string]*memorySession
+	// userSessions indexes sessions by user, a session can be in the index without metadata, like in redis
+	userSessions map[uint]map[string]bool
+	// deniedSessions were removed during the lifetime of their access tokens
+	deniedSessions map[string]time.Time
+	stop           func()
+}
+
+type memoryToken struct {
+	userContext UserContext
+	expiresAt   time.Time
+}
+
+type memorySession struct {
+	tokens     map[string]bool
+	createdAt  time.Time
+	lastUsedAt time.Time
+	clientIP   string
+	userAgent  string
+	// expiresAt is zero until the session was created with CreateSession
+	expiresAt time.Time
+}
+
+// NewMemoryTokenRepository creates new instance of MemoryTokenRepository that removes expired entries every
+// janitorInterval
+func NewMemoryTokenRepository(janitorInterval time.Duration) *MemoryTokenRepository {
+	r := &MemoryTokenRepository{
+		tokens:         make(map[string]memoryToken),
+		usedTokens:     make(map[string]time.Time),
+		sessions:       make(map[string]*memorySession),
+		userSessions:   make(map[uint]map[string]bool),
+		deniedSessions: make(map[string]time.Time),
+	}
+	r.stop = util.StartJanitor(janitorInterval, r.removeExpired)
+	return r
+}
+
+// Save stores both tokens as part of the session from provided UserContext and marks the session as used.
+// Session is required.
+func (r *MemoryTokenRepository) Save(pair TokenPair, userContext UserContext) error {
+	if userContext.SessionID == "" {
+		return ErrIncorrectValue
+	}
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tokens[pair.AccessToken.UUID] = memoryToken{userContext, time.Unix(pair.AccessToken.ExpiresAt, 0)}
+	r.tokens[pair.RefreshToken.UUID] = memoryToken{userContext, time.Unix(pair.RefreshToken.ExpiresAt, 0)}
+	session := r.session(userContext.SessionID)
+	session.tokens[pair.AccessToken.UUID] = true
+	session.tokens[pair.RefreshToken.UUID] = true
+	if !session.expiresAt.IsZero() {
+		session.expiresAt = time.Unix(pair.RefreshToken.ExpiresAt, 0)
+	}
+	if !r.isIndexed(userContext.UserID, userContext.SessionID) {
+		session.createdAt = now
+	}
+	session.lastUsedAt = now
+	r.index(userContext.UserID, userContext.SessionID)
+	return nil
+}
+
+// Retrieve returns UserContext of the token and marks its session as used. Returns ErrTokenNotFound if there is no
+// such token.
+func (r *MemoryTokenRepository) Retrieve(uuid string) (UserContext, error) {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	token, ok := r.liveToken(uuid, now)
+	if !ok {
+		return UserContext{}, ErrTokenNotFound
+	}
+	userContext := token.userContext
+	if userContext.SessionID != "" && r.isIndexed(userContext.UserID, userContext.SessionID) {
+		r.sessions[userContext.SessionID].lastUsedAt = now
+	}
+	return userContext, nil
+}
+
+// UpdateUserContext sets UserID and GroupID from provided UserContext for all live tokens of the user keeping their
+// sessions and expiration. Expired tokens and sessions are removed from the indexes.
+func (r *MemoryTokenRepository) UpdateUserContext(userContext UserContext) error {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for sessionID := range r.userSessions[userContext.UserID] {
+		session := r.session(sessionID)
+		if len(session.tokens) == 0 {
+			r.unindex(userContext.UserID, sessionID)
+			continue
+		}
+		sessionContext := UserContext{UserID: userContext.UserID, GroupID: userContext.GroupID, SessionID: sessionID}
+		for uuid := range session.tokens {
+			if token, ok := r.liveToken(uuid, now); ok {
+				r.tokens[uuid] = memoryToken{sessionContext, token.expiresAt}
+			} else {
+				delete(session.tokens, uuid)
+			}
+		}
+	}
+	return nil
+}
+
+// ConsumeRefreshToken removes the refresh token and marks it as used until its expiration. Other tokens of the
+// session are removed as well, so that the session can continue with a new pair. If token was already used
+// - ErrRefreshTokenReused is returned, if it is unknown - ErrTokenNotFound.
+func (r *MemoryTokenRepository) ConsumeRefreshToken(token Token, sessionID string) (UserContext, error) {
+	now := time.Now()
+	expiresAt := time.Unix(token.ExpiresAt, 0)
+	if !expiresAt.After(now) {
+		return UserContext{}, ErrTokenNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stored, ok := r.liveToken(token.UUID, now)
+	delete(r.tokens, token.UUID)
+	usedAt, used := r.usedTokens[token.UUID]
+	alreadyUsed := used && usedAt.After(now)
+	if !alreadyUsed {
+		r.usedTokens[token.UUID] = expiresAt
+	}
+	if !ok {
+		if alreadyUsed {
+			return UserContext{}, ErrRefreshTokenReused
+		}
+		return UserContext{}, ErrTokenNotFound
+	}
+	r.removeSessionTokens(sessionID)
+	return stored.userContext, nil
+}
+
+// CreateSession stores metadata of a new session of the user
+func (r *MemoryTokenRepository) CreateSession(userID uint, session Session, expiration time.Duration) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	stored := r.session(session.ID)
+	stored.clientIP = session.ClientIP
+	stored.userAgent = session.UserAgent
+	stored.expiresAt = time.Now().Add(expiration)
+	stored.createdAt = session.CreatedAt
+	stored.lastUsedAt = session.LastUsedAt
+	r.index(userID, session.ID)
+	return nil
+}
+
+// FindSessions returns live sessions of the user ordered by creation time, the oldest first. Expired sessions are
+// removed from the index. Times have a precision of seconds, like in redis.
+func (r *MemoryTokenRepository) FindSessions(userID uint) ([]Session, error) {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sessions := make([]Session, 0, len(r.userSessions[userID]))
+	for sessionID := range r.userSessions[userID] {
+		session := r.sessions[sessionID]
+		if !session.expiresAt.After(now) {
+			r.unindex(userID, sessionID)
+			continue
+		}
+		sessions = append(sessions, Session{
+			ID:         sessionID,
+			CreatedAt:  time.Unix(session.createdAt.Unix(), 0),
+			LastUsedAt: time.Unix(session.lastUsedAt.Unix(), 0),
+			ClientIP:   session.clientIP,
+			UserAgent:  session.userAgent,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].ID < sessions[j].ID
+		}
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// RemoveSession removes all tokens of the session and the session itself. The session is added to the denylist.
+func (r *MemoryTokenRepository) RemoveSession(userID uint, sessionID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.removeSession(userID, sessionID)
+	return nil
+}
+
+// RemoveAllSessions removes all tokens of all sessions of the user. The sessions are added to the denylist.
+func (r *MemoryTokenRepository) RemoveAllSessions(userID uint) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for sessionID := range r.userSessions[userID] {
+		r.removeSession(userID, sessionID)
+	}
+	return nil
+}
+
+// RemoveOtherSessions removes all tokens of all sessions of the user except the kept one. The removed sessions are
+// added to the denylist.
+func (r *MemoryTokenRepository) RemoveOtherSessions(userID uint, keptSessionID string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for sessionID := range r.userSessions[userID] {
+		if sessionID != keptSessionID {
+			r.removeSession(userID, sessionID)
+		}
+	}
+	return nil
+}
+
+// IsDenied checks whether the session was removed during the lifetime of access tokens
+func (r *MemoryTokenRepository) IsDenied(sessionID string) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	deniedUntil, ok := r.deniedSessions[sessionID]
+	return ok && deniedUntil.After(time.Now()), nil
+}
+
+// Stop the janitor
+func (r *MemoryTokenRepository) Stop() {
+	r.stop()
+}
+
+func (r *MemoryTokenRepository) removeExpired() {
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for uuid, token := range r.tokens {
+		if !token.expiresAt.After(now) {
+			delete(r.tokens, uuid)
+		}
+	}
+	for uuid, expiresAt := range r.usedTokens {
+		if !expiresAt.After(now) {
+			delete(r.usedTokens, uuid)
+		}
+	}
+	for sessionID, deniedUntil := range r.deniedSessions {
+		if !deniedUntil.After(now) {
+			delete(r.deniedSessions, sessionID)
+		}
+	}
+	for _, session := range r.sessions {
+		for uuid := range session.tokens {
+			if _, ok := r.tokens[uuid]; !ok {
+				delete(session.tokens, uuid)
+			}
+		}
+	}
+	indexed := make(map[string]bool)
+	for userID, sessionIDs := range r.userSessions {
+		for sessionID := range sessionIDs {
+			if session := r.sessions[sessionID]; len(session.tokens) == 0 && !session.expiresAt.After(now) {
+				r.unindex(userID, sessionID)
+			} else {
+				indexed[sessionID] = true
+			}
+		}
+	}
+	for sessionID, session := range r.sessions {
+		if len(session.tokens) == 0 && !session.expiresAt.After(now) && !indexed[sessionID] {
+			delete(r.sessions, sessionID)
+		}
+	}
+}
+
+func (r *MemoryTokenRepository) liveToken(uuid string, now time.Time) (memoryToken, bool) {
+	token, ok := r.tokens[uuid]
+	if !ok || !token.expiresAt.After(now) {
+		return memoryToken{}, false
+	}
+	return token, true
+}
+
+// session returns the stored session, a new one is stored if there is none yet
+func (r *MemoryTokenRepository) session(sessionID string) *memorySession {
+	session, ok := r.sessions[sessionID]
+	if !ok {
+		session = &memorySession{tokens: make(map[string]bool)}
+		r.sessions[sessionID] = session
+	}
+	return session
+}
+
+func (r *MemoryTokenRepository) removeSessionTokens(sessionID string) {
+	session, ok := r.sessions[sessionID]
+	if !ok {
+		return
+	}
+	for uuid := range session.tokens {
+		delete(r.tokens, uuid)
+	}
+	session.tokens = make(map[string]bool)
+}
+
+func (r *MemoryTokenRepository) removeSession(userID uint, sessionID string) {
+	r.removeSessionTokens(sessionID)
+	r.deniedSessions[sessionID] = time.Now().Add(accessTokenExpiration)
+	delete(r.sessions, sessionID)
+	r.unindex(userID, sessionID)
+}
+
+func (r *MemoryTokenRepository) isIndexed(userID uint, sessionID string) bool {
+	return r.userSessions[userID][sessionID]
+}
+
+func (r *MemoryTokenRepository) index(userID uint, sessionID string) {
+	if r.userSessions[userID] == nil {
+		r.userSessions[userID] = make(map[string]bool)
+	}
+	r.userSessions[userID][sessionID] = true
+}
+
+func (r *MemoryTokenRepository) unindex(userID uint, sessionID string) {
+	delete(r.userSessions[userID], sessionID)
+	if len(r.userSessions[userID]) == 0 {
+		delete(r.userSessions, userID)
+	}
+}
@@ -0,0 +1,435 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-spend/authentication/jwt"
+	"go-spend/db"
+	"go-spend/expenses"
+	"go-spend/log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcPurpose         = "oidc"
+	oidcStateExpiration = 10 * time.Minute
+	oidcDiscoveryPath   = "/.well-known/openid-configuration"
+	// pkceVerifierSize in bytes gives 43 characters, the minimum length of RFC 7636
+	pkceVerifierSize = 32
+	oidcNonceSize    = 16
+	// oidcPasswordSize in bytes of a random password of provisioned users, nobody knows it
+	oidcPasswordSize = 32
+	// oidcKeysRefreshInterval limits how often keys of the provider are fetched again after an unknown kid
+	oidcKeysRefreshInterval = time.Minute
+)
+
+var (
+	ErrInvalidOIDCState     = errors.New("oidc state is not valid")
+	ErrOIDCCodeRejected     = errors.New("authorization code was rejected by the identity provider")
+	ErrInvalidIDToken       = errors.New("id token is not valid")
+	ErrOIDCEmailNotVerified = errors.New("identity provider hasn't verified the email")
+	ErrOIDCUserNotFound     = errors.New("there is no user with the email of the identity provider")
+)
+
+// OIDCProvider is the part of OpenID Provider Metadata that is used by the authorization code flow
+type OIDCProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCConfig describes the client registered at the identity provider
+type OIDCConfig struct {
+	ClientID string
+	// ClientSecret is empty for public clients, PKCE protects the code of those
+	ClientSecret string
+	// RedirectURL is registered at the provider, it receives code and state after the user signs in
+	RedirectURL string
+	// AutoProvision creates users that sign in for the first time, otherwise only existing users are linked by email
+	AutoProvision bool
+}
+
+// ExternalAuthenticator is an Authenticator for users that sign in at an identity provider instead of giving
+// a password to the application
+type ExternalAuthenticator interface {
+	// AuthorizationURL returns the URL of the identity provider where the user should be redirected to sign in
+	AuthorizationURL(ctx context.Context) (string, error)
+	// Callback exchanges code and state that the provider has redirected back with for tokens of a new session
+	Callback(ctx context.Context, code string, state string) (TokenResponse, error)
+}
+
+// DiscoverOIDCProvider reads the configuration of the provider from its well-known location. The issuer of the
+// configuration must be the same as the requested one.
+func DiscoverOIDCProvider(ctx context.Context, client *http.Client, issuer string) (OIDCProvider, error) {
+	var provider OIDCProvider
+	if err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+oidcDiscoveryPath, &provider); err != nil {
+		return OIDCProvider{}, fmt.Errorf("couldn't discover oidc provider %s - %w", issuer, err)
+	}
+	if provider.Issuer != issuer {
+		return OIDCProvider{}, fmt.Errorf("oidc provider %s has returned issuer %s", issuer, provider.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return OIDCProvider{}, fmt.Errorf("oidc provider %s has incomplete configuration", issuer)
+	}
+	return provider, nil
+}
+
+// OIDCAuthenticator signs users in with OpenID Connect authorization code flow with PKCE. State, nonce and PKCE
+// verifier are kept in OneTimeTokenStore, the state is the token, so a callback can be used only once and only within
+// 10 minutes. ID tokens are verified with keys of the provider, they are fetched again when a token is signed with
+// an unknown key. Users are linked by an email that the provider has verified, the email of a linked user becomes
+// verified as well. Users who have enabled two-factor authentication of the application get an MFA token instead of
+// a session, like after a correct password, as the provider may sign them in with weaker factors.
+type OIDCAuthenticator struct {
+	client          *http.Client
+	config          OIDCConfig
+	db              db.TxQuerier
+	keys            *oidcKeySet
+	passwordEncoder PasswordEncoder
+	provider        OIDCProvider
+	sessionStarter  SessionStarter
+	tokenStore      OneTimeTokenStore
+	twoFactor       TwoFactorChallenger
+	userRepository  expenses.UserRepository
+}
+
+// NewOIDCAuthenticator creates new instance of OIDCAuthenticator, provider is usually found by DiscoverOIDCProvider
+func NewOIDCAuthenticator(
+	client *http.Client,
+	config OIDCConfig,
+	db db.TxQuerier,
+	passwordEncoder PasswordEncoder,
+	provider OIDCProvider,
+	sessionStarter SessionStarter,
+	tokenStore OneTimeTokenStore,
+	twoFactor TwoFactorChallenger,
+	userRepository expenses.UserRepository,
+) *OIDCAuthenticator {
+	return &OIDCAuthenticator{
+		client:          client,
+		config:          config,
+		db:              db,
+		keys:            &oidcKeySet{client: client, uri: provider.JWKSURI, algorithms: map[string]*jwt.Algorithm{}},
+		passwordEncoder: passwordEncoder,
+		provider:        provider,
+		sessionStarter:  sessionStarter,
+		tokenStore:      tokenStore,
+		twoFactor:       twoFactor,
+		userRepository:  userRepository,
+	}
+}
+
+func (a *OIDCAuthenticator) AuthorizationURL(ctx context.Context) (string, error) {
+	verifier, err := randomString(pkceVerifierSize)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString(oidcNonceSize)
+	if err != nil {
+		return "", err
+	}
+	state, err := a.tokenStore.Create(oidcPurpose, verifier+" "+nonce, oidcStateExpiration)
+	if err != nil {
+		return "", err
+	}
+	authorizationURL, err := url.Parse(a.provider.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := authorizationURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", a.config.ClientID)
+	query.Set("redirect_uri", a.config.RedirectURL)
+	query.Set("scope", "openid email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	authorizationURL.RawQuery = query.Encode()
+	return authorizationURL.String(), nil
+}
+
+// Callback returns ErrInvalidOIDCState if the state is unknown, expired or was already used, ErrOIDCCodeRejected if
+// the provider didn't accept the code and ErrInvalidIDToken if the ID token can't be trusted.
+// ErrOIDCEmailNotVerified is returned if the provider hasn't verified the email and ErrOIDCUserNotFound if there is
+// no user with the email and auto-provisioning is off. Only MFA token is returned if the user has enabled two-factor
+// authentication, see TwoFactorService.Verify. The session is started for the client from the context,
+// see WithClientInfo.
+func (a *OIDCAuthenticator) Callback(ctx context.Context, code string, state string) (TokenResponse, error) {
+	value, err := a.tokenStore.Consume(oidcPurpose, state)
+	if err == ErrOneTimeTokenNotFound {
+		return TokenResponse{}, ErrInvalidOIDCState
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	parts := strings.Split(value, " ")
+	if len(parts) != 2 {
+		return TokenResponse{}, ErrInvalidOIDCState
+	}
+	verifier, nonce := parts[0], parts[1]
+	idToken, err := a.exchange(ctx, code, verifier)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	claims, err := a.verify(ctx, idToken, nonce)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return TokenResponse{}, ErrOIDCEmailNotVerified
+	}
+	email, _ := claims["email"].(string)
+	validEmail, err := expenses.ValidEmail(email)
+	if err != nil {
+		return TokenResponse{}, ErrInvalidIDToken
+	}
+	user, err := a.findOrProvision(ctx, validEmail)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	mfaToken, err := a.twoFactor.Challenge(ctx, user.ID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if mfaToken != "" {
+		return TokenResponse{MFAToken: mfaToken}, nil
+	}
+	userContext := UserContext{UserID: user.ID, GroupID: user.GroupID}
+	return a.sessionStarter.Start(userContext, ExtractClientInfo(ctx))
+}
+
+// exchange the code for an ID token at the token endpoint
+func (a *OIDCAuthenticator) exchange(ctx context.Context, code string, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", a.config.RedirectURL)
+	form.Set("client_id", a.config.ClientID)
+	form.Set("code_verifier", verifier)
+	request, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		a.provider.TokenEndpoint,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if a.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+	}
+	response, err := a.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusBadRequest || response.StatusCode == http.StatusUnauthorized {
+		return "", ErrOIDCCodeRejected
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint of oidc provider has responded with %d", response.StatusCode)
+	}
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.IDToken == "" {
+		return "", ErrInvalidIDToken
+	}
+	return tokens.IDToken, nil
+}
+
+// verify signature, issuer, audience, expiration and nonce of the ID token
+func (a *OIDCAuthenticator) verify(ctx context.Context, idToken string, nonce string) (jwt.Claims, error) {
+	header, err := jwt.DecodeHeader(idToken)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	algorithm, err := a.keys.algorithm(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := algorithm.DecodeAndValidate(idToken)
+	if err != nil || !claims.HasClaim("exp") {
+		return nil, ErrInvalidIDToken
+	}
+	if issuer, _ := claims["iss"].(string); issuer != a.provider.Issuer {
+		return nil, ErrInvalidIDToken
+	}
+	if !hasAudience(claims, a.config.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+	if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+	return claims, nil
+}
+
+// findOrProvision links the user by the email. Provisioned users get a random password, they can set their own
+// with a password reset.
+func (a *OIDCAuthenticator) findOrProvision(ctx context.Context, email expenses.Email) (expenses.User, error) {
+	user, err := a.userRepository.FindByEmail(ctx, a.db, email)
+	if err == expenses.ErrUserNotFound {
+		if !a.config.AutoProvision {
+			return expenses.User{}, ErrOIDCUserNotFound
+		}
+		user, err = a.provision(ctx, email)
+	}
+	if err != nil {
+		return expenses.User{}, err
+	}
+	if !user.Verified {
+		if err = a.userRepository.MarkVerified(ctx, a.db, user.ID, email); err != nil {
+			return expenses.User{}, err
+		}
+		user.Verified = true
+	}
+	return user, nil
+}
+
+func (a *OIDCAuthenticator) provision(ctx context.Context, email expenses.Email) (expenses.User, error) {
+	password, err := randomString(oidcPasswordSize)
+	if err != nil {
+		return expenses.User{}, err
+	}
+	encodedPassword, err := a.passwordEncoder.Encode(password)
+	if err != nil {
+		return expenses.User{}, err
+	}
+	request := expenses.CreateUserRequest{Email: email, Password: expenses.Password(encodedPassword)}
+	user, err := a.userRepository.Create(ctx, a.db, request)
+	if err == expenses.ErrEmailAlreadyExists {
+		// the same user has signed in concurrently
+		return a.userRepository.FindByEmail(ctx, a.db, email)
+	}
+	if err != nil {
+		return expenses.User{}, err
+	}
+	log.Info("user %d was provisioned by oidc provider %s", user.ID, a.provider.Issuer)
+	return user, nil
+}
+
+// hasAudience checks aud claim, that is either a string or an array. If there are several audiences - the client has
+// to be the authorized party as well.
+func hasAudience(claims jwt.Claims, clientID string) bool {
+	switch audience := claims["aud"].(type) {
+	case string:
+		return audience == clientID
+	case []interface{}:
+		found := false
+		for _, value := range audience {
+			if value == clientID {
+				found = true
+			}
+		}
+		if len(audience) > 1 {
+			authorizedParty, _ := claims["azp"].(string)
+			return found && authorizedParty == clientID
+		}
+		return found
+	default:
+		return false
+	}
+}
+
+// oidcKeySet caches keys of the provider
+type oidcKeySet struct {
+	client     *http.Client
+	uri        string
+	mutex      sync.Mutex
+	algorithms map[string]*jwt.Algorithm
+	// missedAt is when fetched keys didn't have the requested kid, so that tokens with made up kids can't make
+	// the keys be fetched on every request
+	missedAt time.Time
+}
+
+// algorithm returns the key with the kid, keys are fetched again if there is no such key. Tokens without kid are
+// accepted only if the provider has a single key.
+func (k *oidcKeySet) algorithm(ctx context.Context, kid string) (*jwt.Algorithm, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if algorithm, ok := k.find(kid); ok {
+		return algorithm, nil
+	}
+	if time.Since(k.missedAt) < oidcKeysRefreshInterval {
+		return nil, ErrInvalidIDToken
+	}
+	if err := k.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if algorithm, ok := k.find(kid); ok {
+		return algorithm, nil
+	}
+	k.missedAt = time.Now()
+	return nil, ErrInvalidIDToken
+}
+
+func (k *oidcKeySet) find(kid string) (*jwt.Algorithm, bool) {
+	if kid == "" && len(k.algorithms) == 1 {
+		for _, algorithm := range k.algorithms {
+			return algorithm, true
+		}
+	}
+	algorithm, ok := k.algorithms[kid]
+	return algorithm, ok
+}
+
+// fetch replaces all keys, keys that are not supported are skipped
+func (k *oidcKeySet) fetch(ctx context.Context) error {
+	var keySet jwt.KeySet
+	if err := getJSON(ctx, k.client, k.uri, &keySet); err != nil {
+		return fmt.Errorf("couldn't fetch keys of oidc provider - %w", err)
+	}
+	algorithms := make(map[string]*jwt.Algorithm, len(keySet.Keys))
+	for _, key := range keySet.Keys {
+		algorithm, err := jwt.ParseJWK(key)
+		if err != nil {
+			log.Warn("skipping key %s of oidc provider - %s", key.Kid, err)
+			continue
+		}
+		algorithms[key.Kid] = algorithm
+	}
+	k.algorithms = algorithms
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, uri string, target interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s has responded with %d", uri, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+func randomString(size int) (string, error) {
+	random := make([]byte, size)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,562 @@
+package authentication_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/authentication/jwt"
+	"go-spend/expenses"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testOIDCClientID     = "go-spend"
+	testOIDCClientSecret = "client-secret"
+	testOIDCRedirectURL  = "http://localhost/callback"
+	testOIDCEmail        = "oidc@mail.com"
+)
+
+// mockIdP is a minimal OpenID provider. It remembers authorization requests, checks PKCE, client credentials and
+// redirect URI at the token endpoint and issues ID tokens signed with a key published in its JWKS.
+type mockIdP struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	kid    string
+	key    *jwt.Algorithm
+	grants map[string]url.Values
+	// claims of the next ID tokens can be changed by tests
+	modifyClaims func(claims jwt.Claims)
+	// forgedKey signs ID tokens instead of the published key if it is set
+	forgedKey *jwt.Algorithm
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{grants: map[string]url.Values{}, modifyClaims: func(jwt.Claims) {}}
+	idp.rotateKey(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(authentication.OIDCProvider{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mutex.Lock()
+		defer idp.mutex.Unlock()
+		jwk, _ := idp.key.JWK()
+		jwk.Kid = idp.kid
+		_ = json.NewEncoder(w).Encode(jwt.KeySet{Keys: []jwt.JWK{jwk}})
+	})
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) rotateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp.mutex.Lock()
+	defer idp.mutex.Unlock()
+	idp.kid = fmt.Sprintf("key-%d", time.Now().UnixNano())
+	idp.key = jwt.RsaSha256(key)
+}
+
+// authorize signs the user in right away and redirects back with a code
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+		return
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mutex.Lock()
+	idp.grants[code] = query
+	idp.mutex.Unlock()
+	redirect := fmt.Sprintf("%s?code=%s&state=%s", query.Get("redirect_uri"), code, url.QueryEscape(query.Get("state")))
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mutex.Lock()
+	defer idp.mutex.Unlock()
+	grant, ok := idp.grants[r.PostFormValue("code")]
+	delete(idp.grants, r.PostFormValue("code"))
+	clientID, secret, _ := r.BasicAuth()
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok ||
+		r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != grant.Get("redirect_uri") ||
+		clientID != testOIDCClientID || secret != testOIDCClientSecret ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.Get("code_challenge") {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	claims := jwt.NewClaims()
+	claims["iss"] = idp.server.URL
+	claims["sub"] = "subject"
+	claims["aud"] = grant.Get("client_id")
+	claims["nonce"] = grant.Get("nonce")
+	claims["email"] = testOIDCEmail
+	claims["email_verified"] = true
+	claims.SetTime("exp", time.Now().Add(time.Minute))
+	idp.modifyClaims(claims)
+	signingKey := idp.key
+	if idp.forgedKey != nil {
+		signingKey = idp.forgedKey
+	}
+	idToken, err := signingKey.EncodeWithKeyID(idp.kid, claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// signIn follows the authorization URL to the provider and returns code and state of the redirect back
+func (idp *mockIdP) signIn(t *testing.T, authorizationURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Get(authorizationURL)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusFound, response.StatusCode)
+	location, err := url.Parse(response.Header.Get("Location"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(location.String(), testOIDCRedirectURL))
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+type oidcMocks struct {
+	userRepository *mockUserRepository
+	sessionStarter *mockSessionStarter
+}
+
+func newTestOIDCAuthenticator(
+	t *testing.T,
+	idp *mockIdP,
+	autoProvision bool,
+) (*authentication.OIDCAuthenticator, oidcMocks) {
+	return newTestOIDCAuthenticatorWithTwoFactor(t, idp, autoProvision, noTwoFactor)
+}
+
+func newTestOIDCAuthenticatorWithTwoFactor(
+	t *testing.T,
+	idp *mockIdP,
+	autoProvision bool,
+	twoFactor authentication.TwoFactorChallenger,
+) (*authentication.OIDCAuthenticator, oidcMocks) {
+	clearRedis()
+	provider, err := authentication.DiscoverOIDCProvider(context.Background(), http.DefaultClient, idp.server.URL)
+	require.NoError(t, err)
+	mocks := oidcMocks{userRepository: new(mockUserRepository), sessionStarter: new(mockSessionStarter)}
+	authenticator := authentication.NewOIDCAuthenticator(
+		http.DefaultClient,
+		authentication.OIDCConfig{
+			ClientID:      testOIDCClientID,
+			ClientSecret:  testOIDCClientSecret,
+			RedirectURL:   testOIDCRedirectURL,
+			AutoProvision: autoProvision,
+		},
+		new(mockQuerier),
+		simplePasswordChecker,
+		provider,
+		mocks.sessionStarter,
+		authentication.NewRedisOneTimeTokenStore(redisClient),
+		twoFactor,
+		mocks.userRepository,
+	)
+	return authenticator, mocks
+}
+
+func TestDiscoverOIDCProvider(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+
+	// when
+	provider, err := authentication.DiscoverOIDCProvider(context.Background(), http.DefaultClient, idp.server.URL)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, idp.server.URL, provider.Issuer)
+	assert.Equal(t, idp.server.URL+"/token", provider.TokenEndpoint)
+	assert.Equal(t, idp.server.URL+"/jwks", provider.JWKSURI)
+}
+
+func TestDiscoverOIDCProviderErrors(t *testing.T) {
+	idp := newMockIdP(t)
+	tests := []struct {
+		name   string
+		issuer string
+	}{
+		{name: "other issuer", issuer: idp.server.URL + "/"},
+		{name: "no configuration", issuer: idp.server.URL + "/tenant"},
+		{name: "unreachable", issuer: "http://127.0.0.1:1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := authentication.DiscoverOIDCProvider(context.Background(), http.DefaultClient, test.issuer)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, _ := newTestOIDCAuthenticator(t, idp, false)
+
+	// when
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+
+	// then
+	require.NoError(t, err)
+	parsed, err := url.Parse(authorizationURL)
+	require.NoError(t, err)
+	assert.Equal(t, idp.server.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	query := parsed.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, testOIDCClientID, query.Get("client_id"))
+	assert.Equal(t, testOIDCRedirectURL, query.Get("redirect_uri"))
+	assert.Contains(t, strings.Fields(query.Get("scope")), "openid")
+	assert.Contains(t, strings.Fields(query.Get("scope")), "email")
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.Len(t, query.Get("code_challenge"), 43)
+	assert.NotEmpty(t, query.Get("state"))
+	assert.NotEmpty(t, query.Get("nonce"))
+	another, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, authorizationURL, another)
+}
+
+func TestOIDCCallbackLinksExistingUser(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, mocks := newTestOIDCAuthenticator(t, idp, false)
+	user := expenses.User{ID: 1, Email: testOIDCEmail, GroupID: 2, Verified: true}
+	expected := authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(user, nil)
+	mocks.sessionStarter.On("Start", authentication.UserContext{UserID: 1, GroupID: 2}, mock.Anything).
+		Return(expected, nil)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+
+	// when
+	tokens, err := authenticator.Callback(context.Background(), code, state)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, expected, tokens)
+	mocks.userRepository.AssertExpectations(t)
+	mocks.userRepository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	mocks.userRepository.AssertNotCalled(t, "MarkVerified", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestOIDCCallbackChallengesUserWithTwoFactor(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	challenger := new(mockTwoFactorChallenger)
+	authenticator, mocks := newTestOIDCAuthenticatorWithTwoFactor(t, idp, false, challenger)
+	user := expenses.User{ID: 1, Email: testOIDCEmail, GroupID: 2, Verified: true}
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(user, nil)
+	challenger.On("Challenge", mock.Anything, uint(1)).Return("mfa-token", nil)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+
+	// when
+	tokens, err := authenticator.Callback(context.Background(), code, state)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, authentication.TokenResponse{MFAToken: "mfa-token"}, tokens)
+	mocks.sessionStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+}
+
+func TestOIDCCallbackReturnsErrorWhenTwoFactorChallengeFails(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	challenger := new(mockTwoFactorChallenger)
+	authenticator, mocks := newTestOIDCAuthenticatorWithTwoFactor(t, idp, false, challenger)
+	expectedErr := errors.New("expected")
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(expenses.User{ID: 1, Email: testOIDCEmail, Verified: true}, nil)
+	challenger.On("Challenge", mock.Anything, uint(1)).Return("", expectedErr)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+
+	// when
+	tokens, err := authenticator.Callback(context.Background(), code, state)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	assert.Zero(t, tokens)
+	mocks.sessionStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+}
+
+func TestOIDCCallbackVerifiesEmailOfLinkedUser(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, mocks := newTestOIDCAuthenticator(t, idp, false)
+	user := expenses.User{ID: 1, Email: testOIDCEmail}
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(user, nil)
+	mocks.userRepository.On("MarkVerified", mock.Anything, mock.Anything, uint(1), expenses.Email(testOIDCEmail)).
+		Return(nil)
+	mocks.sessionStarter.On("Start", authentication.UserContext{UserID: 1}, mock.Anything).
+		Return(authentication.TokenResponse{AccessToken: "access"}, nil)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+
+	// when
+	_, err = authenticator.Callback(context.Background(), code, state)
+
+	// then
+	require.NoError(t, err)
+	mocks.userRepository.AssertExpectations(t)
+}
+
+func TestOIDCCallbackProvisionsUser(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, mocks := newTestOIDCAuthenticator(t, idp, true)
+	user := expenses.User{ID: 3, Email: testOIDCEmail}
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(expenses.User{}, expenses.ErrUserNotFound)
+	var password expenses.Password
+	mocks.userRepository.On("Create", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { password = args.Get(2).(expenses.CreateUserRequest).Password }).
+		Return(user, nil)
+	mocks.userRepository.On("MarkVerified", mock.Anything, mock.Anything, uint(3), expenses.Email(testOIDCEmail)).
+		Return(nil)
+	mocks.sessionStarter.On("Start", authentication.UserContext{UserID: 3}, mock.Anything).
+		Return(authentication.TokenResponse{AccessToken: "access"}, nil)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+
+	// when
+	tokens, err := authenticator.Callback(context.Background(), code, state)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, "access", tokens.AccessToken)
+	assert.GreaterOrEqual(t, len(password), 43)
+	mocks.userRepository.AssertExpectations(t)
+}
+
+func TestOIDCCallbackWithoutAutoProvision(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, mocks := newTestOIDCAuthenticator(t, idp, false)
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(expenses.User{}, expenses.ErrUserNotFound)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+
+	// when
+	_, err = authenticator.Callback(context.Background(), code, state)
+
+	// then
+	assert.Equal(t, authentication.ErrOIDCUserNotFound, err)
+	mocks.userRepository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	mocks.sessionStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+}
+
+func TestOIDCCallbackAcceptsRotatedKey(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, mocks := newTestOIDCAuthenticator(t, idp, false)
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(expenses.User{ID: 1, Email: testOIDCEmail, Verified: true}, nil)
+	mocks.sessionStarter.On("Start", authentication.UserContext{UserID: 1}, mock.Anything).
+		Return(authentication.TokenResponse{AccessToken: "access"}, nil)
+	for i := 0; i < 2; i++ {
+		authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+		require.NoError(t, err)
+		code, state := idp.signIn(t, authorizationURL)
+
+		// when
+		_, err = authenticator.Callback(context.Background(), code, state)
+
+		// then
+		require.NoError(t, err)
+		idp.rotateKey(t)
+	}
+}
+
+func TestOIDCCallbackErrors(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tests := []struct {
+		name         string
+		modifyClaims func(claims jwt.Claims)
+		// tamper with code and state that the provider has returned
+		tamper      func(code string, state string) (string, string)
+		forgedKey   *jwt.Algorithm
+		expectedErr error
+	}{
+		{
+			name:        "unknown state",
+			tamper:      func(code string, state string) (string, string) { return code, "unknown" },
+			expectedErr: authentication.ErrInvalidOIDCState,
+		},
+		{
+			name:        "unknown code",
+			tamper:      func(code string, state string) (string, string) { return "unknown", state },
+			expectedErr: authentication.ErrOIDCCodeRejected,
+		},
+		{
+			name:         "other issuer",
+			modifyClaims: func(claims jwt.Claims) { claims["iss"] = "http://other" },
+			expectedErr:  authentication.ErrInvalidIDToken,
+		},
+		{
+			name:         "other audience",
+			modifyClaims: func(claims jwt.Claims) { claims["aud"] = "other" },
+			expectedErr:  authentication.ErrInvalidIDToken,
+		},
+		{
+			name: "several audiences without authorized party",
+			modifyClaims: func(claims jwt.Claims) {
+				claims["aud"] = []string{testOIDCClientID, "other"}
+			},
+			expectedErr: authentication.ErrInvalidIDToken,
+		},
+		{
+			name:         "other nonce",
+			modifyClaims: func(claims jwt.Claims) { claims["nonce"] = "other" },
+			expectedErr:  authentication.ErrInvalidIDToken,
+		},
+		{
+			name:         "expired",
+			modifyClaims: func(claims jwt.Claims) { claims.SetTime("exp", time.Now().Add(-time.Minute)) },
+			expectedErr:  authentication.ErrInvalidIDToken,
+		},
+		{
+			name:         "without expiration",
+			modifyClaims: func(claims jwt.Claims) { delete(claims, "exp") },
+			expectedErr:  authentication.ErrInvalidIDToken,
+		},
+		{
+			name:         "email not verified",
+			modifyClaims: func(claims jwt.Claims) { claims["email_verified"] = false },
+			expectedErr:  authentication.ErrOIDCEmailNotVerified,
+		},
+		{
+			name:         "incorrect email",
+			modifyClaims: func(claims jwt.Claims) { claims["email"] = "not an email" },
+			expectedErr:  authentication.ErrInvalidIDToken,
+		},
+		{
+			name:        "signed with unknown key",
+			forgedKey:   jwt.RsaSha256(otherKey),
+			expectedErr: authentication.ErrInvalidIDToken,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			idp := newMockIdP(t)
+			authenticator, mocks := newTestOIDCAuthenticator(t, idp, true)
+			if test.modifyClaims != nil {
+				idp.modifyClaims = test.modifyClaims
+			}
+			idp.forgedKey = test.forgedKey
+			authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+			require.NoError(t, err)
+			code, state := idp.signIn(t, authorizationURL)
+			if test.tamper != nil {
+				code, state = test.tamper(code, state)
+			}
+
+			// when
+			_, err = authenticator.Callback(context.Background(), code, state)
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+			mocks.sessionStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestOIDCCallbackStateIsSingleUse(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, mocks := newTestOIDCAuthenticator(t, idp, false)
+	mocks.userRepository.On("FindByEmail", mock.Anything, mock.Anything, expenses.Email(testOIDCEmail)).
+		Return(expenses.User{ID: 1, Email: testOIDCEmail, Verified: true}, nil)
+	mocks.sessionStarter.On("Start", authentication.UserContext{UserID: 1}, mock.Anything).
+		Return(authentication.TokenResponse{AccessToken: "access"}, nil)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+	_, err = authenticator.Callback(context.Background(), code, state)
+	require.NoError(t, err)
+
+	// when
+	_, err = authenticator.Callback(context.Background(), code, state)
+
+	// then
+	assert.Equal(t, authentication.ErrInvalidOIDCState, err)
+}
+
+func TestOIDCCallbackWithCodeOfAnotherAuthorization(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, _ := newTestOIDCAuthenticator(t, idp, false)
+	first, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	second, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, _ := idp.signIn(t, first)
+	_, state := idp.signIn(t, second)
+
+	// when
+	_, err = authenticator.Callback(context.Background(), code, state)
+
+	// then PKCE verifier of the second authorization doesn't match the challenge of the first one
+	assert.Equal(t, authentication.ErrOIDCCodeRejected, err)
+}
+
+func TestOIDCCallbackProviderError(t *testing.T) {
+	// given
+	idp := newMockIdP(t)
+	authenticator, _ := newTestOIDCAuthenticator(t, idp, false)
+	authorizationURL, err := authenticator.AuthorizationURL(context.Background())
+	require.NoError(t, err)
+	code, state := idp.signIn(t, authorizationURL)
+	idp.server.Close()
+
+	// when
+	_, err = authenticator.Callback(context.Background(), code, state)
+
+	// then
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, authentication.ErrOIDCCodeRejected))
+}
@@ -0,0 +1,136 @@
+package authentication
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis"
+	"go-spend/util"
+	"sync"
+	"time"
+)
+
+const oneTimeTokenSize = 32
+
+var (
+	ErrOneTimeTokenNotFound = errors.New("token is unknown, expired or was already used")
+)
+
+// OneTimeTokenStore issues random tokens that can be exchanged for the stored value only once. Purpose separates
+// tokens of different flows, so that a token of one flow is never accepted by another.
+type OneTimeTokenStore interface {
+	// Create a token for the value that expires after expiration
+	Create(purpose string, value string, expiration time.Duration) (string, error)
+	// Consume returns the value of the token and forgets the token. ErrOneTimeTokenNotFound is returned if there is
+	// no such token.
+	Consume(purpose string, token string) (string, error)
+}
+
+// RedisOneTimeTokenStore keeps only hashes of tokens, so that tokens can't be used by anyone with access to Redis
+type RedisOneTimeTokenStore struct {
+	redis redis.UniversalClient
+}
+
+// NewRedisOneTimeTokenStore creates new instance of RedisOneTimeTokenStore
+func NewRedisOneTimeTokenStore(redis redis.UniversalClient) *RedisOneTimeTokenStore {
+	return &RedisOneTimeTokenStore{redis: redis}
+}
+
+func (s *RedisOneTimeTokenStore) Create(purpose string, value string, expiration time.Duration) (string, error) {
+	random := make([]byte, oneTimeTokenSize)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	if err := s.redis.Set(oneTimeTokenKey(purpose, token), value, expiration).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Consume reads and removes the token in one transaction, so that the token can't be used twice concurrently
+func (s *RedisOneTimeTokenStore) Consume(purpose string, token string) (string, error) {
+	key := oneTimeTokenKey(purpose, token)
+	var get *redis.StringCmd
+	_, err := s.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key)
+		pipe.Del(key)
+		return nil
+	})
+	if err == redis.Nil {
+		return "", ErrOneTimeTokenNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return get.Val(), nil
+}
+
+// MemoryOneTimeTokenStore keeps hashes of tokens in memory of the instance, for deployments without redis. Expired
+// tokens are removed by a janitor goroutine until Stop.
+type MemoryOneTimeTokenStore struct {
+	mutex  sync.Mutex
+	tokens map[string]memoryOneTimeToken
+	stop   func()
+}
+
+type memoryOneTimeToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewMemoryOneTimeTokenStore creates new instance of MemoryOneTimeTokenStore that removes expired tokens every
+// janitorInterval
+func NewMemoryOneTimeTokenStore(janitorInterval time.Duration) *MemoryOneTimeTokenStore {
+	s := &MemoryOneTimeTokenStore{tokens: make(map[string]memoryOneTimeToken)}
+	s.stop = util.StartJanitor(janitorInterval, s.removeExpired)
+	return s
+}
+
+func (s *MemoryOneTimeTokenStore) Create(purpose string, value string, expiration time.Duration) (string, error) {
+	random := make([]byte, oneTimeTokenSize)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.tokens[oneTimeTokenKey(purpose, token)] = memoryOneTimeToken{value: value, expiresAt: time.Now().Add(expiration)}
+	return token, nil
+}
+
+func (s *MemoryOneTimeTokenStore) Consume(purpose string, token string) (string, error) {
+	key := oneTimeTokenKey(purpose, token)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stored, ok := s.tokens[key]
+	delete(s.tokens, key)
+	if !ok || !stored.expiresAt.After(time.Now()) {
+		return "", ErrOneTimeTokenNotFound
+	}
+	return stored.value, nil
+}
+
+// Stop the janitor
+func (s *MemoryOneTimeTokenStore) Stop() {
+	s.stop()
+}
+
+func (s *MemoryOneTimeTokenStore) removeExpired() {
+	now := time.Now()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, stored := range s.tokens {
+		if !stored.expiresAt.After(now) {
+			delete(s.tokens, key)
+		}
+	}
+}
+
+func oneTimeTokenKey(purpose string, token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%s_%s", purpose, hex.EncodeToString(hash[:]))
+}
@@ -0,0 +1,117 @@
+package authentication_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"testing"
+	"time"
+)
+
+// oneTimeTokenStores create an empty OneTimeTokenStore of every implementation, so that all of them pass the same tests
+var oneTimeTokenStores = []struct {
+	name     string
+	newStore func(t *testing.T) authentication.OneTimeTokenStore
+}{
+	{
+		name: "redis",
+		newStore: func(t *testing.T) authentication.OneTimeTokenStore {
+			clearRedis()
+			return authentication.NewRedisOneTimeTokenStore(redisClient)
+		},
+	},
+	{
+		name: "memory",
+		newStore: func(t *testing.T) authentication.OneTimeTokenStore {
+			store := authentication.NewMemoryOneTimeTokenStore(time.Minute)
+			t.Cleanup(store.Stop)
+			return store
+		},
+	},
+}
+
+func TestOneTimeTokenStore(t *testing.T) {
+	for _, implementation := range oneTimeTokenStores {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			store := implementation.newStore(t)
+			token, err := store.Create("purpose", "value", time.Minute)
+			require.NoError(t, err)
+			otherToken, err := store.Create("purpose", "value", time.Minute)
+			require.NoError(t, err)
+
+			// when
+			value, err := store.Consume("purpose", token)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, "value", value)
+			assert.NotEqual(t, token, otherToken)
+			_, err = store.Consume("purpose", token)
+			assert.Equal(t, authentication.ErrOneTimeTokenNotFound, err, "token can be used only once")
+			value, err = store.Consume("purpose", otherToken)
+			require.NoError(t, err)
+			assert.Equal(t, "value", value)
+		})
+	}
+}
+
+func TestRedisOneTimeTokenStoreKeepsOnlyHash(t *testing.T) {
+	clearRedis()
+	// given
+	store := authentication.NewRedisOneTimeTokenStore(redisClient)
+
+	// when
+	token, err := store.Create("purpose", "value", time.Minute)
+
+	// then
+	require.NoError(t, err)
+	keys, err := redisClient.Keys("*").Result()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotContains(t, keys[0], token)
+	ttl, err := redisClient.TTL(keys[0]).Result()
+	require.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+}
+
+func TestOneTimeTokenStoreSeparatesPurposes(t *testing.T) {
+	for _, implementation := range oneTimeTokenStores {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			store := implementation.newStore(t)
+			token, err := store.Create("purpose", "value", time.Minute)
+			require.NoError(t, err)
+
+			// when
+			_, err = store.Consume("other", token)
+
+			// then
+			assert.Equal(t, authentication.ErrOneTimeTokenNotFound, err)
+		})
+	}
+}
+
+func TestOneTimeTokenStoreUnknownToken(t *testing.T) {
+	for _, implementation := range oneTimeTokenStores {
+		t.Run(implementation.name, func(t *testing.T) {
+			_, err := implementation.newStore(t).Consume("purpose", "unknown")
+			assert.Equal(t, authentication.ErrOneTimeTokenNotFound, err)
+		})
+	}
+}
+
+func TestMemoryOneTimeTokenStoreExpires(t *testing.T) {
+	// given
+	store := authentication.NewMemoryOneTimeTokenStore(time.Minute)
+	defer store.Stop()
+	token, err := store.Create("purpose", "value", 10*time.Millisecond)
+	require.NoError(t, err)
+
+	// when
+	time.Sleep(20 * time.Millisecond)
+	_, err = store.Consume("purpose", token)
+
+	// then
+	assert.Equal(t, authentication.ErrOneTimeTokenNotFound, err)
+}
@@ -11,6 +11,15 @@ type PasswordEncoderChecker interface {
 	PasswordChecker
 }
 
+// RehashingPasswordEncoder is a PasswordEncoderChecker that knows when a hash is outdated, so that the password can
+// be hashed again while it is known, e.g. after a successful authentication
+type RehashingPasswordEncoder interface {
+	PasswordEncoderChecker
+	// NeedsRehash returns true if the hash was created by another algorithm or with other parameters than Encode
+	// would use now
+	NeedsRehash(hashed string) bool
+}
+
 // PasswordEncoder hashes the password
 type PasswordEncoder interface {
 	Encode(password string) (string, error)
@@ -21,17 +30,23 @@ type PasswordChecker interface {
 	Check(hashed string, toTest string) bool
 }
 
-// BCryptPasswordEncoder is a PasswordEncoder that uses BCrypt algorithm
+// BCryptPasswordEncoder is a PasswordEncoder that uses BCrypt algorithm. Zero value uses bcrypt.DefaultCost.
 type BCryptPasswordEncoder struct {
+	cost int
 }
 
-// NewBCryptPasswordEncoder creates new instance of BCryptPasswordEncoder
+// NewBCryptPasswordEncoder creates new instance of BCryptPasswordEncoder with bcrypt.DefaultCost
 func NewBCryptPasswordEncoder() *BCryptPasswordEncoder {
 	return &BCryptPasswordEncoder{}
 }
 
-func (*BCryptPasswordEncoder) Encode(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+// NewBCryptPasswordEncoderWithCost creates new instance of BCryptPasswordEncoder. Cost is checked by bcrypt on Encode.
+func NewBCryptPasswordEncoderWithCost(cost int) *BCryptPasswordEncoder {
+	return &BCryptPasswordEncoder{cost: cost}
+}
+
+func (b *BCryptPasswordEncoder) Encode(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), b.currentCost())
 	if err != nil {
 		return "", err
 	}
@@ -42,6 +57,55 @@ func (*BCryptPasswordEncoder) Check(hashed string, toTest string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(toTest)) == nil
 }
 
+// NeedsRehash returns true if the hash is not a bcrypt hash or has another cost
+func (b *BCryptPasswordEncoder) NeedsRehash(hashed string) bool {
+	cost, err := bcrypt.Cost([]byte(hashed))
+	return err != nil || cost != b.currentCost()
+}
+
+func (b *BCryptPasswordEncoder) currentCost() int {
+	if b.cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return b.cost
+}
+
+// MigratingPasswordEncoder hashes passwords with the current encoder, but still accepts hashes of legacy encoders.
+// Hashes of legacy encoders always need a rehash, so users are moved to the current encoder as they authenticate.
+type MigratingPasswordEncoder struct {
+	current RehashingPasswordEncoder
+	legacy  []PasswordChecker
+}
+
+// NewMigratingPasswordEncoder creates new instance of MigratingPasswordEncoder. Legacy checkers are tried in order
+// when the current encoder doesn't accept a password, so they have to reject hashes they don't recognize.
+func NewMigratingPasswordEncoder(
+	current RehashingPasswordEncoder,
+	legacy ...PasswordChecker,
+) *MigratingPasswordEncoder {
+	return &MigratingPasswordEncoder{current: current, legacy: legacy}
+}
+
+func (m *MigratingPasswordEncoder) Encode(password string) (string, error) {
+	return m.current.Encode(password)
+}
+
+func (m *MigratingPasswordEncoder) Check(hashed string, toTest string) bool {
+	if m.current.Check(hashed, toTest) {
+		return true
+	}
+	for _, checker := range m.legacy {
+		if checker.Check(hashed, toTest) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MigratingPasswordEncoder) NeedsRehash(hashed string) bool {
+	return m.current.NeedsRehash(hashed)
+}
+
 type NoAcPasswordEncoder struct {
 }
 
@@ -52,3 +116,7 @@ func (*NoAcPasswordEncoder) Encode(password string) (string, error) {
 func (*NoAcPasswordEncoder) Check(hashed string, toTest string) bool {
 	return hashed == toTest
 }
+
+func (*NoAcPasswordEncoder) NeedsRehash(_ string) bool {
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go-spend/authentication"
+	"strings"
 	"testing"
 )
 
@@ -58,3 +59,136 @@ func TestNoAcPasswordEncoder(t *testing.T) {
 		})
 	}
 }
+
+func TestBCryptPasswordEncoderNeedsRehash(t *testing.T) {
+	encoder := authentication.NewBCryptPasswordEncoderWithCost(5)
+	hashed, err := encoder.Encode("password")
+	require.NoError(t, err)
+	assert.False(t, encoder.NeedsRehash(hashed))
+	assert.True(t, authentication.NewBCryptPasswordEncoderWithCost(6).NeedsRehash(hashed))
+	assert.True(t, encoder.NeedsRehash("password"))
+}
+
+var testArgon2idParams = authentication.Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestNewArgon2idPasswordEncoder(t *testing.T) {
+	encoder, err := authentication.NewArgon2idPasswordEncoder(authentication.DefaultArgon2idParams)
+	require.NoError(t, err)
+	assert.NotNil(t, encoder)
+}
+
+func TestNewArgon2idPasswordEncoderRejectsIncorrectParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(params *authentication.Argon2idParams)
+	}{
+		{name: "no memory", modify: func(params *authentication.Argon2idParams) { params.Memory = 0 }},
+		{name: "not enough memory per thread", modify: func(params *authentication.Argon2idParams) {
+			params.Memory = 16
+			params.Parallelism = 4
+		}},
+		{name: "no iterations", modify: func(params *authentication.Argon2idParams) { params.Iterations = 0 }},
+		{name: "no parallelism", modify: func(params *authentication.Argon2idParams) { params.Parallelism = 0 }},
+		{name: "no salt", modify: func(params *authentication.Argon2idParams) { params.SaltLength = 0 }},
+		{name: "no key", modify: func(params *authentication.Argon2idParams) { params.KeyLength = 0 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := testArgon2idParams
+			test.modify(&params)
+			encoder, err := authentication.NewArgon2idPasswordEncoder(params)
+			assert.Equal(t, authentication.ErrIncorrectArgon2idParams, err)
+			assert.Nil(t, encoder)
+		})
+	}
+}
+
+func TestArgon2idPasswordEncoder(t *testing.T) {
+	tests := []struct {
+		name string
+	}{
+		{name: "12414681s"},
+		{name: "T^E*&"},
+		{name: "оылфврфлор(*ЦУ*?"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder, err := authentication.NewArgon2idPasswordEncoder(testArgon2idParams)
+			require.NoError(t, err)
+			password := test.name
+			hashed1, err := encoder.Encode(password)
+			require.NoError(t, err)
+			hashed2, err := encoder.Encode(password)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(hashed1, "$argon2id$v=19$m=1024,t=1,p=1$"))
+			assert.True(t, encoder.Check(hashed1, password))
+			assert.True(t, encoder.Check(hashed2, password))
+			assert.False(t, encoder.Check(hashed1, password+"1"))
+			assert.NotEqual(t, hashed1, hashed2)
+		})
+	}
+}
+
+func TestArgon2idPasswordEncoderChecksWithParamsOfTheHash(t *testing.T) {
+	old, err := authentication.NewArgon2idPasswordEncoder(testArgon2idParams)
+	require.NoError(t, err)
+	hashed, err := old.Encode("password")
+	require.NoError(t, err)
+	params := testArgon2idParams
+	params.Iterations = 2
+	current, err := authentication.NewArgon2idPasswordEncoder(params)
+	require.NoError(t, err)
+
+	assert.True(t, current.Check(hashed, "password"))
+	assert.True(t, current.NeedsRehash(hashed))
+	assert.False(t, old.NeedsRehash(hashed))
+}
+
+func TestArgon2idPasswordEncoderRejectsOtherHashes(t *testing.T) {
+	encoder, err := authentication.NewArgon2idPasswordEncoder(testArgon2idParams)
+	require.NoError(t, err)
+	bcryptHash, err := authentication.NewBCryptPasswordEncoderWithCost(4).Encode("password")
+	require.NoError(t, err)
+	tests := []struct {
+		name   string
+		hashed string
+	}{
+		{name: "bcrypt", hashed: bcryptHash},
+		{name: "plain text", hashed: "password"},
+		{name: "other version", hashed: "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{name: "argon2i", hashed: "$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5"},
+		{name: "broken params", hashed: "$argon2id$v=19$m=1024$c2FsdHNhbHQ$a2V5a2V5"},
+		{name: "broken salt", hashed: "$argon2id$v=19$m=1024,t=1,p=1$!!!$a2V5a2V5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.False(t, encoder.Check(test.hashed, "password"))
+			assert.True(t, encoder.NeedsRehash(test.hashed))
+		})
+	}
+}
+
+func TestMigratingPasswordEncoder(t *testing.T) {
+	argon2id, err := authentication.NewArgon2idPasswordEncoder(testArgon2idParams)
+	require.NoError(t, err)
+	bcrypt := authentication.NewBCryptPasswordEncoderWithCost(4)
+	encoder := authentication.NewMigratingPasswordEncoder(argon2id, bcrypt)
+	legacyHash, err := bcrypt.Encode("password")
+	require.NoError(t, err)
+
+	hashed, err := encoder.Encode("password")
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(hashed, "$argon2id$"))
+	assert.True(t, encoder.Check(hashed, "password"))
+	assert.False(t, encoder.NeedsRehash(hashed))
+	assert.True(t, encoder.Check(legacyHash, "password"))
+	assert.False(t, encoder.Check(legacyHash, "other"))
+	assert.True(t, encoder.NeedsRehash(legacyHash))
+}
@@ -0,0 +1,180 @@
+package authentication
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	breachedHashLength = 2 * sha1.Size
+	// breachedPrefixLength is the length of a hash prefix that is shared with an index. It is short enough for many
+	// hashes to share it, so the index can't tell which password is checked.
+	breachedPrefixLength = 5
+)
+
+// PasswordPolicyError is a reason to reject a password chosen by a user
+type PasswordPolicyError string
+
+func (e PasswordPolicyError) Error() string {
+	return string(e)
+}
+
+var (
+	ErrPasswordTooShort  = PasswordPolicyError("password is too short")
+	ErrPasswordTooSimple = PasswordPolicyError("password doesn't have enough kinds of characters")
+	ErrPasswordBreached  = PasswordPolicyError("password was found in a data breach")
+)
+
+// PasswordPolicy decides whether a password is good enough to be chosen. Violations are reported with
+// PasswordPolicyError, other errors mean that the password couldn't be checked.
+type PasswordPolicy interface {
+	Check(password string) error
+}
+
+// PasswordPolicies is a PasswordPolicy that requires a password to satisfy all the policies, violations are
+// checked in order. Empty PasswordPolicies accepts any password.
+type PasswordPolicies []PasswordPolicy
+
+func (p PasswordPolicies) Check(password string) error {
+	for _, policy := range p {
+		if err := policy.Check(password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LengthPasswordPolicy requires a minimum number of characters, not bytes
+type LengthPasswordPolicy struct {
+	minLength int
+}
+
+// NewLengthPasswordPolicy creates new instance of LengthPasswordPolicy
+func NewLengthPasswordPolicy(minLength int) *LengthPasswordPolicy {
+	return &LengthPasswordPolicy{minLength: minLength}
+}
+
+func (p *LengthPasswordPolicy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.minLength {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
+
+// CharacterClassPasswordPolicy requires characters from a minimum number of classes. The classes are lower case
+// letters, upper case letters, digits and everything else.
+type CharacterClassPasswordPolicy struct {
+	minClasses int
+}
+
+// NewCharacterClassPasswordPolicy creates new instance of CharacterClassPasswordPolicy
+func NewCharacterClassPasswordPolicy(minClasses int) *CharacterClassPasswordPolicy {
+	return &CharacterClassPasswordPolicy{minClasses: minClasses}
+}
+
+func (p *CharacterClassPasswordPolicy) Check(password string) error {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if lower+upper+digit+other < p.minClasses {
+		return ErrPasswordTooSimple
+	}
+	return nil
+}
+
+// BreachedPasswordIndex finds hashes of breached passwords by a prefix, so that neither the password nor its full
+// hash is shared with the index
+type BreachedPasswordIndex interface {
+	// Range returns suffixes of upper case hex SHA-1 hashes that start with the prefix
+	Range(prefix string) ([]string, error)
+}
+
+// BreachedPasswordPolicy rejects passwords that are known from data breaches
+type BreachedPasswordPolicy struct {
+	index BreachedPasswordIndex
+}
+
+// NewBreachedPasswordPolicy creates new instance of BreachedPasswordPolicy
+func NewBreachedPasswordPolicy(index BreachedPasswordIndex) *BreachedPasswordPolicy {
+	return &BreachedPasswordPolicy{index: index}
+}
+
+func (p *BreachedPasswordPolicy) Check(password string) error {
+	hash := breachedPasswordHash(password)
+	suffixes, err := p.index.Range(hash[:breachedPrefixLength])
+	if err != nil {
+		return err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[breachedPrefixLength:] {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
+
+// PrefixBreachedPasswordIndex is an in-memory BreachedPasswordIndex with hash suffixes grouped by prefix
+type PrefixBreachedPasswordIndex struct {
+	suffixes map[string][]string
+}
+
+// LoadBreachedPasswordIndex reads a file with one breached password per line. A line is either an SHA-1 hash in hex,
+// optionally followed by a colon and a number of occurrences like in Have I Been Pwned downloads, or a password
+// in plain text. Empty lines are skipped.
+func LoadBreachedPasswordIndex(path string) (*PrefixBreachedPasswordIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	index := &PrefixBreachedPasswordIndex{suffixes: make(map[string][]string)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hash := strings.ToUpper(strings.SplitN(line, ":", 2)[0])
+		if !isSha1Hex(hash) {
+			hash = breachedPasswordHash(line)
+		}
+		prefix := hash[:breachedPrefixLength]
+		index.suffixes[prefix] = append(index.suffixes[prefix], hash[breachedPrefixLength:])
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+func (i *PrefixBreachedPasswordIndex) Range(prefix string) ([]string, error) {
+	return i.suffixes[strings.ToUpper(prefix)], nil
+}
+
+func breachedPasswordHash(password string) string {
+	hash := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+func isSha1Hex(value string) bool {
+	if len(value) != breachedHashLength {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
@@ -0,0 +1,88 @@
+package authentication_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLengthPasswordPolicy(t *testing.T) {
+	policy := authentication.NewLengthPasswordPolicy(8)
+	assert.NoError(t, policy.Check("12345678"))
+	assert.NoError(t, policy.Check("пароль12"))
+	assert.Equal(t, authentication.ErrPasswordTooShort, policy.Check("1234567"))
+}
+
+func TestCharacterClassPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		expected error
+	}{
+		{password: "password", expected: authentication.ErrPasswordTooSimple},
+		{password: "PASSWORD", expected: authentication.ErrPasswordTooSimple},
+		{password: "Password", expected: authentication.ErrPasswordTooSimple},
+		{password: "Password1"},
+		{password: "password1!"},
+		{password: "Пароль1"},
+	}
+	policy := authentication.NewCharacterClassPasswordPolicy(3)
+	for _, test := range tests {
+		t.Run(test.password, func(t *testing.T) {
+			assert.Equal(t, test.expected, policy.Check(test.password))
+		})
+	}
+}
+
+func TestPasswordPoliciesReturnsFirstViolation(t *testing.T) {
+	policies := authentication.PasswordPolicies{
+		authentication.NewLengthPasswordPolicy(8),
+		authentication.NewCharacterClassPasswordPolicy(2),
+	}
+	assert.Equal(t, authentication.ErrPasswordTooShort, policies.Check("short"))
+	assert.Equal(t, authentication.ErrPasswordTooSimple, policies.Check("longenough"))
+	assert.NoError(t, policies.Check("longenough1"))
+	assert.NoError(t, authentication.PasswordPolicies{}.Check(""))
+}
+
+func TestBreachedPasswordPolicy(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// SHA-1 of "password" in HIBP format, SHA-1 of "qwerty" in lower case and "letmein" in plain text
+	content := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n" +
+		"\n" +
+		"b1b3773a05c0ed0176787a4f1574ff0075f7521e\n" +
+		"letmein\n"
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	index, err := authentication.LoadBreachedPasswordIndex(path)
+	require.NoError(t, err)
+	policy := authentication.NewBreachedPasswordPolicy(index)
+
+	// then
+	assert.Equal(t, authentication.ErrPasswordBreached, policy.Check("password"))
+	assert.Equal(t, authentication.ErrPasswordBreached, policy.Check("qwerty"))
+	assert.Equal(t, authentication.ErrPasswordBreached, policy.Check("letmein"))
+	assert.NoError(t, policy.Check("correct horse battery staple"))
+}
+
+func TestBreachedPasswordIndexRangeSharesOnlyPrefix(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n"), 0600))
+	index, err := authentication.LoadBreachedPasswordIndex(path)
+	require.NoError(t, err)
+
+	// when
+	suffixes, err := index.Range("5baa6")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1E4C9B93F3F0682250B6CF8331B7EE68FD8"}, suffixes)
+}
+
+func TestLoadBreachedPasswordIndexWithoutFile(t *testing.T) {
+	_, err := authentication.LoadBreachedPasswordIndex(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package authentication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgtype/pgxtype"
+	"go-spend/expenses"
+	"go-spend/log"
+	"go-spend/mail"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	passwordResetPurpose    = "password_reset"
+	passwordResetExpiration = time.Hour
+)
+
+var (
+	ErrInvalidResetToken        = errors.New("password reset token is not valid")
+	ErrCurrentPasswordIncorrect = errors.New("current password is incorrect")
+)
+
+// PasswordService lets users regain access to their accounts
+type PasswordService interface {
+	// Forgot sends a password reset link to the user with the email
+	Forgot(ctx context.Context, email expenses.Email) error
+	// Reset sets a new password of the user of the reset token
+	Reset(ctx context.Context, request ResetPasswordRequest) error
+	// Change replaces the password of the user from the context if the current password is correct
+	Change(ctx context.Context, userContext UserContext, request ChangePasswordRequest) error
+}
+
+// DefaultPasswordService sends single-use reset tokens by email. Tokens expire in an hour. New passwords have to
+// satisfy the password policy, otherwise PasswordPolicyError is returned.
+type DefaultPasswordService struct {
+	db              pgxtype.Querier
+	failedAttempts  FailedAttemptsClearer
+	mailer          mail.Mailer
+	passwordEncoder PasswordEncoderChecker
+	passwordPolicy  PasswordPolicy
+	repository      expenses.UserRepository
+	sessionRemover  SessionRemover
+	tokenStore      OneTimeTokenStore
+	resetURL        string
+}
+
+// NewDefaultPasswordService creates new instance of DefaultPasswordService. The reset token is added to resetURL
+// as token query parameter.
+func NewDefaultPasswordService(
+	db pgxtype.Querier,
+	failedAttempts FailedAttemptsClearer,
+	mailer mail.Mailer,
+	passwordEncoder PasswordEncoderChecker,
+	passwordPolicy PasswordPolicy,
+	repository expenses.UserRepository,
+	sessionRemover SessionRemover,
+	tokenStore OneTimeTokenStore,
+	resetURL string,
+) *DefaultPasswordService {
+	return &DefaultPasswordService{
+		db:              db,
+		failedAttempts:  failedAttempts,
+		mailer:          mailer,
+		passwordEncoder: passwordEncoder,
+		passwordPolicy:  passwordPolicy,
+		repository:      repository,
+		sessionRemover:  sessionRemover,
+		tokenStore:      tokenStore,
+		resetURL:        resetURL,
+	}
+}
+
+// Forgot doesn't return an error for unknown emails, so that it is not possible to find out who is registered
+func (s *DefaultPasswordService) Forgot(ctx context.Context, email expenses.Email) error {
+	user, err := s.repository.FindByEmail(ctx, s.db, email)
+	if err == expenses.ErrUserNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	userID := strconv.FormatUint(uint64(user.ID), 10)
+	token, err := s.tokenStore.Create(passwordResetPurpose, userID, passwordResetExpiration)
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(s.resetURL, token)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(mail.Message{
+		To:      string(user.Email),
+		Subject: "Reset your go-spend password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password of your go-spend account. "+
+				"Follow the link within an hour to choose a new password:\n\n%s\n\n"+
+				"If it wasn't you, just ignore this email.",
+			link,
+		),
+	})
+}
+
+// Reset revokes all sessions of the user, as they might have been started by someone who knew the old password, and
+// clears failed authentication attempts of the user's email.
+// Returns ErrInvalidResetToken if token is unknown, expired or was already used.
+func (s *DefaultPasswordService) Reset(ctx context.Context, request ResetPasswordRequest) error {
+	if err := s.passwordPolicy.Check(string(request.Password)); err != nil {
+		return err
+	}
+	value, err := s.tokenStore.Consume(passwordResetPurpose, request.Token)
+	if err == ErrOneTimeTokenNotFound {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	encodedPassword, err := s.passwordEncoder.Encode(string(request.Password))
+	if err != nil {
+		return err
+	}
+	err = s.repository.UpdatePassword(ctx, s.db, uint(userID), expenses.Password(encodedPassword))
+	if err == expenses.ErrUserNotFound {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.sessionRemover.RemoveAllSessions(uint(userID)); err != nil {
+		return err
+	}
+	s.clearFailedAttempts(ctx, uint(userID))
+	return nil
+}
+
+// clearFailedAttempts only logs a failure, the password is already reset and the lockout expires eventually
+func (s *DefaultPasswordService) clearFailedAttempts(ctx context.Context, userID uint) {
+	user, err := s.repository.FindById(ctx, s.db, userID)
+	if err != nil {
+		log.Warn("couldn't clear failed authentication attempts of user %d - %s", userID, err)
+		return
+	}
+	s.failedAttempts.Clear(user.Email)
+}
+
+// Change revokes all sessions of the user except the one from the context, so that the user stays logged in only
+// where the password was changed. Returns ErrCurrentPasswordIncorrect if the current password doesn't match.
+func (s *DefaultPasswordService) Change(
+	ctx context.Context,
+	userContext UserContext,
+	request ChangePasswordRequest,
+) error {
+	user, err := s.repository.FindById(ctx, s.db, userContext.UserID)
+	if err != nil {
+		return err
+	}
+	if !s.passwordEncoder.Check(string(user.Password), string(request.CurrentPassword)) {
+		return ErrCurrentPasswordIncorrect
+	}
+	if err = s.passwordPolicy.Check(string(request.NewPassword)); err != nil {
+		return err
+	}
+	encodedPassword, err := s.passwordEncoder.Encode(string(request.NewPassword))
+	if err != nil {
+		return err
+	}
+	if err = s.repository.UpdatePassword(ctx, s.db, user.ID, expenses.Password(encodedPassword)); err != nil {
+		return err
+	}
+	return s.sessionRemover.RemoveOtherSessions(user.ID, userContext.SessionID)
+}
+
+// linkWithToken adds token to the query of baseURL
+func linkWithToken(baseURL string, token string) (string, error) {
+	link, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
@@ -0,0 +1,371 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/expenses"
+	"go-spend/mail"
+	"testing"
+	"time"
+)
+
+const testResetURL = "https://go-spend.com/reset?lang=en"
+
+type mockMailer struct {
+	mock.Mock
+}
+
+func (m *mockMailer) Send(message mail.Message) error {
+	args := m.Called(message)
+	return args.Error(0)
+}
+
+type mockOneTimeTokenStore struct {
+	mock.Mock
+}
+
+func (m *mockOneTimeTokenStore) Create(purpose string, value string, expiration time.Duration) (string, error) {
+	args := m.Called(purpose, value, expiration)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockOneTimeTokenStore) Consume(purpose string, token string) (string, error) {
+	args := m.Called(purpose, token)
+	return args.String(0), args.Error(1)
+}
+
+type passwordServiceMocks struct {
+	failedAttempts *mockLoginThrottler
+	mailer         *mockMailer
+	repository     *mockUserRepository
+	sessionRemover *mockTokenRepository
+	tokenStore     *mockOneTimeTokenStore
+}
+
+func newTestPasswordService() (*authentication.DefaultPasswordService, passwordServiceMocks) {
+	mocks := passwordServiceMocks{
+		failedAttempts: new(mockLoginThrottler),
+		mailer:         new(mockMailer),
+		repository:     new(mockUserRepository),
+		sessionRemover: new(mockTokenRepository),
+		tokenStore:     new(mockOneTimeTokenStore),
+	}
+	service := authentication.NewDefaultPasswordService(
+		new(mockQuerier),
+		mocks.failedAttempts,
+		mocks.mailer,
+		simplePasswordChecker,
+		authentication.NewLengthPasswordPolicy(3),
+		mocks.repository,
+		mocks.sessionRemover,
+		mocks.tokenStore,
+		testResetURL,
+	)
+	return service, mocks
+}
+
+func TestNewDefaultPasswordService(t *testing.T) {
+	service, _ := newTestPasswordService()
+	assert.NotNil(t, service)
+}
+
+func TestPasswordServiceForgotSendsResetLink(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestPasswordService()
+	user := expenses.User{ID: 42, Email: "user@mail.com", Password: "old"}
+	mocks.repository.On("FindByEmail", ctx, mock.Anything, user.Email).Return(user, nil)
+	mocks.tokenStore.On("Create", "password_reset", "42", time.Hour).Return("reset-token", nil)
+	mocks.mailer.On("Send", mock.MatchedBy(func(message mail.Message) bool {
+		return message.To == "user@mail.com" &&
+			assert.Contains(t, message.Body, "https://go-spend.com/reset?lang=en&token=reset-token")
+	})).Return(nil)
+
+	// when
+	err := service.Forgot(ctx, user.Email)
+
+	// then
+	require.NoError(t, err)
+	mocks.mailer.AssertExpectations(t)
+}
+
+func TestPasswordServiceForgotIgnoresUnknownEmail(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestPasswordService()
+	mocks.repository.On("FindByEmail", ctx, mock.Anything, expenses.Email("unknown@mail.com")).
+		Return(expenses.User{}, expenses.ErrUserNotFound)
+
+	// when
+	err := service.Forgot(ctx, "unknown@mail.com")
+
+	// then
+	require.NoError(t, err)
+	mocks.tokenStore.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+	mocks.mailer.AssertNotCalled(t, "Send", mock.Anything)
+}
+
+func TestPasswordServiceForgotErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	user := expenses.User{ID: 42, Email: "user@mail.com"}
+	tests := []struct {
+		name        string
+		prepareMock func(mocks passwordServiceMocks)
+	}{
+		{
+			name: "repository fails",
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindByEmail", mock.Anything, mock.Anything, user.Email).
+					Return(expenses.User{}, expectedErr)
+			},
+		},
+		{
+			name: "token store fails",
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindByEmail", mock.Anything, mock.Anything, user.Email).Return(user, nil)
+				mocks.tokenStore.On("Create", mock.Anything, mock.Anything, mock.Anything).Return("", expectedErr)
+			},
+		},
+		{
+			name: "mailer fails",
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindByEmail", mock.Anything, mock.Anything, user.Email).Return(user, nil)
+				mocks.tokenStore.On("Create", mock.Anything, mock.Anything, mock.Anything).Return("token", nil)
+				mocks.mailer.On("Send", mock.Anything).Return(expectedErr)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			service, mocks := newTestPasswordService()
+			test.prepareMock(mocks)
+
+			// when
+			err := service.Forgot(context.Background(), user.Email)
+
+			// then
+			assert.Equal(t, expectedErr, err)
+		})
+	}
+}
+
+func TestPasswordServiceResetUpdatesPasswordAndRevokesSessions(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestPasswordService()
+	mocks.tokenStore.On("Consume", "password_reset", "reset-token").Return("42", nil)
+	mocks.repository.On("UpdatePassword", ctx, mock.Anything, uint(42), expenses.Password("new")).Return(nil)
+	mocks.sessionRemover.On("RemoveAllSessions", uint(42)).Return(nil)
+	mocks.repository.On("FindById", ctx, mock.Anything, uint(42)).
+		Return(expenses.User{ID: 42, Email: "user@mail.com"}, nil)
+	mocks.failedAttempts.On("Clear", expenses.Email("user@mail.com")).Return()
+
+	// when
+	err := service.Reset(ctx, authentication.ResetPasswordRequest{Token: "reset-token", Password: "new"})
+
+	// then
+	require.NoError(t, err)
+	mocks.repository.AssertExpectations(t)
+	mocks.sessionRemover.AssertExpectations(t)
+	mocks.failedAttempts.AssertExpectations(t)
+}
+
+func TestPasswordServiceResetIgnoresFailureToClearFailedAttempts(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestPasswordService()
+	mocks.tokenStore.On("Consume", "password_reset", "reset-token").Return("42", nil)
+	mocks.repository.On("UpdatePassword", ctx, mock.Anything, uint(42), expenses.Password("new")).Return(nil)
+	mocks.sessionRemover.On("RemoveAllSessions", uint(42)).Return(nil)
+	mocks.repository.On("FindById", ctx, mock.Anything, uint(42)).Return(expenses.User{}, errors.New("expected"))
+
+	// when
+	err := service.Reset(ctx, authentication.ResetPasswordRequest{Token: "reset-token", Password: "new"})
+
+	// then
+	require.NoError(t, err)
+	mocks.failedAttempts.AssertNotCalled(t, "Clear", mock.Anything)
+}
+
+func TestPasswordServiceResetErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name        string
+		expectedErr error
+		prepareMock func(mocks passwordServiceMocks)
+	}{
+		{
+			name:        "unknown token",
+			expectedErr: authentication.ErrInvalidResetToken,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).
+					Return("", authentication.ErrOneTimeTokenNotFound)
+			},
+		},
+		{
+			name:        "token store fails",
+			expectedErr: expectedErr,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("", expectedErr)
+			},
+		},
+		{
+			name:        "user was deleted",
+			expectedErr: authentication.ErrInvalidResetToken,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("UpdatePassword", mock.Anything, mock.Anything, uint(42), mock.Anything).
+					Return(expenses.ErrUserNotFound)
+			},
+		},
+		{
+			name:        "repository fails",
+			expectedErr: expectedErr,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("UpdatePassword", mock.Anything, mock.Anything, uint(42), mock.Anything).
+					Return(expectedErr)
+			},
+		},
+		{
+			name:        "sessions are not revoked",
+			expectedErr: expectedErr,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("UpdatePassword", mock.Anything, mock.Anything, uint(42), mock.Anything).
+					Return(nil)
+				mocks.sessionRemover.On("RemoveAllSessions", uint(42)).Return(expectedErr)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			service, mocks := newTestPasswordService()
+			test.prepareMock(mocks)
+
+			// when
+			err := service.Reset(
+				context.Background(),
+				authentication.ResetPasswordRequest{Token: "reset-token", Password: "new"},
+			)
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+		})
+	}
+}
+
+func TestPasswordServiceResetRejectsWeakPasswordWithoutUsingToken(t *testing.T) {
+	// given
+	service, mocks := newTestPasswordService()
+
+	// when
+	err := service.Reset(context.Background(), authentication.ResetPasswordRequest{Token: "reset-token", Password: "ab"})
+
+	// then
+	assert.Equal(t, authentication.ErrPasswordTooShort, err)
+	mocks.tokenStore.AssertNotCalled(t, "Consume", mock.Anything, mock.Anything)
+}
+
+func TestPasswordServiceChangeUpdatesPasswordAndRevokesOtherSessions(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestPasswordService()
+	userContext := authentication.UserContext{UserID: 42, SessionID: "current"}
+	mocks.repository.On("FindById", ctx, mock.Anything, uint(42)).
+		Return(expenses.User{ID: 42, Email: "user@mail.com", Password: "old"}, nil)
+	mocks.repository.On("UpdatePassword", ctx, mock.Anything, uint(42), expenses.Password("new")).Return(nil)
+	mocks.sessionRemover.On("RemoveOtherSessions", uint(42), "current").Return(nil)
+
+	// when
+	err := service.Change(ctx, userContext, authentication.ChangePasswordRequest{
+		CurrentPassword: "old",
+		NewPassword:     "new",
+	})
+
+	// then
+	require.NoError(t, err)
+	mocks.repository.AssertExpectations(t)
+	mocks.sessionRemover.AssertExpectations(t)
+}
+
+func TestPasswordServiceChangeErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	user := expenses.User{ID: 42, Email: "user@mail.com", Password: "old"}
+	tests := []struct {
+		name        string
+		request     authentication.ChangePasswordRequest
+		expectedErr error
+		prepareMock func(mocks passwordServiceMocks)
+	}{
+		{
+			name:        "repository fails to find the user",
+			request:     authentication.ChangePasswordRequest{CurrentPassword: "old", NewPassword: "new"},
+			expectedErr: expectedErr,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindById", mock.Anything, mock.Anything, uint(42)).
+					Return(expenses.User{}, expectedErr)
+			},
+		},
+		{
+			name:        "current password is incorrect",
+			request:     authentication.ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "new"},
+			expectedErr: authentication.ErrCurrentPasswordIncorrect,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+			},
+		},
+		{
+			name:        "new password violates the policy",
+			request:     authentication.ChangePasswordRequest{CurrentPassword: "old", NewPassword: "ab"},
+			expectedErr: authentication.ErrPasswordTooShort,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+			},
+		},
+		{
+			name:        "repository fails to update",
+			request:     authentication.ChangePasswordRequest{CurrentPassword: "old", NewPassword: "new"},
+			expectedErr: expectedErr,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+				mocks.repository.On("UpdatePassword", mock.Anything, mock.Anything, uint(42), mock.Anything).
+					Return(expectedErr)
+			},
+		},
+		{
+			name:        "other sessions are not revoked",
+			request:     authentication.ChangePasswordRequest{CurrentPassword: "old", NewPassword: "new"},
+			expectedErr: expectedErr,
+			prepareMock: func(mocks passwordServiceMocks) {
+				mocks.repository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+				mocks.repository.On("UpdatePassword", mock.Anything, mock.Anything, uint(42), mock.Anything).
+					Return(nil)
+				mocks.sessionRemover.On("RemoveOtherSessions", uint(42), "current").Return(expectedErr)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			service, mocks := newTestPasswordService()
+			test.prepareMock(mocks)
+
+			// when
+			err := service.Change(
+				context.Background(),
+				authentication.UserContext{UserID: 42, SessionID: "current"},
+				test.request,
+			)
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"go-spend/db"
+	"go-spend/expenses"
+	"go-spend/log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// AccessTokenPrefix distinguishes personal access tokens from JWTs in Authorization header
+	AccessTokenPrefix = "gsp_"
+	// ScopeRead allows GET requests of expenses, groups and balance
+	ScopeRead = "read"
+	// ScopeExpensesWrite allows to create expenses
+	ScopeExpensesWrite = "expenses:write"
+	// accessTokenSize in bytes, 256 bits are safe to store as a plain sha256
+	accessTokenSize = 32
+)
+
+var (
+	ErrUnknownScope                   = errors.New("unknown scope")
+	ErrAccessTokenNameIncorrect       = errors.New("name of the token should be from 1 to 100 characters long")
+	ErrAccessTokenExpirationIncorrect = errors.New("expiration of the token should be in the future")
+	// scopes that can be granted to personal access tokens
+	knownScopes = map[string]bool{ScopeRead: true, ScopeExpensesWrite: true}
+	// paths that manage credentials of the user, they are not available with personal access tokens
+	credentialPaths = []string{"/users/me", "/sessions", "/logout"}
+)
+
+// PersonalAccessTokenResponse describes a personal access token. Token is set only once, when the token is created.
+type PersonalAccessTokenResponse struct {
+	ID        uint       `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Token     string     `json:"token,omitempty"`
+}
+
+// AccessTokenService manages personal access tokens of users
+type AccessTokenService interface {
+	// Create a new token for the user from the context
+	Create(
+		ctx context.Context,
+		userContext UserContext,
+		request CreateAccessTokenRequest,
+	) (PersonalAccessTokenResponse, error)
+	// List tokens of the user from the context, the oldest first
+	List(ctx context.Context, userContext UserContext) ([]PersonalAccessTokenResponse, error)
+	// Revoke a token of the user from the context
+	Revoke(ctx context.Context, userContext UserContext, tokenID uint) error
+}
+
+// DefaultAccessTokenService stores only hashes of the tokens, so they can't be shown again after creation
+type DefaultAccessTokenService struct {
+	db         db.TxQuerier
+	repository expenses.PersonalAccessTokenRepository
+	now        func() time.Time
+}
+
+// NewDefaultAccessTokenService creates new instance of DefaultAccessTokenService
+func NewDefaultAccessTokenService(
+	db db.TxQuerier,
+	repository expenses.PersonalAccessTokenRepository,
+) *DefaultAccessTokenService {
+	return &DefaultAccessTokenService{db: db, repository: repository, now: time.Now}
+}
+
+// Create returns ErrAccessTokenExpirationIncorrect if the token would already be expired
+func (s *DefaultAccessTokenService) Create(
+	ctx context.Context,
+	userContext UserContext,
+	request CreateAccessTokenRequest,
+) (PersonalAccessTokenResponse, error) {
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(s.now()) {
+		return PersonalAccessTokenResponse{}, ErrAccessTokenExpirationIncorrect
+	}
+	random := make([]byte, accessTokenSize)
+	if _, err := rand.Read(random); err != nil {
+		return PersonalAccessTokenResponse{}, err
+	}
+	secret := AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+	token, err := s.repository.Create(ctx, s.db, expenses.PersonalAccessToken{
+		UserID:    userContext.UserID,
+		Name:      request.Name,
+		Scopes:    request.Scopes,
+		ExpiresAt: request.ExpiresAt,
+	}, secret)
+	if err != nil {
+		return PersonalAccessTokenResponse{}, err
+	}
+	response := toAccessTokenResponse(token)
+	response.Token = secret
+	return response, nil
+}
+
+// List includes expired tokens, so that users can see and remove them
+func (s *DefaultAccessTokenService) List(
+	ctx context.Context,
+	userContext UserContext,
+) ([]PersonalAccessTokenResponse, error) {
+	tokens, err := s.repository.List(ctx, s.db, userContext.UserID)
+	if err != nil {
+		return nil, err
+	}
+	responses := make([]PersonalAccessTokenResponse, len(tokens))
+	for i, token := range tokens {
+		responses[i] = toAccessTokenResponse(token)
+	}
+	return responses, nil
+}
+
+// Revoke returns expenses.ErrAccessTokenNotFound if the user doesn't have such token
+func (s *DefaultAccessTokenService) Revoke(ctx context.Context, userContext UserContext, tokenID uint) error {
+	return s.repository.Delete(ctx, s.db, userContext.UserID, tokenID)
+}
+
+func toAccessTokenResponse(token expenses.PersonalAccessToken) PersonalAccessTokenResponse {
+	return PersonalAccessTokenResponse{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+}
+
+// PersonalAccessTokenAuthorizer accepts personal access tokens in Authorization header and passes all other
+// credentials to the delegate. A token is allowed only requests that its scopes cover: ScopeRead for GET and HEAD
+// requests, ScopeExpensesWrite for creation of expenses. Credentials of the user can't be managed with a token.
+// UserContext of a token has no SessionID.
+type PersonalAccessTokenAuthorizer struct {
+	delegate   Authorizer
+	db         db.TxQuerier
+	repository expenses.PersonalAccessTokenRepository
+}
+
+// NewPersonalAccessTokenAuthorizer creates new instance of PersonalAccessTokenAuthorizer
+func NewPersonalAccessTokenAuthorizer(
+	delegate Authorizer,
+	db db.TxQuerier,
+	repository expenses.PersonalAccessTokenRepository,
+) *PersonalAccessTokenAuthorizer {
+	return &PersonalAccessTokenAuthorizer{delegate: delegate, db: db, repository: repository}
+}
+
+func (a *PersonalAccessTokenAuthorizer) Authorize(realHandler http.HandlerFunc) http.HandlerFunc {
+	delegated := a.delegate.Authorize(realHandler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.Header.Get("Authorization"), " ")
+		if len(parts) != 2 || parts[0] != "Bearer" || !strings.HasPrefix(parts[1], AccessTokenPrefix) {
+			delegated(w, r)
+			return
+		}
+		token, err := a.repository.FindActive(r.Context(), a.db, parts[1])
+		if err != nil {
+			if err != expenses.ErrAccessTokenNotFound {
+				log.Error("couldn't find personal access token - %s", err)
+			}
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		scope, ok := requiredScope(r)
+		if !ok || !hasScope(token.Scopes, scope) {
+			http.Error(w, NotAuthorized, http.StatusForbidden)
+			return
+		}
+		accessContext := UserContext{UserID: token.UserID, GroupID: token.GroupID}
+		contextWithUser := context.WithValue(r.Context(), "user", accessContext)
+		requestWithUser := r.WithContext(contextWithUser)
+		realHandler.ServeHTTP(w, requestWithUser)
+	}
+}
+
+// requiredScope returns false if the request is not allowed for personal access tokens at all
+func requiredScope(r *http.Request) (string, bool) {
+	for _, path := range credentialPaths {
+		if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/") {
+			return "", false
+		}
+	}
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return ScopeRead, true
+	case r.Method == http.MethodPost && r.URL.Path == "/expenses":
+		return ScopeExpensesWrite, true
+	default:
+		return "", false
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,305 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/expenses"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockAccessTokenRepository struct {
+	mock.Mock
+}
+
+func (m *mockAccessTokenRepository) Create(
+	ctx context.Context,
+	db pgxtype.Querier,
+	token expenses.PersonalAccessToken,
+	secret string,
+) (expenses.PersonalAccessToken, error) {
+	args := m.Called(ctx, db, token, secret)
+	return args.Get(0).(expenses.PersonalAccessToken), args.Error(1)
+}
+
+func (m *mockAccessTokenRepository) FindActive(
+	ctx context.Context,
+	db pgxtype.Querier,
+	secret string,
+) (expenses.PersonalAccessToken, error) {
+	args := m.Called(ctx, db, secret)
+	return args.Get(0).(expenses.PersonalAccessToken), args.Error(1)
+}
+
+func (m *mockAccessTokenRepository) List(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+) ([]expenses.PersonalAccessToken, error) {
+	args := m.Called(ctx, db, userID)
+	return args.Get(0).([]expenses.PersonalAccessToken), args.Error(1)
+}
+
+func (m *mockAccessTokenRepository) Delete(ctx context.Context, db pgxtype.Querier, userID uint, tokenID uint) error {
+	args := m.Called(ctx, db, userID, tokenID)
+	return args.Error(0)
+}
+
+// delegateAuthorizer marks requests that were passed to it instead of checking them
+type delegateAuthorizer struct {
+}
+
+func (a *delegateAuthorizer) Authorize(realHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Delegated", "true")
+		realHandler(w, r)
+	}
+}
+
+func TestNewDefaultAccessTokenService(t *testing.T) {
+	require.NotNil(t, authentication.NewDefaultAccessTokenService(new(mockQuerier), new(mockAccessTokenRepository)))
+}
+
+func TestCreateAccessToken(t *testing.T) {
+	// given
+	db := new(mockQuerier)
+	repository := new(mockAccessTokenRepository)
+	service := authentication.NewDefaultAccessTokenService(db, repository)
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	expiresAt := time.Now().Add(time.Hour)
+	request := authentication.CreateAccessTokenRequest{
+		Name:      "script",
+		Scopes:    []string{authentication.ScopeRead},
+		ExpiresAt: &expiresAt,
+	}
+	token := expenses.PersonalAccessToken{UserID: 1, Name: "script", Scopes: request.Scopes, ExpiresAt: &expiresAt}
+	stored := token
+	stored.ID = 3
+	stored.CreatedAt = time.Now()
+	var secret string
+	repository.On("Create", mock.Anything, db, token, mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { secret = args.String(3) }).
+		Return(stored, nil)
+
+	// when
+	response, err := service.Create(context.Background(), userContext, request)
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(response.Token, authentication.AccessTokenPrefix))
+	assert.Equal(t, secret, response.Token)
+	assert.Greater(t, len(response.Token), 40)
+	assert.Equal(t, authentication.PersonalAccessTokenResponse{
+		ID:        3,
+		Name:      "script",
+		Scopes:    request.Scopes,
+		CreatedAt: stored.CreatedAt,
+		ExpiresAt: &expiresAt,
+		Token:     secret,
+	}, response)
+	repository.AssertExpectations(t)
+}
+
+func TestCreateAccessTokenExpirationInThePast(t *testing.T) {
+	// given
+	repository := new(mockAccessTokenRepository)
+	service := authentication.NewDefaultAccessTokenService(new(mockQuerier), repository)
+	expiresAt := time.Now().Add(-time.Minute)
+	request := authentication.CreateAccessTokenRequest{
+		Name:      "script",
+		Scopes:    []string{authentication.ScopeRead},
+		ExpiresAt: &expiresAt,
+	}
+
+	// when
+	_, err := service.Create(context.Background(), authentication.UserContext{UserID: 1}, request)
+
+	// then
+	assert.Equal(t, authentication.ErrAccessTokenExpirationIncorrect, err)
+	repository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateAccessTokenRepositoryError(t *testing.T) {
+	// given
+	db := new(mockQuerier)
+	repository := new(mockAccessTokenRepository)
+	service := authentication.NewDefaultAccessTokenService(db, repository)
+	expected := errors.New("expected")
+	repository.On("Create", mock.Anything, db, mock.Anything, mock.Anything).
+		Return(expenses.PersonalAccessToken{}, expected)
+
+	// when
+	_, err := service.Create(
+		context.Background(),
+		authentication.UserContext{UserID: 1},
+		authentication.CreateAccessTokenRequest{Name: "script", Scopes: []string{authentication.ScopeRead}},
+	)
+
+	// then
+	assert.Equal(t, expected, err)
+}
+
+func TestListAccessTokens(t *testing.T) {
+	// given
+	db := new(mockQuerier)
+	repository := new(mockAccessTokenRepository)
+	service := authentication.NewDefaultAccessTokenService(db, repository)
+	createdAt := time.Now()
+	repository.On("List", mock.Anything, db, uint(1)).Return([]expenses.PersonalAccessToken{
+		{ID: 3, UserID: 1, Name: "script", Scopes: []string{authentication.ScopeRead}, CreatedAt: createdAt},
+	}, nil)
+
+	// when
+	tokens, err := service.List(context.Background(), authentication.UserContext{UserID: 1})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []authentication.PersonalAccessTokenResponse{
+		{ID: 3, Name: "script", Scopes: []string{authentication.ScopeRead}, CreatedAt: createdAt},
+	}, tokens)
+}
+
+func TestListAccessTokensEmpty(t *testing.T) {
+	// given
+	db := new(mockQuerier)
+	repository := new(mockAccessTokenRepository)
+	service := authentication.NewDefaultAccessTokenService(db, repository)
+	repository.On("List", mock.Anything, db, uint(1)).Return([]expenses.PersonalAccessToken(nil), nil)
+
+	// when
+	tokens, err := service.List(context.Background(), authentication.UserContext{UserID: 1})
+
+	// then
+	require.NoError(t, err)
+	assert.NotNil(t, tokens)
+	assert.Empty(t, tokens)
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	// given
+	db := new(mockQuerier)
+	repository := new(mockAccessTokenRepository)
+	service := authentication.NewDefaultAccessTokenService(db, repository)
+	repository.On("Delete", mock.Anything, db, uint(1), uint(3)).Return(expenses.ErrAccessTokenNotFound)
+
+	// when
+	err := service.Revoke(context.Background(), authentication.UserContext{UserID: 1}, 3)
+
+	// then
+	assert.Equal(t, expenses.ErrAccessTokenNotFound, err)
+}
+
+func TestPersonalAccessTokenAuthorizerAuthorize(t *testing.T) {
+	token := expenses.PersonalAccessToken{
+		ID:      3,
+		UserID:  1,
+		GroupID: 2,
+		Scopes:  []string{authentication.ScopeRead, authentication.ScopeExpensesWrite},
+	}
+	readOnly := expenses.PersonalAccessToken{ID: 4, UserID: 1, GroupID: 2, Scopes: []string{authentication.ScopeRead}}
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		token        expenses.PersonalAccessToken
+		findErr      error
+		expectedCode int
+	}{
+		{name: "read", method: http.MethodGet, path: "/balance", token: token, expectedCode: http.StatusOK},
+		{name: "write expenses", method: http.MethodPost, path: "/expenses", token: token, expectedCode: http.StatusOK},
+		{
+			name:         "write expenses with read-only token",
+			method:       http.MethodPost,
+			path:         "/expenses",
+			token:        readOnly,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "request without scope",
+			method:       http.MethodPost,
+			path:         "/groups",
+			token:        token,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "token management",
+			method:       http.MethodGet,
+			path:         "/users/me/tokens",
+			token:        token,
+			expectedCode: http.StatusForbidden,
+		},
+		{name: "sessions", method: http.MethodGet, path: "/sessions", token: token, expectedCode: http.StatusForbidden},
+		{
+			name:         "unknown or expired token",
+			method:       http.MethodGet,
+			path:         "/balance",
+			findErr:      expenses.ErrAccessTokenNotFound,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "storage error",
+			method:       http.MethodGet,
+			path:         "/balance",
+			findErr:      errors.New("expected"),
+			expectedCode: http.StatusForbidden,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			db := new(mockQuerier)
+			repository := new(mockAccessTokenRepository)
+			authorizer := authentication.NewPersonalAccessTokenAuthorizer(new(delegateAuthorizer), db, repository)
+			repository.On("FindActive", mock.Anything, db, "gsp_token").Return(test.token, test.findErr)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				userContext, err := authentication.ExtractUser(r)
+				require.NoError(t, err)
+				assert.Equal(t, authentication.UserContext{UserID: 1, GroupID: 2}, userContext)
+				w.WriteHeader(http.StatusOK)
+			}
+			request := httptest.NewRequest(test.method, test.path, nil)
+			request.Header.Set("Authorization", "Bearer gsp_token")
+			recorder := httptest.NewRecorder()
+
+			// when
+			authorizer.Authorize(handler).ServeHTTP(recorder, request)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			assert.Empty(t, recorder.Header().Get("Delegated"))
+			repository.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPersonalAccessTokenAuthorizerPassesOtherCredentialsToDelegate(t *testing.T) {
+	for _, header := range []string{"", "Bearer jwt", "Basic gsp_token"} {
+		t.Run(header, func(t *testing.T) {
+			// given
+			repository := new(mockAccessTokenRepository)
+			authorizer := authentication.NewPersonalAccessTokenAuthorizer(
+				new(delegateAuthorizer),
+				new(mockQuerier),
+				repository,
+			)
+			request := httptest.NewRequest(http.MethodGet, "/balance", nil)
+			request.Header.Set("Authorization", header)
+			recorder := httptest.NewRecorder()
+
+			// when
+			authorizer.Authorize(func(w http.ResponseWriter, r *http.Request) {}).ServeHTTP(recorder, request)
+
+			// then
+			assert.Equal(t, "true", recorder.Header().Get("Delegated"))
+			repository.AssertNotCalled(t, "FindActive", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
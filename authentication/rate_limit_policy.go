@@ -0,0 +1,271 @@
+package authentication
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-spend/util"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Role of the client that has sent a request, rate limit rules can have different limits for each of them
+type Role string
+
+const (
+	// RoleAnonymous requests have no user in context, they are limited per client IP
+	RoleAnonymous Role = "anonymous"
+	// RoleUser requests are authorized with an access token of a session
+	RoleUser Role = "user"
+	// RoleAccessToken requests are authorized with a personal access token
+	RoleAccessToken Role = "token"
+)
+
+var (
+	ErrRateLimitRouteIncorrect = errors.New("route should start with /")
+	ErrRateLimitMethodUnknown  = errors.New("unknown HTTP method")
+	ErrRateLimitRoleUnknown    = errors.New("unknown role")
+	ErrLimitIncorrect          = errors.New("limit period and amount should be positive")
+	ErrLimitPeriodDuplicated   = errors.New("limits of a rule should have different periods")
+
+	knownRoles   = map[Role]bool{RoleAnonymous: true, RoleUser: true, RoleAccessToken: true}
+	knownMethods = map[string]bool{
+		http.MethodGet:     true,
+		http.MethodHead:    true,
+		http.MethodPost:    true,
+		http.MethodPut:     true,
+		http.MethodPatch:   true,
+		http.MethodDelete:  true,
+		http.MethodOptions: true,
+	}
+)
+
+// UnmarshalJSON reads a limit in format {"period": "1m", "amount": 100}. Period is used as the suffix of the key.
+func (l *Limit) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" { // by convention
+		return nil
+	}
+	type limit struct {
+		Period string `json:"period"`
+		Amount uint64 `json:"amount"`
+	}
+	var parsed limit
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&parsed); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(parsed.Period)
+	if err != nil {
+		return err
+	}
+	if duration <= 0 || parsed.Amount == 0 {
+		return ErrLimitIncorrect
+	}
+	l.Suffix = util.NonEmptyString(duration.String())
+	l.Duration = duration
+	l.Amount = parsed.Amount
+	return nil
+}
+
+// RateLimitRule defines limits of requests to a route. Route is a pattern like in http.ServeMux: it matches only the
+// same path or, if it ends with a slash, the whole subtree. Rule with no Methods matches all of them.
+// Roles override Limits for requests of the role. A rule, or an override, without limits doesn't limit requests.
+// All requests that match a rule share the same quota, even if they have different paths.
+type RateLimitRule struct {
+	Route   string           `json:"route"`
+	Methods []string         `json:"methods"`
+	Limits  []Limit          `json:"limits"`
+	Roles   map[Role][]Limit `json:"roles"`
+}
+
+// Matches tells if the rule applies to the request
+func (r *RateLimitRule) Matches(request *http.Request) bool {
+	path := request.URL.Path
+	if path != r.Route && !(strings.HasSuffix(r.Route, "/") && strings.HasPrefix(path, r.Route)) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, method := range r.Methods {
+		if method == request.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitsOf returns limits for requests of the role
+func (r *RateLimitRule) LimitsOf(role Role) []Limit {
+	if limits, ok := r.Roles[role]; ok {
+		return limits
+	}
+	return r.Limits
+}
+
+func (r *RateLimitRule) validate() error {
+	if !strings.HasPrefix(r.Route, "/") {
+		return ErrRateLimitRouteIncorrect
+	}
+	for _, method := range r.Methods {
+		if !knownMethods[method] {
+			return fmt.Errorf("%w %s", ErrRateLimitMethodUnknown, method)
+		}
+	}
+	if err := validateLimits(r.Limits); err != nil {
+		return err
+	}
+	for role, limits := range r.Roles {
+		if !knownRoles[role] {
+			return fmt.Errorf("%w %s", ErrRateLimitRoleUnknown, role)
+		}
+		if err := validateLimits(limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateLimits(limits []Limit) error {
+	periods := make(map[time.Duration]bool, len(limits))
+	for _, limit := range limits {
+		if limit.Duration <= 0 || limit.Amount == 0 || limit.Suffix == "" {
+			return ErrLimitIncorrect
+		}
+		if periods[limit.Duration] {
+			return ErrLimitPeriodDuplicated
+		}
+		periods[limit.Duration] = true
+	}
+	return nil
+}
+
+// key identifies quota of the rule for the role, so that requests to different paths of a subtree can't get more of it
+// and requests of a role don't use up the quota of another one
+func (r *RateLimitRule) key(role Role) string {
+	if len(r.Methods) == 0 {
+		return string(role) + " " + r.Route
+	}
+	return string(role) + " " + strings.Join(r.Methods, ",") + " " + r.Route
+}
+
+// RateLimitPolicy is an ordered list of rules, a request is limited by the first rule that matches it. Requests that
+// match no rule are not limited.
+type RateLimitPolicy []RateLimitRule
+
+// Validate checks that all rules can be applied
+func (p RateLimitPolicy) Validate() error {
+	for i := range p {
+		if err := p[i].validate(); err != nil {
+			return fmt.Errorf("rate limit rule %d for %s - %w", i, p[i].Route, err)
+		}
+	}
+	return nil
+}
+
+// LoadRateLimitPolicy reads a policy from a JSON file with a list of rules, e.g.
+// [{"route": "/balance", "methods": ["GET"], "limits": [{"period": "1s", "amount": 5}],
+// "roles": {"token": [{"period": "1m", "amount": 30}]}}]
+func LoadRateLimitPolicy(path string) (RateLimitPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy RateLimitPolicy
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return nil, err
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// RequestRole tells which role sent the request. It is RoleAnonymous until the request was authorized.
+func RequestRole(r *http.Request) Role {
+	if _, err := ExtractUser(r); err != nil {
+		return RoleAnonymous
+	}
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) == 2 && parts[0] == "Bearer" && strings.HasPrefix(parts[1], AccessTokenPrefix) {
+		return RoleAccessToken
+	}
+	return RoleUser
+}
+
+// policyRule is a rule with a RateLimiter for limits of every role, nil if the role is not limited
+type policyRule struct {
+	rule     RateLimitRule
+	limiters map[Role]RateLimiter
+}
+
+// PolicyRequestLimiter is a RequestLimiter that limits every request by the RateLimitPolicy. Requests of users are
+// limited per user, anonymous requests are limited per client IP. Endpoints that require authorization should be
+// limited both before and after it, so that requests with invalid tokens are limited per client IP and the rest per
+// user. Anonymous requests from the allowlist are not limited.
+type PolicyRequestLimiter struct {
+	allowlist []*net.IPNet
+	rules     []policyRule
+}
+
+// NewPolicyRequestLimiter creates new instance of PolicyRequestLimiter. newRateLimiter is called once for limits of
+// every role of every rule. Policy must be valid.
+func NewPolicyRequestLimiter(
+	allowlist []*net.IPNet,
+	policy RateLimitPolicy,
+	newRateLimiter func(limits []Limit) RateLimiter,
+) *PolicyRequestLimiter {
+	rules := make([]policyRule, 0, len(policy))
+	for _, rule := range policy {
+		limiters := make(map[Role]RateLimiter, len(knownRoles))
+		for role := range knownRoles {
+			if limits := rule.LimitsOf(role); len(limits) > 0 {
+				limiters[role] = newRateLimiter(limits)
+			}
+		}
+		rules = append(rules, policyRule{rule: rule, limiters: limiters})
+	}
+	return &PolicyRequestLimiter{allowlist: allowlist, rules: rules}
+}
+
+// RateLimit the request with the first rule that matches it
+func (rh *PolicyRequestLimiter) RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limitRequest(w, rh.check(r)) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (rh *PolicyRequestLimiter) check(r *http.Request) LimitDecision {
+	for _, policyRule := range rh.rules {
+		if !policyRule.rule.Matches(r) {
+			continue
+		}
+		role := RequestRole(r)
+		limiter := policyRule.limiters[role]
+		if limiter == nil {
+			return LimitDecision{Allowed: true}
+		}
+		limitContext := LimitContext{Path: policyRule.rule.key(role)}
+		if role == RoleAnonymous {
+			limitContext.IP = NewClientInfo(r).IP
+			if parsed := net.ParseIP(limitContext.IP); parsed != nil && containsIP(rh.allowlist, parsed) {
+				return LimitDecision{Allowed: true}
+			}
+		} else {
+			user, _ := ExtractUser(r)
+			limitContext.UserID = user.UserID
+		}
+		return limiter.Check(limitContext)
+	}
+	return LimitDecision{Allowed: true}
+}
@@ -0,0 +1,332 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadRateLimitPolicy(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "policy.json")
+	content := `[
+		{
+			"route": "/balance",
+			"methods": ["GET"],
+			"limits": [{"period": "1s", "amount": 5}, {"period": "1h", "amount": 1000}],
+			"roles": {"token": [{"period": "1m", "amount": 30}], "user": []}
+		},
+		{"route": "/"}
+	]`
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+	// when
+	policy, err := authentication.LoadRateLimitPolicy(path)
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, policy, 2)
+	assert.Equal(t, "/balance", policy[0].Route)
+	assert.Equal(t, []string{http.MethodGet}, policy[0].Methods)
+	assert.Equal(t, []authentication.Limit{
+		{Suffix: "1s", Duration: time.Second, Amount: 5},
+		{Suffix: "1h0m0s", Duration: time.Hour, Amount: 1000},
+	}, policy[0].Limits)
+	assert.Equal(t, []authentication.Limit{
+		{Suffix: "1m0s", Duration: time.Minute, Amount: 30},
+	}, policy[0].LimitsOf(authentication.RoleAccessToken))
+	assert.Empty(t, policy[0].LimitsOf(authentication.RoleUser))
+	assert.Equal(t, policy[0].Limits, policy[0].LimitsOf(authentication.RoleAnonymous))
+	assert.Equal(t, authentication.RateLimitRule{Route: "/"}, policy[1])
+}
+
+func TestLoadRateLimitPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		expectedErr error
+	}{
+		{
+			name:        "route without slash",
+			content:     `[{"route": "balance"}]`,
+			expectedErr: authentication.ErrRateLimitRouteIncorrect,
+		},
+		{
+			name:        "unknown method",
+			content:     `[{"route": "/balance", "methods": ["get"]}]`,
+			expectedErr: authentication.ErrRateLimitMethodUnknown,
+		},
+		{
+			name:        "unknown role",
+			content:     `[{"route": "/balance", "roles": {"admin": []}}]`,
+			expectedErr: authentication.ErrRateLimitRoleUnknown,
+		},
+		{
+			name:        "zero amount",
+			content:     `[{"route": "/balance", "limits": [{"period": "1s", "amount": 0}]}]`,
+			expectedErr: authentication.ErrLimitIncorrect,
+		},
+		{
+			name:        "negative period",
+			content:     `[{"route": "/balance", "roles": {"user": [{"period": "-1s", "amount": 1}]}}]`,
+			expectedErr: authentication.ErrLimitIncorrect,
+		},
+		{
+			name:        "duplicated period",
+			content:     `[{"route": "/", "limits": [{"period": "60s", "amount": 1}, {"period": "1m", "amount": 2}]}]`,
+			expectedErr: authentication.ErrLimitPeriodDuplicated,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			path := filepath.Join(t.TempDir(), "policy.json")
+			require.NoError(t, ioutil.WriteFile(path, []byte(test.content), 0600))
+
+			// when
+			policy, err := authentication.LoadRateLimitPolicy(path)
+
+			// then
+			assert.True(t, errors.Is(err, test.expectedErr), "%v", err)
+			assert.Nil(t, policy)
+		})
+	}
+}
+
+func TestLoadRateLimitPolicyIncorrectJSON(t *testing.T) {
+	contents := []string{
+		`{"route": "/"}`,
+		`[{"route": "/", "limit": []}]`,
+		`[{"route": "/", "limits": [{"period": "minute", "amount": 1}]}]`,
+	}
+	for _, content := range contents {
+		t.Run(content, func(t *testing.T) {
+			// given
+			path := filepath.Join(t.TempDir(), "policy.json")
+			require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+
+			// when
+			_, err := authentication.LoadRateLimitPolicy(path)
+
+			// then
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRateLimitRuleMatches(t *testing.T) {
+	tests := []struct {
+		rule     authentication.RateLimitRule
+		method   string
+		path     string
+		expected bool
+	}{
+		{rule: authentication.RateLimitRule{Route: "/balance"}, method: http.MethodGet, path: "/balance", expected: true},
+		{rule: authentication.RateLimitRule{Route: "/balance"}, method: http.MethodGet, path: "/balance/1"},
+		{rule: authentication.RateLimitRule{Route: "/users"}, method: http.MethodGet, path: "/users/me"},
+		{rule: authentication.RateLimitRule{Route: "/users/"}, method: http.MethodGet, path: "/users/me", expected: true},
+		{rule: authentication.RateLimitRule{Route: "/"}, method: http.MethodDelete, path: "/sessions/1", expected: true},
+		{
+			rule:     authentication.RateLimitRule{Route: "/expenses", Methods: []string{http.MethodPost}},
+			method:   http.MethodPost,
+			path:     "/expenses",
+			expected: true,
+		},
+		{
+			rule:   authentication.RateLimitRule{Route: "/expenses", Methods: []string{http.MethodPost}},
+			method: http.MethodGet,
+			path:   "/expenses",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path+" by "+test.rule.Route, func(t *testing.T) {
+			// given
+			req := httptest.NewRequest(test.method, test.path, nil)
+
+			// then
+			assert.Equal(t, test.expected, test.rule.Matches(req))
+		})
+	}
+}
+
+func TestRequestRole(t *testing.T) {
+	// given
+	anonymous := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	anonymous.Header.Set("Authorization", "Bearer gsp_token")
+	user := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	user.Header.Set("Authorization", "Bearer jwt")
+	user = user.WithContext(context.WithValue(user.Context(), "user", authentication.UserContext{UserID: 1}))
+	token := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	token.Header.Set("Authorization", "Bearer gsp_token")
+	token = token.WithContext(context.WithValue(token.Context(), "user", authentication.UserContext{UserID: 1}))
+
+	// then
+	assert.Equal(t, authentication.RoleAnonymous, authentication.RequestRole(anonymous))
+	assert.Equal(t, authentication.RoleUser, authentication.RequestRole(user))
+	assert.Equal(t, authentication.RoleAccessToken, authentication.RequestRole(token))
+}
+
+func TestPolicyRequestLimiterRateLimit(t *testing.T) {
+	minute := func(amount uint64) []authentication.Limit {
+		return []authentication.Limit{{Suffix: "m", Duration: time.Minute, Amount: amount}}
+	}
+	policy := authentication.RateLimitPolicy{
+		{
+			Route:   "/balance",
+			Methods: []string{http.MethodGet},
+			Limits:  minute(1),
+			Roles:   map[authentication.Role][]authentication.Limit{authentication.RoleAccessToken: minute(2)},
+		},
+		{Route: "/health"},
+		{Route: "/sessions/", Limits: minute(3)},
+		{Route: "/", Roles: map[authentication.Role][]authentication.Limit{authentication.RoleAnonymous: minute(4)}},
+	}
+	allowlist, err := authentication.ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		authorization   string
+		user            bool
+		remoteAddr      string
+		expectedLimiter uint64
+		expectedContext authentication.LimitContext
+	}{
+		{
+			name:            "user",
+			method:          http.MethodGet,
+			path:            "/balance",
+			authorization:   "Bearer jwt",
+			user:            true,
+			expectedLimiter: 1,
+			expectedContext: authentication.LimitContext{UserID: 1, Path: "user GET /balance"},
+		},
+		{
+			name:            "role override",
+			method:          http.MethodGet,
+			path:            "/balance",
+			authorization:   "Bearer gsp_token",
+			user:            true,
+			expectedLimiter: 2,
+			expectedContext: authentication.LimitContext{UserID: 1, Path: "token GET /balance"},
+		},
+		{
+			name:          "other method is not limited for users by the next rule",
+			method:        http.MethodPost,
+			path:          "/balance",
+			authorization: "Bearer jwt",
+			user:          true,
+		},
+		{
+			name:   "rule without limits",
+			method: http.MethodGet,
+			path:   "/health",
+		},
+		{
+			name:            "subtree shares quota",
+			method:          http.MethodDelete,
+			path:            "/sessions/session",
+			authorization:   "Bearer jwt",
+			user:            true,
+			expectedLimiter: 3,
+			expectedContext: authentication.LimitContext{UserID: 1, Path: "user /sessions/"},
+		},
+		{
+			name:            "anonymous per client IP",
+			method:          http.MethodPost,
+			path:            "/users",
+			remoteAddr:      "192.0.2.1:1234",
+			expectedLimiter: 4,
+			expectedContext: authentication.LimitContext{IP: "192.0.2.1", Path: "anonymous /"},
+		},
+		{
+			name:       "allowlisted IP is not limited",
+			method:     http.MethodPost,
+			path:       "/users",
+			remoteAddr: "10.0.0.1:1234",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			limiters := make(map[uint64]*mockRateLimiter)
+			requestLimiter := authentication.NewPolicyRequestLimiter(
+				allowlist,
+				policy,
+				func(limits []authentication.Limit) authentication.RateLimiter {
+					if limiter, ok := limiters[limits[0].Amount]; ok {
+						return limiter
+					}
+					limiter := new(mockRateLimiter)
+					limiter.On("Check", mock.Anything).Return(authentication.LimitDecision{Allowed: true})
+					limiters[limits[0].Amount] = limiter
+					return limiter
+				},
+			)
+			nextCalled := false
+			rateLimited := requestLimiter.RateLimit(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+			})
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Authorization", test.authorization)
+			if test.remoteAddr != "" {
+				req.RemoteAddr = test.remoteAddr
+			}
+			if test.user {
+				req = req.WithContext(context.WithValue(req.Context(), "user", authentication.UserContext{UserID: 1}))
+			}
+
+			// when
+			rateLimited.ServeHTTP(httptest.NewRecorder(), req)
+
+			// then
+			assert.True(t, nextCalled)
+			for amount, limiter := range limiters {
+				if amount == test.expectedLimiter {
+					limiter.AssertCalled(t, "Check", test.expectedContext)
+				} else {
+					limiter.AssertNotCalled(t, "Check", mock.Anything)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyRequestLimiterRejects(t *testing.T) {
+	// given
+	policy := authentication.RateLimitPolicy{
+		{Route: "/", Limits: []authentication.Limit{{Suffix: "m", Duration: time.Minute, Amount: 1}}},
+	}
+	limiter := new(mockRateLimiter)
+	limiter.On("Check", authentication.LimitContext{UserID: 1, Path: "user /"}).
+		Return(authentication.LimitDecision{Allowed: false, RetryAfter: time.Minute})
+	nextCalled := false
+	rateLimited := authentication.NewPolicyRequestLimiter(
+		nil,
+		policy,
+		func(_ []authentication.Limit) authentication.RateLimiter { return limiter },
+	).RateLimit(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+	req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user", authentication.UserContext{UserID: 1}))
+	w := httptest.NewRecorder()
+
+	// when
+	rateLimited.ServeHTTP(w, req)
+
+	// then
+	assert.False(t, nextCalled)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+}
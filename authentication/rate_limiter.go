@@ -1,29 +1,79 @@
 package authentication
 
 import (
-	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"go-spend/log"
 	"go-spend/util"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
-const (
-	justValue = "1"
-)
-
-var (
-	ErrTooManyRequests = errors.New("too many requests from the same user")
-)
-
 // RateLimiter checks if limit for a particular context was reached
 type RateLimiter interface {
-	IsUnderLimits(context LimitContext) bool
+	// Check counts the request of the context if it is under all limits
+	Check(context LimitContext) LimitDecision
 }
 
-// RedisRateLimiter is a RateLimiter that uses redis as a storage
+// LimitDecision tells whether a request is allowed and how much of its quota is left. If the request is not allowed
+// Limit is the limit that was hit, otherwise it is the limit with the least remaining quota. Limit is zero when limits
+// couldn't be checked.
+type LimitDecision struct {
+	Allowed   bool
+	Limit     Limit
+	Remaining uint64
+	// Reset is how long it takes to restore the whole quota of Limit
+	Reset time.Duration
+	// RetryAfter is how long to wait before the next request can be allowed, zero for allowed requests
+	RetryAfter time.Duration
+}
+
+// gcraScript checks all limits of a request and counts the request only if it is under every limit, so that requests
+// rejected by one limit don't use up the others. Each limit is a generic cell rate algorithm: the key stores the
+// theoretical arrival time (TAT) of the next request in microseconds. Every request moves TAT by the emission interval
+// period/amount, a request is rejected if TAT would be more than the period ahead of now. It allows amount requests
+// at once and then one request per emission interval, without storing every request.
+// Time is taken from redis, so that clocks of application instances don't matter.
+// KEYS - key of every limit, ARGV - period in microseconds and amount of every limit in the same order.
+// Returns 1 if the request is under limits or 0, index of the decisive limit starting from 1, remaining quota, reset
+// and retry after in microseconds.
+var gcraScript = redis.NewScript(`
+if redis.replicate_commands then
+    redis.replicate_commands()
+end
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local tats = {}
+local intervals = {}
+for i, key in ipairs(KEYS) do
+    local period = tonumber(ARGV[2 * i - 1])
+    local interval = math.ceil(period / tonumber(ARGV[2 * i]))
+    local tat = math.max(tonumber(redis.call('GET', key) or now), now)
+    local newTat = tat + interval
+    if newTat - period > now then
+        return {0, i, 0, tat - now, newTat - period - now}
+    end
+    tats[i] = newTat
+    intervals[i] = interval
+end
+local decisive, remaining = 0, -1
+for i, key in ipairs(KEYS) do
+    redis.call('SET', key, string.format('%d', tats[i]), 'PX', math.ceil((tats[i] - now) / 1000))
+    local left = math.floor((now + tonumber(ARGV[2 * i - 1]) - tats[i]) / intervals[i])
+    if remaining < 0 or left < remaining then
+        decisive, remaining = i, left
+    end
+end
+if decisive == 0 then
+    return {1, 0, 0, 0, 0}
+end
+return {1, decisive, remaining, tats[decisive] - now, 0}
+`)
+
+// RedisRateLimiter is a RateLimiter that uses redis as a storage. All limits are checked and updated atomically in
+// a single round-trip by a lua script, memory used per limit doesn't depend on the amount of requests.
 type RedisRateLimiter struct {
 	limits []Limit
 	redis  redis.UniversalClient
@@ -35,55 +85,61 @@ func NewRedisRateLimiter(limits []Limit, redis redis.UniversalClient) *RedisRate
 	return &RedisRateLimiter{limits: limits, redis: redis}
 }
 
-// IsUnderLimits checks if limit for a context was not reached for seconds/minutes/hours.
-// It is a tolerant limiter and will return true when something went wrong while trying to access redis. Only returns
-// false when was able to check that the limit was reached.
-func (r *RedisRateLimiter) IsUnderLimits(context LimitContext) bool {
-	err := r.redis.Watch(func(tx *redis.Tx) error {
-		for _, limit := range r.limits {
-			key := context.AsKey(string(limit.Suffix))
-			if err := checkLimitForKey(tx, context, limit); err != nil {
-				if err == ErrTooManyRequests {
-					return err
-				}
-				log.Warn("failed to check %s key - %s", key, err)
-			}
-		}
-		return nil
-	})
-	return err != ErrTooManyRequests
-}
-
-func checkLimitForKey(tx *redis.Tx, limitContext LimitContext, limit Limit) error {
-	key := limitContext.AsKey(string(limit.Suffix))
-	curLen, err := tx.LLen(key).Result()
+// Check checks if limit for a context was not reached for seconds/minutes/hours.
+// It is a tolerant limiter and allows the request when something went wrong while trying to access redis. Only denies
+// when was able to check that the limit was reached.
+func (r *RedisRateLimiter) Check(context LimitContext) LimitDecision {
+	keys := make([]string, 0, len(r.limits))
+	args := make([]interface{}, 0, 2*len(r.limits))
+	for _, limit := range r.limits {
+		keys = append(keys, rateLimitKey(context, limit))
+		args = append(args, limit.Duration.Microseconds(), limit.Amount)
+	}
+	result, err := gcraScript.Run(r.redis, keys, args...).Result()
 	if err != nil {
-		return err
+		log.Warn("failed to check rate limits of %s - %s", context.AsKey(""), err)
+		return LimitDecision{Allowed: true}
 	}
-	if uint64(curLen) >= limit.Amount {
-		return ErrTooManyRequests
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 5 {
+		log.Warn("unexpected result of rate limits check of %s - %v", context.AsKey(""), result)
+		return LimitDecision{Allowed: true}
 	}
-	keyExists, err := tx.Exists(key).Result()
-	if err != nil {
-		return err
+	numbers := make([]int64, len(values))
+	for i, value := range values {
+		numbers[i], _ = value.(int64)
 	}
-	if keyExists == 1 {
-		return tx.RPushX(key, justValue).Err()
+	decision := LimitDecision{
+		Allowed:    numbers[0] == 1,
+		Remaining:  uint64(numbers[2]),
+		Reset:      time.Duration(numbers[3]) * time.Microsecond,
+		RetryAfter: time.Duration(numbers[4]) * time.Microsecond,
 	}
-	if err := tx.RPush(key, justValue).Err(); err != nil {
-		return err
+	if index := int(numbers[1]); index > 0 && index <= len(r.limits) {
+		decision.Limit = r.limits[index-1]
 	}
-	return tx.Expire(key, limit.Duration).Err()
+	return decision
+}
+
+// rateLimitKey puts the context into a hash tag, so that all keys of a request are in the same slot of a redis cluster
+// and can be used by one script
+func rateLimitKey(context LimitContext, limit Limit) string {
+	return "rate_limit_{" + context.AsKey("") + "}" + string(limit.Suffix)
 }
 
-// LimitContext contains info to prepare base for the key in cache
+// LimitContext contains info to prepare base for the key in cache. Requests are limited per user, per client IP or
+// per both if both are set.
 type LimitContext struct {
 	UserID uint
+	IP     string
 	Path   string
 }
 
 // AsKey transforms LimitContext to a value to be stored as key in cache
 func (l *LimitContext) AsKey(suffix string) string {
+	if l.IP != "" {
+		return fmt.Sprintf("%d_%s_%s_%s", l.UserID, l.IP, l.Path, suffix)
+	}
 	return fmt.Sprintf("%d_%s_%s", l.UserID, l.Path, suffix)
 }
 
@@ -114,14 +170,14 @@ func NewContextBasedRequestLimiter(rateLimiter RateLimiter) *ContextBasedRequest
 // RateLimit extract user from request context and rate limit the request
 func (rh *ContextBasedRequestLimiter) RateLimit(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !rh.isUnderLimit(r) {
-			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		if limitRequest(w, rh.check(r)) {
+			return
 		}
 		next.ServeHTTP(w, r)
 	}
 }
 
-func (rh *ContextBasedRequestLimiter) isUnderLimit(r *http.Request) bool {
+func (rh *ContextBasedRequestLimiter) check(r *http.Request) LimitDecision {
 	user, err := ExtractUser(r)
 	path := r.URL.Path
 	if err != nil {
@@ -129,7 +185,46 @@ func (rh *ContextBasedRequestLimiter) isUnderLimit(r *http.Request) bool {
 			"couldn't rate limit request to %s because use not present in context, possible misconfiguration!",
 			path,
 		)
-		return true
+		return LimitDecision{Allowed: true}
+	}
+	return rh.rateLimiter.Check(LimitContext{UserID: user.UserID, Path: path})
+}
+
+// limitRequest writes RateLimit-* headers of the decision and responds with 429 and Retry-After if the request is not
+// allowed. Returns true if the request was answered and must not be handled any further.
+// When several limiters handle the same request the headers show the one with the least remaining quota.
+func limitRequest(w http.ResponseWriter, decision LimitDecision) bool {
+	if decision.Limit.Amount > 0 && !hasLessRemaining(w.Header(), decision.Remaining) {
+		w.Header().Set("RateLimit-Limit", strconv.FormatUint(decision.Limit.Amount, 10))
+		w.Header().Set("RateLimit-Remaining", strconv.FormatUint(decision.Remaining, 10))
+		w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(decision.Reset), 10))
+	}
+	if decision.Allowed {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(decision.RetryAfter), 10))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// hasLessRemaining tells if the headers already show a limit with less remaining quota than remaining
+func hasLessRemaining(header http.Header, remaining uint64) bool {
+	current, err := strconv.ParseUint(header.Get("RateLimit-Remaining"), 10, 64)
+	return err == nil && current < remaining
+}
+
+// ceilSeconds rounds up, so that a client waiting for the returned number of seconds doesn't come too early
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// RequestLimiters is a RequestLimiter that applies all the limiters in order, e.g. to add a limit of a single route to
+// the policy. Empty RequestLimiters doesn't limit anything.
+type RequestLimiters []RequestLimiter
+
+func (l RequestLimiters) RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	for i := len(l) - 1; i >= 0; i-- {
+		next = l[i].RateLimit(next)
 	}
-	return rh.rateLimiter.IsUnderLimits(LimitContext{UserID: user.UserID, Path: path})
+	return next
 }
@@ -0,0 +1,139 @@
+package authentication_test
+
+import (
+	"errors"
+	"github.com/go-redis/redis"
+	"go-spend/authentication"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	benchmarkLimits = []authentication.Limit{
+		{
+			Suffix:   "s",
+			Duration: time.Second,
+			Amount:   1000000,
+		},
+		{
+			Suffix:   "m",
+			Duration: time.Minute,
+			Amount:   1000000,
+		},
+		{
+			Suffix:   "h",
+			Duration: time.Hour,
+			Amount:   10000000,
+		},
+	}
+	errListLimitReached = errors.New("limit reached")
+)
+
+// listRateLimiter is the previous implementation of RedisRateLimiter, kept to compare the lua script with. It stores
+// an element of a list per request and checks every limit with separate round-trips.
+type listRateLimiter struct {
+	limits []authentication.Limit
+	redis  redis.UniversalClient
+}
+
+func (r *listRateLimiter) Check(context authentication.LimitContext) authentication.LimitDecision {
+	err := r.redis.Watch(func(tx *redis.Tx) error {
+		for _, limit := range r.limits {
+			if err := r.checkLimitForKey(tx, context.AsKey(string(limit.Suffix)), limit); err == errListLimitReached {
+				return err
+			}
+		}
+		return nil
+	})
+	return authentication.LimitDecision{Allowed: err != errListLimitReached}
+}
+
+func (r *listRateLimiter) checkLimitForKey(tx *redis.Tx, key string, limit authentication.Limit) error {
+	curLen, err := tx.LLen(key).Result()
+	if err != nil {
+		return err
+	}
+	if uint64(curLen) >= limit.Amount {
+		return errListLimitReached
+	}
+	keyExists, err := tx.Exists(key).Result()
+	if err != nil {
+		return err
+	}
+	if keyExists == 1 {
+		return tx.RPushX(key, "1").Err()
+	}
+	if err := tx.RPush(key, "1").Err(); err != nil {
+		return err
+	}
+	return tx.Expire(key, limit.Duration).Err()
+}
+
+func BenchmarkRedisRateLimiter(b *testing.B) {
+	benchmarkRateLimiter(b, authentication.NewRedisRateLimiter(benchmarkLimits, redisClient))
+}
+
+func BenchmarkListRateLimiter(b *testing.B) {
+	benchmarkRateLimiter(b, &listRateLimiter{limits: benchmarkLimits, redis: redisClient})
+}
+
+func benchmarkRateLimiter(b *testing.B, limiter authentication.RateLimiter) {
+	b.Run("one user", func(b *testing.B) {
+		clearRedis()
+		memoryBefore := usedMemory()
+		limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			limiter.Check(limitContext)
+		}
+		b.StopTimer()
+		reportMemory(b, memoryBefore)
+	})
+	b.Run("one user parallel", func(b *testing.B) {
+		clearRedis()
+		memoryBefore := usedMemory()
+		limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				limiter.Check(limitContext)
+			}
+		})
+		b.StopTimer()
+		reportMemory(b, memoryBefore)
+	})
+	b.Run("many users parallel", func(b *testing.B) {
+		clearRedis()
+		memoryBefore := usedMemory()
+		var users uint64
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			limitContext := authentication.LimitContext{Path: "/balance"}
+			for pb.Next() {
+				limitContext.UserID = uint(atomic.AddUint64(&users, 1) % 100)
+				limiter.Check(limitContext)
+			}
+		})
+		b.StopTimer()
+		reportMemory(b, memoryBefore)
+	})
+}
+
+// reportMemory reports how much memory of redis a request takes until the keys expire
+func reportMemory(b *testing.B, memoryBefore int64) {
+	b.ReportMetric(float64(usedMemory()-memoryBefore)/float64(b.N), "redis-B/op")
+}
+
+// usedMemory returns memory used by redis in bytes, zero if it is unknown
+func usedMemory() int64 {
+	for _, line := range strings.Split(redisClient.Info("memory").Val(), "\r\n") {
+		if strings.HasPrefix(line, "used_memory:") {
+			used, _ := strconv.ParseInt(strings.TrimPrefix(line, "used_memory:"), 10, 64)
+			return used
+		}
+	}
+	return 0
+}
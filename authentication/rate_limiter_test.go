@@ -5,6 +5,7 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go-spend/authentication"
 	"net/http"
 	"net/http/httptest"
@@ -16,20 +17,43 @@ type mockRateLimiter struct {
 	mock.Mock
 }
 
-func (m *mockRateLimiter) IsUnderLimits(context authentication.LimitContext) bool {
+func (m *mockRateLimiter) Check(context authentication.LimitContext) authentication.LimitDecision {
 	args := m.Called(context)
-	return args.Get(0).(bool)
+	return args.Get(0).(authentication.LimitDecision)
+}
+
+// rateLimiters create a RateLimiter of every implementation without any counted requests, so that all of them pass
+// the same tests
+var rateLimiters = []struct {
+	name       string
+	newLimiter func(t *testing.T, limits []authentication.Limit) authentication.RateLimiter
+}{
+	{
+		name: "redis",
+		newLimiter: func(t *testing.T, limits []authentication.Limit) authentication.RateLimiter {
+			clearRedis()
+			return authentication.NewRedisRateLimiter(limits, redisClient)
+		},
+	},
+	{
+		name: "memory",
+		newLimiter: func(t *testing.T, limits []authentication.Limit) authentication.RateLimiter {
+			limiter := authentication.NewMemoryRateLimiter(limits, time.Minute)
+			t.Cleanup(limiter.Stop)
+			return limiter
+		},
+	},
 }
 
 func TestRateLimiter(t *testing.T) {
 	tests := []struct {
-		name        string
-		rateLimiter *authentication.RedisRateLimiter
-		expected    int
+		name     string
+		limits   []authentication.Limit
+		expected int
 	}{
 		{
 			name: "seconds",
-			rateLimiter: authentication.NewRedisRateLimiter([]authentication.Limit{
+			limits: []authentication.Limit{
 				{
 					Suffix:   "s",
 					Duration: time.Second,
@@ -45,12 +69,12 @@ func TestRateLimiter(t *testing.T) {
 					Duration: time.Hour,
 					Amount:   100,
 				},
-			}, redisClient),
+			},
 			expected: 4,
 		},
 		{
 			name: "minutes",
-			rateLimiter: authentication.NewRedisRateLimiter([]authentication.Limit{
+			limits: []authentication.Limit{
 				{
 					Suffix:   "s",
 					Duration: time.Second,
@@ -66,12 +90,12 @@ func TestRateLimiter(t *testing.T) {
 					Duration: time.Hour,
 					Amount:   100,
 				},
-			}, redisClient),
+			},
 			expected: 5,
 		},
 		{
 			name: "hours",
-			rateLimiter: authentication.NewRedisRateLimiter([]authentication.Limit{
+			limits: []authentication.Limit{
 				{
 					Suffix:   "s",
 					Duration: time.Second,
@@ -87,34 +111,36 @@ func TestRateLimiter(t *testing.T) {
 					Duration: time.Hour,
 					Amount:   10,
 				},
-			}, redisClient),
+			},
 			expected: 10,
 		},
 	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			clearRedis()
-			limitContext := authentication.LimitContext{
-				UserID: 1,
-				Path:   "/balance",
-			}
-			executions := 99
-			var results []chan bool
-			f := func(c chan<- bool) {
-				c <- test.rateLimiter.IsUnderLimits(limitContext)
-			}
-			for i := 0; i < executions; i++ {
-				results = append(results, make(chan bool, 1))
-				f(results[i])
-			}
-			numberOfTrue := 0
-			for _, c := range results {
-				if ok := <-c; ok {
-					numberOfTrue++
+	for _, implementation := range rateLimiters {
+		for _, test := range tests {
+			t.Run(implementation.name+" "+test.name, func(t *testing.T) {
+				rateLimiter := implementation.newLimiter(t, test.limits)
+				limitContext := authentication.LimitContext{
+					UserID: 1,
+					Path:   "/balance",
 				}
-			}
-			assert.Equal(t, test.expected, numberOfTrue)
-		})
+				executions := 99
+				var results []chan bool
+				f := func(c chan<- bool) {
+					c <- rateLimiter.Check(limitContext).Allowed
+				}
+				for i := 0; i < executions; i++ {
+					results = append(results, make(chan bool, 1))
+					f(results[i])
+				}
+				numberOfTrue := 0
+				for _, c := range results {
+					if ok := <-c; ok {
+						numberOfTrue++
+					}
+				}
+				assert.Equal(t, test.expected, numberOfTrue)
+			})
+		}
 	}
 }
 
@@ -141,32 +167,242 @@ func TestWithRedisUnavailableAlwaysTrue(t *testing.T) {
 		UserID: 12,
 		Path:   "/aa",
 	}
-	assert.True(t, limiter.IsUnderLimits(limitContext))
-	assert.True(t, limiter.IsUnderLimits(limitContext))
-	assert.True(t, limiter.IsUnderLimits(limitContext))
+	assert.True(t, limiter.Check(limitContext).Allowed)
+	assert.True(t, limiter.Check(limitContext).Allowed)
+	assert.True(t, limiter.Check(limitContext).Allowed)
+}
+
+func TestRateLimiterDoesNotCountRejectedRequests(t *testing.T) {
+	for _, implementation := range rateLimiters {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			limiter := implementation.newLimiter(t, []authentication.Limit{
+				{
+					Suffix:   "ms",
+					Duration: 100 * time.Millisecond,
+					Amount:   2,
+				},
+				{
+					Suffix:   "m",
+					Duration: time.Minute,
+					Amount:   3,
+				},
+			})
+			limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+
+			// when
+			var results []bool
+			for i := 0; i < 3; i++ {
+				results = append(results, limiter.Check(limitContext).Allowed)
+			}
+			time.Sleep(100 * time.Millisecond)
+			for i := 0; i < 2; i++ {
+				results = append(results, limiter.Check(limitContext).Allowed)
+			}
+
+			// then
+			assert.Equal(t, []bool{true, true, false, true, false}, results)
+		})
+	}
+}
+
+func TestRateLimiterRefillsGradually(t *testing.T) {
+	for _, implementation := range rateLimiters {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			limiter := implementation.newLimiter(t, []authentication.Limit{
+				{
+					Suffix:   "ms",
+					Duration: 200 * time.Millisecond,
+					Amount:   2,
+				},
+			})
+			limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+			require.True(t, limiter.Check(limitContext).Allowed)
+			require.True(t, limiter.Check(limitContext).Allowed)
+			require.False(t, limiter.Check(limitContext).Allowed)
+
+			// when
+			time.Sleep(110 * time.Millisecond)
+
+			// then
+			assert.True(t, limiter.Check(limitContext).Allowed, "one request is allowed after the emission interval")
+			assert.False(t, limiter.Check(limitContext).Allowed, "the rest of the period is still used")
+		})
+	}
+}
+
+func TestRateLimiterDecision(t *testing.T) {
+	for _, implementation := range rateLimiters {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			minuteLimit := authentication.Limit{Suffix: "m", Duration: time.Minute, Amount: 3}
+			hourLimit := authentication.Limit{Suffix: "h", Duration: time.Hour, Amount: 100}
+			limiter := implementation.newLimiter(t, []authentication.Limit{minuteLimit, hourLimit})
+			limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+
+			// when
+			first := limiter.Check(limitContext)
+			limiter.Check(limitContext)
+			last := limiter.Check(limitContext)
+			denied := limiter.Check(limitContext)
+
+			// then
+			assert.True(t, first.Allowed)
+			assert.Equal(t, minuteLimit, first.Limit, "the limit with the least remaining quota is reported")
+			assert.Equal(t, uint64(2), first.Remaining)
+			assert.InDelta(t, float64(20*time.Second), float64(first.Reset), float64(time.Second))
+			assert.Zero(t, first.RetryAfter)
+			assert.True(t, last.Allowed)
+			assert.Zero(t, last.Remaining)
+			assert.InDelta(t, float64(time.Minute), float64(last.Reset), float64(time.Second))
+			assert.False(t, denied.Allowed)
+			assert.Equal(t, minuteLimit, denied.Limit)
+			assert.Zero(t, denied.Remaining)
+			assert.InDelta(t, float64(20*time.Second), float64(denied.RetryAfter), float64(time.Second))
+		})
+	}
+}
+
+func TestRequestLimitersShowLeastRemainingQuota(t *testing.T) {
+	// given
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	userRateLimiter := new(mockRateLimiter)
+	routeRateLimiter := new(mockRateLimiter)
+	rateLimited := authentication.RequestLimiters{
+		authentication.NewContextBasedRequestLimiter(userRateLimiter),
+		authentication.NewContextBasedRequestLimiter(routeRateLimiter),
+	}.RateLimit(okHandler)
+	req := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user", authentication.UserContext{UserID: 1}))
+	userRateLimiter.On("Check", authentication.LimitContext{UserID: 1, Path: "/balance"}).
+		Return(authentication.LimitDecision{Allowed: true, Limit: authentication.Limit{Amount: 10}, Remaining: 2})
+	routeRateLimiter.On("Check", authentication.LimitContext{UserID: 1, Path: "/balance"}).
+		Return(authentication.LimitDecision{Allowed: true, Limit: authentication.Limit{Amount: 100}, Remaining: 50})
+	w := httptest.NewRecorder()
+
+	// when
+	rateLimited.ServeHTTP(w, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "10", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Remaining"))
+}
+
+func TestMemoryRateLimiterJanitorForgetsFullBuckets(t *testing.T) {
+	// given
+	limiter := authentication.NewMemoryRateLimiter([]authentication.Limit{
+		{
+			Suffix:   "ms",
+			Duration: 20 * time.Millisecond,
+			Amount:   1,
+		},
+	}, 10*time.Millisecond)
+	defer limiter.Stop()
+	limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+	require.True(t, limiter.Check(limitContext).Allowed)
+	require.False(t, limiter.Check(limitContext).Allowed)
+
+	// when
+	time.Sleep(50 * time.Millisecond)
+
+	// then
+	decision := limiter.Check(limitContext)
+	assert.True(t, decision.Allowed)
+	assert.Zero(t, decision.Remaining)
+}
+
+func TestRateLimiterStoresOneKeyPerLimit(t *testing.T) {
+	// given
+	clearRedis()
+	limiter := authentication.NewRedisRateLimiter([]authentication.Limit{
+		{
+			Suffix:   "s",
+			Duration: time.Second,
+			Amount:   100,
+		},
+		{
+			Suffix:   "h",
+			Duration: time.Hour,
+			Amount:   10000,
+		},
+	}, redisClient)
+	limitContext := authentication.LimitContext{UserID: 1, Path: "/balance"}
+
+	// when
+	for i := 0; i < 50; i++ {
+		require.True(t, limiter.Check(limitContext).Allowed)
+	}
+
+	// then
+	keys, err := redisClient.Keys("*").Result()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	for _, key := range keys {
+		assert.Equal(t, "string", redisClient.Type(key).Val())
+		assert.True(t, redisClient.PTTL(key).Val() > 0, "keys expire when they are not needed anymore")
+	}
 }
 
 func TestContextBasedRequestLimiterRateLimit(t *testing.T) {
+	limit := authentication.Limit{Suffix: "m", Duration: time.Minute, Amount: 10}
 	tests := []struct {
-		name               string
-		rateLimiterReturns bool
-		expectedCode       int
+		name            string
+		decision        authentication.LimitDecision
+		expectedCode    int
+		expectedHeaders map[string]string
 	}{
 		{
-			name:               "rate limit is not reached",
-			rateLimiterReturns: true,
-			expectedCode:       http.StatusOK,
+			name: "rate limit is not reached",
+			decision: authentication.LimitDecision{
+				Allowed:   true,
+				Limit:     limit,
+				Remaining: 7,
+				Reset:     18 * time.Second,
+			},
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"RateLimit-Limit":     "10",
+				"RateLimit-Remaining": "7",
+				"RateLimit-Reset":     "18",
+				"Retry-After":         "",
+			},
+		},
+		{
+			name: "rate limit is reached",
+			decision: authentication.LimitDecision{
+				Limit:      limit,
+				Reset:      time.Minute,
+				RetryAfter: 5500 * time.Millisecond,
+			},
+			expectedCode: http.StatusTooManyRequests,
+			expectedHeaders: map[string]string{
+				"RateLimit-Limit":     "10",
+				"RateLimit-Remaining": "0",
+				"RateLimit-Reset":     "60",
+				"Retry-After":         "6",
+			},
 		},
 		{
-			name:               "rate limit is reached",
-			rateLimiterReturns: false,
-			expectedCode:       http.StatusTooManyRequests,
+			name:         "limits were not checked",
+			decision:     authentication.LimitDecision{Allowed: true},
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"RateLimit-Limit":     "",
+				"RateLimit-Remaining": "",
+				"Retry-After":         "",
+			},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			// given
+			nextCalled := false
 			okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
 				w.WriteHeader(http.StatusOK)
 			})
 			rateLimiter := new(mockRateLimiter)
@@ -178,16 +414,20 @@ func TestContextBasedRequestLimiterRateLimit(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/path", nil)
 			req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
 			w := httptest.NewRecorder()
-			rateLimiter.On("IsUnderLimits", authentication.LimitContext{
+			rateLimiter.On("Check", authentication.LimitContext{
 				UserID: userContext.UserID,
 				Path:   req.URL.Path,
-			}).Return(test.rateLimiterReturns)
+			}).Return(test.decision)
 			// when
 
 			rateLimited.ServeHTTP(w, req)
 
 			//then
 			assert.Equal(t, test.expectedCode, w.Code)
+			assert.Equal(t, test.decision.Allowed, nextCalled, "the chain stops when the limit is exceeded")
+			for name, value := range test.expectedHeaders {
+				assert.Equal(t, value, w.Header().Get(name), name)
+			}
 		})
 	}
 }
@@ -209,3 +449,59 @@ func TestContextBasedRequestLimiterNoUserInContextStatusOK(t *testing.T) {
 	//then
 	assert.Equal(t, http.StatusOK, w.Code)
 }
+
+func TestRateLimiterSeparatesUsersAndIPs(t *testing.T) {
+	for _, implementation := range rateLimiters {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			limiter := implementation.newLimiter(t, []authentication.Limit{
+				{
+					Suffix:   "m",
+					Duration: time.Minute,
+					Amount:   1,
+				},
+			})
+			require.True(t, limiter.Check(authentication.LimitContext{UserID: 1, Path: "/balance"}).Allowed)
+
+			// when
+			ipUnderLimit := limiter.Check(authentication.LimitContext{IP: "192.0.2.1", Path: "/balance"}).Allowed
+			userAndIPUnderLimit := limiter.Check(
+				authentication.LimitContext{UserID: 1, IP: "192.0.2.1", Path: "/balance"},
+			).Allowed
+			userUnderLimit := limiter.Check(authentication.LimitContext{UserID: 1, Path: "/balance"}).Allowed
+
+			// then
+			assert.True(t, ipUnderLimit)
+			assert.True(t, userAndIPUnderLimit)
+			assert.False(t, userUnderLimit)
+		})
+	}
+}
+
+func TestRequestLimitersApplyAllLimiters(t *testing.T) {
+	// given
+	okHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	userRateLimiter := new(mockRateLimiter)
+	routeRateLimiter := new(mockRateLimiter)
+	rateLimited := authentication.RequestLimiters{
+		authentication.NewContextBasedRequestLimiter(userRateLimiter),
+		authentication.NewContextBasedRequestLimiter(routeRateLimiter),
+	}.RateLimit(okHandler)
+	req := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user", authentication.UserContext{UserID: 1}))
+	userRateLimiter.On("Check", authentication.LimitContext{UserID: 1, Path: "/balance"}).
+		Return(authentication.LimitDecision{Allowed: true})
+	routeRateLimiter.On("Check", authentication.LimitContext{UserID: 1, Path: "/balance"}).
+		Return(authentication.LimitDecision{Allowed: false})
+	w := httptest.NewRecorder()
+
+	// when
+	rateLimited.ServeHTTP(w, req)
+
+	// then
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	userRateLimiter.AssertExpectations(t)
+	routeRateLimiter.AssertExpectations(t)
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"net"
+	"sync"
 )
 
 const (
@@ -13,14 +15,47 @@ const (
 )
 
 var (
+	redisContainer lazyRedisContainer
+	// redisClient starts the container with its first connection, so that tests that don't use Redis, like the ones
+	// of memory implementations, run without Docker
 	redisClient = redis.NewClient(&redis.Options{
 		Password: redisPassword,
-		Addr:     createRedisContainer(context.Background()),
+		Dialer: func() (net.Conn, error) {
+			addr, err := redisContainer.start()
+			if err != nil {
+				return nil, err
+			}
+			return net.Dial("tcp", addr)
+		},
 	})
 )
 
+// lazyRedisContainer is started once, when it is needed for the first time
+type lazyRedisContainer struct {
+	mutex sync.Mutex
+	addr  string
+	err   error
+}
+
+// start the container if it is not started yet and return its address
+func (c *lazyRedisContainer) start() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.addr == "" && c.err == nil {
+		c.addr, c.err = createRedisContainer(context.Background())
+	}
+	return c.addr, c.err
+}
+
+// started tells if the container was started already
+func (c *lazyRedisContainer) started() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.addr != ""
+}
+
 // Creates Redis container and return its address
-func createRedisContainer(ctx context.Context) string {
+func createRedisContainer(ctx context.Context) (string, error) {
 	redisC, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
 			Image:        redisImage,
@@ -31,16 +66,16 @@ func createRedisContainer(ctx context.Context) string {
 		Started: true,
 	})
 	if err != nil {
-		panic(err)
-	}
-	endpoint, err := redisC.Endpoint(ctx, "")
-	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return endpoint
+	return redisC.Endpoint(ctx, "")
 }
 
+// clearRedis does nothing if Redis wasn't used yet, there is nothing to clear
 func clearRedis() {
+	if !redisContainer.started() {
+		return
+	}
 	if err := redisClient.FlushAll().Err(); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,46 @@
+package authentication
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Session contains information about a login session. All tokens acquired by authentication and refreshes that
+// follow it belong to the same session.
+type Session struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ClientIP   string    `json:"clientIp"`
+	UserAgent  string    `json:"userAgent"`
+	// Current is true for the session of the request
+	Current bool `json:"current"`
+}
+
+// ClientInfo describes a client that performs a request
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// NewClientInfo extracts ClientInfo from the request
+func NewClientInfo(r *http.Request) ClientInfo {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return ClientInfo{IP: ip, UserAgent: r.UserAgent()}
+}
+
+// WithClientInfo returns a copy of the context that holds ClientInfo
+func WithClientInfo(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, "client", client)
+}
+
+// ExtractClientInfo from the context. If it wasn't put there - empty ClientInfo is returned.
+func ExtractClientInfo(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value("client").(ClientInfo)
+	return client
+}
@@ -0,0 +1,179 @@
+package authentication
+
+import (
+	"errors"
+	"go-spend/log"
+	"go-spend/util/uuid"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is not valid")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// SessionStarter starts a new session for an authenticated user
+type SessionStarter interface {
+	Start(userContext UserContext, client ClientInfo) (TokenResponse, error)
+}
+
+// SessionService manages sessions started by authentication
+type SessionService interface {
+	Refresh(refreshToken string) (TokenResponse, error)
+	Logout(userContext UserContext) error
+	LogoutAll(userID uint) error
+	List(userContext UserContext) ([]Session, error)
+	Delete(userID uint, sessionID string) error
+}
+
+// DefaultSessionService is a default implementation of SessionService and SessionStarter that keeps sessions in
+// TokenRepository
+type DefaultSessionService struct {
+	tokenCreator    *TokenCreator
+	tokenRepository TokenRepository
+	maxSessions     int
+}
+
+// NewDefaultSessionService creates a new instance of DefaultSessionService. If user has more than maxSessions
+// sessions - the oldest ones are removed when a new one is started. Zero means no limit.
+func NewDefaultSessionService(
+	tokenCreator *TokenCreator,
+	tokenRepository TokenRepository,
+	maxSessions int,
+) *DefaultSessionService {
+	return &DefaultSessionService{
+		tokenCreator:    tokenCreator,
+		tokenRepository: tokenRepository,
+		maxSessions:     maxSessions,
+	}
+}
+
+// Start creates tokens in a new session of the user. SessionID of provided UserContext is ignored.
+func (s *DefaultSessionService) Start(userContext UserContext, client ClientInfo) (TokenResponse, error) {
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	userContext.SessionID = sessionID.String()
+	tokenPair, err := s.tokenCreator.CreateTokenPair(userContext)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if err = s.tokenRepository.Save(tokenPair, userContext); err != nil {
+		return TokenResponse{}, err
+	}
+	now := time.Now()
+	session := Session{
+		ID:         userContext.SessionID,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ClientIP:   client.IP,
+		UserAgent:  client.UserAgent,
+	}
+	expiration := time.Unix(tokenPair.RefreshToken.ExpiresAt, 0).Sub(now)
+	if err = s.tokenRepository.CreateSession(userContext.UserID, session, expiration); err != nil {
+		return TokenResponse{}, err
+	}
+	s.evictOldestSessions(userContext.UserID)
+	return TokenResponse{
+		AccessToken:  tokenPair.AccessToken.Encoded,
+		RefreshToken: tokenPair.RefreshToken.Encoded,
+	}, nil
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens in the same session. Every refresh token can be used only
+// once - if an already used one is presented, the whole session is revoked as the token was most likely stolen.
+// If token is not valid, unknown or reused - ErrInvalidRefreshToken is returned.
+func (s *DefaultSessionService) Refresh(refreshToken string) (TokenResponse, error) {
+	token, claimedContext, err := s.tokenCreator.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return TokenResponse{}, ErrInvalidRefreshToken
+	}
+	userContext, err := s.tokenRepository.ConsumeRefreshToken(token, claimedContext.SessionID)
+	switch err {
+	case nil:
+	case ErrTokenNotFound:
+		return TokenResponse{}, ErrInvalidRefreshToken
+	case ErrRefreshTokenReused:
+		log.Warn("reused refresh token of user %d, revoking session %s", claimedContext.UserID, claimedContext.SessionID)
+		if err = s.tokenRepository.RemoveSession(claimedContext.UserID, claimedContext.SessionID); err != nil {
+			return TokenResponse{}, err
+		}
+		return TokenResponse{}, ErrInvalidRefreshToken
+	default:
+		return TokenResponse{}, err
+	}
+	if userContext.UserID != claimedContext.UserID || userContext.SessionID != claimedContext.SessionID {
+		return TokenResponse{}, ErrInvalidRefreshToken
+	}
+	// stored context is used as it is kept up to date with group membership changes
+	tokenPair, err := s.tokenCreator.CreateTokenPair(userContext)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if err = s.tokenRepository.Save(tokenPair, userContext); err != nil {
+		return TokenResponse{}, err
+	}
+	return TokenResponse{
+		AccessToken:  tokenPair.AccessToken.Encoded,
+		RefreshToken: tokenPair.RefreshToken.Encoded,
+	}, nil
+}
+
+// Logout revokes tokens of the session from provided UserContext. If context has no session - ErrSessionNotFound
+// is returned.
+func (s *DefaultSessionService) Logout(userContext UserContext) error {
+	if userContext.SessionID == "" {
+		return ErrSessionNotFound
+	}
+	return s.tokenRepository.RemoveSession(userContext.UserID, userContext.SessionID)
+}
+
+// LogoutAll revokes tokens of all sessions of the user
+func (s *DefaultSessionService) LogoutAll(userID uint) error {
+	return s.tokenRepository.RemoveAllSessions(userID)
+}
+
+// List returns live sessions of the user from provided UserContext, the session of the context is marked as current
+func (s *DefaultSessionService) List(userContext UserContext) ([]Session, error) {
+	sessions, err := s.tokenRepository.FindSessions(userContext.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == userContext.SessionID
+	}
+	return sessions, nil
+}
+
+// Delete revokes tokens of the session of the user. If user has no such session - ErrSessionNotFound is returned.
+func (s *DefaultSessionService) Delete(userID uint, sessionID string) error {
+	sessions, err := s.tokenRepository.FindSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return s.tokenRepository.RemoveSession(userID, sessionID)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// evictOldestSessions removes the oldest sessions of the user above the limit. Failure is only logged as the new
+// session has already started.
+func (s *DefaultSessionService) evictOldestSessions(userID uint) {
+	if s.maxSessions <= 0 {
+		return
+	}
+	sessions, err := s.tokenRepository.FindSessions(userID)
+	if err != nil {
+		log.Warn("couldn't find sessions of user %d to evict - %s", userID, err)
+		return
+	}
+	for i := 0; i < len(sessions)-s.maxSessions; i++ {
+		if err = s.tokenRepository.RemoveSession(userID, sessions[i].ID); err != nil {
+			log.Warn("couldn't evict session %s of user %d - %s", sessions[i].ID, userID, err)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package authentication_test
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"testing"
+	"time"
+)
+
+type mockTokenRepository struct {
+	mock.Mock
+}
+
+func (m *mockTokenRepository) Save(pair authentication.TokenPair, userContext authentication.UserContext) error {
+	args := m.Called(pair, userContext)
+	return args.Error(0)
+}
+
+func (m *mockTokenRepository) Retrieve(_ string) (authentication.UserContext, error) {
+	panic("implement me")
+}
+
+func (m *mockTokenRepository) UpdateUserContext(_ authentication.UserContext) error {
+	panic("implement me")
+}
+
+func (m *mockTokenRepository) ConsumeRefreshToken(
+	token authentication.Token,
+	sessionID string,
+) (authentication.UserContext, error) {
+	args := m.Called(token, sessionID)
+	return args.Get(0).(authentication.UserContext), args.Error(1)
+}
+
+func (m *mockTokenRepository) CreateSession(
+	userID uint,
+	session authentication.Session,
+	expiration time.Duration,
+) error {
+	args := m.Called(userID, session, expiration)
+	return args.Error(0)
+}
+
+func (m *mockTokenRepository) FindSessions(userID uint) ([]authentication.Session, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]authentication.Session), args.Error(1)
+}
+
+func (m *mockTokenRepository) RemoveSession(userID uint, sessionID string) error {
+	args := m.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *mockTokenRepository) RemoveAllSessions(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *mockTokenRepository) RemoveOtherSessions(userID uint, keptSessionID string) error {
+	args := m.Called(userID, keptSessionID)
+	return args.Error(0)
+}
+
+func (m *mockTokenRepository) IsDenied(_ string) (bool, error) {
+	panic("implement me")
+}
+
+func TestNewDefaultSessionService(t *testing.T) {
+	require.NotNil(t, authentication.NewDefaultSessionService(testTokenCreator, new(mockTokenRepository), 0))
+}
+
+func TestSessionServiceRefresh(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	claimedContext := authentication.UserContext{UserID: 1, GroupID: 0, SessionID: "session"}
+	storedContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	tokenPair, err := testTokenCreator.CreateTokenPair(claimedContext)
+	require.NoError(t, err)
+	tokenRepository.On("ConsumeRefreshToken", tokenPair.RefreshToken, "session").Return(storedContext, nil)
+	tokenRepository.On("Save", mock.Anything, storedContext).Return(nil)
+
+	// when
+	tokens, err := service.Refresh(tokenPair.RefreshToken.Encoded)
+
+	// then
+	require.NoError(t, err)
+	assert.NotEqual(t, tokenPair.AccessToken.Encoded, tokens.AccessToken)
+	assert.NotEqual(t, tokenPair.RefreshToken.Encoded, tokens.RefreshToken)
+	_, refreshedContext, err := testTokenCreator.ValidateRefreshToken(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, storedContext, refreshedContext)
+	tokenRepository.AssertExpectations(t)
+}
+
+func TestSessionServiceRefreshWithAccessToken(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenPair, err := testTokenCreator.CreateTokenPair(authentication.UserContext{UserID: 1, SessionID: "session"})
+	require.NoError(t, err)
+
+	// when
+	_, err = service.Refresh(tokenPair.AccessToken.Encoded)
+
+	// then
+	assert.Equal(t, authentication.ErrInvalidRefreshToken, err)
+	tokenRepository.AssertNotCalled(t, "ConsumeRefreshToken", mock.Anything, mock.Anything)
+}
+
+func TestSessionServiceRefreshUnknownToken(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenPair, err := testTokenCreator.CreateTokenPair(authentication.UserContext{UserID: 1, SessionID: "session"})
+	require.NoError(t, err)
+	tokenRepository.On("ConsumeRefreshToken", tokenPair.RefreshToken, "session").
+		Return(authentication.UserContext{}, authentication.ErrTokenNotFound)
+
+	// when
+	_, err = service.Refresh(tokenPair.RefreshToken.Encoded)
+
+	// then
+	assert.Equal(t, authentication.ErrInvalidRefreshToken, err)
+	tokenRepository.AssertNotCalled(t, "RemoveSession", mock.Anything, mock.Anything)
+}
+
+func TestSessionServiceRefreshReusedTokenRevokesSession(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenPair, err := testTokenCreator.CreateTokenPair(authentication.UserContext{UserID: 1, SessionID: "session"})
+	require.NoError(t, err)
+	tokenRepository.On("ConsumeRefreshToken", tokenPair.RefreshToken, "session").
+		Return(authentication.UserContext{}, authentication.ErrRefreshTokenReused)
+	tokenRepository.On("RemoveSession", uint(1), "session").Return(nil)
+
+	// when
+	_, err = service.Refresh(tokenPair.RefreshToken.Encoded)
+
+	// then
+	assert.Equal(t, authentication.ErrInvalidRefreshToken, err)
+	tokenRepository.AssertExpectations(t)
+}
+
+func TestSessionServiceRefreshSaveFails(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	userContext := authentication.UserContext{UserID: 1, SessionID: "session"}
+	tokenPair, err := testTokenCreator.CreateTokenPair(userContext)
+	require.NoError(t, err)
+	expectedErr := errors.New("expected")
+	tokenRepository.On("ConsumeRefreshToken", tokenPair.RefreshToken, "session").Return(userContext, nil)
+	tokenRepository.On("Save", mock.Anything, userContext).Return(expectedErr)
+
+	// when
+	_, err = service.Refresh(tokenPair.RefreshToken.Encoded)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestSessionServiceLogout(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenRepository.On("RemoveSession", uint(1), "session").Return(nil)
+
+	// when
+	err := service.Logout(authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"})
+
+	// then
+	require.NoError(t, err)
+	tokenRepository.AssertExpectations(t)
+}
+
+func TestSessionServiceLogoutWithoutSession(t *testing.T) {
+	service := authentication.NewDefaultSessionService(testTokenCreator, new(mockTokenRepository), 0)
+	err := service.Logout(authentication.UserContext{UserID: 1, GroupID: 2})
+	assert.Equal(t, authentication.ErrSessionNotFound, err)
+}
+
+func TestSessionServiceLogoutAll(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	expectedErr := errors.New("expected")
+	tokenRepository.On("RemoveAllSessions", uint(1)).Return(expectedErr)
+
+	// when
+	err := service.LogoutAll(1)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+}
+
+func TestSessionServiceStart(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	client := authentication.ClientInfo{IP: "10.0.0.1", UserAgent: "curl/7.74.0"}
+	var savedContext authentication.UserContext
+	tokenRepository.On("Save", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		savedContext = args.Get(1).(authentication.UserContext)
+	})
+	tokenRepository.On("CreateSession", uint(1), mock.MatchedBy(func(session authentication.Session) bool {
+		return session.ID == savedContext.SessionID && session.ClientIP == client.IP &&
+			session.UserAgent == client.UserAgent && !session.CreatedAt.IsZero()
+	}), mock.Anything).Return(nil)
+
+	// when
+	tokens, err := service.Start(authentication.UserContext{UserID: 1, GroupID: 2}, client)
+
+	// then
+	require.NoError(t, err)
+	_, claimedContext, err := testTokenCreator.ValidateRefreshToken(tokens.RefreshToken)
+	require.NoError(t, err)
+	assert.Equal(t, savedContext, claimedContext)
+	assert.NotEmpty(t, savedContext.SessionID)
+	tokenRepository.AssertExpectations(t)
+	tokenRepository.AssertNotCalled(t, "FindSessions", mock.Anything)
+}
+
+func TestSessionServiceStartEvictsOldestSessions(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 2)
+	tokenRepository.On("Save", mock.Anything, mock.Anything).Return(nil)
+	tokenRepository.On("CreateSession", uint(1), mock.Anything, mock.Anything).Return(nil)
+	tokenRepository.On("FindSessions", uint(1)).Return([]authentication.Session{
+		{ID: "oldest"}, {ID: "older"}, {ID: "old"}, {ID: "new"},
+	}, nil)
+	tokenRepository.On("RemoveSession", uint(1), "oldest").Return(nil)
+	tokenRepository.On("RemoveSession", uint(1), "older").Return(nil)
+
+	// when
+	_, err := service.Start(authentication.UserContext{UserID: 1}, authentication.ClientInfo{})
+
+	// then
+	require.NoError(t, err)
+	tokenRepository.AssertExpectations(t)
+	tokenRepository.AssertNumberOfCalls(t, "RemoveSession", 2)
+}
+
+func TestSessionServiceStartSaveFails(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	expectedErr := errors.New("expected")
+	tokenRepository.On("Save", mock.Anything, mock.Anything).Return(expectedErr)
+
+	// when
+	_, err := service.Start(authentication.UserContext{UserID: 1}, authentication.ClientInfo{})
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	tokenRepository.AssertNotCalled(t, "CreateSession", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSessionServiceList(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenRepository.On("FindSessions", uint(1)).Return([]authentication.Session{{ID: "other"}, {ID: "current"}}, nil)
+
+	// when
+	sessions, err := service.List(authentication.UserContext{UserID: 1, SessionID: "current"})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []authentication.Session{{ID: "other"}, {ID: "current", Current: true}}, sessions)
+}
+
+func TestSessionServiceDelete(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenRepository.On("FindSessions", uint(1)).Return([]authentication.Session{{ID: "first"}, {ID: "second"}}, nil)
+	tokenRepository.On("RemoveSession", uint(1), "second").Return(nil)
+
+	// when
+	err := service.Delete(1, "second")
+
+	// then
+	require.NoError(t, err)
+	tokenRepository.AssertExpectations(t)
+}
+
+func TestSessionServiceDeleteSessionOfOtherUser(t *testing.T) {
+	// given
+	tokenRepository := new(mockTokenRepository)
+	service := authentication.NewDefaultSessionService(testTokenCreator, tokenRepository, 0)
+	tokenRepository.On("FindSessions", uint(1)).Return([]authentication.Session{{ID: "first"}}, nil)
+
+	// when
+	err := service.Delete(1, "other")
+
+	// then
+	assert.Equal(t, authentication.ErrSessionNotFound, err)
+	tokenRepository.AssertNotCalled(t, "RemoveSession", mock.Anything, mock.Anything)
+}
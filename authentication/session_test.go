@@ -0,0 +1,30 @@
+package authentication_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go-spend/authentication"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewClientInfo(t *testing.T) {
+	// given
+	request := httptest.NewRequest(http.MethodPost, "/authenticate", nil)
+	request.RemoteAddr = "10.0.0.1:45321"
+	request.Header.Set("User-Agent", "curl/7.74.0")
+
+	// when
+	client := authentication.NewClientInfo(request)
+
+	// then
+	assert.Equal(t, authentication.ClientInfo{IP: "10.0.0.1", UserAgent: "curl/7.74.0"}, client)
+}
+
+func TestClientInfoContext(t *testing.T) {
+	client := authentication.ClientInfo{IP: "10.0.0.1", UserAgent: "curl/7.74.0"}
+	ctx := authentication.WithClientInfo(context.Background(), client)
+	assert.Equal(t, client, authentication.ExtractClientInfo(ctx))
+	assert.Equal(t, authentication.ClientInfo{}, authentication.ExtractClientInfo(context.Background()))
+}
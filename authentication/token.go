@@ -1,8 +1,9 @@
 package authentication
 
 import (
+	"errors"
 	"go-spend/authentication/jwt"
-	"go-spend/authentication/uuid"
+	"go-spend/util/uuid"
 	"time"
 )
 
@@ -12,13 +13,24 @@ const (
 	refreshUUIDClaim = "refresh_uuid"
 	userIDClaim      = "user_id"
 	groupIDClaim     = "group_id"
+	sessionIDClaim   = "session_id"
 	expClaim         = "exp"
+
+	// hardcoded for now, if necessary can be made configurable
+	accessTokenExpiration  = 15 * time.Minute
+	refreshTokenExpiration = 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken = errors.New("token is not valid")
 )
 
-// TokenResponse is a struct to map token values to JSON
+// TokenResponse is a struct to map token values to JSON. Users with two-factor authentication get only MFAToken
+// after the password check, it is exchanged together with a code for access and refresh tokens.
 type TokenResponse struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
+	AccessToken  string `json:"accessToken,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	MFAToken     string `json:"mfaToken,omitempty"`
 }
 
 // TokenPair contains info about both access and refresh tokens
@@ -36,34 +48,78 @@ type Token struct {
 
 // TokenCreator creates tokens based on provided algorithms
 type TokenCreator struct {
-	accessAlgorithm        *jwt.Algorithm
-	refreshAlgorithm       *jwt.Algorithm
+	accessAlgorithm        jwt.Codec
+	refreshAlgorithm       jwt.Codec
 	accessTokenExpiration  time.Duration
 	refreshTokenExpiration time.Duration
 }
 
-func NewTokenCreator(accessAlgorithm *jwt.Algorithm, refreshAlgorithm *jwt.Algorithm) *TokenCreator {
+func NewTokenCreator(accessAlgorithm jwt.Codec, refreshAlgorithm jwt.Codec) *TokenCreator {
 	return &TokenCreator{
 		accessAlgorithm:        accessAlgorithm,
 		refreshAlgorithm:       refreshAlgorithm,
-		accessTokenExpiration:  15 * time.Minute, // hardcoded for now, if necessary can be made configurable
-		refreshTokenExpiration: 24 * time.Hour,
+		accessTokenExpiration:  accessTokenExpiration,
+		refreshTokenExpiration: refreshTokenExpiration,
 	}
 }
 
-func (t *TokenCreator) CreateTokenPair(userID uint, groupID uint) (TokenPair, error) {
-	token, err := t.createAccessToken(userID, groupID)
+// CreateTokenPair creates access and refresh tokens for provided UserContext
+func (t *TokenCreator) CreateTokenPair(userContext UserContext) (TokenPair, error) {
+	token, err := t.createAccessToken(userContext)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	refreshToken, err := t.createRefreshToken(userContext)
 	if err != nil {
-		return TokenPair{}, nil
+		return TokenPair{}, err
 	}
-	refreshToken, err := t.createRefreshToken(userID, groupID)
 	return TokenPair{
 		AccessToken:  token,
 		RefreshToken: refreshToken,
 	}, nil
 }
 
-func (t *TokenCreator) createAccessToken(userID uint, groupID uint) (Token, error) {
+// ValidateRefreshToken checks signature and expiration of the refresh token and returns its info together with
+// the UserContext from its claims. If token is not valid - ErrInvalidToken is returned.
+func (t *TokenCreator) ValidateRefreshToken(encoded string) (Token, UserContext, error) {
+	claims, err := t.refreshAlgorithm.DecodeAndValidate(encoded)
+	if err != nil {
+		return Token{}, UserContext{}, ErrInvalidToken
+	}
+	tokenUUID, ok := claims[refreshUUIDClaim].(string)
+	if !ok {
+		return Token{}, UserContext{}, ErrInvalidToken
+	}
+	expiresAt, ok := claims.GetTime(expClaim)
+	if !ok {
+		return Token{}, UserContext{}, ErrInvalidToken
+	}
+	userContext, err := userContextFromClaims(claims)
+	if err != nil {
+		return Token{}, UserContext{}, err
+	}
+	return Token{Encoded: encoded, UUID: tokenUUID, ExpiresAt: expiresAt.Unix()}, userContext, nil
+}
+
+// userContextFromClaims extracts UserContext from claims of either access or refresh token
+func userContextFromClaims(claims jwt.Claims) (UserContext, error) {
+	sessionID, ok := claims[sessionIDClaim].(string)
+	if !ok || sessionID == "" {
+		return UserContext{}, ErrInvalidToken
+	}
+	// numbers are decoded as float64 from JSON
+	userID, ok := claims[userIDClaim].(float64)
+	if !ok {
+		return UserContext{}, ErrInvalidToken
+	}
+	groupID, ok := claims[groupIDClaim].(float64)
+	if !ok {
+		return UserContext{}, ErrInvalidToken
+	}
+	return UserContext{UserID: uint(userID), GroupID: uint(groupID), SessionID: sessionID}, nil
+}
+
+func (t *TokenCreator) createAccessToken(userContext UserContext) (Token, error) {
 	token := Token{}
 	token.ExpiresAt = time.Now().Add(t.accessTokenExpiration).Unix()
 	newUUID, err := uuid.NewV4()
@@ -73,8 +129,9 @@ func (t *TokenCreator) createAccessToken(userID uint, groupID uint) (Token, erro
 	token.UUID = newUUID.String()
 	atClaims := jwt.NewClaims()
 	atClaims[accessUUIDClaim] = token.UUID
-	atClaims[userIDClaim] = userID
-	atClaims[groupIDClaim] = groupID
+	atClaims[userIDClaim] = userContext.UserID
+	atClaims[groupIDClaim] = userContext.GroupID
+	atClaims[sessionIDClaim] = userContext.SessionID
 	atClaims[expClaim] = token.ExpiresAt
 	token.Encoded, err = t.accessAlgorithm.Encode(atClaims)
 	if err != nil {
@@ -83,7 +140,7 @@ func (t *TokenCreator) createAccessToken(userID uint, groupID uint) (Token, erro
 	return token, nil
 }
 
-func (t *TokenCreator) createRefreshToken(userID uint, groupID uint) (Token, error) {
+func (t *TokenCreator) createRefreshToken(userContext UserContext) (Token, error) {
 	token := Token{}
 	token.ExpiresAt = time.Now().Add(t.refreshTokenExpiration).Unix()
 	newUUID, err := uuid.NewV4()
@@ -93,10 +150,11 @@ func (t *TokenCreator) createRefreshToken(userID uint, groupID uint) (Token, err
 	token.UUID = newUUID.String()
 	claims := jwt.NewClaims()
 	claims[refreshUUIDClaim] = token.UUID
-	claims[userIDClaim] = userID
-	claims[groupIDClaim] = groupID
+	claims[userIDClaim] = userContext.UserID
+	claims[groupIDClaim] = userContext.GroupID
+	claims[sessionIDClaim] = userContext.SessionID
 	claims[expClaim] = token.ExpiresAt
-	token.Encoded, err = t.accessAlgorithm.Encode(claims)
+	token.Encoded, err = t.refreshAlgorithm.Encode(claims)
 	if err != nil {
 		return Token{}, err
 	}
@@ -1,10 +1,23 @@
 package authentication
 
 import (
+	"errors"
+	"fmt"
 	"github.com/go-redis/redis"
+	"go-spend/log"
 	"time"
 )
 
+const (
+	clientIPField  = "ip"
+	userAgentField = "userAgent"
+)
+
+var (
+	ErrTokenNotFound      = errors.New("token not found")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
 // TokenSaver stores both access and refresh token information
 type TokenSaver interface {
 	Save(pair TokenPair, userContext UserContext) error
@@ -15,19 +28,63 @@ type TokenRetriever interface {
 	Retrieve(uuid string) (UserContext, error)
 }
 
+// UserContextUpdater replaces UserContext stored for all live tokens of the user
+type UserContextUpdater interface {
+	UpdateUserContext(userContext UserContext) error
+}
+
+// RefreshTokenConsumer makes a refresh token unusable and returns UserContext it was stored with
+type RefreshTokenConsumer interface {
+	ConsumeRefreshToken(token Token, sessionID string) (UserContext, error)
+}
+
+// SessionStorage keeps information about sessions of users
+type SessionStorage interface {
+	CreateSession(userID uint, session Session, expiration time.Duration) error
+	FindSessions(userID uint) ([]Session, error)
+}
+
+// SessionRemover removes all tokens of either one, all or all but one sessions of a user
+type SessionRemover interface {
+	RemoveSession(userID uint, sessionID string) error
+	RemoveAllSessions(userID uint) error
+	RemoveOtherSessions(userID uint, keptSessionID string) error
+}
+
+// SessionDenylist tells whether a session was revoked recently. A session only needs to be remembered as long as
+// its access tokens can live.
+type SessionDenylist interface {
+	IsDenied(sessionID string) (bool, error)
+}
+
 // Combines capabilities to store and retrieve values from the storage
 type TokenRepository interface {
 	TokenSaver
 	TokenRetriever
+	UserContextUpdater
+	RefreshTokenConsumer
+	SessionStorage
+	SessionRemover
+	SessionDenylist
 }
 
 // SimpleRedisClient provides only necessary methods to simplify testing, see redis.UniversalClient
 type SimpleRedisClient interface {
 	Set(key string, value interface{}, expiration time.Duration) *redis.StatusCmd
 	Get(key string) *redis.StringCmd
+	Del(keys ...string) *redis.IntCmd
+	Exists(keys ...string) *redis.IntCmd
+	SMembers(key string) *redis.StringSliceCmd
+	ZAddXX(key string, members ...redis.Z) *redis.IntCmd
+	ZRange(key string, start, stop int64) *redis.StringSliceCmd
+	ZRangeWithScores(key string, start, stop int64) *redis.ZSliceCmd
+	Pipelined(fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+	TxPipelined(fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
 }
 
-// RedisTokenRepository is TokenSaver which stores value in redis
+// RedisTokenRepository is TokenSaver which stores value in redis. Tokens are grouped into sessions - every session
+// keeps a set of its tokens and its metadata, every user keeps sorted sets of their sessions by creation and last use
+// time, so that tokens can be found by user.
 type RedisTokenRepository struct {
 	redis SimpleRedisClient
 }
@@ -37,22 +94,355 @@ func NewRedisTokenRepository(redis SimpleRedisClient) *RedisTokenRepository {
 	return &RedisTokenRepository{redis: redis}
 }
 
+// Save stores both tokens as part of the session from provided UserContext and marks the session as used.
+// Session is required.
 func (r *RedisTokenRepository) Save(pair TokenPair, userContext UserContext) error {
-	var err error
+	if userContext.SessionID == "" {
+		return ErrIncorrectValue
+	}
 	now := time.Now()
 	accessDuration := time.Unix(pair.AccessToken.ExpiresAt, 0).Sub(now)
-	err = r.redis.Set(pair.AccessToken.UUID, userContext.Value(), accessDuration).Err()
-	if err != nil {
-		return err
-	}
 	refreshDuration := time.Unix(pair.RefreshToken.ExpiresAt, 0).Sub(now)
-	return r.redis.Set(pair.RefreshToken.UUID, userContext.Value(), refreshDuration).Err()
+	tokensKey := sessionTokensKey(userContext.SessionID)
+	indexKey := userSessionsKey(userContext.UserID)
+	lastUsedKey := userSessionsLastUsedKey(userContext.UserID)
+	member := redis.Z{Score: float64(now.Unix()), Member: userContext.SessionID}
+	_, err := r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(pair.AccessToken.UUID, userContext.Value(), accessDuration)
+		pipe.Set(pair.RefreshToken.UUID, userContext.Value(), refreshDuration)
+		// refresh token lives longer than access token, so the indexes should live at least as long as it
+		pipe.SAdd(tokensKey, pair.AccessToken.UUID, pair.RefreshToken.UUID)
+		pipe.Expire(tokensKey, refreshDuration)
+		pipe.Expire(sessionKey(userContext.SessionID), refreshDuration)
+		pipe.ZAddNX(indexKey, member)
+		pipe.Expire(indexKey, refreshDuration)
+		pipe.ZAdd(lastUsedKey, member)
+		pipe.Expire(lastUsedKey, refreshDuration)
+		return nil
+	})
+	return err
 }
 
+// Retrieve returns UserContext of the token and marks its session as used. Returns ErrTokenNotFound if there is no
+// such token.
 func (r *RedisTokenRepository) Retrieve(uuid string) (UserContext, error) {
 	value, err := r.redis.Get(uuid).Result()
+	if err == redis.Nil {
+		return UserContext{}, ErrTokenNotFound
+	}
 	if err != nil {
 		return UserContext{}, err
 	}
-	return ParseUserContext(value)
+	userContext, err := ParseUserContext(value)
+	if err != nil || userContext.SessionID == "" {
+		return userContext, err
+	}
+	member := redis.Z{Score: float64(time.Now().Unix()), Member: userContext.SessionID}
+	// XX so that a session removed in the meantime is not brought back
+	if err = r.redis.ZAddXX(userSessionsLastUsedKey(userContext.UserID), member).Err(); err != nil {
+		log.Warn("couldn't mark session %s as used - %s", userContext.SessionID, err)
+	}
+	return userContext, nil
+}
+
+// UpdateUserContext sets UserID and GroupID from provided UserContext for all live tokens of the user keeping their
+// sessions and expiration. Expired tokens and sessions are removed from the indexes.
+func (r *RedisTokenRepository) UpdateUserContext(userContext UserContext) error {
+	sessionIDs, err := r.redis.ZRange(userSessionsKey(userContext.UserID), 0, -1).Result()
+	if err != nil || len(sessionIDs) == 0 {
+		return err
+	}
+	sessionTokens, err := r.findSessionTokens(sessionIDs)
+	if err != nil {
+		return err
+	}
+	ttls := make([][]*redis.DurationCmd, len(sessionIDs))
+	if _, err = r.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		for i := range sessionIDs {
+			for _, uuid := range sessionTokens[i] {
+				ttls[i] = append(ttls[i], pipe.PTTL(uuid))
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	_, err = r.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, sessionID := range sessionIDs {
+			uuids := sessionTokens[i]
+			if len(uuids) == 0 {
+				pipe.ZRem(userSessionsKey(userContext.UserID), sessionID)
+				pipe.ZRem(userSessionsLastUsedKey(userContext.UserID), sessionID)
+				continue
+			}
+			sessionContext := UserContext{UserID: userContext.UserID, GroupID: userContext.GroupID, SessionID: sessionID}
+			value := sessionContext.Value()
+			for j, uuid := range uuids {
+				if ttl := ttls[i][j].Val(); ttl > 0 {
+					// XX so that a token removed in the meantime is not brought back
+					pipe.SetXX(uuid, value, ttl)
+				} else {
+					pipe.SRem(sessionTokensKey(sessionID), uuid)
+				}
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// ConsumeRefreshToken atomically removes the refresh token and marks it as used until its expiration. Other tokens
+// of the session are removed as well, so that the session can continue with a new pair. If token was already used
+// - ErrRefreshTokenReused is returned, if it is unknown - ErrTokenNotFound.
+func (r *RedisTokenRepository) ConsumeRefreshToken(token Token, sessionID string) (UserContext, error) {
+	ttl := time.Until(time.Unix(token.ExpiresAt, 0))
+	if ttl <= 0 {
+		return UserContext{}, ErrTokenNotFound
+	}
+	var value *redis.StringCmd
+	var marked *redis.BoolCmd
+	_, err := r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		value = pipe.Get(token.UUID)
+		pipe.Del(token.UUID)
+		marked = pipe.SetNX(usedTokenKey(token.UUID), sessionID, ttl)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return UserContext{}, err
+	}
+	if value.Err() == redis.Nil {
+		if !marked.Val() {
+			return UserContext{}, ErrRefreshTokenReused
+		}
+		return UserContext{}, ErrTokenNotFound
+	}
+	userContext, err := ParseUserContext(value.Val())
+	if err != nil {
+		return UserContext{}, err
+	}
+	if err = r.removeSessionTokens(sessionID); err != nil {
+		return UserContext{}, err
+	}
+	return userContext, nil
+}
+
+// CreateSession stores metadata of a new session of the user
+func (r *RedisTokenRepository) CreateSession(userID uint, session Session, expiration time.Duration) error {
+	key := sessionKey(session.ID)
+	indexKey := userSessionsKey(userID)
+	lastUsedKey := userSessionsLastUsedKey(userID)
+	_, err := r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(key, map[string]interface{}{clientIPField: session.ClientIP, userAgentField: session.UserAgent})
+		pipe.Expire(key, expiration)
+		pipe.ZAdd(indexKey, redis.Z{Score: float64(session.CreatedAt.Unix()), Member: session.ID})
+		pipe.Expire(indexKey, expiration)
+		pipe.ZAdd(lastUsedKey, redis.Z{Score: float64(session.LastUsedAt.Unix()), Member: session.ID})
+		pipe.Expire(lastUsedKey, expiration)
+		return nil
+	})
+	return err
+}
+
+// FindSessions returns live sessions of the user ordered by creation time, the oldest first. Expired sessions are
+// removed from the indexes.
+func (r *RedisTokenRepository) FindSessions(userID uint) ([]Session, error) {
+	indexKey := userSessionsKey(userID)
+	lastUsedKey := userSessionsLastUsedKey(userID)
+	created, err := r.redis.ZRangeWithScores(indexKey, 0, -1).Result()
+	if err != nil || len(created) == 0 {
+		return nil, err
+	}
+	metadata := make([]*redis.StringStringMapCmd, len(created))
+	lastUsed := make([]*redis.FloatCmd, len(created))
+	if _, err = r.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, z := range created {
+			sessionID := z.Member.(string)
+			metadata[i] = pipe.HGetAll(sessionKey(sessionID))
+			lastUsed[i] = pipe.ZScore(lastUsedKey, sessionID)
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(created))
+	var expired []interface{}
+	for i, z := range created {
+		sessionID := z.Member.(string)
+		fields := metadata[i].Val()
+		if len(fields) == 0 {
+			expired = append(expired, sessionID)
+			continue
+		}
+		createdAt := time.Unix(int64(z.Score), 0)
+		lastUsedAt := createdAt
+		if lastUsed[i].Err() == nil {
+			lastUsedAt = time.Unix(int64(lastUsed[i].Val()), 0)
+		}
+		sessions = append(sessions, Session{
+			ID:         sessionID,
+			CreatedAt:  createdAt,
+			LastUsedAt: lastUsedAt,
+			ClientIP:   fields[clientIPField],
+			UserAgent:  fields[userAgentField],
+		})
+	}
+	if len(expired) > 0 {
+		if _, err = r.redis.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.ZRem(indexKey, expired...)
+			pipe.ZRem(lastUsedKey, expired...)
+			return nil
+		}); err != nil {
+			log.Warn("couldn't remove expired sessions of user %d - %s", userID, err)
+		}
+	}
+	return sessions, nil
+}
+
+// RemoveSession removes all tokens of the session and the session itself. The session is added to the denylist.
+func (r *RedisTokenRepository) RemoveSession(userID uint, sessionID string) error {
+	if err := r.removeSessionTokens(sessionID); err != nil {
+		return err
+	}
+	_, err := r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Set(deniedSessionKey(sessionID), "", accessTokenExpiration)
+		pipe.Del(sessionTokensKey(sessionID), sessionKey(sessionID))
+		pipe.ZRem(userSessionsKey(userID), sessionID)
+		pipe.ZRem(userSessionsLastUsedKey(userID), sessionID)
+		return nil
+	})
+	return err
+}
+
+// RemoveAllSessions removes all tokens of all sessions of the user. The sessions are added to the denylist.
+func (r *RedisTokenRepository) RemoveAllSessions(userID uint) error {
+	indexKey := userSessionsKey(userID)
+	sessionIDs, err := r.redis.ZRange(indexKey, 0, -1).Result()
+	if err != nil || len(sessionIDs) == 0 {
+		return err
+	}
+	sessionTokens, err := r.findSessionTokens(sessionIDs)
+	if err != nil {
+		return err
+	}
+	keys := []string{indexKey, userSessionsLastUsedKey(userID)}
+	for i, sessionID := range sessionIDs {
+		keys = append(keys, sessionTokensKey(sessionID), sessionKey(sessionID))
+		keys = append(keys, sessionTokens[i]...)
+	}
+	_, err = r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, sessionID := range sessionIDs {
+			pipe.Set(deniedSessionKey(sessionID), "", accessTokenExpiration)
+		}
+		pipe.Del(keys...)
+		return nil
+	})
+	return err
+}
+
+// RemoveOtherSessions removes all tokens of all sessions of the user except the kept one. The removed sessions are
+// added to the denylist.
+func (r *RedisTokenRepository) RemoveOtherSessions(userID uint, keptSessionID string) error {
+	sessionIDs, err := r.redis.ZRange(userSessionsKey(userID), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	var otherSessionIDs []string
+	var members []interface{}
+	for _, sessionID := range sessionIDs {
+		if sessionID != keptSessionID {
+			otherSessionIDs = append(otherSessionIDs, sessionID)
+			members = append(members, sessionID)
+		}
+	}
+	if len(otherSessionIDs) == 0 {
+		return nil
+	}
+	sessionTokens, err := r.findSessionTokens(otherSessionIDs)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for i, sessionID := range otherSessionIDs {
+		keys = append(keys, sessionTokensKey(sessionID), sessionKey(sessionID))
+		keys = append(keys, sessionTokens[i]...)
+	}
+	_, err = r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, sessionID := range otherSessionIDs {
+			pipe.Set(deniedSessionKey(sessionID), "", accessTokenExpiration)
+		}
+		pipe.Del(keys...)
+		pipe.ZRem(userSessionsKey(userID), members...)
+		pipe.ZRem(userSessionsLastUsedKey(userID), members...)
+		return nil
+	})
+	return err
+}
+
+// IsDenied checks whether the session was removed during the lifetime of access tokens
+func (r *RedisTokenRepository) IsDenied(sessionID string) (bool, error) {
+	exists, err := r.redis.Exists(deniedSessionKey(sessionID)).Result()
+	if err != nil {
+		return false, err
+	}
+	return exists > 0, nil
+}
+
+// findSessionTokens returns UUIDs of tokens of every provided session
+func (r *RedisTokenRepository) findSessionTokens(sessionIDs []string) ([][]string, error) {
+	commands := make([]*redis.StringSliceCmd, len(sessionIDs))
+	if _, err := r.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		for i, sessionID := range sessionIDs {
+			commands[i] = pipe.SMembers(sessionTokensKey(sessionID))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sessionTokens := make([][]string, len(sessionIDs))
+	for i, command := range commands {
+		sessionTokens[i] = command.Val()
+	}
+	return sessionTokens, nil
+}
+
+func (r *RedisTokenRepository) removeSessionTokens(sessionID string) error {
+	tokensKey := sessionTokensKey(sessionID)
+	uuids, err := r.redis.SMembers(tokensKey).Result()
+	if err != nil || len(uuids) == 0 {
+		return err
+	}
+	members := make([]interface{}, len(uuids))
+	for i, uuid := range uuids {
+		members[i] = uuid
+	}
+	_, err = r.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(uuids...)
+		pipe.SRem(tokensKey, members...)
+		return nil
+	})
+	return err
+}
+
+func userSessionsKey(userID uint) string {
+	return fmt.Sprintf("%d_sessions", userID)
+}
+
+func userSessionsLastUsedKey(userID uint) string {
+	return fmt.Sprintf("%d_sessions_last_used", userID)
+}
+
+func sessionKey(sessionID string) string {
+	return fmt.Sprintf("%s_session", sessionID)
+}
+
+func sessionTokensKey(sessionID string) string {
+	return fmt.Sprintf("%s_tokens", sessionID)
+}
+
+func deniedSessionKey(sessionID string) string {
+	return fmt.Sprintf("%s_denied", sessionID)
+}
+
+func usedTokenKey(uuid string) string {
+	return fmt.Sprintf("%s_used", uuid)
 }
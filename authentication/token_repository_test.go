@@ -9,40 +9,66 @@ import (
 	"time"
 )
 
+// tokenRepositories create an empty TokenRepository of every implementation, so that all of them pass the same tests
+var tokenRepositories = []struct {
+	name          string
+	newRepository func(t *testing.T) authentication.TokenRepository
+}{
+	{
+		name: "redis",
+		newRepository: func(t *testing.T) authentication.TokenRepository {
+			clearRedis()
+			return authentication.NewRedisTokenRepository(redisClient)
+		},
+	},
+	{
+		name: "memory",
+		newRepository: func(t *testing.T) authentication.TokenRepository {
+			repository := authentication.NewMemoryTokenRepository(time.Minute)
+			t.Cleanup(repository.Stop)
+			return repository
+		},
+	},
+}
+
 func TestNewRedisTokenRepository(t *testing.T) {
 	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
 	require.NotNil(t, tokenRepository)
 }
 
-func TestRedisTokenRepositorySaveRetrieve(t *testing.T) {
-	clearRedis()
-	// given
-	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
-	userContext := authentication.UserContext{
-		UserID:  1111,
-		GroupID: 21,
-	}
-	tokenPair := authentication.TokenPair{
-		AccessToken: authentication.Token{
-			Encoded:   "jshgfhja12",
-			UUID:      "aa-22s",
-			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
-		},
-		RefreshToken: authentication.Token{
-			Encoded:   "aaaazxczcxz",
-			UUID:      "222ds2",
-			ExpiresAt: time.Now().Add(16 * time.Minute).Unix(),
-		},
-	}
+func TestTokenRepositorySaveRetrieve(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			userContext := authentication.UserContext{
+				UserID:    1111,
+				GroupID:   21,
+				SessionID: "session",
+			}
+			tokenPair := authentication.TokenPair{
+				AccessToken: authentication.Token{
+					Encoded:   "jshgfhja12",
+					UUID:      "aa-22s",
+					ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+				},
+				RefreshToken: authentication.Token{
+					Encoded:   "aaaazxczcxz",
+					UUID:      "222ds2",
+					ExpiresAt: time.Now().Add(16 * time.Minute).Unix(),
+				},
+			}
 
-	// when and then
-	require.NoError(t, tokenRepository.Save(tokenPair, userContext))
-	accessContext, err := tokenRepository.Retrieve(tokenPair.AccessToken.UUID)
-	require.NoError(t, err)
-	assert.Equal(t, userContext, accessContext)
-	refreshContext, err := tokenRepository.Retrieve(tokenPair.RefreshToken.UUID)
-	require.NoError(t, err)
-	assert.Equal(t, refreshContext, refreshContext)
+			// when and then
+			require.NoError(t, tokenRepository.Save(tokenPair, userContext))
+			accessContext, err := tokenRepository.Retrieve(tokenPair.AccessToken.UUID)
+			require.NoError(t, err)
+			assert.Equal(t, userContext, accessContext)
+			refreshContext, err := tokenRepository.Retrieve(tokenPair.RefreshToken.UUID)
+			require.NoError(t, err)
+			assert.Equal(t, refreshContext, refreshContext)
+		})
+	}
 }
 
 // Due to the nature of redis methods return type only simple test is present.
@@ -53,8 +79,9 @@ func TestRedisTokenRepositorySaveErrors(t *testing.T) {
 	tokenRepository := authentication.NewRedisTokenRepository(localClient)
 
 	userContext := authentication.UserContext{
-		UserID:  1111,
-		GroupID: 21,
+		UserID:    1111,
+		GroupID:   21,
+		SessionID: "session",
 	}
 	tokenPair := authentication.TokenPair{
 		AccessToken: authentication.Token{
@@ -70,3 +97,411 @@ func TestRedisTokenRepositorySaveErrors(t *testing.T) {
 	}
 	require.Error(t, tokenRepository.Save(tokenPair, userContext))
 }
+
+func TestTokenRepositorySaveWithoutSession(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			tokenRepository := implementation.newRepository(t)
+			tokenPair := newTestTokenPair("access", "refresh")
+			err := tokenRepository.Save(tokenPair, authentication.UserContext{UserID: 1111})
+			assert.Equal(t, authentication.ErrIncorrectValue, err)
+		})
+	}
+}
+
+func TestTokenRepositoryUpdateUserContext(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			firstContext := authentication.UserContext{UserID: 1111, SessionID: "first"}
+			secondContext := authentication.UserContext{UserID: 1111, SessionID: "second"}
+			otherUserContext := authentication.UserContext{UserID: 2222, SessionID: "other"}
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("first-access", "first-refresh"), firstContext))
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("second-access", "second-refresh"), secondContext))
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("other-access", "other-refresh"), otherUserContext))
+
+			// when
+			require.NoError(t, tokenRepository.UpdateUserContext(authentication.UserContext{UserID: 1111, GroupID: 21}))
+
+			// then
+			expected := map[string]authentication.UserContext{
+				"first-access":   {UserID: 1111, GroupID: 21, SessionID: "first"},
+				"first-refresh":  {UserID: 1111, GroupID: 21, SessionID: "first"},
+				"second-access":  {UserID: 1111, GroupID: 21, SessionID: "second"},
+				"second-refresh": {UserID: 1111, GroupID: 21, SessionID: "second"},
+				"other-access":   otherUserContext,
+				"other-refresh":  otherUserContext,
+			}
+			for uuid, expectedContext := range expected {
+				actual, err := tokenRepository.Retrieve(uuid)
+				require.NoError(t, err)
+				assert.Equal(t, expectedContext, actual)
+			}
+		})
+	}
+}
+
+func TestRedisTokenRepositoryUpdateUserContextDoesNotRestoreRemovedTokens(t *testing.T) {
+	clearRedis()
+	// given
+	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
+	userContext := authentication.UserContext{UserID: 1111, SessionID: "session"}
+	require.NoError(t, tokenRepository.Save(newTestTokenPair("access", "refresh"), userContext))
+	require.NoError(t, redisClient.Del("access").Err())
+
+	// when
+	require.NoError(t, tokenRepository.UpdateUserContext(authentication.UserContext{UserID: 1111, GroupID: 21}))
+
+	// then
+	_, err := tokenRepository.Retrieve("access")
+	assert.Equal(t, authentication.ErrTokenNotFound, err)
+	actual, err := tokenRepository.Retrieve("refresh")
+	require.NoError(t, err)
+	assert.Equal(t, authentication.UserContext{UserID: 1111, GroupID: 21, SessionID: "session"}, actual)
+}
+
+func TestTokenRepositoryUpdateUserContextWithoutTokens(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			tokenRepository := implementation.newRepository(t)
+			require.NoError(t, tokenRepository.UpdateUserContext(authentication.UserContext{UserID: 1111, GroupID: 21}))
+		})
+	}
+}
+
+func TestTokenRepositoryConsumeRefreshToken(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			userContext := authentication.UserContext{UserID: 1111, GroupID: 21, SessionID: "session"}
+			tokenPair := newTestTokenPair("access", "refresh")
+			require.NoError(t, tokenRepository.Save(tokenPair, userContext))
+
+			// when
+			consumedContext, err := tokenRepository.ConsumeRefreshToken(tokenPair.RefreshToken, "session")
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, userContext, consumedContext)
+			_, err = tokenRepository.Retrieve("access")
+			assert.Equal(t, authentication.ErrTokenNotFound, err)
+			_, err = tokenRepository.Retrieve("refresh")
+			assert.Equal(t, authentication.ErrTokenNotFound, err)
+		})
+	}
+}
+
+func TestTokenRepositoryConsumeRefreshTokenTwice(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			userContext := authentication.UserContext{UserID: 1111, GroupID: 21, SessionID: "session"}
+			tokenPair := newTestTokenPair("access", "refresh")
+			require.NoError(t, tokenRepository.Save(tokenPair, userContext))
+			_, err := tokenRepository.ConsumeRefreshToken(tokenPair.RefreshToken, "session")
+			require.NoError(t, err)
+
+			// when
+			_, err = tokenRepository.ConsumeRefreshToken(tokenPair.RefreshToken, "session")
+
+			// then
+			assert.Equal(t, authentication.ErrRefreshTokenReused, err)
+		})
+	}
+}
+
+func TestTokenRepositoryConsumeUnknownRefreshToken(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			tokenRepository := implementation.newRepository(t)
+			_, err := tokenRepository.ConsumeRefreshToken(newTestTokenPair("access", "refresh").RefreshToken, "session")
+			assert.Equal(t, authentication.ErrTokenNotFound, err)
+		})
+	}
+}
+
+func TestTokenRepositoryRemoveSession(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			removedContext := authentication.UserContext{UserID: 1111, SessionID: "removed"}
+			keptContext := authentication.UserContext{UserID: 1111, SessionID: "kept"}
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("removed-access", "removed-refresh"), removedContext))
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("kept-access", "kept-refresh"), keptContext))
+
+			// when
+			require.NoError(t, tokenRepository.RemoveSession(1111, "removed"))
+
+			// then
+			for _, uuid := range []string{"removed-access", "removed-refresh"} {
+				_, err := tokenRepository.Retrieve(uuid)
+				assert.Equal(t, authentication.ErrTokenNotFound, err)
+			}
+			for _, uuid := range []string{"kept-access", "kept-refresh"} {
+				actual, err := tokenRepository.Retrieve(uuid)
+				require.NoError(t, err)
+				assert.Equal(t, keptContext, actual)
+			}
+		})
+	}
+}
+
+func TestTokenRepositoryRemoveAllSessions(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			otherUserContext := authentication.UserContext{UserID: 2222, SessionID: "other"}
+			require.NoError(t, tokenRepository.Save(
+				newTestTokenPair("first-access", "first-refresh"),
+				authentication.UserContext{UserID: 1111, SessionID: "first"},
+			))
+			require.NoError(t, tokenRepository.Save(
+				newTestTokenPair("second-access", "second-refresh"),
+				authentication.UserContext{UserID: 1111, SessionID: "second"},
+			))
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("other-access", "other-refresh"), otherUserContext))
+
+			// when
+			require.NoError(t, tokenRepository.RemoveAllSessions(1111))
+
+			// then
+			for _, uuid := range []string{"first-access", "first-refresh", "second-access", "second-refresh"} {
+				_, err := tokenRepository.Retrieve(uuid)
+				assert.Equal(t, authentication.ErrTokenNotFound, err)
+			}
+			actual, err := tokenRepository.Retrieve("other-access")
+			require.NoError(t, err)
+			assert.Equal(t, otherUserContext, actual)
+			require.NoError(t, tokenRepository.RemoveAllSessions(1111))
+		})
+	}
+}
+
+func TestTokenRepositoryRemoveOtherSessions(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			keptUserContext := authentication.UserContext{UserID: 1111, SessionID: "kept"}
+			require.NoError(t, tokenRepository.CreateSession(1111, authentication.Session{ID: "kept"}, time.Hour))
+			require.NoError(t, tokenRepository.CreateSession(1111, authentication.Session{ID: "removed"}, time.Hour))
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("kept-access", "kept-refresh"), keptUserContext))
+			require.NoError(t, tokenRepository.Save(
+				newTestTokenPair("removed-access", "removed-refresh"),
+				authentication.UserContext{UserID: 1111, SessionID: "removed"},
+			))
+
+			// when
+			require.NoError(t, tokenRepository.RemoveOtherSessions(1111, "kept"))
+
+			// then
+			for _, uuid := range []string{"removed-access", "removed-refresh"} {
+				_, err := tokenRepository.Retrieve(uuid)
+				assert.Equal(t, authentication.ErrTokenNotFound, err)
+			}
+			actual, err := tokenRepository.Retrieve("kept-access")
+			require.NoError(t, err)
+			assert.Equal(t, keptUserContext, actual)
+			sessions, err := tokenRepository.FindSessions(1111)
+			require.NoError(t, err)
+			require.Len(t, sessions, 1)
+			assert.Equal(t, "kept", sessions[0].ID)
+			denied, err := tokenRepository.IsDenied("removed")
+			require.NoError(t, err)
+			assert.True(t, denied)
+			denied, err = tokenRepository.IsDenied("kept")
+			require.NoError(t, err)
+			assert.False(t, denied)
+			require.NoError(t, tokenRepository.RemoveOtherSessions(1111, "kept"))
+		})
+	}
+}
+
+func TestTokenRepositoryCreateAndFindSessions(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			now := time.Now().Truncate(time.Second)
+			older := authentication.Session{
+				ID:         "older",
+				CreatedAt:  now.Add(-time.Hour),
+				LastUsedAt: now.Add(-time.Minute),
+				ClientIP:   "10.0.0.1",
+				UserAgent:  "curl/7.74.0",
+			}
+			newer := authentication.Session{ID: "newer", CreatedAt: now, LastUsedAt: now, ClientIP: "10.0.0.2"}
+
+			// when
+			require.NoError(t, tokenRepository.CreateSession(1111, newer, time.Hour))
+			require.NoError(t, tokenRepository.CreateSession(1111, older, time.Hour))
+			require.NoError(t, tokenRepository.CreateSession(2222, authentication.Session{ID: "other"}, time.Hour))
+			sessions, err := tokenRepository.FindSessions(1111)
+
+			// then
+			require.NoError(t, err)
+			require.Len(t, sessions, 2)
+			assert.Equal(t, older.ID, sessions[0].ID)
+			assert.True(t, older.CreatedAt.Equal(sessions[0].CreatedAt))
+			assert.True(t, older.LastUsedAt.Equal(sessions[0].LastUsedAt))
+			assert.Equal(t, older.ClientIP, sessions[0].ClientIP)
+			assert.Equal(t, older.UserAgent, sessions[0].UserAgent)
+			assert.Equal(t, newer.ID, sessions[1].ID)
+		})
+	}
+}
+
+func TestTokenRepositoryRetrieveMarksSessionAsUsed(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			userContext := authentication.UserContext{UserID: 1111, SessionID: "session"}
+			longAgo := time.Now().Add(-time.Hour).Truncate(time.Second)
+			session := authentication.Session{ID: "session", CreatedAt: longAgo, LastUsedAt: longAgo}
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("access", "refresh"), userContext))
+			require.NoError(t, tokenRepository.CreateSession(1111, session, time.Hour))
+
+			// when
+			_, err := tokenRepository.Retrieve("access")
+
+			// then
+			require.NoError(t, err)
+			sessions, err := tokenRepository.FindSessions(1111)
+			require.NoError(t, err)
+			require.Len(t, sessions, 1)
+			assert.True(t, longAgo.Equal(sessions[0].CreatedAt))
+			assert.True(t, sessions[0].LastUsedAt.After(longAgo))
+		})
+	}
+}
+
+func TestTokenRepositoryRemovedSessionIsNotFound(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			userContext := authentication.UserContext{UserID: 1111, SessionID: "session"}
+			require.NoError(t, tokenRepository.Save(newTestTokenPair("access", "refresh"), userContext))
+			require.NoError(t, tokenRepository.CreateSession(1111, authentication.Session{ID: "session"}, time.Hour))
+
+			// when
+			require.NoError(t, tokenRepository.RemoveSession(1111, "session"))
+
+			// then
+			sessions, err := tokenRepository.FindSessions(1111)
+			require.NoError(t, err)
+			assert.Empty(t, sessions)
+			_, err = tokenRepository.Retrieve("access")
+			assert.Equal(t, authentication.ErrTokenNotFound, err)
+			exists, err := redisClient.Exists("session_session").Result()
+			require.NoError(t, err)
+			assert.Zero(t, exists)
+		})
+	}
+}
+
+func TestTokenRepositoryRemovedSessionsAreDenied(t *testing.T) {
+	for _, implementation := range tokenRepositories {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			tokenRepository := implementation.newRepository(t)
+			require.NoError(t, tokenRepository.Save(
+				newTestTokenPair("first-access", "first-refresh"),
+				authentication.UserContext{UserID: 1111, SessionID: "first"},
+			))
+			require.NoError(t, tokenRepository.Save(
+				newTestTokenPair("second-access", "second-refresh"),
+				authentication.UserContext{UserID: 1111, SessionID: "second"},
+			))
+			require.NoError(t, tokenRepository.Save(
+				newTestTokenPair("third-access", "third-refresh"),
+				authentication.UserContext{UserID: 2222, SessionID: "third"},
+			))
+
+			// when
+			require.NoError(t, tokenRepository.RemoveSession(1111, "first"))
+			require.NoError(t, tokenRepository.RemoveAllSessions(1111))
+
+			// then
+			for sessionID, expected := range map[string]bool{"first": true, "second": true, "third": false} {
+				denied, err := tokenRepository.IsDenied(sessionID)
+				require.NoError(t, err)
+				assert.Equal(t, expected, denied, sessionID)
+			}
+		})
+	}
+}
+
+func TestRedisTokenRepositoryUpdateUserContextKeepsExpiration(t *testing.T) {
+	clearRedis()
+	// given
+	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
+	userContext := authentication.UserContext{UserID: 1111, SessionID: "session"}
+	require.NoError(t, tokenRepository.Save(newTestTokenPair("access", "refresh"), userContext))
+
+	// when
+	require.NoError(t, tokenRepository.UpdateUserContext(authentication.UserContext{UserID: 1111, GroupID: 21}))
+
+	// then
+	for _, uuid := range []string{"access", "refresh"} {
+		ttl, err := redisClient.PTTL(uuid).Result()
+		require.NoError(t, err)
+		assert.True(t, ttl > 0)
+	}
+}
+
+func TestRedisTokenRepositoryRemoveSessionRemovesMetadata(t *testing.T) {
+	clearRedis()
+	// given
+	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
+	require.NoError(t, tokenRepository.CreateSession(1111, authentication.Session{ID: "session"}, time.Hour))
+
+	// when
+	require.NoError(t, tokenRepository.RemoveSession(1111, "session"))
+
+	// then
+	exists, err := redisClient.Exists("session_session").Result()
+	require.NoError(t, err)
+	assert.Zero(t, exists)
+}
+
+func TestMemoryTokenRepositoryJanitorKeepsLiveSessions(t *testing.T) {
+	// given
+	tokenRepository := authentication.NewMemoryTokenRepository(10 * time.Millisecond)
+	defer tokenRepository.Stop()
+	userContext := authentication.UserContext{UserID: 1111, SessionID: "session"}
+	expired := authentication.TokenPair{
+		AccessToken:  authentication.Token{UUID: "expired-access", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+		RefreshToken: authentication.Token{UUID: "expired-refresh", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+	}
+	require.NoError(t, tokenRepository.Save(expired, authentication.UserContext{UserID: 2222, SessionID: "expired"}))
+	require.NoError(t, tokenRepository.Save(newTestTokenPair("access", "refresh"), userContext))
+	require.NoError(t, tokenRepository.CreateSession(1111, authentication.Session{ID: "session"}, time.Hour))
+
+	// when
+	time.Sleep(50 * time.Millisecond)
+
+	// then
+	_, err := tokenRepository.Retrieve("expired-access")
+	assert.Equal(t, authentication.ErrTokenNotFound, err)
+	actual, err := tokenRepository.Retrieve("access")
+	require.NoError(t, err)
+	assert.Equal(t, userContext, actual)
+	sessions, err := tokenRepository.FindSessions(1111)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "session", sessions[0].ID)
+}
+
+func newTestTokenPair(accessUUID string, refreshUUID string) authentication.TokenPair {
+	return authentication.TokenPair{
+		AccessToken:  authentication.Token{UUID: accessUUID, ExpiresAt: time.Now().Add(10 * time.Minute).Unix()},
+		RefreshToken: authentication.Token{UUID: refreshUUID, ExpiresAt: time.Now().Add(16 * time.Minute).Unix()},
+	}
+}
@@ -23,7 +23,8 @@ func TestCreateTokenPair(t *testing.T) {
 	creator := authentication.NewTokenCreator(accessAlg, refreshAlg)
 	var userID uint = 123
 	var groupId uint = 1
-	tokenPair, err := creator.CreateTokenPair(userID, groupId)
+	userContext := authentication.UserContext{UserID: userID, GroupID: groupId, SessionID: "s"}
+	tokenPair, err := creator.CreateTokenPair(userContext)
 	require.NoError(t, err)
 	require.NotNil(t, tokenPair)
 	assert.NotZero(t, userID, tokenPair.AccessToken.UUID)
@@ -33,3 +34,51 @@ func TestCreateTokenPair(t *testing.T) {
 	refreshTimeLeft := tokenPair.RefreshToken.ExpiresAt - time.Now().Unix()
 	assert.True(t, refreshTimeLeft > 0)
 }
+
+func TestValidateRefreshToken(t *testing.T) {
+	// given
+	creator := authentication.NewTokenCreator(accessAlg, refreshAlg)
+	userContext := authentication.UserContext{UserID: 123, GroupID: 1, SessionID: "session"}
+	tokenPair, err := creator.CreateTokenPair(userContext)
+	require.NoError(t, err)
+
+	// when
+	token, claimedContext, err := creator.ValidateRefreshToken(tokenPair.RefreshToken.Encoded)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, tokenPair.RefreshToken, token)
+	assert.Equal(t, userContext, claimedContext)
+}
+
+func TestValidateRefreshTokenErrors(t *testing.T) {
+	creator := authentication.NewTokenCreator(accessAlg, refreshAlg)
+	userContext := authentication.UserContext{UserID: 123, GroupID: 1, SessionID: "s"}
+	tokenPair, err := creator.CreateTokenPair(userContext)
+	require.NoError(t, err)
+	otherPair, err := authentication.NewTokenCreator(accessAlg, accessAlg).CreateTokenPair(userContext)
+	require.NoError(t, err)
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "access token",
+			token: tokenPair.AccessToken.Encoded,
+		},
+		{
+			name:  "signed with another key",
+			token: otherPair.RefreshToken.Encoded,
+		},
+		{
+			name:  "malformed",
+			token: "abc.def",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := creator.ValidateRefreshToken(test.token)
+			assert.Equal(t, authentication.ErrInvalidToken, err)
+		})
+	}
+}
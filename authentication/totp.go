@@ -0,0 +1,83 @@
+package authentication
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	totpDigits = 6
+	// totpModulus keeps totpDigits last decimal digits of the truncated HMAC
+	totpModulus = 1000000
+	totpPeriod  = 30 * time.Second
+	// totpSkew is the number of time steps before and after the current one that are accepted, so that a code
+	// is still valid while it is typed and clocks can drift a bit
+	totpSkew = 1
+	// totpSecretSize is the size of HMAC-SHA1 output recommended by RFC 4226
+	totpSecretSize = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTP returns the code of the base32 encoded secret at the given time, as defined by RFC 6238 with
+// default parameters that authenticator apps expect: HMAC-SHA1, 6 digits and 30 seconds period.
+func GenerateTOTP(secret string, at time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, totpStep(at)), nil
+}
+
+// matchTOTP returns the time step of the code if it is valid at the given time
+func matchTOTP(secret string, code string, at time.Time) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	current := totpStep(at)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// isTOTPCode returns true if the value looks like a TOTP code and not like a recovery code
+func isTOTPCode(value string) bool {
+	if len(value) != totpDigits {
+		return false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func totpStep(at time.Time) int64 {
+	return at.Unix() / int64(totpPeriod/time.Second)
+}
+
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
+
+// decodeTOTPSecret accepts secrets the way users may type them - in lower case and with spaces
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.ReplaceAll(secret, " ", "")))
+}
@@ -0,0 +1,48 @@
+package authentication_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"testing"
+	"time"
+)
+
+func TestGenerateTOTP(t *testing.T) {
+	// test vectors of RFC 6238 for SHA1, the secret is "12345678901234567890", last 6 digits are used
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		at       int64
+		expected string
+	}{
+		{at: 59, expected: "287082"},
+		{at: 1111111109, expected: "081804"},
+		{at: 1111111111, expected: "050471"},
+		{at: 1234567890, expected: "005924"},
+		{at: 2000000000, expected: "279037"},
+		{at: 20000000000, expected: "353130"},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			code, err := authentication.GenerateTOTP(secret, time.Unix(test.at, 0))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, code)
+		})
+	}
+}
+
+func TestGenerateTOTPAcceptsTypedSecret(t *testing.T) {
+	at := time.Unix(59, 0)
+	expected, err := authentication.GenerateTOTP("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", at)
+	require.NoError(t, err)
+
+	actual, err := authentication.GenerateTOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", at)
+
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func TestGenerateTOTPRejectsIncorrectSecret(t *testing.T) {
+	_, err := authentication.GenerateTOTP("not base32!", time.Now())
+	assert.Error(t, err)
+}
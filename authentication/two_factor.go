@@ -0,0 +1,295 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgtype/pgxtype"
+	"go-spend/db"
+	"go-spend/expenses"
+	"go-spend/log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	twoFactorPurpose         = "two_factor"
+	twoFactorTokenExpiration = 5 * time.Minute
+	totpIssuer               = "go-spend"
+	recoveryCodesCount       = 10
+	// recoveryCodeSize in bytes gives 32 base32 characters, 160 bits are safe to store as a plain sha256
+	recoveryCodeSize = 20
+	// recoveryCodeGroup is the number of characters between dashes
+	recoveryCodeGroup = 8
+)
+
+var (
+	ErrInvalidMFAToken        = errors.New("mfa token is not valid")
+	ErrTwoFactorCodeIncorrect = errors.New("two-factor code is incorrect")
+)
+
+// TwoFactorEnrollment contains a new TOTP secret. URI is an otpauth:// URI that authenticator apps read from a QR code,
+// Secret is for typing it in manually.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodesResponse contains single-use codes that replace TOTP codes when the authenticator is lost
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// TwoFactorChallenger starts the second step of authentication for users with two-factor authentication
+type TwoFactorChallenger interface {
+	// Challenge returns a short-lived MFA token if the user has enabled two-factor authentication, empty string
+	// otherwise
+	Challenge(ctx context.Context, userID uint) (string, error)
+}
+
+// TwoFactorService manages TOTP two-factor authentication of users
+type TwoFactorService interface {
+	// Enroll creates a new secret for the user from the context, it is not used until it is confirmed
+	Enroll(ctx context.Context, userContext UserContext) (TwoFactorEnrollment, error)
+	// Confirm enables two-factor authentication if the code matches the enrolled secret
+	Confirm(ctx context.Context, userContext UserContext, code string) (RecoveryCodesResponse, error)
+	// Disable turns off two-factor authentication if the password of the user is correct
+	Disable(ctx context.Context, userContext UserContext, password expenses.Password) error
+	// Verify exchanges an MFA token and a code for tokens of a new session
+	Verify(ctx context.Context, request TwoFactorAuthRequest) (TokenResponse, error)
+}
+
+// DefaultTwoFactorService uses TOTP codes of RFC 6238 that work with common authenticator apps. A code can't be used
+// twice, MFA tokens expire in 5 minutes and are single-use, so every wrong code requires the password again. Wrong
+// codes are recorded as failed attempts of the email, so that the password can't be used to keep guessing codes.
+type DefaultTwoFactorService struct {
+	db              db.TxQuerier
+	passwordChecker PasswordChecker
+	repository      expenses.TwoFactorRepository
+	sessionStarter  SessionStarter
+	tokenStore      OneTimeTokenStore
+	userRepository  expenses.UserRepository
+	failedAttempts  FailedAttemptsRecorder
+	now             func() time.Time
+}
+
+// NewDefaultTwoFactorService creates new instance of DefaultTwoFactorService
+func NewDefaultTwoFactorService(
+	db db.TxQuerier,
+	passwordChecker PasswordChecker,
+	repository expenses.TwoFactorRepository,
+	sessionStarter SessionStarter,
+	tokenStore OneTimeTokenStore,
+	userRepository expenses.UserRepository,
+	failedAttempts FailedAttemptsRecorder,
+) *DefaultTwoFactorService {
+	return &DefaultTwoFactorService{
+		db:              db,
+		passwordChecker: passwordChecker,
+		repository:      repository,
+		sessionStarter:  sessionStarter,
+		tokenStore:      tokenStore,
+		userRepository:  userRepository,
+		failedAttempts:  failedAttempts,
+		now:             time.Now,
+	}
+}
+
+// Enroll replaces a previous secret that wasn't confirmed. Returns expenses.ErrTwoFactorAlreadyEnabled if two-factor
+// authentication is already enabled, it has to be disabled first.
+func (s *DefaultTwoFactorService) Enroll(ctx context.Context, userContext UserContext) (TwoFactorEnrollment, error) {
+	user, err := s.userRepository.FindById(ctx, s.db, userContext.UserID)
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	random := make([]byte, totpSecretSize)
+	if _, err = rand.Read(random); err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	secret := totpEncoding.EncodeToString(random)
+	if err = s.repository.SavePending(ctx, s.db, user.ID, secret); err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+	return TwoFactorEnrollment{Secret: secret, URI: totpURI(user.Email, secret)}, nil
+}
+
+// Confirm returns ErrTwoFactorCodeIncorrect if the code doesn't match the enrolled secret and
+// expenses.ErrTwoFactorNotFound if nothing was enrolled. Recovery codes are returned only once, they are stored
+// as hashes.
+func (s *DefaultTwoFactorService) Confirm(
+	ctx context.Context,
+	userContext UserContext,
+	code string,
+) (RecoveryCodesResponse, error) {
+	twoFactor, err := s.repository.Find(ctx, s.db, userContext.UserID)
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+	if twoFactor.Enabled {
+		return RecoveryCodesResponse{}, expenses.ErrTwoFactorAlreadyEnabled
+	}
+	step, ok := matchTOTP(twoFactor.Secret, code, s.now())
+	if !ok {
+		return RecoveryCodesResponse{}, ErrTwoFactorCodeIncorrect
+	}
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+	normalized := make([]string, len(recoveryCodes))
+	for i, recoveryCode := range recoveryCodes {
+		normalized[i] = normalizeRecoveryCode(recoveryCode)
+	}
+	err = db.WithTx(ctx, s.db, func(tx pgxtype.Querier) error {
+		if err := s.repository.Enable(ctx, tx, userContext.UserID, normalized); err != nil {
+			return err
+		}
+		return s.repository.UseStep(ctx, tx, userContext.UserID, step)
+	})
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+	return RecoveryCodesResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// Disable returns ErrCurrentPasswordIncorrect if the password doesn't match and expenses.ErrTwoFactorNotFound if
+// two-factor authentication was neither enabled nor enrolled
+func (s *DefaultTwoFactorService) Disable(
+	ctx context.Context,
+	userContext UserContext,
+	password expenses.Password,
+) error {
+	user, err := s.userRepository.FindById(ctx, s.db, userContext.UserID)
+	if err != nil {
+		return err
+	}
+	if !s.passwordChecker.Check(string(user.Password), string(password)) {
+		return ErrCurrentPasswordIncorrect
+	}
+	return s.repository.Delete(ctx, s.db, user.ID)
+}
+
+// Challenge doesn't require a code from users who have only enrolled but haven't confirmed a secret yet
+func (s *DefaultTwoFactorService) Challenge(ctx context.Context, userID uint) (string, error) {
+	twoFactor, err := s.repository.Find(ctx, s.db, userID)
+	if err == expenses.ErrTwoFactorNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !twoFactor.Enabled {
+		return "", nil
+	}
+	return s.tokenStore.Create(twoFactorPurpose, strconv.FormatUint(uint64(userID), 10), twoFactorTokenExpiration)
+}
+
+// Verify consumes the MFA token even if the code is incorrect. Returns ErrInvalidMFAToken if the token is unknown,
+// expired or was already used and ErrTwoFactorCodeIncorrect if the code is neither a valid TOTP code nor an unused
+// recovery code, the latter is recorded as a failed attempt of the user's email and the IP of the client. The session
+// is started for the client from the context, see WithClientInfo.
+func (s *DefaultTwoFactorService) Verify(ctx context.Context, request TwoFactorAuthRequest) (TokenResponse, error) {
+	value, err := s.tokenStore.Consume(twoFactorPurpose, request.MFAToken)
+	if err == ErrOneTimeTokenNotFound {
+		return TokenResponse{}, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return TokenResponse{}, ErrInvalidMFAToken
+	}
+	twoFactor, err := s.repository.Find(ctx, s.db, uint(userID))
+	if err == expenses.ErrTwoFactorNotFound {
+		return TokenResponse{}, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	if !twoFactor.Enabled {
+		return TokenResponse{}, ErrInvalidMFAToken
+	}
+	user, err := s.userRepository.FindById(ctx, s.db, twoFactor.UserID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	clientInfo := ExtractClientInfo(ctx)
+	if err = s.checkCode(ctx, twoFactor, request.Code); err != nil {
+		if err == ErrTwoFactorCodeIncorrect {
+			s.failedAttempts.Fail(user.Email, clientInfo.IP)
+		}
+		return TokenResponse{}, err
+	}
+	s.failedAttempts.Clear(user.Email)
+	userContext := UserContext{UserID: user.ID, GroupID: user.GroupID}
+	return s.sessionStarter.Start(userContext, clientInfo)
+}
+
+// checkCode accepts a TOTP code of a time step that wasn't used yet or an unused recovery code
+func (s *DefaultTwoFactorService) checkCode(ctx context.Context, twoFactor expenses.TwoFactor, code string) error {
+	if isTOTPCode(code) {
+		step, ok := matchTOTP(twoFactor.Secret, code, s.now())
+		if !ok {
+			return ErrTwoFactorCodeIncorrect
+		}
+		err := s.repository.UseStep(ctx, s.db, twoFactor.UserID, step)
+		if err == expenses.ErrTwoFactorStepUsed {
+			return ErrTwoFactorCodeIncorrect
+		}
+		return err
+	}
+	err := s.repository.UseRecoveryCode(ctx, s.db, twoFactor.UserID, normalizeRecoveryCode(code))
+	if err == expenses.ErrRecoveryCodeNotFound {
+		return ErrTwoFactorCodeIncorrect
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("user %d has used a recovery code", twoFactor.UserID)
+	return nil
+}
+
+// totpURI follows Key Uri Format of Google Authenticator that is supported by most of the apps
+func totpURI(email expenses.Email, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", totpIssuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", strconv.Itoa(totpDigits))
+	query.Set("period", strconv.Itoa(int(totpPeriod/time.Second)))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     fmt.Sprintf("/%s:%s", totpIssuer, email),
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// generateRecoveryCodes returns codes like abcdefgh-ijklmnop-qrstuvwx-yz234567 that are easy to read and type
+func generateRecoveryCodes() ([]string, error) {
+	recoveryCodes := make([]string, recoveryCodesCount)
+	for i := range recoveryCodes {
+		random := make([]byte, recoveryCodeSize)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))
+		groups := make([]string, 0, len(code)/recoveryCodeGroup)
+		for len(code) > 0 {
+			groups = append(groups, code[:recoveryCodeGroup])
+			code = code[recoveryCodeGroup:]
+		}
+		recoveryCodes[i] = strings.Join(groups, "-")
+	}
+	return recoveryCodes, nil
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces, so that the code is accepted however it was typed
+func normalizeRecoveryCode(recoveryCode string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(recoveryCode))
+}
@@ -0,0 +1,570 @@
+package authentication_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/authentication"
+	"go-spend/expenses"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+type mockTwoFactorRepository struct {
+	mock.Mock
+}
+
+func (m *mockTwoFactorRepository) SavePending(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	secret string,
+) error {
+	args := m.Called(ctx, db, userID, secret)
+	return args.Error(0)
+}
+
+func (m *mockTwoFactorRepository) Find(ctx context.Context, db pgxtype.Querier, userID uint) (expenses.TwoFactor, error) {
+	args := m.Called(ctx, db, userID)
+	return args.Get(0).(expenses.TwoFactor), args.Error(1)
+}
+
+func (m *mockTwoFactorRepository) Enable(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	recoveryCodes []string,
+) error {
+	args := m.Called(ctx, db, userID, recoveryCodes)
+	return args.Error(0)
+}
+
+func (m *mockTwoFactorRepository) UseStep(ctx context.Context, db pgxtype.Querier, userID uint, step int64) error {
+	args := m.Called(ctx, db, userID, step)
+	return args.Error(0)
+}
+
+func (m *mockTwoFactorRepository) UseRecoveryCode(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	recoveryCode string,
+) error {
+	args := m.Called(ctx, db, userID, recoveryCode)
+	return args.Error(0)
+}
+
+func (m *mockTwoFactorRepository) Delete(ctx context.Context, db pgxtype.Querier, userID uint) error {
+	args := m.Called(ctx, db, userID)
+	return args.Error(0)
+}
+
+type mockTx struct {
+	mock.Mock
+}
+
+func (m *mockTx) Begin(_ context.Context) (pgx.Tx, error) {
+	panic("implement me")
+}
+
+func (m *mockTx) Commit(ctx context.Context) error {
+	args := m.Called(ctx)
+	return args.Error(0)
+}
+
+func (m *mockTx) Rollback(_ context.Context) error {
+	return nil
+}
+
+func (m *mockTx) CopyFrom(_ context.Context, _ pgx.Identifier, _ []string, _ pgx.CopyFromSource) (int64, error) {
+	panic("implement me")
+}
+
+func (m *mockTx) SendBatch(_ context.Context, _ *pgx.Batch) pgx.BatchResults {
+	panic("implement me")
+}
+
+func (m *mockTx) LargeObjects() pgx.LargeObjects {
+	panic("implement me")
+}
+
+func (m *mockTx) Prepare(_ context.Context, _, _ string) (*pgconn.StatementDescription, error) {
+	panic("implement me")
+}
+
+func (m *mockTx) Exec(_ context.Context, _ string, _ ...interface{}) (commandTag pgconn.CommandTag, err error) {
+	panic("implement me")
+}
+
+func (m *mockTx) Query(_ context.Context, _ string, _ ...interface{}) (pgx.Rows, error) {
+	panic("implement me")
+}
+
+func (m *mockTx) QueryRow(_ context.Context, _ string, _ ...interface{}) pgx.Row {
+	panic("implement me")
+}
+
+func (m *mockTx) QueryFunc(_ context.Context, _ string, _ []interface{}, _ []interface{}, _ func(pgx.QueryFuncRow) error) (pgconn.CommandTag, error) {
+	panic("implement me")
+}
+
+func (m *mockTx) Conn() *pgx.Conn {
+	panic("implement me")
+}
+
+type twoFactorServiceMocks struct {
+	db             *mockQuerier
+	repository     *mockTwoFactorRepository
+	sessionStarter *mockSessionStarter
+	tokenStore     *mockOneTimeTokenStore
+	userRepository *mockUserRepository
+	failedAttempts *mockLoginThrottler
+}
+
+func newTestTwoFactorService() (*authentication.DefaultTwoFactorService, twoFactorServiceMocks) {
+	mocks := twoFactorServiceMocks{
+		db:             new(mockQuerier),
+		repository:     new(mockTwoFactorRepository),
+		sessionStarter: new(mockSessionStarter),
+		tokenStore:     new(mockOneTimeTokenStore),
+		userRepository: new(mockUserRepository),
+		failedAttempts: new(mockLoginThrottler),
+	}
+	service := authentication.NewDefaultTwoFactorService(
+		mocks.db,
+		simplePasswordChecker,
+		mocks.repository,
+		mocks.sessionStarter,
+		mocks.tokenStore,
+		mocks.userRepository,
+		mocks.failedAttempts,
+	)
+	return service, mocks
+}
+
+func currentTestTOTP(t *testing.T) string {
+	code, err := authentication.GenerateTOTP(testTOTPSecret, time.Now())
+	require.NoError(t, err)
+	return code
+}
+
+// incorrectTestTOTP returns a code that is not valid around the current time
+func incorrectTestTOTP(t *testing.T) string {
+	valid := make(map[string]bool)
+	for _, at := range []time.Time{time.Now().Add(-time.Minute), time.Now(), time.Now().Add(time.Minute)} {
+		code, err := authentication.GenerateTOTP(testTOTPSecret, at)
+		require.NoError(t, err)
+		valid[code] = true
+	}
+	for _, code := range []string{"000000", "111111", "222222", "333333"} {
+		if !valid[code] {
+			return code
+		}
+	}
+	panic("all candidates are valid codes")
+}
+
+func TestNewDefaultTwoFactorService(t *testing.T) {
+	service, _ := newTestTwoFactorService()
+	assert.NotNil(t, service)
+}
+
+func TestTwoFactorEnroll(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestTwoFactorService()
+	mocks.userRepository.On("FindById", ctx, mocks.db, uint(42)).
+		Return(expenses.User{ID: 42, Email: "user@mail.com"}, nil)
+	var savedSecret string
+	mocks.repository.On("SavePending", ctx, mocks.db, uint(42), mock.AnythingOfType("string")).
+		Run(func(args mock.Arguments) { savedSecret = args.String(3) }).
+		Return(nil)
+
+	// when
+	enrollment, err := service.Enroll(ctx, authentication.UserContext{UserID: 42})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, savedSecret, enrollment.Secret)
+	assert.Len(t, enrollment.Secret, 32)
+	_, err = authentication.GenerateTOTP(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	uri, err := url.Parse(enrollment.URI)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/go-spend:user@mail.com", uri.Path)
+	assert.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+	assert.Equal(t, "go-spend", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+	assert.Equal(t, "30", uri.Query().Get("period"))
+}
+
+func TestTwoFactorEnrollWhenAlreadyEnabled(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestTwoFactorService()
+	mocks.userRepository.On("FindById", ctx, mocks.db, uint(42)).
+		Return(expenses.User{ID: 42, Email: "user@mail.com"}, nil)
+	mocks.repository.On("SavePending", ctx, mocks.db, uint(42), mock.Anything).
+		Return(expenses.ErrTwoFactorAlreadyEnabled)
+
+	// when
+	enrollment, err := service.Enroll(ctx, authentication.UserContext{UserID: 42})
+
+	// then
+	assert.Equal(t, expenses.ErrTwoFactorAlreadyEnabled, err)
+	assert.Zero(t, enrollment)
+}
+
+func TestTwoFactorConfirm(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestTwoFactorService()
+	tx := new(mockTx)
+	mocks.repository.On("Find", ctx, mocks.db, uint(42)).
+		Return(expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret}, nil)
+	mocks.db.On("Begin", ctx).Return(tx, nil)
+	tx.On("Commit", ctx).Return(nil)
+	var storedCodes []string
+	mocks.repository.On("Enable", ctx, tx, uint(42), mock.Anything).
+		Run(func(args mock.Arguments) { storedCodes = args.Get(3).([]string) }).
+		Return(nil)
+	mocks.repository.On("UseStep", ctx, tx, uint(42), mock.AnythingOfType("int64")).Return(nil)
+
+	// when
+	response, err := service.Confirm(ctx, authentication.UserContext{UserID: 42}, currentTestTOTP(t))
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, response.RecoveryCodes, 10)
+	require.Len(t, storedCodes, 10)
+	codePattern := regexp.MustCompile("^[a-z2-7]{8}-[a-z2-7]{8}-[a-z2-7]{8}-[a-z2-7]{8}$")
+	for i, code := range response.RecoveryCodes {
+		assert.Regexp(t, codePattern, code)
+		assert.Equal(t, strings.ReplaceAll(code, "-", ""), storedCodes[i])
+	}
+	tx.AssertExpectations(t)
+	mocks.repository.AssertExpectations(t)
+}
+
+func TestTwoFactorConfirmErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	tests := []struct {
+		name        string
+		twoFactor   expenses.TwoFactor
+		findErr     error
+		code        string
+		expectedErr error
+	}{
+		{
+			name:        "nothing was enrolled",
+			findErr:     expenses.ErrTwoFactorNotFound,
+			expectedErr: expenses.ErrTwoFactorNotFound,
+		},
+		{
+			name:        "repository fails",
+			findErr:     expectedErr,
+			expectedErr: expectedErr,
+		},
+		{
+			name:        "already enabled",
+			twoFactor:   expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret, Enabled: true},
+			expectedErr: expenses.ErrTwoFactorAlreadyEnabled,
+		},
+		{
+			name:        "incorrect code",
+			twoFactor:   expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret},
+			code:        "abcdef",
+			expectedErr: authentication.ErrTwoFactorCodeIncorrect,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			service, mocks := newTestTwoFactorService()
+			mocks.repository.On("Find", ctx, mocks.db, uint(42)).Return(test.twoFactor, test.findErr)
+			code := test.code
+			if code == "" {
+				code = currentTestTOTP(t)
+			}
+
+			// when
+			response, err := service.Confirm(ctx, authentication.UserContext{UserID: 42}, code)
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+			assert.Zero(t, response)
+			mocks.repository.AssertNotCalled(t, "Enable", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestTwoFactorConfirmRollsBackWhenEnableFails(t *testing.T) {
+	// given
+	ctx := context.Background()
+	service, mocks := newTestTwoFactorService()
+	tx := new(mockTx)
+	expectedErr := errors.New("expected")
+	mocks.repository.On("Find", ctx, mocks.db, uint(42)).
+		Return(expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret}, nil)
+	mocks.db.On("Begin", ctx).Return(tx, nil)
+	mocks.repository.On("Enable", ctx, tx, uint(42), mock.Anything).Return(expectedErr)
+
+	// when
+	response, err := service.Confirm(ctx, authentication.UserContext{UserID: 42}, currentTestTOTP(t))
+
+	// then
+	assert.Equal(t, expectedErr, err)
+	assert.Zero(t, response)
+	tx.AssertNotCalled(t, "Commit", mock.Anything)
+}
+
+func TestTwoFactorDisable(t *testing.T) {
+	tests := []struct {
+		name        string
+		password    expenses.Password
+		deleteErr   error
+		expectedErr error
+	}{
+		{
+			name:     "disabled",
+			password: "password",
+		},
+		{
+			name:        "incorrect password",
+			password:    "another",
+			expectedErr: authentication.ErrCurrentPasswordIncorrect,
+		},
+		{
+			name:        "not enabled",
+			password:    "password",
+			deleteErr:   expenses.ErrTwoFactorNotFound,
+			expectedErr: expenses.ErrTwoFactorNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			service, mocks := newTestTwoFactorService()
+			mocks.userRepository.On("FindById", ctx, mocks.db, uint(42)).
+				Return(expenses.User{ID: 42, Email: "user@mail.com", Password: "password"}, nil)
+			mocks.repository.On("Delete", ctx, mocks.db, uint(42)).Return(test.deleteErr)
+
+			// when
+			err := service.Disable(ctx, authentication.UserContext{UserID: 42}, test.password)
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+			if test.expectedErr == authentication.ErrCurrentPasswordIncorrect {
+				mocks.repository.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestTwoFactorChallenge(t *testing.T) {
+	tests := []struct {
+		name          string
+		twoFactor     expenses.TwoFactor
+		findErr       error
+		expectedToken string
+	}{
+		{
+			name:    "not set up",
+			findErr: expenses.ErrTwoFactorNotFound,
+		},
+		{
+			name:      "not confirmed",
+			twoFactor: expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret},
+		},
+		{
+			name:          "enabled",
+			twoFactor:     expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret, Enabled: true},
+			expectedToken: "mfa-token",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			service, mocks := newTestTwoFactorService()
+			mocks.repository.On("Find", ctx, mocks.db, uint(42)).Return(test.twoFactor, test.findErr)
+			mocks.tokenStore.On("Create", "two_factor", "42", 5*time.Minute).Return("mfa-token", nil)
+
+			// when
+			token, err := service.Challenge(ctx, 42)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedToken, token)
+		})
+	}
+}
+
+func TestTwoFactorVerify(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        func(t *testing.T) string
+		prepareMock func(mocks twoFactorServiceMocks)
+	}{
+		{
+			name: "totp code",
+			code: currentTestTOTP,
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.repository.On("UseStep", mock.Anything, mocks.db, uint(42), mock.AnythingOfType("int64")).
+					Return(nil)
+			},
+		},
+		{
+			name: "recovery code",
+			code: func(t *testing.T) string { return "ABCD-EFGH" },
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.repository.On("UseRecoveryCode", mock.Anything, mocks.db, uint(42), "abcdefgh").Return(nil)
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			client := authentication.ClientInfo{UserAgent: "test", IP: "127.0.0.1"}
+			ctx := authentication.WithClientInfo(context.Background(), client)
+			service, mocks := newTestTwoFactorService()
+			expected := authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}
+			mocks.tokenStore.On("Consume", "two_factor", "mfa-token").Return("42", nil)
+			mocks.repository.On("Find", ctx, mocks.db, uint(42)).
+				Return(expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret, Enabled: true}, nil)
+			test.prepareMock(mocks)
+			mocks.userRepository.On("FindById", ctx, mocks.db, uint(42)).
+				Return(expenses.User{ID: 42, Email: "user@mail.com", GroupID: 7}, nil)
+			mocks.failedAttempts.On("Clear", expenses.Email("user@mail.com")).Return()
+			mocks.sessionStarter.On("Start", authentication.UserContext{UserID: 42, GroupID: 7}, client).
+				Return(expected, nil)
+
+			// when
+			actual, err := service.Verify(ctx, authentication.TwoFactorAuthRequest{
+				MFAToken: "mfa-token",
+				Code:     test.code(t),
+			})
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, expected, actual)
+			mocks.repository.AssertExpectations(t)
+			mocks.failedAttempts.AssertExpectations(t)
+		})
+	}
+}
+
+func TestTwoFactorVerifyErrors(t *testing.T) {
+	expectedErr := errors.New("expected")
+	enabled := expenses.TwoFactor{UserID: 42, Secret: testTOTPSecret, Enabled: true}
+	user := expenses.User{ID: 42, Email: "user@mail.com"}
+	ctx := authentication.WithClientInfo(context.Background(), authentication.ClientInfo{IP: "127.0.0.1"})
+	tests := []struct {
+		name        string
+		code        string
+		prepareMock func(mocks twoFactorServiceMocks)
+		expectedErr error
+	}{
+		{
+			name: "unknown token",
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).
+					Return("", authentication.ErrOneTimeTokenNotFound)
+			},
+			expectedErr: authentication.ErrInvalidMFAToken,
+		},
+		{
+			name: "token store fails",
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("", expectedErr)
+			},
+			expectedErr: expectedErr,
+		},
+		{
+			name: "disabled after the password check",
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("Find", mock.Anything, mock.Anything, uint(42)).
+					Return(expenses.TwoFactor{}, expenses.ErrTwoFactorNotFound)
+			},
+			expectedErr: authentication.ErrInvalidMFAToken,
+		},
+		{
+			name: "incorrect code",
+			code: "incorrect",
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("Find", mock.Anything, mock.Anything, uint(42)).Return(enabled, nil)
+				mocks.userRepository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+				mocks.failedAttempts.On("Fail", expenses.Email("user@mail.com"), "127.0.0.1").Return()
+			},
+			expectedErr: authentication.ErrTwoFactorCodeIncorrect,
+		},
+		{
+			name: "code was already used",
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("Find", mock.Anything, mock.Anything, uint(42)).Return(enabled, nil)
+				mocks.userRepository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+				mocks.failedAttempts.On("Fail", expenses.Email("user@mail.com"), "127.0.0.1").Return()
+				mocks.repository.On("UseStep", mock.Anything, mock.Anything, uint(42), mock.Anything).
+					Return(expenses.ErrTwoFactorStepUsed)
+			},
+			expectedErr: authentication.ErrTwoFactorCodeIncorrect,
+		},
+		{
+			name: "unknown recovery code",
+			code: "abcd-efgh",
+			prepareMock: func(mocks twoFactorServiceMocks) {
+				mocks.tokenStore.On("Consume", mock.Anything, mock.Anything).Return("42", nil)
+				mocks.repository.On("Find", mock.Anything, mock.Anything, uint(42)).Return(enabled, nil)
+				mocks.userRepository.On("FindById", mock.Anything, mock.Anything, uint(42)).Return(user, nil)
+				mocks.failedAttempts.On("Fail", expenses.Email("user@mail.com"), "127.0.0.1").Return()
+				mocks.repository.On("UseRecoveryCode", mock.Anything, mock.Anything, uint(42), "abcdefgh").
+					Return(expenses.ErrRecoveryCodeNotFound)
+			},
+			expectedErr: authentication.ErrTwoFactorCodeIncorrect,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			service, mocks := newTestTwoFactorService()
+			test.prepareMock(mocks)
+			code := test.code
+			switch code {
+			case "":
+				code = currentTestTOTP(t)
+			case "incorrect":
+				code = incorrectTestTOTP(t)
+			}
+
+			// when
+			tokens, err := service.Verify(ctx, authentication.TwoFactorAuthRequest{
+				MFAToken: "mfa-token",
+				Code:     code,
+			})
+
+			// then
+			assert.Equal(t, test.expectedErr, err)
+			assert.Zero(t, tokens)
+			mocks.sessionStarter.AssertNotCalled(t, "Start", mock.Anything, mock.Anything)
+			mocks.failedAttempts.AssertExpectations(t)
+			mocks.failedAttempts.AssertNotCalled(t, "Clear", mock.Anything)
+		})
+	}
+}
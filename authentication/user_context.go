@@ -17,17 +17,23 @@ var (
 type UserContext struct {
 	UserID  uint
 	GroupID uint
+	// SessionID identifies the login session the tokens belong to. Refreshed tokens stay in the same session.
+	SessionID string
 }
 
 // Value converts to a format that can be stored in Redis
 func (u *UserContext) Value() string {
-	return fmt.Sprintf("%d%s%d", u.UserID, userContextSeparator, u.GroupID)
+	value := fmt.Sprintf("%d%s%d", u.UserID, userContextSeparator, u.GroupID)
+	if u.SessionID != "" {
+		value += userContextSeparator + u.SessionID
+	}
+	return value
 }
 
 // ParseUserContext from a string, returns error if could not parse
 func ParseUserContext(value string) (UserContext, error) {
 	split := strings.Split(value, userContextSeparator)
-	if len(split) != 2 {
+	if len(split) != 2 && len(split) != 3 {
 		return UserContext{}, ErrIncorrectValue
 	}
 	userID, err := strconv.ParseUint(split[0], 10, 0)
@@ -38,8 +44,15 @@ func ParseUserContext(value string) (UserContext, error) {
 	if err != nil {
 		return UserContext{}, err
 	}
-	return UserContext{
+	userContext := UserContext{
 		UserID:  uint(userID),
 		GroupID: uint(groupID),
-	}, nil
+	}
+	if len(split) == 3 {
+		if split[2] == "" {
+			return UserContext{}, ErrIncorrectValue
+		}
+		userContext.SessionID = split[2]
+	}
+	return userContext, nil
 }
@@ -92,3 +92,22 @@ func TestUserContextParseErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestUserContextWithSessionValueAndParse(t *testing.T) {
+	// given
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "a0b1-c2d3"}
+
+	// when
+	value := userContext.Value()
+	parsed, err := authentication.ParseUserContext(value)
+
+	// then
+	assert.Equal(t, "1_2_a0b1-c2d3", value)
+	require.NoError(t, err)
+	assert.Equal(t, userContext, parsed)
+}
+
+func TestUserContextParseEmptySession(t *testing.T) {
+	_, err := authentication.ParseUserContext("1_2_")
+	assert.Equal(t, authentication.ErrIncorrectValue, err)
+}
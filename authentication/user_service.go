@@ -14,6 +14,7 @@ type UserService interface {
 type DefaultUserService struct {
 	db              db.TxQuerier
 	passwordEncoder PasswordEncoder
+	passwordPolicy  PasswordPolicy
 	repository      expenses.UserRepository
 }
 
@@ -21,21 +22,37 @@ type DefaultUserService struct {
 func NewDefaultUserService(
 	db db.TxQuerier,
 	passwordEncoder PasswordEncoder,
+	passwordPolicy PasswordPolicy,
 	repository expenses.UserRepository,
 ) *DefaultUserService {
-	return &DefaultUserService{db: db, passwordEncoder: passwordEncoder, repository: repository}
+	return &DefaultUserService{
+		db:              db,
+		passwordEncoder: passwordEncoder,
+		passwordPolicy:  passwordPolicy,
+		repository:      repository,
+	}
 }
 
-// Store a new user in repository. CreateUserRequest is expected to be valid.
+// Store a new user in repository. CreateUserRequest is expected to be valid. If request contains a claim code - the
+// placeholder member with that code becomes the user, keeping its group and expenses. A password that doesn't satisfy
+// the password policy is rejected with PasswordPolicyError.
 func (d *DefaultUserService) Create(ctx context.Context, request expenses.CreateUserRequest) (expenses.UserResponse, error) {
+	if err := d.passwordPolicy.Check(string(request.Password)); err != nil {
+		return expenses.UserResponse{}, err
+	}
 	encodedPassword, err := d.passwordEncoder.Encode(string(request.Password))
 	request.Password = expenses.Password(encodedPassword)
 	if err != nil {
 		return expenses.UserResponse{}, err
 	}
-	createdUser, err := d.repository.Create(ctx, d.db, request)
+	var createdUser expenses.User
+	if request.ClaimCode != "" {
+		createdUser, err = d.repository.ClaimPlaceholder(ctx, d.db, request)
+	} else {
+		createdUser, err = d.repository.Create(ctx, d.db, request)
+	}
 	if err != nil {
 		return expenses.UserResponse{}, err
 	}
-	return expenses.UserResponse{ID: createdUser.ID, Email: createdUser.Email}, nil
+	return expenses.NewUserResponse(createdUser), nil
 }
@@ -19,6 +19,7 @@ func TestNewDefaultUserService(t *testing.T) {
 	service := authentication.NewDefaultUserService(
 		new(mockQuerier),
 		&authentication.NoAcPasswordEncoder{},
+		authentication.PasswordPolicies{},
 		new(mockUserRepository),
 	)
 	assert.NotNil(t, service)
@@ -27,7 +28,7 @@ func TestNewDefaultUserService(t *testing.T) {
 func TestDefaultUserServiceCreate(t *testing.T) {
 	mockRepo := new(mockUserRepository)
 	db := new(mockQuerier)
-	service := authentication.NewDefaultUserService(db, simplePasswordChecker, mockRepo)
+	service := authentication.NewDefaultUserService(db, simplePasswordChecker, authentication.PasswordPolicies{}, mockRepo)
 
 	ctx := context.Background()
 	request := expenses.CreateUserRequest{Email: validEmail, Password: "123"}
@@ -44,7 +45,7 @@ func TestDefaultUserServiceCreate(t *testing.T) {
 func TestDefaultUserServiceCreateError(t *testing.T) {
 	mockRepo := new(mockUserRepository)
 	db := new(mockQuerier)
-	service := authentication.NewDefaultUserService(db, simplePasswordChecker, mockRepo)
+	service := authentication.NewDefaultUserService(db, simplePasswordChecker, authentication.PasswordPolicies{}, mockRepo)
 
 	ctx := context.Background()
 	request := expenses.CreateUserRequest{Email: validEmail, Password: "123"}
@@ -60,7 +61,7 @@ func TestDefaultUserServiceCreateWithBCrypt(t *testing.T) {
 	mockRepo := new(mockUserRepository)
 	db := new(mockQuerier)
 	passwordEncoder := &authentication.BCryptPasswordEncoder{}
-	service := authentication.NewDefaultUserService(db, passwordEncoder, mockRepo)
+	service := authentication.NewDefaultUserService(db, passwordEncoder, authentication.PasswordPolicies{}, mockRepo)
 
 	ctx := context.Background()
 	request := expenses.CreateUserRequest{Email: validEmail, Password: "123"}
@@ -77,3 +78,38 @@ func TestDefaultUserServiceCreateWithBCrypt(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, actual, expected)
 }
+
+func TestDefaultUserServiceCreateClaimsPlaceholder(t *testing.T) {
+	mockRepo := new(mockUserRepository)
+	db := new(mockQuerier)
+	service := authentication.NewDefaultUserService(db, simplePasswordChecker, authentication.PasswordPolicies{}, mockRepo)
+
+	ctx := context.Background()
+	request := expenses.CreateUserRequest{Email: validEmail, Password: "123", ClaimCode: "code"}
+	claimedUser := expenses.User{ID: 5, Email: validEmail, Password: "123", GroupID: 2, DisplayName: "Bob"}
+	mockRepo.On("ClaimPlaceholder", ctx, db, request).Return(claimedUser, nil)
+
+	expected := expenses.UserResponse{ID: claimedUser.ID, Email: claimedUser.Email, DisplayName: "Bob"}
+
+	actual, err := service.Create(ctx, request)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDefaultUserServiceCreateRejectsPasswordByPolicy(t *testing.T) {
+	mockRepo := new(mockUserRepository)
+	service := authentication.NewDefaultUserService(
+		new(mockQuerier),
+		simplePasswordChecker,
+		authentication.NewLengthPasswordPolicy(8),
+		mockRepo,
+	)
+
+	request := expenses.CreateUserRequest{Email: validEmail, Password: "123"}
+
+	actual, err := service.Create(context.Background(), request)
+	assert.Zero(t, actual)
+	assert.Equal(t, authentication.ErrPasswordTooShort, err)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -10,8 +10,11 @@ import (
 	"go-spend/authentication/jwt"
 	"go-spend/expenses"
 	"go-spend/log"
+	"go-spend/mail"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -21,7 +24,14 @@ type Config struct {
 	ServerRequestTimeout time.Duration
 	DB                   DBConfig
 	Redis                RedisConfig
+	Storage              StorageConfig
+	Balance              BalanceConfig
 	Security             SecurityConfig
+	Mail                 MailConfig
+	Password             PasswordConfig
+	OIDC                 OIDCConfig
+	Login                LoginConfig
+	RateLimit            RateLimitConfig
 }
 
 // DBConfig contains information about DB connectivity
@@ -36,17 +46,141 @@ type RedisConfig struct {
 	Password string
 }
 
-// SecurityConfig contains keys for generated tokens
+// StorageConfig selects where sessions, cached balances, rate limits and other short-lived data are kept
+type StorageConfig struct {
+	// Backend is either "redis" or "memory", empty means redis. Memory backend keeps everything in the instance and
+	// doesn't need Redis, so it fits only a single instance, e.g. local development. Everything is lost on restart.
+	Backend string
+	// BalanceCacheSize is how many balances memory backend or the local tier of redis backend keeps
+	BalanceCacheSize uint
+	// LocalBalanceCacheDuration is how long redis backend keeps local copies of balances, zero disables the local tier.
+	// Copies are dropped on all instances when a balance changes.
+	LocalBalanceCacheDuration time.Duration
+}
+
+// BalanceConfig defines how cached balances are kept up to date
+type BalanceConfig struct {
+	// WriteThrough recomputes cached balances of users involved in a new expense instead of removing them
+	WriteThrough bool
+	// EarlyRefreshBeta scales how early before the expiration cached balances may be refreshed, zero disables it
+	EarlyRefreshBeta float64
+}
+
+const (
+	mailSMTP      = "smtp"
+	mailFile      = "file"
+	mailLog       = "log"
+	storageRedis  = "redis"
+	storageMemory = "memory"
+	// janitorInterval of memory backend, how often expired entries are removed
+	janitorInterval = time.Minute
+	// balanceCacheDuration can be configurable of course
+	balanceCacheDuration = 15 * time.Minute
+)
+
+// SecurityConfig contains keys for generated tokens and session restrictions
 type SecurityConfig struct {
-	AccessSecret  string
-	RefreshSecret string
+	AccessSecret string
+	// AccessKeyFile is a PEM encoded private key used to sign access tokens instead of AccessSecret, so that
+	// they can be verified with public keys. RSA, ECDSA P-256 and Ed25519 keys are supported.
+	AccessKeyFile string
+	// AccessKeysDir contains PEM encoded private keys of a key ring, named <key ID>.pem. Keys are promoted in
+	// the order of their IDs. Takes precedence over AccessKeyFile.
+	AccessKeysDir string
+	// AccessKeyRotationPeriod is how long a key of the key ring signs tokens before the next one is activated.
+	// Zero disables rotation.
+	AccessKeyRotationPeriod time.Duration
+	RefreshSecret           string
+	// MaxSessions of a user, the oldest sessions are removed when the limit is exceeded. Zero means no limit.
+	MaxSessions uint
+	// StatelessAuthorization trusts claims of valid access tokens instead of looking them up in Redis. Revoked
+	// sessions are still rejected through a denylist that lives as long as an access token.
+	StatelessAuthorization bool
+	// RequireVerifiedEmailToAuthenticate rejects authentication of users that haven't verified their email
+	RequireVerifiedEmailToAuthenticate bool
+	// RequireVerifiedEmailForGroups doesn't let users that haven't verified their email create or join groups
+	RequireVerifiedEmailForGroups bool
+	// TrustedProxies are comma separated IPs or CIDRs of reverse proxies. Client IP is taken from Forwarded or
+	// X-Forwarded-For headers only if a request came from one of them.
+	TrustedProxies string
+}
+
+// MailConfig defines how emails to users are delivered. Without Backend SMTP server is used if SMTPAddr is set,
+// otherwise emails are appended to File. Writing emails to the log has to be chosen explicitly, as they contain tokens.
+type MailConfig struct {
+	// Backend is smtp, file or log, empty chooses by SMTPAddr and File
+	Backend      string
+	From         string
+	SMTPAddr     string
+	SMTPUsername string
+	SMTPPassword string
+	File         string
+	// ResetPasswordURL is a link in password reset emails, the reset token is added as token query parameter
+	ResetPasswordURL string
+	// VerifyEmailURL is a link in email verification emails, the verification token is added as token query parameter
+	VerifyEmailURL string
+}
+
+// PasswordConfig defines which passwords users may choose and how they are hashed
+type PasswordConfig struct {
+	MinLength           uint
+	MinCharacterClasses uint
+	// BreachedPasswordsFile lists breached passwords, either as SHA-1 hashes or in plain text, one per line.
+	// Empty means no check.
+	BreachedPasswordsFile string
+	// Argon2Memory in KiB, Argon2Iterations and Argon2Parallelism are cost parameters of Argon2id. Passwords hashed
+	// with other parameters or with bcrypt are rehashed when users authenticate.
+	Argon2Memory      uint
+	Argon2Iterations  uint
+	Argon2Parallelism uint
+}
+
+// OIDCConfig enables sign in with an OpenID Connect identity provider, it is disabled if Issuer is empty
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// AutoProvision creates users that sign in for the first time, otherwise only existing users are linked by email
+	AutoProvision bool
+}
+
+// LoginConfig defines how failed authentication attempts are throttled. Attempts are tracked per email and per client
+// IP, IP limits should be higher, as many users might share the same address.
+type LoginConfig struct {
+	// FreeAttempts can fail without any delay, after that the delay starts with BackoffDelay and doubles with every
+	// next failure
+	FreeAttempts   uint
+	IPFreeAttempts uint
+	BackoffDelay   time.Duration
+	// LockoutAfter failures the next attempt is possible only after LockoutDuration
+	LockoutAfter    uint
+	IPLockoutAfter  uint
+	LockoutDuration time.Duration
+}
+
+// RateLimitConfig defines how requests are rate limited
+type RateLimitConfig struct {
+	// IPAllowlist are comma separated IPs or CIDRs of clients that are not limited per IP, e.g. internal services
+	IPAllowlist string
+	// PolicyFile is a JSON file with authentication.RateLimitPolicy, defaultRateLimitPolicy is used if it is empty
+	PolicyFile string
 }
 
 // Application constructs all parts and starts the work of the system
 type Application struct {
 	server *http.Server
 	db     *pgxpool.Pool
-	redis  redis.UniversalClient
+	// closeStorage closes connections or stops janitors of the storage backend
+	closeStorage func()
+	// stopKeyRotation is nil if access tokens are not signed with a key ring
+	stopKeyRotation func()
+}
+
+// accessTokenCodec signs and validates access tokens and publishes keys to verify them
+type accessTokenCodec interface {
+	jwt.Codec
+	jwt.PublicKeyProvider
 }
 
 // NewApplication does all necessary preparations to start the application server
@@ -55,54 +189,212 @@ func NewApplication(config *Config) (*Application, error) {
 	if config.Port < 1 || config.Port > 65535 {
 		return nil, fmt.Errorf("incorrect port value %d, should be between 1 and 65535", config.Port)
 	}
+	accessAlg, keyRing, err := createAccessAlgorithm(config.Security)
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy, err := createPasswordPolicy(config.Password)
+	if err != nil {
+		return nil, err
+	}
+	passwordEncoder, err := createPasswordEncoder(config.Password)
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, err := authentication.ParseCIDRs(splitList(config.Security.TrustedProxies))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse trusted proxies - %w", err)
+	}
+	ipAllowlist, err := authentication.ParseCIDRs(splitList(config.RateLimit.IPAllowlist))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse rate limit IP allowlist - %w", err)
+	}
+	rateLimitPolicy, err := createRateLimitPolicy(config.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	mailer, err := createMailer(config.Mail)
+	if err != nil {
+		return nil, err
+	}
+	if config.Balance.EarlyRefreshBeta < 0 {
+		return nil, errors.New("balance early refresh beta should not be negative")
+	}
+	loginPolicy, ipLoginPolicy, err := createBackoffPolicies(config.Login)
+	if err != nil {
+		return nil, err
+	}
 	db, err := prepareDB(ctx, config)
 	if err != nil {
 		return nil, err
 	}
-	accessAlg := jwt.HmacSha256(config.Security.AccessSecret)
 	refreshAlg := jwt.HmacSha256(config.Security.RefreshSecret)
 	tokenCreator := authentication.NewTokenCreator(accessAlg, refreshAlg)
-	redisClient := redis.NewClient(&redis.Options{Addr: config.Redis.Addr, Password: config.Redis.Password})
-	tokenRepository := authentication.NewRedisTokenRepository(redisClient)
-	passwordEncoder := authentication.NewBCryptPasswordEncoder()
+	storage, err := createStorage(config, loginPolicy, ipLoginPolicy)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	application := &Application{db: db, closeStorage: storage.close}
+	if keyRing != nil {
+		application.stopKeyRotation, err = startKeyRotation(keyRing, storage.keyStatusStorage, config.Security)
+		if err != nil {
+			application.release()
+			return nil, err
+		}
+	}
+	tokenRepository := storage.tokenRepository
 	userRepository := expenses.NewPgUserRepository()
-	authService := authentication.NewAuthService(db, tokenCreator, tokenRepository, passwordEncoder, userRepository)
+	sessionService := authentication.NewDefaultSessionService(
+		tokenCreator,
+		tokenRepository,
+		int(config.Security.MaxSessions),
+	)
+	verificationPolicy := authentication.VerificationPolicy{
+		RequireForAuthentication: config.Security.RequireVerifiedEmailToAuthenticate,
+		RequireForGroups:         config.Security.RequireVerifiedEmailForGroups,
+	}
+	oneTimeTokenStore := storage.oneTimeTokenStore
+	loginThrottler := storage.loginThrottler
+	twoFactorService := authentication.NewDefaultTwoFactorService(
+		db,
+		passwordEncoder,
+		expenses.NewPgTwoFactorRepository(),
+		sessionService,
+		oneTimeTokenStore,
+		userRepository,
+		loginThrottler,
+	)
+	authService := authentication.NewThrottlingAuthenticator(
+		authentication.NewAuthService(
+			db,
+			sessionService,
+			passwordEncoder,
+			userRepository,
+			twoFactorService,
+			verificationPolicy,
+		),
+		loginThrottler,
+	)
 
-	authorizer := authentication.NewJWTAuthorizer(accessAlg, tokenRepository)
-	balanceCache := expenses.NewRedisBalanceCache(redisClient, 15*time.Minute) // this can be configurable of course
+	var authorizer authentication.Authorizer
+	if config.Security.StatelessAuthorization {
+		authorizer = authentication.NewStatelessJWTAuthorizer(accessAlg, tokenRepository)
+	} else {
+		authorizer = authentication.NewJWTAuthorizer(accessAlg, tokenRepository)
+	}
+	accessTokenRepository := expenses.NewPgPersonalAccessTokenRepository()
+	authorizer = authentication.NewPersonalAccessTokenAuthorizer(authorizer, db, accessTokenRepository)
+	accessTokenService := authentication.NewDefaultAccessTokenService(db, accessTokenRepository)
+	balanceCache := storage.balanceCache
 	repository := expenses.NewPgBalanceRepository()
-	balanceService := expenses.NewDefaultBalanceService(db, balanceCache, repository)
+	balanceService := expenses.NewDefaultBalanceService(
+		db,
+		balanceCache,
+		repository,
+		config.Balance.EarlyRefreshBeta,
+	)
 
 	groupRepository := expenses.NewPgGroupRepository()
 	expensesRepository := expenses.NewPgRepository()
-	expensesServices := expenses.NewCacheRemovingService(
-		expenses.NewDefaultService(db, groupRepository, expensesRepository),
-		balanceCache,
+	var expensesServices expenses.Service = expenses.NewDefaultService(db, groupRepository, expensesRepository)
+	if config.Balance.WriteThrough {
+		expensesServices = expenses.NewCacheUpdatingService(expensesServices, balanceService, balanceCache)
+	} else {
+		expensesServices = expenses.NewCacheRemovingService(expensesServices, balanceCache)
+	}
+
+	groupService := authentication.NewContextUpdatingGroupService(
+		authentication.NewVerificationCheckingGroupService(
+			expenses.NewCacheRemovingGroupService(
+				expenses.NewDefaultGroupService(db, userRepository, groupRepository),
+				balanceCache,
+			),
+			db,
+			userRepository,
+			verificationPolicy,
+		),
+		tokenRepository,
 	)
 
-	groupService := expenses.NewDefaultGroupService(db, userRepository, groupRepository)
+	requestLimiter := authentication.NewPolicyRequestLimiter(ipAllowlist, rateLimitPolicy, storage.newRateLimiter)
 
-	// surely this can also be extracted into configuration
-	limiter := createRateLimiter(redisClient)
-	requestLimiter := authentication.NewContextBasedRequestLimiter(limiter)
+	passwordService := authentication.NewDefaultPasswordService(
+		db,
+		loginThrottler,
+		mailer,
+		passwordEncoder,
+		passwordPolicy,
+		userRepository,
+		tokenRepository,
+		oneTimeTokenStore,
+		config.Mail.ResetPasswordURL,
+	)
+	emailVerifier := authentication.NewDefaultEmailVerifier(
+		db,
+		mailer,
+		userRepository,
+		oneTimeTokenStore,
+		config.Mail.VerifyEmailURL,
+	)
 
-	userService := authentication.NewDefaultUserService(db, &authentication.BCryptPasswordEncoder{}, userRepository)
+	externalAuthenticator, err := createExternalAuthenticator(
+		ctx,
+		config.OIDC,
+		db,
+		passwordEncoder,
+		sessionService,
+		oneTimeTokenStore,
+		twoFactorService,
+		userRepository,
+	)
+	if err != nil {
+		application.release()
+		return nil, err
+	}
 
-	router := NewRouterWithRateLimit(
+	userService := authentication.NewVerifyingUserService(
+		authentication.NewDefaultUserService(db, passwordEncoder, passwordPolicy, userRepository),
+		emailVerifier,
+		verificationPolicy,
+	)
+
+	profileRepository := expenses.NewPgProfileRepository()
+	accountService := authentication.NewDefaultAccountService(
+		db,
+		expenses.NewPgAccountRepository(),
+		repository,
+		passwordEncoder,
+		profileRepository,
+		tokenRepository,
+		tokenRepository,
+		userRepository,
+	)
+
+	router := NewRouter(
+		accessTokenService,
+		accountService,
 		authService,
 		authorizer,
 		balanceService,
+		emailVerifier,
 		expensesServices,
+		externalAuthenticator,
 		groupService,
 		requestLimiter,
+		passwordService,
+		expenses.NewDefaultProfileService(db, profileRepository),
+		accessAlg,
+		sessionService,
+		twoFactorService,
 		userService,
 	)
-	server := &http.Server{
+	application.server = &http.Server{
 		Addr:        fmt.Sprintf(":%d", config.Port),
-		Handler:     router,
+		Handler:     authentication.NewClientIPResolver(trustedProxies).Handler(router),
 		ReadTimeout: config.ServerRequestTimeout,
 	}
-	return &Application{server: server, db: db, redis: redisClient}, nil
+	return application, nil
 }
 
 // Start a server and block until finished
@@ -114,32 +406,308 @@ func (a *Application) Start() error {
 // Stop the server and close connections
 func (a *Application) Stop() error {
 	log.Info("Stopping the server...")
-	defer a.db.Close()
-	defer a.redis.Close()
+	defer a.release()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	return a.server.Shutdown(ctx)
 }
 
-func createRateLimiter(redisClient *redis.Client) *authentication.RedisRateLimiter {
-	limiter := authentication.NewRedisRateLimiter([]authentication.Limit{
-		{
-			Suffix:   "s",
-			Duration: time.Second,
-			Amount:   5,
-		},
-		{
-			Suffix:   "m",
-			Duration: time.Minute,
-			Amount:   1000,
+// release stops key rotation and closes storage and DB connections
+func (a *Application) release() {
+	if a.stopKeyRotation != nil {
+		a.stopKeyRotation()
+	}
+	a.closeStorage()
+	a.db.Close()
+}
+
+// createAccessAlgorithm returns KeyRing as well if the keys are rotated
+func createAccessAlgorithm(config SecurityConfig) (accessTokenCodec, *jwt.KeyRing, error) {
+	if config.AccessKeysDir != "" {
+		keyRing, err := jwt.LoadKeyRing(config.AccessKeysDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't load access token keys - %w", err)
+		}
+		return keyRing, keyRing, nil
+	}
+	if config.AccessKeyFile == "" {
+		return jwt.HmacSha256(config.AccessSecret), nil, nil
+	}
+	algorithm, err := jwt.LoadPrivateKeyPEM(config.AccessKeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't load access token key - %w", err)
+	}
+	return algorithm, nil, nil
+}
+
+// startKeyRotation syncs the key ring with other instances before the start, so that the right key is active
+func startKeyRotation(
+	keyRing *jwt.KeyRing,
+	storage authentication.KeyStatusStorage,
+	config SecurityConfig,
+) (func(), error) {
+	rotator := authentication.NewKeyRotator(keyRing, storage, config.AccessKeyRotationPeriod)
+	if err := rotator.Sync(time.Now()); err != nil {
+		return nil, fmt.Errorf("couldn't sync access token keys - %w", err)
+	}
+	return rotator.Start(time.Minute), nil
+}
+
+func createPasswordPolicy(config PasswordConfig) (authentication.PasswordPolicy, error) {
+	policies := authentication.PasswordPolicies{
+		authentication.NewLengthPasswordPolicy(int(config.MinLength)),
+		authentication.NewCharacterClassPasswordPolicy(int(config.MinCharacterClasses)),
+	}
+	if config.BreachedPasswordsFile != "" {
+		index, err := authentication.LoadBreachedPasswordIndex(config.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, authentication.NewBreachedPasswordPolicy(index))
+	}
+	return policies, nil
+}
+
+// createPasswordEncoder hashes new passwords with Argon2id and still accepts bcrypt hashes of older accounts
+func createPasswordEncoder(config PasswordConfig) (authentication.RehashingPasswordEncoder, error) {
+	if config.Argon2Parallelism > math.MaxUint8 {
+		return nil, authentication.ErrIncorrectArgon2idParams
+	}
+	params := authentication.DefaultArgon2idParams
+	params.Memory = uint32(config.Argon2Memory)
+	params.Iterations = uint32(config.Argon2Iterations)
+	params.Parallelism = uint8(config.Argon2Parallelism)
+	argon2id, err := authentication.NewArgon2idPasswordEncoder(params)
+	if err != nil {
+		return nil, err
+	}
+	return authentication.NewMigratingPasswordEncoder(argon2id, authentication.NewBCryptPasswordEncoder()), nil
+}
+
+// createExternalAuthenticator returns nil if sign in with an identity provider is not configured. Configuration of
+// the provider is discovered at the start, so the provider has to be available.
+func createExternalAuthenticator(
+	ctx context.Context,
+	config OIDCConfig,
+	db *pgxpool.Pool,
+	passwordEncoder authentication.PasswordEncoder,
+	sessionStarter authentication.SessionStarter,
+	tokenStore authentication.OneTimeTokenStore,
+	twoFactor authentication.TwoFactorChallenger,
+	userRepository expenses.UserRepository,
+) (authentication.ExternalAuthenticator, error) {
+	if config.Issuer == "" {
+		return nil, nil
+	}
+	if config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc client ID and redirect URL are required")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	provider, err := authentication.DiscoverOIDCProvider(ctx, client, config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return authentication.NewOIDCAuthenticator(
+		client,
+		authentication.OIDCConfig{
+			ClientID:      config.ClientID,
+			ClientSecret:  config.ClientSecret,
+			RedirectURL:   config.RedirectURL,
+			AutoProvision: config.AutoProvision,
 		},
-		{
-			Suffix:   "h",
-			Duration: time.Hour,
-			Amount:   10000,
+		db,
+		passwordEncoder,
+		provider,
+		sessionStarter,
+		tokenStore,
+		twoFactor,
+		userRepository,
+	), nil
+}
+
+// createMailer fails if there is nowhere to deliver emails to, the log is used only if it was chosen
+func createMailer(config MailConfig) (mail.Mailer, error) {
+	backend := config.Backend
+	if backend == "" && config.SMTPAddr != "" {
+		backend = mailSMTP
+	} else if backend == "" && config.File != "" {
+		backend = mailFile
+	}
+	switch backend {
+	case mailSMTP:
+		if config.SMTPAddr == "" {
+			return nil, errors.New("smtp address is required by smtp mail backend")
+		}
+		return mail.NewSMTPMailer(config.SMTPAddr, config.From, config.SMTPUsername, config.SMTPPassword), nil
+	case mailFile:
+		if config.File == "" {
+			return nil, errors.New("mail file is required by file mail backend")
+		}
+		return mail.NewFileMailer(config.File, config.From), nil
+	case mailLog:
+		log.Warn("emails are written to the log together with reset and verification tokens, use only for development")
+		return mail.NewLogMailer(), nil
+	case "":
+		return nil, errors.New("emails can't be delivered, set smtp address, mail file or log mail backend")
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q, should be smtp, file or log", config.Backend)
+	}
+}
+
+// createBackoffPolicies returns policies for emails and for client IPs
+func createBackoffPolicies(config LoginConfig) (authentication.BackoffPolicy, authentication.BackoffPolicy, error) {
+	if config.LockoutAfter <= config.FreeAttempts || config.IPLockoutAfter <= config.IPFreeAttempts {
+		err := errors.New("login lockout should start after more failures than free attempts")
+		return authentication.BackoffPolicy{}, authentication.BackoffPolicy{}, err
+	}
+	emailPolicy := authentication.BackoffPolicy{
+		FreeAttempts:    config.FreeAttempts,
+		Delay:           config.BackoffDelay,
+		LockoutAfter:    config.LockoutAfter,
+		LockoutDuration: config.LockoutDuration,
+	}
+	ipPolicy := emailPolicy
+	ipPolicy.FreeAttempts = config.IPFreeAttempts
+	ipPolicy.LockoutAfter = config.IPLockoutAfter
+	return emailPolicy, ipPolicy, nil
+}
+
+// storage is everything kept by the storage backend
+type storage struct {
+	tokenRepository   authentication.TokenRepository
+	balanceCache      expenses.BalanceCache
+	oneTimeTokenStore authentication.OneTimeTokenStore
+	loginThrottler    authentication.LoginThrottler
+	keyStatusStorage  authentication.KeyStatusStorage
+	newRateLimiter    func(limits []authentication.Limit) authentication.RateLimiter
+	close             func()
+}
+
+func createStorage(config *Config, loginPolicy, ipLoginPolicy authentication.BackoffPolicy) (*storage, error) {
+	switch config.Storage.Backend {
+	case "", storageRedis:
+		redisClient := redis.NewClient(&redis.Options{Addr: config.Redis.Addr, Password: config.Redis.Password})
+		balanceCache, stopBalanceCache, err := createRedisBalanceCache(config.Storage, redisClient)
+		if err != nil {
+			_ = redisClient.Close()
+			return nil, err
+		}
+		return &storage{
+			tokenRepository:   authentication.NewRedisTokenRepository(redisClient),
+			balanceCache:      balanceCache,
+			oneTimeTokenStore: authentication.NewRedisOneTimeTokenStore(redisClient),
+			loginThrottler:    authentication.NewRedisLoginThrottler(loginPolicy, ipLoginPolicy, redisClient),
+			keyStatusStorage:  authentication.NewRedisKeyStatusStorage(redisClient),
+			newRateLimiter: func(limits []authentication.Limit) authentication.RateLimiter {
+				return authentication.NewRedisRateLimiter(limits, redisClient)
+			},
+			close: func() {
+				stopBalanceCache()
+				if err := redisClient.Close(); err != nil {
+					log.Warn("couldn't close redis client - %s", err)
+				}
+			},
+		}, nil
+	case storageMemory:
+		if config.Storage.BalanceCacheSize == 0 {
+			return nil, errors.New("balance cache size of memory storage should be positive")
+		}
+		tokenRepository := authentication.NewMemoryTokenRepository(janitorInterval)
+		balanceCache := expenses.NewMemoryBalanceCache(
+			int(config.Storage.BalanceCacheSize),
+			balanceCacheDuration,
+			janitorInterval,
+		)
+		oneTimeTokenStore := authentication.NewMemoryOneTimeTokenStore(janitorInterval)
+		loginThrottler := authentication.NewMemoryLoginThrottler(loginPolicy, ipLoginPolicy, janitorInterval)
+		stops := []func(){tokenRepository.Stop, balanceCache.Stop, oneTimeTokenStore.Stop, loginThrottler.Stop}
+		return &storage{
+			tokenRepository:   tokenRepository,
+			balanceCache:      balanceCache,
+			oneTimeTokenStore: oneTimeTokenStore,
+			loginThrottler:    loginThrottler,
+			keyStatusStorage:  authentication.NewMemoryKeyStatusStorage(),
+			newRateLimiter: func(limits []authentication.Limit) authentication.RateLimiter {
+				rateLimiter := authentication.NewMemoryRateLimiter(limits, janitorInterval)
+				stops = append(stops, rateLimiter.Stop)
+				return rateLimiter
+			},
+			close: func() {
+				for _, stop := range stops {
+					stop()
+				}
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q, should be redis or memory", config.Storage.Backend)
+	}
+}
+
+// createRedisBalanceCache returns the cache with a local tier if it is enabled and a func to stop it
+func createRedisBalanceCache(
+	config StorageConfig,
+	redisClient *redis.Client,
+) (expenses.BalanceCache, func(), error) {
+	remote := expenses.NewRedisBalanceCache(redisClient, balanceCacheDuration)
+	if config.LocalBalanceCacheDuration == 0 {
+		return remote, func() {}, nil
+	}
+	if config.BalanceCacheSize == 0 {
+		return nil, nil, errors.New("balance cache size of the local tier should be positive")
+	}
+	cache := expenses.NewTieredBalanceCache(
+		remote,
+		int(config.BalanceCacheSize),
+		config.LocalBalanceCacheDuration,
+		janitorInterval,
+	)
+	return cache, cache.Stop, nil
+}
+
+// anonymousLimits are limits per client IP of requests without a user, like sign up, authentication or requests
+// before authorization. They are higher than the per user ones, as many clients can share the same IP.
+var anonymousLimits = []authentication.Limit{
+	{Suffix: "s", Duration: time.Second, Amount: 10},
+	{Suffix: "m", Duration: time.Minute, Amount: 300},
+	{Suffix: "h", Duration: time.Hour, Amount: 3000},
+}
+
+// defaultRateLimitPolicy limits balance requests per user and requests without a user per client IP
+var defaultRateLimitPolicy = authentication.RateLimitPolicy{
+	{
+		Route: "/balance",
+		Limits: []authentication.Limit{
+			{Suffix: "s", Duration: time.Second, Amount: 5},
+			{Suffix: "m", Duration: time.Minute, Amount: 1000},
+			{Suffix: "h", Duration: time.Hour, Amount: 10000},
 		},
-	}, redisClient)
-	return limiter
+		Roles: map[authentication.Role][]authentication.Limit{authentication.RoleAnonymous: anonymousLimits},
+	},
+	{Route: "/health"},
+	{Route: "/.well-known/"},
+	{Route: "/", Roles: map[authentication.Role][]authentication.Limit{authentication.RoleAnonymous: anonymousLimits}},
+}
+
+func createRateLimitPolicy(config RateLimitConfig) (authentication.RateLimitPolicy, error) {
+	if config.PolicyFile == "" {
+		return defaultRateLimitPolicy, nil
+	}
+	policy, err := authentication.LoadRateLimitPolicy(config.PolicyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load rate limit policy - %w", err)
+	}
+	return policy, nil
+}
+
+// splitList splits a comma separated list, empty values are skipped
+func splitList(list string) []string {
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
 
 func prepareDB(ctx context.Context, config *Config) (*pgxpool.Pool, error) {
@@ -152,10 +720,12 @@ func prepareDB(ctx context.Context, config *Config) (*pgxpool.Pool, error) {
 	}
 	schema, err := ioutil.ReadFile(config.DB.SchemaLocation)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	_, err = db.Exec(ctx, string(schema))
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
@@ -9,8 +9,11 @@ import (
 	"go-spend/authentication"
 	"go-spend/cmd/go-spend"
 	"go-spend/expenses"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -31,10 +34,30 @@ var defaultConfig = main.Config{
 		Addr:     createRedisContainer(context.Background()),
 		Password: redisPassword,
 	},
+	Storage: main.StorageConfig{
+		BalanceCacheSize:          100,
+		LocalBalanceCacheDuration: 5 * time.Second,
+	},
 	Security: main.SecurityConfig{
 		AccessSecret:  "1234321",
 		RefreshSecret: "zzzzz",
 	},
+	Mail: main.MailConfig{
+		Backend: "log",
+	},
+	Password: main.PasswordConfig{
+		Argon2Memory:      1024,
+		Argon2Iterations:  1,
+		Argon2Parallelism: 1,
+	},
+	Login: main.LoginConfig{
+		FreeAttempts:    2,
+		IPFreeAttempts:  100,
+		BackoffDelay:    time.Minute,
+		LockoutAfter:    3,
+		IPLockoutAfter:  1000,
+		LockoutDuration: time.Hour,
+	},
 }
 
 func TestNewApplicationFull(t *testing.T) {
@@ -63,8 +86,476 @@ func TestNewApplicationFull(t *testing.T) {
 	default:
 	}
 
-	//create 4 users, 2 groups
-	checkApplication(t, serverAddr)
+	//create 4 users, 2 groups
+	checkApplication(t, serverAddr)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationMemoryStorage(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+	config.Redis = main.RedisConfig{Addr: "localhost:1"}
+	config.Storage = main.StorageConfig{Backend: "memory", BalanceCacheSize: 100}
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	//create 4 users, 2 groups without redis
+	checkApplication(t, serverAddr)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationBalanceWriteThrough(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+	config.Balance = main.BalanceConfig{WriteThrough: true, EarlyRefreshBeta: 1}
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	//create 4 users, 2 groups, balances are checked before and after expenses are added
+	checkApplication(t, serverAddr)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationRateLimit(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	//create 4 users, 2 groups
+	user1 := createUser(t, serverAddr, "1")
+	user1.authenticate(t)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusTooManyRequests)
+	time.Sleep(time.Second)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationRateLimitPolicyFile(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+	config.RateLimit.PolicyFile = filepath.Join(t.TempDir(), "policy.json")
+	policy := `[{"route": "/balance", "methods": ["GET"], "limits": [{"period": "1m", "amount": 2}]}]`
+	require.NoError(t, ioutil.WriteFile(config.RateLimit.PolicyFile, []byte(policy), 0600))
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	go func() {
+		_ = application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	user := createUser(t, serverAddr, "1")
+	user.authenticate(t)
+	user.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user.requestBalanceWithExpectedCode(t, http.StatusTooManyRequests)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationPasswordReset(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+	config.Mail.Backend = "file"
+	config.Mail.File = filepath.Join(t.TempDir(), "mails.txt")
+	config.Mail.ResetPasswordURL = "http://localhost/password/reset"
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	user1 := createUser(t, serverAddr, "1")
+	user1.authenticate(t)
+	user1.forgotPassword(t)
+	mails, err := ioutil.ReadFile(config.Mail.File)
+	require.NoError(t, err)
+	token := regexp.MustCompile(`reset\?token=([\w-]+)`).FindStringSubmatch(string(mails))
+	require.Len(t, token, 2)
+	user1.resetPassword(t, token[1], "new-password", http.StatusNoContent)
+	user1.requestBalanceWithExpectedCode(t, http.StatusForbidden)
+	user1.resetPassword(t, token[1], "other-password", http.StatusBadRequest)
+	user1.Password = "new-password"
+	user1.authenticate(t)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	otherSession := user1
+	otherSession.authenticate(t)
+	user1.changePassword(t, "changed-password", http.StatusNoContent)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	otherSession.requestBalanceWithExpectedCode(t, http.StatusForbidden)
+	wrongPassword := user1
+	wrongPassword.Password = "wrong-password"
+	wrongPassword.changePassword(t, "another-password", http.StatusForbidden)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationLoginLockout(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+	config.Mail.Backend = "file"
+	config.Mail.File = filepath.Join(t.TempDir(), "mails.txt")
+	config.Mail.ResetPasswordURL = "http://localhost/password/reset"
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	user1 := createUser(t, serverAddr, "1")
+	wrongPassword := user1
+	wrongPassword.Password = "wrong-password"
+	wrongPassword.authenticateWithExpectedCode(t, http.StatusUnauthorized)
+	wrongPassword.authenticateWithExpectedCode(t, http.StatusUnauthorized)
+	wrongPassword.authenticateWithExpectedCode(t, http.StatusUnauthorized)
+	user1.authenticateWithExpectedCode(t, http.StatusTooManyRequests)
+	user2 := createUser(t, serverAddr, "2")
+	user2.authenticate(t)
+	user1.forgotPassword(t)
+	mails, err := ioutil.ReadFile(config.Mail.File)
+	require.NoError(t, err)
+	token := regexp.MustCompile(`reset\?token=([\w-]+)`).FindStringSubmatch(string(mails))
+	require.Len(t, token, 2)
+	user1.resetPassword(t, token[1], "new-password", http.StatusNoContent)
+	user1.Password = "new-password"
+	user1.authenticate(t)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationProfile(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	user1 := createUser(t, serverAddr, "1")
+	user1.authenticate(t)
+	profile := user1.updateProfile(t, `{"displayName":"Alice","locale":"en-GB","currency":"eur"}`)
+	assert.Equal(t, "Alice", profile.DisplayName)
+	assert.Equal(t, "EUR", profile.Currency)
+	profile = user1.updateProfile(t, `{"locale":""}`)
+	assert.Equal(t, "Alice", profile.DisplayName)
+	assert.Empty(t, profile.Locale)
+	assert.Equal(t, profile, user1.getProfile(t))
+	request, err := http.NewRequest(http.MethodPost, serverAddr+"/groups", strings.NewReader(`{"name":"group"}`))
+	require.NoError(t, err)
+	user1.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	var group expenses.GroupResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&group))
+	require.Len(t, group.Users, 1)
+	assert.Equal(t, "Alice", group.Users[0].DisplayName)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationExportAndDeleteAccount(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	user1 := createUser(t, serverAddr, "1")
+	user2 := createUser(t, serverAddr, "2")
+	user1.authenticate(t)
+	user2.authenticate(t)
+	groupID := user1.createGroup(t, "group")
+	user1.addUserToGroup(t, user2.ID, groupID)
+	user1.payForExpense(t, fmt.Sprintf(`{"amount": 10, "shares": {"%d": 50, "%d": 50}}`, user1.ID, user2.ID))
+
+	export := user1.exportAccount(t)
+	assert.Equal(t, expenses.Email(user1.Email), export.Profile.Email)
+	require.Len(t, export.Expenses, 1)
+	assert.Empty(t, export.Shares)
+	assert.Equal(t, expenses.Balance{user2.ID: 5}, export.Settlements)
+
+	user1.deleteAccount(t, "incorrect-password", http.StatusForbidden)
+	user1.deleteAccount(t, user1.Password, http.StatusNoContent)
+	user1.requestBalanceWithExpectedCode(t, http.StatusForbidden)
+	user1.authenticateWithExpectedCode(t, http.StatusUnauthorized)
+	assert.Equal(t, expenses.Balance{user1.ID: -5}, user2.requestBalance(t))
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationEmailVerification(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+	config.Mail.Backend = "file"
+	config.Mail.File = filepath.Join(t.TempDir(), "mails.txt")
+	config.Mail.VerifyEmailURL = "http://localhost/users/verify"
+	config.Security.RequireVerifiedEmailToAuthenticate = true
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	user1 := createUser(t, serverAddr, "1")
+	user1.authenticateWithExpectedCode(t, http.StatusForbidden)
+	mails, err := ioutil.ReadFile(config.Mail.File)
+	require.NoError(t, err)
+	token := regexp.MustCompile(`verify\?token=([\w-]+)`).FindStringSubmatch(string(mails))
+	require.Len(t, token, 2)
+	user1.verifyEmail(t, token[1], http.StatusOK)
+	user1.verifyEmail(t, token[1], http.StatusBadRequest)
+	user1.authenticate(t)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+
+	err = application.Stop()
+	if err != nil && err != http.ErrServerClosed {
+		t.Error(err)
+	}
+}
+
+func TestNewApplicationTwoFactor(t *testing.T) {
+	defer cleanUpDB(t, context.Background())
+	defer cleanupRedis(t)
+	port, err := getFreePort()
+	require.NoError(t, err)
+	config := defaultConfig
+	config.Port = uint(port)
+
+	application, err := main.NewApplication(&config)
+	require.NoError(t, err)
+	assert.NotNil(t, application)
+
+	errC := make(chan error)
+	go func() {
+		errC <- application.Start()
+	}()
+	serverAddr := fmt.Sprintf("http://localhost:%d", port)
+	healthCheck(t, serverAddr, 3*time.Second)
+
+	//Check if there was an error when starting
+	select {
+	case err = <-errC:
+		t.Error(err)
+	default:
+	}
+
+	user1 := createUser(t, serverAddr, "1")
+	user1.authenticate(t)
+	enrollment := user1.enrollTwoFactor(t)
+	code, err := authentication.GenerateTOTP(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	recoveryCodes := user1.confirmTwoFactor(t, code)
+	require.NotEmpty(t, recoveryCodes)
+
+	user1.authenticate(t)
+	assert.Empty(t, user1.AccessToken)
+	require.NotEmpty(t, user1.MFAToken)
+	user1.verifyTwoFactor(t, recoveryCodes[0], http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.authenticate(t)
+	user1.verifyTwoFactor(t, recoveryCodes[0], http.StatusUnauthorized)
+	// the code of the confirmation can't be used again, the next one is accepted
+	user1.authenticate(t)
+	code, err = authentication.GenerateTOTP(enrollment.Secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	user1.verifyTwoFactor(t, code, http.StatusOK)
+	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+
+	user1.disableTwoFactor(t, http.StatusNoContent)
+	user1.authenticate(t)
+	assert.NotEmpty(t, user1.AccessToken)
+	assert.Empty(t, user1.MFAToken)
 
 	err = application.Stop()
 	if err != nil && err != http.ErrServerClosed {
@@ -72,7 +563,7 @@ func TestNewApplicationFull(t *testing.T) {
 	}
 }
 
-func TestNewApplicationRateLimit(t *testing.T) {
+func TestNewApplicationPersonalAccessTokens(t *testing.T) {
 	defer cleanUpDB(t, context.Background())
 	defer cleanupRedis(t)
 	port, err := getFreePort()
@@ -98,17 +589,30 @@ func TestNewApplicationRateLimit(t *testing.T) {
 	default:
 	}
 
-	//create 4 users, 2 groups
 	user1 := createUser(t, serverAddr, "1")
 	user1.authenticate(t)
-	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
-	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
-	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
-	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
-	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
-	user1.requestBalanceWithExpectedCode(t, http.StatusTooManyRequests)
-	time.Sleep(time.Second)
-	user1.requestBalanceWithExpectedCode(t, http.StatusOK)
+	user1.createGroup(t, "tokens")
+	writeToken := user1.createAccessToken(t, `["read", "expenses:write"]`)
+	readToken := user1.createAccessToken(t, `["read"]`)
+	assert.Len(t, user1.listAccessTokens(t), 2)
+
+	script := user1
+	script.AccessToken = writeToken.Token
+	script.payForExpense(t, fmt.Sprintf(`{"amount": 10, "shares": {"%d": 100}}`, user1.ID))
+	script.requestBalance(t)
+	// tokens can't manage credentials of the user
+	script.requestAccessTokensWithExpectedCode(t, http.StatusForbidden)
+	script.AccessToken = readToken.Token
+	script.requestBalanceWithExpectedCode(t, http.StatusOK)
+	script.createGroupWithExpectedCode(t, "another", http.StatusForbidden)
+
+	user1.revokeAccessToken(t, readToken.ID, http.StatusNoContent)
+	user1.revokeAccessToken(t, readToken.ID, http.StatusNotFound)
+	script.requestBalanceWithExpectedCode(t, http.StatusForbidden)
+	tokens := user1.listAccessTokens(t)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, writeToken.ID, tokens[0].ID)
+	assert.Empty(t, tokens[0].Token)
 
 	err = application.Stop()
 	if err != nil && err != http.ErrServerClosed {
@@ -171,6 +675,126 @@ func TestFailsWithIncorrectSchemaLocation(t *testing.T) {
 	assert.Nil(t, application)
 }
 
+func TestFailsWithIncorrectAccessKeyFile(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Security.AccessKeyFile = "./no_key.pem"
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithIncorrectBreachedPasswordsFile(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Password.BreachedPasswordsFile = "./no_breached_passwords.txt"
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithIncorrectArgon2Params(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Password.Argon2Parallelism = 0
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithEmptyAccessKeysDir(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Security.AccessKeysDir = t.TempDir()
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithIncorrectLoginLockout(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Login.LockoutAfter = config.Login.FreeAttempts
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithIncorrectRateLimitPolicyFile(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.RateLimit.PolicyFile = filepath.Join(t.TempDir(), "policy.json")
+	policy := `[{"route": "/balance", "roles": {"admin": [{"period": "1m", "amount": 2}]}}]`
+	require.NoError(t, ioutil.WriteFile(config.RateLimit.PolicyFile, []byte(policy), 0600))
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithUnknownStorageBackend(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Storage.Backend = "memcached"
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithoutBalanceCacheSizeOfMemoryStorage(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Storage.Backend = "memory"
+	config.Storage.BalanceCacheSize = 0
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithNegativeBalanceEarlyRefreshBeta(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Balance.EarlyRefreshBeta = -1
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithoutBalanceCacheSizeOfLocalTier(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Storage.BalanceCacheSize = 0
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithoutMailBackend(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Mail = main.MailConfig{}
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithUnknownMailBackend(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Mail.Backend = "pigeon"
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
+func TestFailsWithoutAddressOfSMTPMailBackend(t *testing.T) {
+	config := defaultConfig
+	config.Port = 8080
+	config.Mail.Backend = "smtp"
+	application, err := main.NewApplication(&config)
+	require.Error(t, err)
+	assert.Nil(t, application)
+}
+
 func checkBalances(t *testing.T, user1 systemUser, user2 systemUser, user3 systemUser) {
 	balance1 := user1.requestBalance(t)
 	balance2 := user2.requestBalance(t)
@@ -236,7 +860,7 @@ func getFreePort() (int, error) {
 
 func createUser(t *testing.T, serverAddr string, emailPrefix string) systemUser {
 	email := emailPrefix + "mail@mail.com"
-	password := emailPrefix + "123621"
+	password := emailPrefix + "secret-123621"
 	body := fmt.Sprintf(`{"email":"%s", "password":"%s"}`, email, password)
 	result, err := http.Post(serverAddr+"/users", "application/json", strings.NewReader(body))
 	require.NoError(t, err)
@@ -258,6 +882,7 @@ type systemUser struct {
 	Email        string
 	AccessToken  string // it is empty at the start, didn't want to create different struct in tests
 	RefreshToken string // it is empty at the start, didn't want to create different struct in tests
+	MFAToken     string // it is set instead of other tokens when the user has two-factor authentication
 	GroupID      uint   // it is empty at the start, didn't want to create different struct in tests
 	serverAddr   string
 }
@@ -272,6 +897,39 @@ func (u *systemUser) authenticate(t *testing.T) {
 	require.NoError(t, json.NewDecoder(result.Body).Decode(&auth))
 	u.AccessToken = auth.AccessToken
 	u.RefreshToken = auth.RefreshToken
+	u.MFAToken = auth.MFAToken
+}
+
+func (u *systemUser) authenticateWithExpectedCode(t *testing.T, code int) {
+	body := fmt.Sprintf(`{"email":"%s", "password":"%s"}`, u.Email, u.Password)
+	result, err := http.Post(u.serverAddr+"/authenticate", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, code, result.StatusCode)
+}
+
+// refresh exchanges refresh token for new ones
+func (u *systemUser) refresh(t *testing.T) {
+	body := fmt.Sprintf(`{"refreshToken":"%s"}`, u.RefreshToken)
+	result, err := http.Post(u.serverAddr+"/authenticate/refresh", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var auth authentication.TokenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&auth))
+	u.AccessToken = auth.AccessToken
+	u.RefreshToken = auth.RefreshToken
+}
+
+func (u *systemUser) createGroupWithExpectedCode(t *testing.T, groupName string, code int) {
+	body := fmt.Sprintf(`{"name":"%s"}`, groupName)
+	request, err := http.NewRequest(http.MethodPost, u.serverAddr+"/groups", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, code, result.StatusCode)
 }
 
 func (u *systemUser) createGroup(t *testing.T, groupName string) uint {
@@ -357,6 +1015,207 @@ func (u *systemUser) requestBalanceWithExpectedCode(t *testing.T, code int) {
 	require.Equal(t, code, result.StatusCode)
 }
 
+func (u *systemUser) logout(t *testing.T) {
+	request, err := http.NewRequest(http.MethodPost, u.serverAddr+"/logout", nil)
+	u.addAuthHeader(request)
+	require.NoError(t, err)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusNoContent, result.StatusCode)
+}
+
+func (u *systemUser) forgotPassword(t *testing.T) {
+	body := fmt.Sprintf(`{"email":"%s"}`, u.Email)
+	result, err := http.Post(u.serverAddr+"/password/forgot", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusAccepted, result.StatusCode)
+}
+
+func (u *systemUser) resetPassword(t *testing.T, token string, password string, expectedCode int) {
+	body := fmt.Sprintf(`{"token":"%s", "password":"%s"}`, token, password)
+	result, err := http.Post(u.serverAddr+"/password/reset", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, expectedCode, result.StatusCode)
+}
+
+// changePassword sends the current password of the user and remembers the new one if it was changed
+func (u *systemUser) changePassword(t *testing.T, password string, expectedCode int) {
+	body := fmt.Sprintf(`{"currentPassword":"%s", "newPassword":"%s"}`, u.Password, password)
+	request, err := http.NewRequest(http.MethodPut, u.serverAddr+"/users/me/password", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, expectedCode, result.StatusCode)
+	if expectedCode == http.StatusNoContent {
+		u.Password = password
+	}
+}
+
+func (u *systemUser) getProfile(t *testing.T) expenses.ProfileResponse {
+	request, err := http.NewRequest(http.MethodGet, u.serverAddr+"/users/me", nil)
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var profile expenses.ProfileResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&profile))
+	return profile
+}
+
+func (u *systemUser) updateProfile(t *testing.T, body string) expenses.ProfileResponse {
+	request, err := http.NewRequest(http.MethodPatch, u.serverAddr+"/users/me", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var profile expenses.ProfileResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&profile))
+	return profile
+}
+
+func (u *systemUser) exportAccount(t *testing.T) expenses.AccountExport {
+	request, err := http.NewRequest(http.MethodGet, u.serverAddr+"/users/me/export", nil)
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var export expenses.AccountExport
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&export))
+	return export
+}
+
+func (u *systemUser) deleteAccount(t *testing.T, password string, expectedCode int) {
+	body := fmt.Sprintf(`{"password":"%s"}`, password)
+	request, err := http.NewRequest(http.MethodDelete, u.serverAddr+"/users/me", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, expectedCode, result.StatusCode)
+}
+
+func (u *systemUser) verifyEmail(t *testing.T, token string, expectedCode int) {
+	result, err := http.Get(u.serverAddr + "/users/verify?token=" + token)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, expectedCode, result.StatusCode)
+}
+
+func (u *systemUser) enrollTwoFactor(t *testing.T) authentication.TwoFactorEnrollment {
+	request, err := http.NewRequest(http.MethodPost, u.serverAddr+"/users/me/2fa", nil)
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var enrollment authentication.TwoFactorEnrollment
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&enrollment))
+	return enrollment
+}
+
+func (u *systemUser) confirmTwoFactor(t *testing.T, code string) []string {
+	body := fmt.Sprintf(`{"code":"%s"}`, code)
+	request, err := http.NewRequest(http.MethodPost, u.serverAddr+"/users/me/2fa/confirm", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var response authentication.RecoveryCodesResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&response))
+	return response.RecoveryCodes
+}
+
+// verifyTwoFactor exchanges the MFA token of the last authentication and the code for access and refresh tokens
+func (u *systemUser) verifyTwoFactor(t *testing.T, code string, expectedCode int) {
+	body := fmt.Sprintf(`{"mfaToken":"%s", "code":"%s"}`, u.MFAToken, code)
+	result, err := http.Post(u.serverAddr+"/authenticate/2fa", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, expectedCode, result.StatusCode)
+	if expectedCode != http.StatusOK {
+		return
+	}
+	var auth authentication.TokenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&auth))
+	u.AccessToken = auth.AccessToken
+	u.RefreshToken = auth.RefreshToken
+	u.MFAToken = ""
+}
+
+func (u *systemUser) disableTwoFactor(t *testing.T, expectedCode int) {
+	body := fmt.Sprintf(`{"password":"%s"}`, u.Password)
+	request, err := http.NewRequest(http.MethodDelete, u.serverAddr+"/users/me/2fa", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, expectedCode, result.StatusCode)
+}
+
+func (u *systemUser) createAccessToken(t *testing.T, scopes string) authentication.PersonalAccessTokenResponse {
+	body := fmt.Sprintf(`{"name":"script", "scopes":%s}`, scopes)
+	request, err := http.NewRequest(http.MethodPost, u.serverAddr+"/users/me/tokens", strings.NewReader(body))
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusCreated, result.StatusCode)
+	var token authentication.PersonalAccessTokenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&token))
+	return token
+}
+
+func (u *systemUser) listAccessTokens(t *testing.T) []authentication.PersonalAccessTokenResponse {
+	request, err := http.NewRequest(http.MethodGet, u.serverAddr+"/users/me/tokens", nil)
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	var tokens []authentication.PersonalAccessTokenResponse
+	require.NoError(t, json.NewDecoder(result.Body).Decode(&tokens))
+	return tokens
+}
+
+func (u *systemUser) requestAccessTokensWithExpectedCode(t *testing.T, code int) {
+	request, err := http.NewRequest(http.MethodGet, u.serverAddr+"/users/me/tokens", nil)
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, code, result.StatusCode)
+}
+
+func (u *systemUser) revokeAccessToken(t *testing.T, tokenID uint, code int) {
+	url := fmt.Sprintf("%s/users/me/tokens/%d", u.serverAddr, tokenID)
+	request, err := http.NewRequest(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	u.addAuthHeader(request)
+	result, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer result.Body.Close()
+	require.Equal(t, code, result.StatusCode)
+}
+
 func (u *systemUser) addAuthHeader(r *http.Request) {
 	if r == nil {
 		return
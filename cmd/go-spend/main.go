@@ -56,17 +56,218 @@ func PrepareConfig() *Config {
 		"",
 		"Redis password. Might be empty",
 	)
+	flag.StringVar(
+		&config.Storage.Backend,
+		"storage-backend",
+		"redis",
+		"Where sessions, cached balances and rate limits are kept, redis or memory. Memory fits only a single instance",
+	)
+	flag.UintVar(
+		&config.Storage.BalanceCacheSize,
+		"balance-cache-size",
+		10000,
+		"How many balances memory storage backend or local copies of redis storage backend keep",
+	)
+	flag.DurationVar(
+		&config.Storage.LocalBalanceCacheDuration,
+		"local-balance-cache-duration",
+		5*time.Second,
+		"How long redis storage backend keeps local copies of balances in the instance. Zero disables local copies",
+	)
+	flag.BoolVar(
+		&config.Balance.WriteThrough,
+		"balance-write-through",
+		false,
+		"Recompute cached balances of users involved in a new expense instead of removing them",
+	)
+	flag.Float64Var(
+		&config.Balance.EarlyRefreshBeta,
+		"balance-early-refresh-beta",
+		1,
+		"How early cached balances may be refreshed before they expire, higher is earlier. Zero disables it",
+	)
 	flag.StringVar(
 		&config.Security.AccessSecret,
 		"access-token-secret",
 		"access-secret",
 		"Secret key for access token encryption",
 	)
+	flag.StringVar(
+		&config.Security.AccessKeyFile,
+		"access-token-key-file",
+		"",
+		"PEM file with RSA, ECDSA P-256 or Ed25519 private key to sign access tokens. Replaces access-token-secret",
+	)
+	flag.StringVar(
+		&config.Security.AccessKeysDir,
+		"access-token-keys-dir",
+		"",
+		"Directory with <key ID>.pem private keys to sign access tokens with rotation. Replaces access-token-key-file",
+	)
+	flag.DurationVar(
+		&config.Security.AccessKeyRotationPeriod,
+		"access-token-key-rotation-period",
+		7*24*time.Hour,
+		"How long a key from access-token-keys-dir signs tokens before the next one is activated. 0 disables rotation",
+	)
 	flag.StringVar(
 		&config.Security.RefreshSecret,
 		"refresh-token-secret",
 		"refresh-secret",
-		"Secret key for refresh token encryption. They are not implemented at the moment",
+		"Secret key for refresh token encryption",
+	)
+	flag.UintVar(
+		&config.Security.MaxSessions,
+		"max-sessions",
+		10,
+		"Maximum number of concurrent sessions of a user, the oldest ones are removed. 0 means no limit",
+	)
+	flag.BoolVar(
+		&config.Security.StatelessAuthorization,
+		"stateless-authorization",
+		false,
+		"Trust claims of valid access tokens without a lookup in Redis, revoked sessions are checked with a denylist",
+	)
+	flag.BoolVar(
+		&config.Security.RequireVerifiedEmailToAuthenticate,
+		"require-verified-email-to-authenticate",
+		false,
+		"Reject authentication of users that haven't verified their email",
+	)
+	flag.BoolVar(
+		&config.Security.RequireVerifiedEmailForGroups,
+		"require-verified-email-for-groups",
+		false,
+		"Don't let users that haven't verified their email create, join or claim groups",
+	)
+	flag.StringVar(
+		&config.Security.TrustedProxies,
+		"trusted-proxies",
+		"",
+		"Comma separated IPs or CIDRs of reverse proxies whose Forwarded and X-Forwarded-For headers are trusted",
+	)
+	flag.StringVar(
+		&config.RateLimit.IPAllowlist,
+		"rate-limit-ip-allowlist",
+		"",
+		"Comma separated IPs or CIDRs of clients that are not rate limited per IP",
+	)
+	flag.StringVar(
+		&config.RateLimit.PolicyFile,
+		"rate-limit-policy-file",
+		"",
+		"JSON file with rate limit rules of routes and their overrides per role. If empty default limits are used",
+	)
+	flag.StringVar(
+		&config.Mail.Backend,
+		"mail-backend",
+		"",
+		"Where emails to users are delivered, smtp, file or log. If empty smtp-address or mail-file decides. "+
+			"Log exposes reset and verification tokens, it is only for development",
+	)
+	flag.StringVar(&config.Mail.From, "mail-from", "go-spend@localhost", "Sender of emails to users")
+	flag.StringVar(
+		&config.Mail.SMTPAddr,
+		"smtp-address",
+		"",
+		"SMTP server address in format host:port. If empty emails are written to mail-file",
+	)
+	flag.StringVar(&config.Mail.SMTPUsername, "smtp-username", "", "SMTP username. Might be empty")
+	flag.StringVar(&config.Mail.SMTPPassword, "smtp-password", "", "SMTP password. Might be empty")
+	flag.StringVar(
+		&config.Mail.File,
+		"mail-file",
+		"mails.txt",
+		"File to append emails to when SMTP server is not set. Empty requires smtp-address or mail-backend",
+	)
+	flag.StringVar(
+		&config.Mail.ResetPasswordURL,
+		"password-reset-url",
+		"http://localhost:8080/password/reset",
+		"Link in password reset emails, reset token is added as token query parameter",
+	)
+	flag.StringVar(
+		&config.Mail.VerifyEmailURL,
+		"email-verification-url",
+		"http://localhost:8080/users/verify",
+		"Link in email verification emails, verification token is added as token query parameter",
+	)
+	flag.UintVar(&config.Password.MinLength, "password-min-length", 8, "Minimum number of characters in a password")
+	flag.UintVar(
+		&config.Password.MinCharacterClasses,
+		"password-min-character-classes",
+		1,
+		"Minimum number of kinds of characters in a password: lower case, upper case, digits and others",
+	)
+	flag.StringVar(
+		&config.Password.BreachedPasswordsFile,
+		"breached-passwords-file",
+		"",
+		"File with breached passwords that can't be chosen, SHA-1 hashes or plain text, one per line",
+	)
+	flag.UintVar(&config.Password.Argon2Memory, "argon2-memory", 64*1024, "Memory of Argon2id password hashing in KiB")
+	flag.UintVar(&config.Password.Argon2Iterations, "argon2-iterations", 3, "Iterations of Argon2id password hashing")
+	flag.UintVar(&config.Password.Argon2Parallelism, "argon2-parallelism", 4, "Threads of Argon2id password hashing")
+	flag.StringVar(
+		&config.OIDC.Issuer,
+		"oidc-issuer",
+		"",
+		"Issuer URL of an OpenID Connect identity provider to sign in with. Empty disables the sign in",
+	)
+	flag.StringVar(&config.OIDC.ClientID, "oidc-client-id", "", "Client ID registered at the identity provider")
+	flag.StringVar(
+		&config.OIDC.ClientSecret,
+		"oidc-client-secret",
+		"",
+		"Client secret registered at the identity provider. Empty for public clients",
+	)
+	flag.StringVar(
+		&config.OIDC.RedirectURL,
+		"oidc-redirect-url",
+		"http://localhost:8080/authenticate/oidc/callback",
+		"Redirect URL registered at the identity provider, it receives code and state",
+	)
+	flag.BoolVar(
+		&config.OIDC.AutoProvision,
+		"oidc-auto-provision",
+		false,
+		"Create users that sign in with the identity provider for the first time",
+	)
+	flag.UintVar(
+		&config.Login.FreeAttempts,
+		"login-free-attempts",
+		3,
+		"Failed authentication attempts of an email without delay",
+	)
+	flag.UintVar(
+		&config.Login.IPFreeAttempts,
+		"login-ip-free-attempts",
+		20,
+		"Failed authentication attempts from a client IP without delay",
+	)
+	flag.DurationVar(
+		&config.Login.BackoffDelay,
+		"login-backoff-delay",
+		time.Second,
+		"Delay after the first failed authentication attempt over free ones, it doubles with every next failure",
+	)
+	flag.UintVar(
+		&config.Login.LockoutAfter,
+		"login-lockout-after",
+		10,
+		"Failed authentication attempts of an email that lock it out for login-lockout-duration",
+	)
+	flag.UintVar(
+		&config.Login.IPLockoutAfter,
+		"login-ip-lockout-after",
+		100,
+		"Failed authentication attempts from a client IP that lock it out for login-lockout-duration",
+	)
+	flag.DurationVar(
+		&config.Login.LockoutDuration,
+		"login-lockout-duration",
+		15*time.Minute,
+		"How long an email or a client IP is locked out, failed attempts are forgotten as long after the last one",
 	)
 	flag.Parse()
 	return config
@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"go-spend/cmd/go-spend"
+	"net/http"
 	"testing"
 	"time"
 )
@@ -20,9 +21,43 @@ var defaultConfigFromFlags = &main.Config{
 		Addr:     "localhost:6379",
 		Password: "",
 	},
+	Storage: main.StorageConfig{
+		Backend:                   "redis",
+		BalanceCacheSize:          10000,
+		LocalBalanceCacheDuration: 5 * time.Second,
+	},
+	Balance: main.BalanceConfig{
+		EarlyRefreshBeta: 1,
+	},
 	Security: main.SecurityConfig{
-		AccessSecret:  "access-secret",
-		RefreshSecret: "refresh-secret",
+		AccessSecret:            "access-secret",
+		AccessKeyRotationPeriod: 7 * 24 * time.Hour,
+		RefreshSecret:           "refresh-secret",
+		MaxSessions:             10,
+	},
+	Mail: main.MailConfig{
+		From:             "go-spend@localhost",
+		File:             "mails.txt",
+		ResetPasswordURL: "http://localhost:8080/password/reset",
+		VerifyEmailURL:   "http://localhost:8080/users/verify",
+	},
+	Password: main.PasswordConfig{
+		MinLength:           8,
+		MinCharacterClasses: 1,
+		Argon2Memory:        64 * 1024,
+		Argon2Iterations:    3,
+		Argon2Parallelism:   4,
+	},
+	OIDC: main.OIDCConfig{
+		RedirectURL: "http://localhost:8080/authenticate/oidc/callback",
+	},
+	Login: main.LoginConfig{
+		FreeAttempts:    3,
+		IPFreeAttempts:  20,
+		BackoffDelay:    time.Second,
+		LockoutAfter:    10,
+		IPLockoutAfter:  100,
+		LockoutDuration: 15 * time.Minute,
 	},
 }
 
@@ -49,19 +84,18 @@ func checkApplication(t *testing.T, serverAddr string) {
 	user2 := createUser(t, serverAddr, "2")
 	user3 := createUser(t, serverAddr, "3")
 	user4 := createUser(t, serverAddr, "4")
+	// all users sign in before they join groups, their sessions have to pick up the groups without signing in again
 	user1.authenticate(t)
+	user2.authenticate(t)
+	user3.authenticate(t)
 	user4.authenticate(t)
 	groupName1 := "gr1"
 	groupName2 := "group2"
 	group1ID := user1.createGroup(t, groupName1)
 	user4.createGroup(t, groupName2)
-	user1.authenticate(t) // due to current limitations need to reauthenticate after group creation
-	user4.authenticate(t)
 	//add users to group 1
 	user1.addUserToGroup(t, user2.ID, group1ID)
-	user2.authenticate(t)
 	user2.addUserToGroup(t, user3.ID, group1ID)
-	user3.authenticate(t)
 	// request balances to trigger the cache
 	user1.requestBalance(t)
 	user2.requestBalance(t)
@@ -70,4 +104,9 @@ func checkApplication(t *testing.T, serverAddr string) {
 	user1.payForPizza(t)
 	user2.payForCoffee(t)
 	checkBalances(t, user1, user2, user3)
+	// refreshed tokens keep the group too
+	user3.refresh(t)
+	user3.requestBalance(t)
+	user4.logout(t)
+	user4.requestBalanceWithExpectedCode(t, http.StatusForbidden)
 }
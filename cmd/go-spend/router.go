@@ -3,9 +3,13 @@ package main
 import (
 	"encoding/json"
 	"go-spend/authentication"
+	"go-spend/authentication/jwt"
 	"go-spend/expenses"
 	"go-spend/log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -15,71 +19,102 @@ const (
 	ServerError             = "Server Error"
 	Forbidden               = "Forbidden"
 	NotFound                = "Not Found"
+	EmailNotVerified        = "Email is not verified"
 )
 
 // Maps HTTP request to proper service. Validates parameters before passing them
 type Router struct {
 	mux http.Handler
 
-	authenticator   authentication.Authenticator
-	balanceService  expenses.BalanceService
-	expensesService expenses.Service
-	groupService    expenses.GroupService
-	userService     authentication.UserService
+	accessTokenService authentication.AccessTokenService
+	accountService     authentication.AccountService
+	authenticator      authentication.Authenticator
+	balanceService     expenses.BalanceService
+	emailVerifier      authentication.EmailVerifier
+	expensesService    expenses.Service
+	// externalAuthenticator is nil if users can't sign in with an identity provider
+	externalAuthenticator authentication.ExternalAuthenticator
+	groupService          expenses.GroupService
+	passwordService       authentication.PasswordService
+	profileService        expenses.ProfileService
+	publicKeys            jwt.PublicKeyProvider
+	sessionService        authentication.SessionService
+	twoFactorService      authentication.TwoFactorService
+	userService           authentication.UserService
 }
 
-// NewRouter creates new instance of router with necessary mappings
+// NewRouter creates new instance of router with necessary mappings. Every endpoint is rate limited with limiter.
+// Endpoints that require authorization are limited both before it, as anonymous requests, so that requests with invalid
+// tokens are limited too, and after it, as requests of the user. Use empty authentication.RequestLimiters to turn rate
+// limits off.
 func NewRouter(
+	accessTokenService authentication.AccessTokenService,
+	accountService authentication.AccountService,
 	authenticator authentication.Authenticator,
 	authorizer authentication.Authorizer,
 	balanceService expenses.BalanceService,
+	emailVerifier authentication.EmailVerifier,
 	expensesService expenses.Service,
-	groupService expenses.GroupService,
-	userService authentication.UserService,
-) *Router {
-	mux := http.NewServeMux()
-	r := &Router{
-		mux:             mux,
-		authenticator:   authenticator,
-		balanceService:  balanceService,
-		expensesService: expensesService,
-		groupService:    groupService,
-		userService:     userService,
-	}
-	mux.Handle("/users", http.HandlerFunc(r.users))
-	mux.Handle("/expenses", authorizer.Authorize(r.expenses))
-	mux.Handle("/groups", authorizer.Authorize(r.groups))
-	mux.Handle("/authenticate", http.HandlerFunc(r.authenticate))
-	mux.Handle("/balance", authorizer.Authorize(r.balance))
-	mux.Handle("/health", http.HandlerFunc(r.health))
-	return r
-}
-
-// NewRouterWithRateLimit  creates new instance of router with necessary mappings and rate limit for balance requests
-func NewRouterWithRateLimit(
-	authenticator authentication.Authenticator,
-	authorizer authentication.Authorizer,
-	balanceService expenses.BalanceService,
-	expensesService expenses.Service,
+	externalAuthenticator authentication.ExternalAuthenticator,
 	groupService expenses.GroupService,
 	limiter authentication.RequestLimiter,
+	passwordService authentication.PasswordService,
+	profileService expenses.ProfileService,
+	publicKeys jwt.PublicKeyProvider,
+	sessionService authentication.SessionService,
+	twoFactorService authentication.TwoFactorService,
 	userService authentication.UserService,
 ) *Router {
 	mux := http.NewServeMux()
 	r := &Router{
-		mux:             mux,
-		authenticator:   authenticator,
-		balanceService:  balanceService,
-		expensesService: expensesService,
-		groupService:    groupService,
-		userService:     userService,
-	}
-	mux.Handle("/users", http.HandlerFunc(r.users))
-	mux.Handle("/expenses", authorizer.Authorize(r.expenses))
-	mux.Handle("/groups", authorizer.Authorize(r.groups))
-	mux.Handle("/authenticate", http.HandlerFunc(r.authenticate))
-	mux.Handle("/balance", authorizer.Authorize(limiter.RateLimit(r.balance)))
-	mux.Handle("/health", http.HandlerFunc(r.health))
+		mux:                   mux,
+		accessTokenService:    accessTokenService,
+		accountService:        accountService,
+		authenticator:         authenticator,
+		balanceService:        balanceService,
+		emailVerifier:         emailVerifier,
+		expensesService:       expensesService,
+		externalAuthenticator: externalAuthenticator,
+		groupService:          groupService,
+		passwordService:       passwordService,
+		profileService:        profileService,
+		publicKeys:            publicKeys,
+		sessionService:        sessionService,
+		twoFactorService:      twoFactorService,
+		userService:           userService,
+	}
+	authorized := func(handler http.HandlerFunc) http.HandlerFunc {
+		return limiter.RateLimit(authorizer.Authorize(limiter.RateLimit(handler)))
+	}
+	mux.Handle("/users", limiter.RateLimit(r.users))
+	mux.Handle("/users/verify", limiter.RateLimit(r.verifyEmail))
+	mux.Handle("/users/me", authorized(r.profile))
+	mux.Handle("/users/me/export", authorized(r.exportAccount))
+	mux.Handle("/users/me/password", authorized(r.changePassword))
+	mux.Handle("/users/me/2fa", authorized(r.twoFactor))
+	mux.Handle("/users/me/2fa/confirm", authorized(r.confirmTwoFactor))
+	mux.Handle("/users/me/tokens", authorized(r.accessTokens))
+	mux.Handle("/users/me/tokens/", authorized(r.revokeAccessToken))
+	mux.Handle("/expenses", authorized(r.expenses))
+	mux.Handle("/groups", authorized(r.groups))
+	mux.Handle("/groups/placeholders", authorized(r.placeholders))
+	mux.Handle("/groups/claim", authorized(r.claimPlaceholder))
+	mux.Handle("/authenticate", limiter.RateLimit(r.authenticate))
+	mux.Handle("/authenticate/refresh", limiter.RateLimit(r.refresh))
+	mux.Handle("/authenticate/2fa", limiter.RateLimit(r.authenticateTwoFactor))
+	if externalAuthenticator != nil {
+		mux.Handle("/authenticate/oidc", limiter.RateLimit(r.authenticateExternal))
+		mux.Handle("/authenticate/oidc/callback", limiter.RateLimit(r.authenticateExternalCallback))
+	}
+	mux.Handle("/password/forgot", limiter.RateLimit(r.forgotPassword))
+	mux.Handle("/password/reset", limiter.RateLimit(r.resetPassword))
+	mux.Handle("/logout", authorized(r.logout))
+	mux.Handle("/logout/all", authorized(r.logoutAll))
+	mux.Handle("/sessions", authorized(r.sessions))
+	mux.Handle("/sessions/", authorized(r.deleteSession))
+	mux.Handle("/balance", authorized(r.balance))
+	mux.Handle("/.well-known/jwks.json", limiter.RateLimit(r.jwks))
+	mux.Handle("/health", limiter.RateLimit(r.health))
 	return r
 }
 
@@ -111,6 +146,18 @@ func (router *Router) createUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User already exists", http.StatusBadRequest)
 		return
 	}
+	if err == expenses.ErrPlaceholderNotFound {
+		http.Error(w, "Claim code is not valid", http.StatusBadRequest)
+		return
+	}
+	if err == authentication.ErrEmailNotVerified {
+		http.Error(w, EmailNotVerified, http.StatusForbidden)
+		return
+	}
+	if message, ok := passwordPolicyViolation(err); ok {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Error("error while trying to create a user with email %s - %s", createUserRequest.Email, err)
 		http.Error(w, ServerError, http.StatusInternalServerError)
@@ -125,6 +172,59 @@ func (router *Router) createUser(w http.ResponseWriter, r *http.Request) {
 	log.Info("created a new user - %s", createdUser.Email)
 }
 
+// verifyEmail handles requests to /users/verify endpoint. GET verifies the email of the token owner, POST sends
+// a new verification link.
+func (router *Router) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		router.confirmEmail(w, r)
+	case http.MethodPost:
+		router.resendVerification(w, r)
+	default:
+		http.Error(w, NotFound, http.StatusNotFound)
+	}
+}
+
+// confirmEmail marks email of the owner of the token query parameter as verified
+// If everything is correct - responds with 200
+func (router *Router) confirmEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Verification token is not valid", http.StatusBadRequest)
+		return
+	}
+	err := router.emailVerifier.Verify(r.Context(), token)
+	if err == authentication.ErrInvalidVerificationToken {
+		http.Error(w, "Verification token is not valid", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not verify email - %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err = w.Write([]byte("Email is verified")); err != nil {
+		log.Error("couldn't write body of verify email response - %s", err)
+	}
+}
+
+// resendVerification sends a new verification link to the user with the email
+// Responds with 202 even if there is no such user, so that it is not possible to find out who is registered
+func (router *Router) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var resendRequest authentication.ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&resendRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	if err := router.emailVerifier.Resend(r.Context(), resendRequest.Email); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not resend verification link - %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
 // groups handles all requests to /groups endpoint - create and add user.
 func (router *Router) groups(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -173,7 +273,9 @@ func (router *Router) createGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 // authenticate performs user authentication
-// If everything is correct - responds 200 and provides access and refresh tokens
+// If everything is correct - responds 200 and provides access and refresh tokens. Users with two-factor
+// authentication get only an MFA token that is exchanged at /authenticate/2fa. Responds 429 with Retry-After while
+// the email or the client have to wait after failed attempts.
 func (router *Router) authenticate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, NotFound, http.StatusNotFound)
@@ -185,12 +287,24 @@ func (router *Router) authenticate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, IncorrectBody, http.StatusBadRequest)
 		return
 	}
-	tokenResponse, err := router.authenticator.Authenticate(r.Context(), auth.Email, auth.Password)
+	ctx := authentication.WithClientInfo(r.Context(), authentication.NewClientInfo(r))
+	tokenResponse, err := router.authenticator.Authenticate(ctx, auth.Email, auth.Password)
+	if tooManyAttempts, ok := err.(authentication.TooManyAttemptsError); ok {
+		retryAfter := math.Ceil(tooManyAttempts.RetryAfter.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		log.Info("authentication of %s is throttled for %s", auth.Email, tooManyAttempts.RetryAfter)
+		return
+	}
 	if err == authentication.ErrEmailOrPasswordIncorrect {
 		http.Error(w, UserOrPasswordIncorrect, http.StatusUnauthorized)
 		log.Info("incorrect authentication attempt %s", auth.Email)
 		return
 	}
+	if err == authentication.ErrEmailNotVerified {
+		http.Error(w, EmailNotVerified, http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, ServerError, http.StatusInternalServerError)
 		log.Error("could not authenticate user with email %s, error: %s", auth.Email, err)
@@ -202,6 +316,640 @@ func (router *Router) authenticate(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// authenticateExternal redirects the user to the identity provider to sign in
+// If everything is correct - responds with 302
+func (router *Router) authenticateExternal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	authorizationURL, err := router.externalAuthenticator.AuthorizationURL(r.Context())
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't start authentication with identity provider - %s", err)
+		return
+	}
+	http.Redirect(w, r, authorizationURL, http.StatusFound)
+}
+
+// authenticateExternalCallback exchanges code and state that the identity provider has redirected back with for
+// tokens of a new session
+// If everything is correct - responds 200 and provides access and refresh tokens
+func (router *Router) authenticateExternalCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		http.Error(w, "Identity provider has rejected authentication", http.StatusUnauthorized)
+		log.Info("identity provider has rejected authentication - %s", query.Get("error"))
+		return
+	}
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		http.Error(w, IncorrectValues, http.StatusBadRequest)
+		return
+	}
+	ctx := authentication.WithClientInfo(r.Context(), authentication.NewClientInfo(r))
+	tokenResponse, err := router.externalAuthenticator.Callback(ctx, code, state)
+	switch err {
+	case nil:
+	case authentication.ErrInvalidOIDCState, authentication.ErrOIDCCodeRejected, authentication.ErrInvalidIDToken:
+		http.Error(w, "Authentication with identity provider failed", http.StatusUnauthorized)
+		log.Info("authentication with identity provider failed - %s", err)
+		return
+	case authentication.ErrOIDCEmailNotVerified:
+		http.Error(w, EmailNotVerified, http.StatusForbidden)
+		return
+	case authentication.ErrOIDCUserNotFound:
+		http.Error(w, "User doesn't exist", http.StatusForbidden)
+		return
+	default:
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not authenticate user with identity provider - %s", err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(&tokenResponse); err != nil {
+		log.Error("couldn't write body of auth response - %s", err)
+		http.Error(w, ServerError, http.StatusInternalServerError)
+	}
+}
+
+// authenticateTwoFactor exchanges an MFA token and a TOTP or recovery code for tokens of a new session
+// If everything is correct - responds 200 and provides access and refresh tokens
+func (router *Router) authenticateTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var twoFactorRequest authentication.TwoFactorAuthRequest
+	err := json.NewDecoder(r.Body).Decode(&twoFactorRequest)
+	if err == authentication.ErrInvalidMFAToken || err == authentication.ErrTwoFactorCodeIncorrect {
+		http.Error(w, IncorrectValues, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	ctx := authentication.WithClientInfo(r.Context(), authentication.NewClientInfo(r))
+	tokenResponse, err := router.twoFactorService.Verify(ctx, twoFactorRequest)
+	if err == authentication.ErrInvalidMFAToken {
+		http.Error(w, "MFA token is not valid, authenticate again", http.StatusUnauthorized)
+		return
+	}
+	if err == authentication.ErrTwoFactorCodeIncorrect {
+		http.Error(w, "Two-factor code is incorrect, authenticate again", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not verify two-factor code - %s", err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(&tokenResponse); err != nil {
+		log.Error("couldn't write body of two-factor auth response - %s", err)
+		http.Error(w, ServerError, http.StatusInternalServerError)
+	}
+}
+
+// refresh exchanges a refresh token for a new pair of tokens
+// If everything is correct - responds 200 and provides new access and refresh tokens
+func (router *Router) refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var refreshRequest authentication.RefreshRequest
+	var err error
+	if err = json.NewDecoder(r.Body).Decode(&refreshRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	tokenResponse, err := router.sessionService.Refresh(refreshRequest.RefreshToken)
+	if err == authentication.ErrInvalidRefreshToken {
+		http.Error(w, "Refresh token is not valid", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not refresh tokens - %s", err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(&tokenResponse); err != nil {
+		log.Error("couldn't write body of refresh response - %s", err)
+		http.Error(w, ServerError, http.StatusInternalServerError)
+	}
+}
+
+// forgotPassword sends a password reset link to the user with the email
+// Responds with 202 even if there is no such user, so that it is not possible to find out who is registered
+func (router *Router) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var forgotRequest authentication.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&forgotRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	if err := router.passwordService.Forgot(r.Context(), forgotRequest.Email); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not send password reset link - %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// resetPassword sets a new password of the user of the reset token, all sessions of the user are revoked
+// If everything is correct - responds with 204
+func (router *Router) resetPassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var resetRequest authentication.ResetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&resetRequest)
+	if err == authentication.ErrInvalidResetToken {
+		http.Error(w, "Reset token is not valid", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	err = router.passwordService.Reset(r.Context(), resetRequest)
+	if err == authentication.ErrInvalidResetToken {
+		http.Error(w, "Reset token is not valid", http.StatusBadRequest)
+		return
+	}
+	if message, ok := passwordPolicyViolation(err); ok {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not reset password - %s", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// changePassword replaces the password of the current user, all other sessions of the user are revoked
+// If everything is correct - responds with 204
+func (router *Router) changePassword(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var changeRequest authentication.ChangePasswordRequest
+	if err = json.NewDecoder(r.Body).Decode(&changeRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	err = router.passwordService.Change(r.Context(), userContext, changeRequest)
+	if err == authentication.ErrCurrentPasswordIncorrect {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if message, ok := passwordPolicyViolation(err); ok {
+		http.Error(w, message, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not change password of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has changed the password", userContext.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// twoFactor handles requests to /users/me/2fa endpoint. POST enrolls a new TOTP secret of the current user,
+// DELETE disables two-factor authentication.
+func (router *Router) twoFactor(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		router.enrollTwoFactor(w, r)
+	case http.MethodDelete:
+		router.disableTwoFactor(w, r)
+	default:
+		http.Error(w, NotFound, http.StatusNotFound)
+	}
+}
+
+// enrollTwoFactor creates a TOTP secret that is not used until it is confirmed
+// If everything is correct - responds with 200, the secret and otpauth URI of the secret
+func (router *Router) enrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	enrollment, err := router.twoFactorService.Enroll(r.Context(), userContext)
+	if err == expenses.ErrTwoFactorAlreadyEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not enroll two-factor authentication of user %d - %s", userContext.UserID, err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(&enrollment); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write body of two-factor enrollment response - %s", err)
+	}
+}
+
+// disableTwoFactor turns off two-factor authentication of the current user, the password is required
+// If everything is correct - responds with 204
+func (router *Router) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	var disableRequest authentication.DisableTwoFactorRequest
+	if err = json.NewDecoder(r.Body).Decode(&disableRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	err = router.twoFactorService.Disable(r.Context(), userContext, disableRequest.Password)
+	if err == authentication.ErrCurrentPasswordIncorrect {
+		http.Error(w, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err == expenses.ErrTwoFactorNotFound {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not disable two-factor authentication of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has disabled two-factor authentication", userContext.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// confirmTwoFactor enables two-factor authentication of the current user with the first code of the enrolled secret
+// If everything is correct - responds with 200 and recovery codes, they are shown only once
+func (router *Router) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var codeRequest authentication.TwoFactorCodeRequest
+	if err = json.NewDecoder(r.Body).Decode(&codeRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	recoveryCodes, err := router.twoFactorService.Confirm(r.Context(), userContext, codeRequest.Code)
+	switch err {
+	case nil:
+	case authentication.ErrTwoFactorCodeIncorrect:
+		http.Error(w, "Two-factor code is incorrect", http.StatusBadRequest)
+		return
+	case expenses.ErrTwoFactorNotFound:
+		http.Error(w, "Two-factor authentication was not enrolled", http.StatusBadRequest)
+		return
+	case expenses.ErrTwoFactorAlreadyEnabled:
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	default:
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not confirm two-factor authentication of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has enabled two-factor authentication", userContext.UserID)
+	if err = json.NewEncoder(w).Encode(&recoveryCodes); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write body of two-factor confirmation response - %s", err)
+	}
+}
+
+// accessTokens handles requests to /users/me/tokens endpoint. GET lists personal access tokens of the current user,
+// POST creates a new one.
+func (router *Router) accessTokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		router.listAccessTokens(w, r)
+	case http.MethodPost:
+		router.createAccessToken(w, r)
+	default:
+		http.Error(w, NotFound, http.StatusNotFound)
+	}
+}
+
+// profile handles requests to the profile of the current user, DELETE deletes the account
+// If everything is correct - responds with 200 and the profile, PATCH changes only the fields present in the body
+func (router *Router) profile(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		router.deleteAccount(w, r)
+		return
+	}
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	var profile expenses.ProfileResponse
+	switch r.Method {
+	case http.MethodGet:
+		profile, err = router.profileService.Get(r.Context(), userContext.UserID)
+	case http.MethodPatch:
+		var updateRequest expenses.UpdateProfileRequest
+		if err = json.NewDecoder(r.Body).Decode(&updateRequest); err != nil {
+			http.Error(w, IncorrectBody, http.StatusBadRequest)
+			return
+		}
+		profile, err = router.profileService.Update(r.Context(), userContext.UserID, updateRequest)
+	default:
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err == expenses.ErrUserNotFound {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't handle profile of user %d - %s", userContext.UserID, err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(&profile); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write profile response - %s", err)
+	}
+}
+
+// deleteAccount anonymises the current user, expenses stay in the group under an ex-member. The password is required
+// unless the session has just signed in. If everything is correct - responds with 204
+func (router *Router) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	var deleteRequest authentication.DeleteAccountRequest
+	if err = json.NewDecoder(r.Body).Decode(&deleteRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	err = router.accountService.Delete(r.Context(), userContext, deleteRequest.Password)
+	if err == authentication.ErrCurrentPasswordIncorrect {
+		http.Error(w, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err == authentication.ErrReauthenticationRequired {
+		http.Error(w, "Password is required, or sign in again and delete right after", http.StatusForbidden)
+		return
+	}
+	if err == expenses.ErrUserNotFound {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not delete account of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has deleted the account", userContext.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportAccount responds with 200 and personal data of the current user as a JSON attachment
+func (router *Router) exportAccount(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	export, err := router.accountService.Export(r.Context(), userContext.UserID)
+	if err == expenses.ErrUserNotFound {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("could not export account of user %d - %s", userContext.UserID, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="go-spend-export.json"`)
+	if err = json.NewEncoder(w).Encode(&export); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write account export - %s", err)
+	}
+}
+
+// listAccessTokens responds with 200 and personal access tokens of the current user without the tokens themselves
+func (router *Router) listAccessTokens(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	tokens, err := router.accessTokenService.List(r.Context(), userContext)
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't list personal access tokens of user %d - %s", userContext.UserID, err)
+		return
+	}
+	if err = json.NewEncoder(w).Encode(tokens); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write personal access tokens response - %s", err)
+	}
+}
+
+// createAccessToken creates a personal access token of the current user
+// If everything is correct - responds with 201 and the token, it is shown only once
+func (router *Router) createAccessToken(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	var createRequest authentication.CreateAccessTokenRequest
+	if err = json.NewDecoder(r.Body).Decode(&createRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	token, err := router.accessTokenService.Create(r.Context(), userContext, createRequest)
+	if err == authentication.ErrAccessTokenExpirationIncorrect {
+		http.Error(w, IncorrectValues, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't create personal access token of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has created personal access token %d", userContext.UserID, token.ID)
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&token); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write personal access token response - %s", err)
+	}
+}
+
+// revokeAccessToken removes a personal access token of the current user, token ID is the last part of the path
+// If everything is correct - responds with 204
+func (router *Router) revokeAccessToken(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	tokenID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/users/me/tokens/"), 10, 0)
+	if r.Method != http.MethodDelete || err != nil {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	err = router.accessTokenService.Revoke(r.Context(), userContext, uint(tokenID))
+	if err == expenses.ErrAccessTokenNotFound {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't revoke personal access token of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has revoked personal access token %d", userContext.UserID, tokenID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// passwordPolicyViolation returns a message for the client if the password was rejected by the password policy
+func passwordPolicyViolation(err error) (string, bool) {
+	switch err {
+	case authentication.ErrPasswordTooShort:
+		return "Password is too short", true
+	case authentication.ErrPasswordTooSimple:
+		return "Password should contain more kinds of characters", true
+	case authentication.ErrPasswordBreached:
+		return "Password was found in a data breach, choose another one", true
+	default:
+		return "", false
+	}
+}
+
+// logout revokes tokens of the current session
+// If everything is correct - responds with 204
+func (router *Router) logout(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	err = router.sessionService.Logout(userContext)
+	if err == authentication.ErrSessionNotFound {
+		http.Error(w, IncorrectValues, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't logout user %d - %s", userContext.UserID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutAll revokes tokens of all sessions of the current user
+// If everything is correct - responds with 204
+func (router *Router) logoutAll(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err = router.sessionService.LogoutAll(userContext.UserID); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't logout all sessions of user %d - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has logged out of all sessions", userContext.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessions lists live sessions of the current user
+// If everything is correct - responds with 200 and the sessions, the oldest first
+func (router *Router) sessions(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	sessions, err := router.sessionService.List(userContext)
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't list sessions of user %d - %s", userContext.UserID, err)
+		return
+	}
+	if sessions == nil {
+		sessions = []authentication.Session{}
+	}
+	if err = json.NewEncoder(w).Encode(sessions); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write sessions response - %s", err)
+	}
+}
+
+// deleteSession revokes tokens of a session of the current user, session ID is the last part of the path
+// If everything is correct - responds with 204
+func (router *Router) deleteSession(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	sessionID := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	if r.Method != http.MethodDelete || sessionID == "" || strings.Contains(sessionID, "/") {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	err = router.sessionService.Delete(userContext.UserID, sessionID)
+	if err == authentication.ErrSessionNotFound {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't delete session of user %d - %s", userContext.UserID, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func handleGroupCreationErrors(
 	w http.ResponseWriter,
 	err error,
@@ -214,6 +962,8 @@ func handleGroupCreationErrors(
 		http.Error(w, "Group with such name already exists", http.StatusBadRequest)
 	case expenses.ErrUserIsInAnotherGroup:
 		http.Error(w, "User participates in another group", http.StatusBadRequest)
+	case authentication.ErrEmailNotVerified:
+		http.Error(w, EmailNotVerified, http.StatusForbidden)
 	default:
 		http.Error(w, ServerError, http.StatusInternalServerError)
 		log.Error("couldn't create group %s by user %d - %s", createGroupRequest.Name, createGroupRequest.CreatorID, err)
@@ -297,6 +1047,10 @@ func (router *Router) addToGroup(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, IncorrectValues, http.StatusBadRequest)
 			return
 		}
+		if err == authentication.ErrEmailNotVerified {
+			http.Error(w, EmailNotVerified, http.StatusForbidden)
+			return
+		}
 		http.Error(w, ServerError, http.StatusInternalServerError)
 		return
 	}
@@ -304,6 +1058,96 @@ func (router *Router) addToGroup(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// placeholders handles requests to /groups/placeholders endpoint. At the moment that's only creation of a placeholder
+// member in the group of the current user.
+// If everything is correct - responds with 201 and a claim code for the placeholder
+func (router *Router) placeholders(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var placeholderRequest expenses.CreatePlaceholderRequest
+	if err = json.NewDecoder(r.Body).Decode(&placeholderRequest); err != nil {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	if userContext.GroupID == 0 {
+		http.Error(w, IncorrectValues, http.StatusBadRequest)
+		return
+	}
+	created, err := router.groupService.CreatePlaceholder(r.Context(), expenses.CreatePlaceholderContext{
+		Name:    placeholderRequest.Name,
+		GroupID: userContext.GroupID,
+	})
+	if err == expenses.ErrUserOrGroupNotFound {
+		http.Error(w, IncorrectValues, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't create placeholder in group %d - %s", userContext.GroupID, err)
+		return
+	}
+	log.Info("user %d has created placeholder %d in group %d", userContext.UserID, created.ID, userContext.GroupID)
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(&created); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write body for create placeholder response - %s", err)
+	}
+}
+
+// claimPlaceholder handles requests to /groups/claim endpoint. Current user takes over placeholder member with
+// provided claim code together with its expenses and joins its group.
+// If everything is correct - responds with 200 and the group the user has joined
+func (router *Router) claimPlaceholder(w http.ResponseWriter, r *http.Request) {
+	userContext, err := authentication.ExtractUser(r)
+	if err != nil {
+		http.Error(w, Forbidden, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	var claimRequest expenses.ClaimPlaceholderRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&claimRequest); err != nil || claimRequest.ClaimCode == "" {
+		http.Error(w, IncorrectBody, http.StatusBadRequest)
+		return
+	}
+	group, err := router.groupService.ClaimPlaceholder(r.Context(), expenses.ClaimPlaceholderContext{
+		UserID:    userContext.UserID,
+		ClaimCode: claimRequest.ClaimCode,
+	})
+	switch err {
+	case nil:
+	case expenses.ErrPlaceholderNotFound:
+		http.Error(w, "Claim code is not valid", http.StatusBadRequest)
+		return
+	case expenses.ErrUserIsInAnotherGroup:
+		http.Error(w, "User participates in another group", http.StatusBadRequest)
+		return
+	case authentication.ErrEmailNotVerified:
+		http.Error(w, EmailNotVerified, http.StatusForbidden)
+		return
+	default:
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("user %d couldn't claim a placeholder - %s", userContext.UserID, err)
+		return
+	}
+	log.Info("user %d has claimed a placeholder and joined group %d", userContext.UserID, group.ID)
+	if err = json.NewEncoder(w).Encode(&group); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write body for claim placeholder response - %s", err)
+	}
+}
+
 // balance handles request to /balance endpoint. At the moment that's only GET of a balance for a current user.
 func (router *Router) balance(w http.ResponseWriter, r *http.Request) {
 	var err error
@@ -328,6 +1172,19 @@ func (router *Router) balance(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// jwks publishes public keys of access tokens, so that other services can verify them
+func (router *Router) jwks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, NotFound, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(router.publicKeys.PublicKeys()); err != nil {
+		http.Error(w, ServerError, http.StatusInternalServerError)
+		log.Error("couldn't write public keys - %s", err)
+	}
+}
+
 // health is simplest health check
 func (router *Router) health(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
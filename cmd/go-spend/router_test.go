@@ -47,6 +47,35 @@ func (m *mockAuthenticator) Authenticate(
 	return args.Get(0).(authentication.TokenResponse), args.Error(1)
 }
 
+type mockSessionService struct {
+	mock.Mock
+}
+
+func (m *mockSessionService) Refresh(refreshToken string) (authentication.TokenResponse, error) {
+	args := m.Called(refreshToken)
+	return args.Get(0).(authentication.TokenResponse), args.Error(1)
+}
+
+func (m *mockSessionService) Logout(userContext authentication.UserContext) error {
+	args := m.Called(userContext)
+	return args.Error(0)
+}
+
+func (m *mockSessionService) LogoutAll(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *mockSessionService) List(userContext authentication.UserContext) ([]authentication.Session, error) {
+	args := m.Called(userContext)
+	return args.Get(0).([]authentication.Session), args.Error(1)
+}
+
+func (m *mockSessionService) Delete(userID uint, sessionID string) error {
+	args := m.Called(userID, sessionID)
+	return args.Error(0)
+}
+
 type mockGroupService struct {
 	mock.Mock
 }
@@ -65,6 +94,22 @@ func (m *mockGroupService) AddUserToGroup(ctx context.Context, addRequest expens
 	return args.Error(0)
 }
 
+func (m *mockGroupService) CreatePlaceholder(
+	ctx context.Context,
+	request expenses.CreatePlaceholderContext,
+) (expenses.PlaceholderResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(expenses.PlaceholderResponse), args.Error(1)
+}
+
+func (m *mockGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request expenses.ClaimPlaceholderContext,
+) (expenses.GroupResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(expenses.GroupResponse), args.Error(1)
+}
+
 type mockAuthorizer struct {
 	mock.Mock
 }
@@ -103,27 +148,334 @@ func (m *mockBalanceService) Get(ctx context.Context, userID uint) (expenses.Bal
 	return args.Get(0).(expenses.Balance), args.Error(1)
 }
 
+type mockPasswordService struct {
+	mock.Mock
+}
+
+func (m *mockPasswordService) Forgot(ctx context.Context, email expenses.Email) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *mockPasswordService) Reset(ctx context.Context, request authentication.ResetPasswordRequest) error {
+	args := m.Called(ctx, request)
+	return args.Error(0)
+}
+
+func (m *mockPasswordService) Change(
+	ctx context.Context,
+	userContext authentication.UserContext,
+	request authentication.ChangePasswordRequest,
+) error {
+	args := m.Called(ctx, userContext, request)
+	return args.Error(0)
+}
+
+type mockProfileService struct {
+	mock.Mock
+}
+
+func (m *mockProfileService) Get(ctx context.Context, userID uint) (expenses.ProfileResponse, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(expenses.ProfileResponse), args.Error(1)
+}
+
+func (m *mockProfileService) Update(
+	ctx context.Context,
+	userID uint,
+	request expenses.UpdateProfileRequest,
+) (expenses.ProfileResponse, error) {
+	args := m.Called(ctx, userID, request)
+	return args.Get(0).(expenses.ProfileResponse), args.Error(1)
+}
+
+type mockEmailVerifier struct {
+	mock.Mock
+}
+
+func (m *mockEmailVerifier) SendVerification(ctx context.Context, userID uint, email expenses.Email) error {
+	args := m.Called(ctx, userID, email)
+	return args.Error(0)
+}
+
+func (m *mockEmailVerifier) Resend(ctx context.Context, email expenses.Email) error {
+	args := m.Called(ctx, email)
+	return args.Error(0)
+}
+
+func (m *mockEmailVerifier) Verify(ctx context.Context, token string) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+type mockPublicKeyProvider struct {
+	mock.Mock
+}
+
+func (m *mockPublicKeyProvider) PublicKeys() jwt.KeySet {
+	args := m.Called()
+	return args.Get(0).(jwt.KeySet)
+}
+
+type mockTwoFactorService struct {
+	mock.Mock
+}
+
+func (m *mockTwoFactorService) Enroll(
+	ctx context.Context,
+	userContext authentication.UserContext,
+) (authentication.TwoFactorEnrollment, error) {
+	args := m.Called(ctx, userContext)
+	return args.Get(0).(authentication.TwoFactorEnrollment), args.Error(1)
+}
+
+func (m *mockTwoFactorService) Confirm(
+	ctx context.Context,
+	userContext authentication.UserContext,
+	code string,
+) (authentication.RecoveryCodesResponse, error) {
+	args := m.Called(ctx, userContext, code)
+	return args.Get(0).(authentication.RecoveryCodesResponse), args.Error(1)
+}
+
+func (m *mockTwoFactorService) Disable(
+	ctx context.Context,
+	userContext authentication.UserContext,
+	password expenses.Password,
+) error {
+	args := m.Called(ctx, userContext, password)
+	return args.Error(0)
+}
+
+func (m *mockTwoFactorService) Verify(
+	ctx context.Context,
+	request authentication.TwoFactorAuthRequest,
+) (authentication.TokenResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(authentication.TokenResponse), args.Error(1)
+}
+
+type mockExternalAuthenticator struct {
+	mock.Mock
+}
+
+func (m *mockExternalAuthenticator) AuthorizationURL(ctx context.Context) (string, error) {
+	args := m.Called(ctx)
+	return args.String(0), args.Error(1)
+}
+
+func (m *mockExternalAuthenticator) Callback(
+	ctx context.Context,
+	code string,
+	state string,
+) (authentication.TokenResponse, error) {
+	args := m.Called(ctx, code, state)
+	return args.Get(0).(authentication.TokenResponse), args.Error(1)
+}
+
+type mockAccessTokenService struct {
+	mock.Mock
+}
+
+func (m *mockAccessTokenService) Create(
+	ctx context.Context,
+	userContext authentication.UserContext,
+	request authentication.CreateAccessTokenRequest,
+) (authentication.PersonalAccessTokenResponse, error) {
+	args := m.Called(ctx, userContext, request)
+	return args.Get(0).(authentication.PersonalAccessTokenResponse), args.Error(1)
+}
+
+func (m *mockAccessTokenService) List(
+	ctx context.Context,
+	userContext authentication.UserContext,
+) ([]authentication.PersonalAccessTokenResponse, error) {
+	args := m.Called(ctx, userContext)
+	return args.Get(0).([]authentication.PersonalAccessTokenResponse), args.Error(1)
+}
+
+func (m *mockAccessTokenService) Revoke(
+	ctx context.Context,
+	userContext authentication.UserContext,
+	tokenID uint,
+) error {
+	args := m.Called(ctx, userContext, tokenID)
+	return args.Error(0)
+}
+
+type mockAccountService struct {
+	mock.Mock
+}
+
+func (m *mockAccountService) Export(ctx context.Context, userID uint) (expenses.AccountExport, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(expenses.AccountExport), args.Error(1)
+}
+
+func (m *mockAccountService) Delete(
+	ctx context.Context,
+	userContext authentication.UserContext,
+	password expenses.Password,
+) error {
+	args := m.Called(ctx, userContext, password)
+	return args.Error(0)
+}
+
 func TestNewRouter(t *testing.T) {
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	assert.NotNil(t, router)
 }
 
+// denyingRequestLimiter rejects all requests
+type denyingRequestLimiter struct {
+}
+
+func (l denyingRequestLimiter) RateLimit(_ http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	}
+}
+
+func TestNewRouterRateLimitsEveryEndpoint(t *testing.T) {
+	// given
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		denyingRequestLimiter{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	paths := []string{
+		"/users",
+		"/users/verify",
+		"/authenticate",
+		"/authenticate/refresh",
+		"/authenticate/2fa",
+		"/authenticate/oidc",
+		"/authenticate/oidc/callback",
+		"/password/forgot",
+		"/password/reset",
+		"/users/me",
+		"/users/me/export",
+		"/users/me/password",
+		"/users/me/2fa",
+		"/users/me/2fa/confirm",
+		"/users/me/tokens",
+		"/users/me/tokens/1",
+		"/expenses",
+		"/groups",
+		"/groups/placeholders",
+		"/groups/claim",
+		"/logout",
+		"/logout/all",
+		"/sessions",
+		"/sessions/session",
+		"/balance",
+		"/.well-known/jwks.json",
+		"/health",
+	}
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, path, nil)
+			recorder := httptest.NewRecorder()
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+		})
+	}
+}
+
+// rejectingAuthorizer responds 401 to all requests
+type rejectingAuthorizer struct {
+}
+
+func (a rejectingAuthorizer) Authorize(_ http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+func TestNewRouterRateLimitsBeforeAuthorization(t *testing.T) {
+	// given
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		rejectingAuthorizer{},
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		denyingRequestLimiter{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	req := httptest.NewRequest(http.MethodGet, "/balance", nil)
+	req.Header.Set("Authorization", "Bearer gsp_guessed")
+	recorder := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+}
+
 func TestCreateUserWithProperParams(t *testing.T) {
 	// given
 	userService := new(mockUserService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		userService,
 	)
 
@@ -153,11 +505,21 @@ func TestCreateUserWithIncorrectMethod(t *testing.T) {
 	// given
 	userService := new(mockUserService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		userService,
 	)
 
@@ -178,11 +540,21 @@ func TestCreateUserWithIncorrectBody(t *testing.T) {
 	// given
 	userService := new(mockUserService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		userService,
 	)
 
@@ -205,11 +577,21 @@ func TestCreateUserWithEmptyFields(t *testing.T) {
 	// given
 	userService := new(mockUserService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		userService,
 	)
 
@@ -251,11 +633,21 @@ func TestCreateUserWithSomeIncorrectFields(t *testing.T) {
 			// given
 			userService := new(mockUserService)
 			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
 				new(mockAuthenticator),
 				new(mockAuthorizer),
 				new(mockBalanceService),
+				new(mockEmailVerifier),
 				new(mockExpensesService),
+				new(mockExternalAuthenticator),
 				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
 				userService,
 			)
 			jsonBody, err := json.Marshal(&test.body)
@@ -289,6 +681,28 @@ func TestCreateUserServiceError(t *testing.T) {
 			},
 			expectedCode: http.StatusBadRequest,
 		},
+		{
+			name: "password violates the policy",
+			prepareMock: func(userService *mockUserService) {
+				userService.On(
+					"Create",
+					mock.Anything,
+					mock.AnythingOfType("expenses.CreateUserRequest"),
+				).Return(expenses.UserResponse{}, authentication.ErrPasswordTooShort)
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "claim requires verified email",
+			prepareMock: func(userService *mockUserService) {
+				userService.On(
+					"Create",
+					mock.Anything,
+					mock.AnythingOfType("expenses.CreateUserRequest"),
+				).Return(expenses.UserResponse{}, authentication.ErrEmailNotVerified)
+			},
+			expectedCode: http.StatusForbidden,
+		},
 		{
 			name: "other errors",
 			prepareMock: func(userService *mockUserService) {
@@ -306,11 +720,21 @@ func TestCreateUserServiceError(t *testing.T) {
 			// given
 			userService := new(mockUserService)
 			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
 				new(mockAuthenticator),
 				new(mockAuthorizer),
 				new(mockBalanceService),
+				new(mockEmailVerifier),
 				new(mockExpensesService),
+				new(mockExternalAuthenticator),
 				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
 				userService,
 			)
 
@@ -333,11 +757,21 @@ func TestAuthenticateUser(t *testing.T) {
 	// given
 	authenticator := new(mockAuthenticator)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		authenticator,
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -351,7 +785,10 @@ func TestAuthenticateUser(t *testing.T) {
 	recorder := httptest.NewRecorder()
 
 	expectedTokenResponse := authentication.TokenResponse{AccessToken: "asjkhdakj17", RefreshToken: "sakhadkj71"}
-	authenticator.On("Authenticate", context.Background(), authRequest.Email, authRequest.Password).
+	requestWithClientInfo := mock.MatchedBy(func(ctx context.Context) bool {
+		return authentication.ExtractClientInfo(ctx).IP == "192.0.2.1" // default of httptest
+	})
+	authenticator.On("Authenticate", requestWithClientInfo, authRequest.Email, authRequest.Password).
 		Return(expectedTokenResponse, nil)
 
 	// when
@@ -380,16 +817,25 @@ func TestAuthenticateFailed(t *testing.T) {
 			expectedCode: http.StatusUnauthorized,
 			method:       http.MethodPost,
 			prepareMock: func(authenticator *mockAuthenticator) {
-				authenticator.On("Authenticate", context.Background(), authRequest.Email, authRequest.Password).
+				authenticator.On("Authenticate", mock.Anything, authRequest.Email, authRequest.Password).
 					Return(authentication.TokenResponse{}, authentication.ErrEmailOrPasswordIncorrect)
 			},
 		},
+		{
+			name:         "email is not verified",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPost,
+			prepareMock: func(authenticator *mockAuthenticator) {
+				authenticator.On("Authenticate", mock.Anything, authRequest.Email, authRequest.Password).
+					Return(authentication.TokenResponse{}, authentication.ErrEmailNotVerified)
+			},
+		},
 		{
 			name:         "server error",
 			expectedCode: http.StatusInternalServerError,
 			method:       http.MethodPost,
 			prepareMock: func(authenticator *mockAuthenticator) {
-				authenticator.On("Authenticate", context.Background(), authRequest.Email, authRequest.Password).
+				authenticator.On("Authenticate", mock.Anything, authRequest.Email, authRequest.Password).
 					Return(authentication.TokenResponse{}, errors.New("some other error"))
 			},
 		},
@@ -406,11 +852,21 @@ func TestAuthenticateFailed(t *testing.T) {
 			// given
 			authenticator := new(mockAuthenticator)
 			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
 				authenticator,
 				new(mockAuthorizer),
 				new(mockBalanceService),
+				new(mockEmailVerifier),
 				new(mockExpensesService),
+				new(mockExternalAuthenticator),
 				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
 				new(mockUserService),
 			)
 			body, err := json.Marshal(&authRequest)
@@ -428,15 +884,60 @@ func TestAuthenticateFailed(t *testing.T) {
 	}
 }
 
+func TestAuthenticateThrottled(t *testing.T) {
+	// given
+	authenticator := new(mockAuthenticator)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		authenticator,
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	authenticator.On("Authenticate", mock.Anything, expenses.Email("some@mail.com"), expenses.Password("1234")).
+		Return(authentication.TokenResponse{}, authentication.TooManyAttemptsError{RetryAfter: 1500 * time.Millisecond})
+	body := `{"email":"some@mail.com","password":"1234"}`
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewBufferString(body))
+	recorder := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "2", recorder.Header().Get("Retry-After"))
+}
+
 func TestRouterCreateGroup(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -521,6 +1022,16 @@ func TestCreateGroupErrors(t *testing.T) {
 					Return(expenses.GroupResponse{}, expenses.ErrUserIsInAnotherGroup)
 			},
 		},
+		{
+			name:         "creator email is not verified",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPost,
+			request:      body,
+			prepareMock: func(service *mockGroupService) {
+				service.On("Create", mock.Anything, groupContext).
+					Return(expenses.GroupResponse{}, authentication.ErrEmailNotVerified)
+			},
+		},
 		{
 			name:         "incorrect method",
 			expectedCode: http.StatusNotFound,
@@ -550,11 +1061,21 @@ func TestCreateGroupErrors(t *testing.T) {
 			// given
 			groupService := new(mockGroupService)
 			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
 				new(mockAuthenticator),
 				new(mockAuthorizer),
 				new(mockBalanceService),
+				new(mockEmailVerifier),
 				new(mockExpensesService),
+				new(mockExternalAuthenticator),
 				groupService,
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
 				new(mockUserService),
 			)
 
@@ -577,11 +1098,21 @@ func TestRouterCreateGroupUserInGroupInContext(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -610,11 +1141,21 @@ func TestRouterCreateGroupNotUserInContext(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -638,11 +1179,21 @@ func TestCreateGroupWithoutAuthorizationWithJWTAuthorizerForbidden(t *testing.T)
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		authentication.NewJWTAuthorizer(jwt.HmacSha256("key"), new(mockTokenRetriever)),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -681,11 +1232,21 @@ func TestCreateGroupWithAuthorizationWithJWTAuthorizerCreated(t *testing.T) {
 	alg := jwt.HmacSha256("key")
 	tokenUUID, validJWT := prepareValidJWT(t, alg)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		authentication.NewJWTAuthorizer(alg, tokenRetriever),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -729,11 +1290,21 @@ func TestCreateExpense(t *testing.T) {
 	// given
 	expensesService := new(mockExpensesService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		expensesService,
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -781,11 +1352,21 @@ func TestCreateExpenseIncorrectBody(t *testing.T) {
 	// given
 	expensesService := new(mockExpensesService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		expensesService,
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -810,11 +1391,21 @@ func TestCreateExpenseNoUserForbidden(t *testing.T) {
 	// given
 	expensesService := new(mockExpensesService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		expensesService,
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -832,11 +1423,21 @@ func TestCreateExpenseNonProperContextBadRequest(t *testing.T) {
 	// given
 	expensesService := new(mockExpensesService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		expensesService,
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -869,11 +1470,21 @@ func TestCreateExpenseServiceFailsServerError(t *testing.T) {
 	// given
 	expensesService := new(mockExpensesService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		expensesService,
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 
@@ -911,11 +1522,21 @@ func TestAddToGroup(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -946,11 +1567,21 @@ func TestAddToGroupForbiddenWithoutUserContext(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	addRequest := expenses.AddToGroupRequest{
@@ -973,11 +1604,21 @@ func TestAddToGroupForbiddenWithWrongStuffInContext(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	addRequest := expenses.AddToGroupRequest{
@@ -1003,11 +1644,21 @@ func TestAddToGroupIncorrectBodyBadRequest(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1030,11 +1681,21 @@ func TestAddToGroupDifferentGroupOfCallerForbidden(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1063,11 +1724,21 @@ func TestAddToGroupErrUserOrGroupNotFoundBadRequest(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1099,11 +1770,21 @@ func TestAddToGroupErrUserIsInAnotherGroupBadRequest(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1135,11 +1816,21 @@ func TestAddToGroupOtherErrServerError(t *testing.T) {
 	// given
 	groupService := new(mockGroupService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1171,11 +1862,21 @@ func TestGetBalance(t *testing.T) {
 	// given
 	balanceService := new(mockBalanceService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		balanceService,
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1205,11 +1906,21 @@ func TestGetBalanceNoUserInContextForbidden(t *testing.T) {
 	// given
 	balanceService := new(mockBalanceService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		balanceService,
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1227,11 +1938,21 @@ func TestGetBalanceWrongMethodNotFound(t *testing.T) {
 	// given
 	balanceService := new(mockBalanceService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		balanceService,
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1250,11 +1971,21 @@ func TestGetBalanceServiceReturnsError(t *testing.T) {
 	// given
 	balanceService := new(mockBalanceService)
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		balanceService,
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1275,11 +2006,21 @@ func TestGetBalanceServiceReturnsError(t *testing.T) {
 func TestRouterHealth(t *testing.T) {
 	// given
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1296,11 +2037,21 @@ func TestRouterHealth(t *testing.T) {
 func TestRouterHealthWithIncorrectHTTPMethod(t *testing.T) {
 	// given
 	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
 		new(mockAuthenticator),
 		new(mockAuthorizer),
 		new(mockBalanceService),
+		new(mockEmailVerifier),
 		new(mockExpensesService),
+		new(mockExternalAuthenticator),
 		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
 		new(mockUserService),
 	)
 	// that is done by authorizer in real app
@@ -1322,3 +2073,2594 @@ func prepareValidJWT(t *testing.T, accessAlg *jwt.Algorithm) (string, string) {
 	require.NoError(t, err)
 	return accessUUID, accessJWT
 }
+
+func TestCreatePlaceholder(t *testing.T) {
+	// given
+	groupService := new(mockGroupService)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	userContext := authentication.UserContext{
+		UserID:  1,
+		GroupID: 22,
+	}
+	req := httptest.NewRequest(http.MethodPost, "/groups/placeholders", bytes.NewBufferString(`{"name":"Bob"}`))
+	reqWithContext := req.WithContext(context.WithValue(req.Context(), "user", userContext))
+	recorder := httptest.NewRecorder()
+	expected := expenses.PlaceholderResponse{ID: 3, Name: "Bob", ClaimCode: "code"}
+	groupService.On(
+		"CreatePlaceholder",
+		reqWithContext.Context(),
+		expenses.CreatePlaceholderContext{Name: "Bob", GroupID: userContext.GroupID},
+	).Return(expected, nil)
+
+	// when
+	router.ServeHTTP(recorder, reqWithContext)
+
+	// then
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	var result expenses.PlaceholderResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&result))
+	assert.Equal(t, expected, result)
+}
+
+func TestCreatePlaceholderErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		userContext  authentication.UserContext
+		serviceError error
+		expectedCode int
+	}{
+		{
+			name:         "user not in group",
+			body:         `{"name":"Bob"}`,
+			userContext:  authentication.UserContext{UserID: 1},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "empty name",
+			body:         `{"name":""}`,
+			userContext:  authentication.UserContext{UserID: 1, GroupID: 2},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "group not found",
+			body:         `{"name":"Bob"}`,
+			userContext:  authentication.UserContext{UserID: 1, GroupID: 2},
+			serviceError: expenses.ErrUserOrGroupNotFound,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unexpected error",
+			body:         `{"name":"Bob"}`,
+			userContext:  authentication.UserContext{UserID: 1, GroupID: 2},
+			serviceError: errors.New("expected"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			groupService := new(mockGroupService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				groupService,
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(http.MethodPost, "/groups/placeholders", bytes.NewBufferString(test.body))
+			req = req.WithContext(context.WithValue(req.Context(), "user", test.userContext))
+			recorder := httptest.NewRecorder()
+			groupService.On("CreatePlaceholder", mock.Anything, mock.Anything).
+				Return(expenses.PlaceholderResponse{}, test.serviceError)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestClaimPlaceholder(t *testing.T) {
+	// given
+	groupService := new(mockGroupService)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		groupService,
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	userContext := authentication.UserContext{UserID: 1}
+	req := httptest.NewRequest(http.MethodPost, "/groups/claim", bytes.NewBufferString(`{"claimCode":"code"}`))
+	reqWithContext := req.WithContext(context.WithValue(req.Context(), "user", userContext))
+	recorder := httptest.NewRecorder()
+	expected := expenses.GroupResponse{
+		ID:    2,
+		Name:  "group",
+		Users: []expenses.UserResponse{{ID: 1, Email: "some@mail.com", DisplayName: "Bob"}},
+	}
+	groupService.On(
+		"ClaimPlaceholder",
+		reqWithContext.Context(),
+		expenses.ClaimPlaceholderContext{UserID: 1, ClaimCode: "code"},
+	).Return(expected, nil)
+
+	// when
+	router.ServeHTTP(recorder, reqWithContext)
+
+	// then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var result expenses.GroupResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&result))
+	assert.Equal(t, expected, result)
+}
+
+func TestClaimPlaceholderErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		serviceError error
+		expectedCode int
+	}{
+		{
+			name:         "no claim code",
+			body:         `{}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "placeholder not found",
+			body:         `{"claimCode":"code"}`,
+			serviceError: expenses.ErrPlaceholderNotFound,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "user in another group",
+			body:         `{"claimCode":"code"}`,
+			serviceError: expenses.ErrUserIsInAnotherGroup,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "email is not verified",
+			body:         `{"claimCode":"code"}`,
+			serviceError: authentication.ErrEmailNotVerified,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unexpected error",
+			body:         `{"claimCode":"code"}`,
+			serviceError: errors.New("expected"),
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			groupService := new(mockGroupService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				groupService,
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(http.MethodPost, "/groups/claim", bytes.NewBufferString(test.body))
+			req = req.WithContext(context.WithValue(req.Context(), "user", authentication.UserContext{UserID: 1}))
+			recorder := httptest.NewRecorder()
+			groupService.On("ClaimPlaceholder", mock.Anything, mock.Anything).
+				Return(expenses.GroupResponse{}, test.serviceError)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestCreateUserWithUnknownClaimCode(t *testing.T) {
+	// given
+	userService := new(mockUserService)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		userService,
+	)
+	body := `{"email": "some@mail.com", "password": "1234", "claimCode": "code"}`
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(body))
+	recorder := httptest.NewRecorder()
+	userService.On(
+		"Create",
+		mock.Anything,
+		expenses.CreateUserRequest{Email: "some@mail.com", Password: "1234", ClaimCode: "code"},
+	).Return(expenses.UserResponse{}, expenses.ErrPlaceholderNotFound)
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRefreshTokens(t *testing.T) {
+	// given
+	sessionService := new(mockSessionService)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		sessionService,
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	body, err := json.Marshal(&authentication.RefreshRequest{RefreshToken: "old"})
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/authenticate/refresh", bytes.NewBuffer(body))
+	recorder := httptest.NewRecorder()
+	expectedTokenResponse := authentication.TokenResponse{AccessToken: "asjkhdakj17", RefreshToken: "sakhadkj71"}
+	sessionService.On("Refresh", "old").Return(expectedTokenResponse, nil)
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response authentication.TokenResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, expectedTokenResponse, response)
+}
+
+func TestRefreshTokensFailed(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		prepareMock  func(*mockSessionService)
+	}{
+		{
+			name:         "invalid token",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodPost,
+			body:         `{"refreshToken":"old"}`,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Refresh", "old").
+					Return(authentication.TokenResponse{}, authentication.ErrInvalidRefreshToken)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			body:         `{"refreshToken":"old"}`,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Refresh", "old").
+					Return(authentication.TokenResponse{}, errors.New("some other error"))
+			},
+		},
+		{
+			name:         "empty token",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"refreshToken":""}`,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			body:         `{"refreshToken":"old"}`,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sessionService := new(mockSessionService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				sessionService,
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/authenticate/refresh", bytes.NewBufferString(test.body))
+			recorder := httptest.NewRecorder()
+			test.prepareMock(sessionService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		withUser     bool
+		prepareMock  func(*mockSessionService)
+	}{
+		{
+			name:         "logged out",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Logout", userContext).Return(nil)
+			},
+		},
+		{
+			name:         "no session",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Logout", userContext).Return(authentication.ErrSessionNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Logout", userContext).Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPost,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sessionService := new(mockSessionService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				sessionService,
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/logout", nil)
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(sessionService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			sessionService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		withUser     bool
+		prepareMock  func(*mockSessionService)
+	}{
+		{
+			name:         "logged out",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("LogoutAll", userContext.UserID).Return(nil)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("LogoutAll", userContext.UserID).Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPost,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sessionService := new(mockSessionService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				sessionService,
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/logout/all", nil)
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(sessionService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			sessionService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	// given
+	sessionService := new(mockSessionService)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		sessionService,
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "current"}
+	now := time.Now().Truncate(time.Second)
+	expected := []authentication.Session{
+		{ID: "other", CreatedAt: now, LastUsedAt: now, ClientIP: "10.0.0.1", UserAgent: "curl/7.74.0"},
+		{ID: "current", CreatedAt: now, LastUsedAt: now, ClientIP: "10.0.0.2", Current: true},
+	}
+	sessionService.On("List", userContext).Return(expected, nil)
+	req := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+	recorder := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var sessions []authentication.Session
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&sessions))
+	require.Len(t, sessions, 2)
+	for i := range expected {
+		assert.Equal(t, expected[i].ID, sessions[i].ID)
+		assert.True(t, expected[i].CreatedAt.Equal(sessions[i].CreatedAt))
+		assert.Equal(t, expected[i].ClientIP, sessions[i].ClientIP)
+		assert.Equal(t, expected[i].UserAgent, sessions[i].UserAgent)
+		assert.Equal(t, expected[i].Current, sessions[i].Current)
+	}
+}
+
+func TestListSessionsErrors(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "current"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		withUser     bool
+		prepareMock  func(*mockSessionService)
+	}{
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("List", userContext).Return([]authentication.Session(nil), errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodGet,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sessionService := new(mockSessionService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				sessionService,
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/sessions", nil)
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(sessionService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+		})
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "current"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		path         string
+		withUser     bool
+		prepareMock  func(*mockSessionService)
+	}{
+		{
+			name:         "deleted",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodDelete,
+			path:         "/sessions/other",
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Delete", userContext.UserID, "other").Return(nil)
+			},
+		},
+		{
+			name:         "unknown session",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodDelete,
+			path:         "/sessions/other",
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Delete", userContext.UserID, "other").Return(authentication.ErrSessionNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodDelete,
+			path:         "/sessions/other",
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+				sessionService.On("Delete", userContext.UserID, "other").Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "no session id",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodDelete,
+			path:         "/sessions/",
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodDelete,
+			path:         "/sessions/other",
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			path:         "/sessions/other",
+			withUser:     true,
+			prepareMock: func(sessionService *mockSessionService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			sessionService := new(mockSessionService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				sessionService,
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(sessionService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			sessionService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	// given
+	publicKeys := new(mockPublicKeyProvider)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		publicKeys,
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	keySet := jwt.KeySet{Keys: []jwt.JWK{{Kty: "OKP", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: "11qYAYKxCrfVS"}}}
+	publicKeys.On("PublicKeys").Return(keySet)
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	assert.JSONEq(
+		t,
+		`{"keys":[{"kty":"OKP","use":"sig","alg":"EdDSA","crv":"Ed25519","x":"11qYAYKxCrfVS"}]}`,
+		recorder.Body.String(),
+	)
+}
+
+func TestJWKSWithIncorrectHTTPMethod(t *testing.T) {
+	// given
+	publicKeys := new(mockPublicKeyProvider)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		publicKeys,
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	req := httptest.NewRequest(http.MethodPost, "/.well-known/jwks.json", nil)
+	recorder := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+	publicKeys.AssertNotCalled(t, "PublicKeys")
+}
+
+func TestForgotPassword(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		prepareMock  func(*mockPasswordService)
+	}{
+		{
+			name:         "link is sent",
+			expectedCode: http.StatusAccepted,
+			method:       http.MethodPost,
+			body:         `{"email":"user@mail.com"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Forgot", mock.Anything, expenses.Email("user@mail.com")).Return(nil)
+			},
+		},
+		{
+			name:         "incorrect email",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"email":"user"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			body:         `{"email":"user@mail.com"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Forgot", mock.Anything, expenses.Email("user@mail.com")).
+					Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			body:         `{"email":"user@mail.com"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			passwordService := new(mockPasswordService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				passwordService,
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/password/forgot", bytes.NewBufferString(test.body))
+			recorder := httptest.NewRecorder()
+			test.prepareMock(passwordService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			passwordService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	expectedRequest := authentication.ResetPasswordRequest{Token: "reset-token", Password: "new"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		prepareMock  func(*mockPasswordService)
+	}{
+		{
+			name:         "password is reset",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodPost,
+			body:         `{"token":"reset-token","password":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Reset", mock.Anything, expectedRequest).Return(nil)
+			},
+		},
+		{
+			name:         "invalid token",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"token":"reset-token","password":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Reset", mock.Anything, expectedRequest).
+					Return(authentication.ErrInvalidResetToken)
+			},
+		},
+		{
+			name:         "empty token",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"token":"","password":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+		{
+			name:         "empty password",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"token":"reset-token","password":""}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+		{
+			name:         "password violates the policy",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"token":"reset-token","password":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Reset", mock.Anything, expectedRequest).
+					Return(authentication.ErrPasswordBreached)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			body:         `{"token":"reset-token","password":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Reset", mock.Anything, expectedRequest).Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPut,
+			body:         `{"token":"reset-token","password":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			passwordService := new(mockPasswordService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				passwordService,
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/password/reset", bytes.NewBufferString(test.body))
+			recorder := httptest.NewRecorder()
+			test.prepareMock(passwordService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			passwordService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		target       string
+		body         string
+		prepareMock  func(*mockEmailVerifier)
+	}{
+		{
+			name:         "email is verified",
+			expectedCode: http.StatusOK,
+			method:       http.MethodGet,
+			target:       "/users/verify?token=verify-token",
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+				emailVerifier.On("Verify", mock.Anything, "verify-token").Return(nil)
+			},
+		},
+		{
+			name:         "invalid token",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodGet,
+			target:       "/users/verify?token=verify-token",
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+				emailVerifier.On("Verify", mock.Anything, "verify-token").
+					Return(authentication.ErrInvalidVerificationToken)
+			},
+		},
+		{
+			name:         "no token",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodGet,
+			target:       "/users/verify",
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodGet,
+			target:       "/users/verify?token=verify-token",
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+				emailVerifier.On("Verify", mock.Anything, "verify-token").Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "link is resent",
+			expectedCode: http.StatusAccepted,
+			method:       http.MethodPost,
+			target:       "/users/verify",
+			body:         `{"email":"user@mail.com"}`,
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+				emailVerifier.On("Resend", mock.Anything, expenses.Email("user@mail.com")).Return(nil)
+			},
+		},
+		{
+			name:         "resend with incorrect email",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			target:       "/users/verify",
+			body:         `{"email":"user"}`,
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+			},
+		},
+		{
+			name:         "resend server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			target:       "/users/verify",
+			body:         `{"email":"user@mail.com"}`,
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+				emailVerifier.On("Resend", mock.Anything, expenses.Email("user@mail.com")).
+					Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodDelete,
+			target:       "/users/verify?token=verify-token",
+			prepareMock: func(emailVerifier *mockEmailVerifier) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			emailVerifier := new(mockEmailVerifier)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				emailVerifier,
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, test.target, bytes.NewBufferString(test.body))
+			recorder := httptest.NewRecorder()
+			test.prepareMock(emailVerifier)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			emailVerifier.AssertExpectations(t)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	expectedRequest := authentication.ChangePasswordRequest{CurrentPassword: "old", NewPassword: "new"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		withUser     bool
+		prepareMock  func(*mockPasswordService)
+	}{
+		{
+			name:         "password is changed",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodPut,
+			body:         `{"currentPassword":"old","newPassword":"new"}`,
+			withUser:     true,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Change", mock.Anything, userContext, expectedRequest).Return(nil)
+			},
+		},
+		{
+			name:         "current password is incorrect",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPut,
+			body:         `{"currentPassword":"old","newPassword":"new"}`,
+			withUser:     true,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Change", mock.Anything, userContext, expectedRequest).
+					Return(authentication.ErrCurrentPasswordIncorrect)
+			},
+		},
+		{
+			name:         "new password violates the policy",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPut,
+			body:         `{"currentPassword":"old","newPassword":"new"}`,
+			withUser:     true,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Change", mock.Anything, userContext, expectedRequest).
+					Return(authentication.ErrPasswordTooSimple)
+			},
+		},
+		{
+			name:         "empty new password",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPut,
+			body:         `{"currentPassword":"old","newPassword":""}`,
+			withUser:     true,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPut,
+			body:         `{"currentPassword":"old","newPassword":"new"}`,
+			withUser:     true,
+			prepareMock: func(passwordService *mockPasswordService) {
+				passwordService.On("Change", mock.Anything, userContext, expectedRequest).
+					Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPut,
+			body:         `{"currentPassword":"old","newPassword":"new"}`,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPost,
+			body:         `{"currentPassword":"old","newPassword":"new"}`,
+			withUser:     true,
+			prepareMock: func(passwordService *mockPasswordService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			passwordService := new(mockPasswordService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				passwordService,
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/users/me/password", bytes.NewBufferString(test.body))
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(passwordService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			passwordService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateReturnsOnlyMFAToken(t *testing.T) {
+	// given
+	authenticator := new(mockAuthenticator)
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		authenticator,
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		new(mockExternalAuthenticator),
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	authenticator.On("Authenticate", mock.Anything, expenses.Email("some@mail.com"), expenses.Password("1234")).
+		Return(authentication.TokenResponse{MFAToken: "mfa-token"}, nil)
+	body := `{"email":"some@mail.com","password":"1234"}`
+	req := httptest.NewRequest(http.MethodPost, "/authenticate", bytes.NewBufferString(body))
+	recorder := httptest.NewRecorder()
+
+	// when
+	router.ServeHTTP(recorder, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"mfaToken":"mfa-token"}`, recorder.Body.String())
+}
+
+func TestAuthenticateTwoFactor(t *testing.T) {
+	expectedRequest := authentication.TwoFactorAuthRequest{MFAToken: "mfa-token", Code: "123456"}
+	expectedTokens := authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		prepareMock  func(*mockTwoFactorService)
+	}{
+		{
+			name:         "tokens are issued",
+			expectedCode: http.StatusOK,
+			method:       http.MethodPost,
+			body:         `{"mfaToken":"mfa-token","code":"123456"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Verify", mock.Anything, expectedRequest).Return(expectedTokens, nil)
+			},
+		},
+		{
+			name:         "invalid mfa token",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodPost,
+			body:         `{"mfaToken":"mfa-token","code":"123456"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Verify", mock.Anything, expectedRequest).
+					Return(authentication.TokenResponse{}, authentication.ErrInvalidMFAToken)
+			},
+		},
+		{
+			name:         "incorrect code",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodPost,
+			body:         `{"mfaToken":"mfa-token","code":"123456"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Verify", mock.Anything, expectedRequest).
+					Return(authentication.TokenResponse{}, authentication.ErrTwoFactorCodeIncorrect)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			body:         `{"mfaToken":"mfa-token","code":"123456"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Verify", mock.Anything, expectedRequest).
+					Return(authentication.TokenResponse{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "empty code",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"mfaToken":"mfa-token","code":""}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+		{
+			name:         "incorrect body",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"token":"mfa-token"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			body:         `{"mfaToken":"mfa-token","code":"123456"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			twoFactorService := new(mockTwoFactorService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				twoFactorService,
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/authenticate/2fa", bytes.NewBufferString(test.body))
+			recorder := httptest.NewRecorder()
+			test.prepareMock(twoFactorService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			twoFactorService.AssertExpectations(t)
+			if test.expectedCode == http.StatusOK {
+				var actual authentication.TokenResponse
+				require.NoError(t, json.NewDecoder(recorder.Body).Decode(&actual))
+				assert.Equal(t, expectedTokens, actual)
+			}
+		})
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	enrollment := authentication.TwoFactorEnrollment{Secret: "SECRET", URI: "otpauth://totp/go-spend:user?secret=SECRET"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		withUser     bool
+		prepareMock  func(*mockTwoFactorService)
+	}{
+		{
+			name:         "secret is enrolled",
+			expectedCode: http.StatusOK,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Enroll", mock.Anything, userContext).Return(enrollment, nil)
+			},
+		},
+		{
+			name:         "enroll when already enabled",
+			expectedCode: http.StatusConflict,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Enroll", mock.Anything, userContext).
+					Return(authentication.TwoFactorEnrollment{}, expenses.ErrTwoFactorAlreadyEnabled)
+			},
+		},
+		{
+			name:         "enroll server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Enroll", mock.Anything, userContext).
+					Return(authentication.TwoFactorEnrollment{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "disabled",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodDelete,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Disable", mock.Anything, userContext, expenses.Password("password")).Return(nil)
+			},
+		},
+		{
+			name:         "disable with incorrect password",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodDelete,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Disable", mock.Anything, userContext, expenses.Password("password")).
+					Return(authentication.ErrCurrentPasswordIncorrect)
+			},
+		},
+		{
+			name:         "disable when not enabled",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodDelete,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Disable", mock.Anything, userContext, expenses.Password("password")).
+					Return(expenses.ErrTwoFactorNotFound)
+			},
+		},
+		{
+			name:         "disable without password",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodDelete,
+			body:         `{}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+		{
+			name:         "disable server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodDelete,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Disable", mock.Anything, userContext, expenses.Password("password")).
+					Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPost,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			twoFactorService := new(mockTwoFactorService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				twoFactorService,
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/users/me/2fa", bytes.NewBufferString(test.body))
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(twoFactorService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			twoFactorService.AssertExpectations(t)
+			if test.expectedCode == http.StatusOK {
+				var actual authentication.TwoFactorEnrollment
+				require.NoError(t, json.NewDecoder(recorder.Body).Decode(&actual))
+				assert.Equal(t, enrollment, actual)
+			}
+		})
+	}
+}
+
+func TestConfirmTwoFactor(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	recoveryCodes := authentication.RecoveryCodesResponse{RecoveryCodes: []string{"abcd-efgh", "ijkl-mnop"}}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		withUser     bool
+		prepareMock  func(*mockTwoFactorService)
+	}{
+		{
+			name:         "two-factor authentication is enabled",
+			expectedCode: http.StatusOK,
+			method:       http.MethodPost,
+			body:         `{"code":"123456"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Confirm", mock.Anything, userContext, "123456").Return(recoveryCodes, nil)
+			},
+		},
+		{
+			name:         "incorrect code",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"code":"123456"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Confirm", mock.Anything, userContext, "123456").
+					Return(authentication.RecoveryCodesResponse{}, authentication.ErrTwoFactorCodeIncorrect)
+			},
+		},
+		{
+			name:         "not enrolled",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"code":"123456"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Confirm", mock.Anything, userContext, "123456").
+					Return(authentication.RecoveryCodesResponse{}, expenses.ErrTwoFactorNotFound)
+			},
+		},
+		{
+			name:         "already enabled",
+			expectedCode: http.StatusConflict,
+			method:       http.MethodPost,
+			body:         `{"code":"123456"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Confirm", mock.Anything, userContext, "123456").
+					Return(authentication.RecoveryCodesResponse{}, expenses.ErrTwoFactorAlreadyEnabled)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			body:         `{"code":"123456"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+				twoFactorService.On("Confirm", mock.Anything, userContext, "123456").
+					Return(authentication.RecoveryCodesResponse{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "empty code",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"code":""}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodPost,
+			body:         `{"code":"123456"}`,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPut,
+			body:         `{"code":"123456"}`,
+			withUser:     true,
+			prepareMock: func(twoFactorService *mockTwoFactorService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			twoFactorService := new(mockTwoFactorService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				twoFactorService,
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/users/me/2fa/confirm", bytes.NewBufferString(test.body))
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(twoFactorService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			twoFactorService.AssertExpectations(t)
+			if test.expectedCode == http.StatusOK {
+				var actual authentication.RecoveryCodesResponse
+				require.NoError(t, json.NewDecoder(recorder.Body).Decode(&actual))
+				assert.Equal(t, recoveryCodes, actual)
+			}
+		})
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	createdAt := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2030, 10, 1, 12, 0, 0, 0, time.UTC)
+	createRequest := authentication.CreateAccessTokenRequest{
+		Name:      "script",
+		Scopes:    []string{authentication.ScopeRead},
+		ExpiresAt: &expiresAt,
+	}
+	created := authentication.PersonalAccessTokenResponse{
+		ID:        3,
+		Name:      "script",
+		Scopes:    []string{authentication.ScopeRead},
+		CreatedAt: createdAt,
+		ExpiresAt: &expiresAt,
+		Token:     "gsp_token",
+	}
+	listed := []authentication.PersonalAccessTokenResponse{
+		{ID: 3, Name: "script", Scopes: []string{authentication.ScopeRead}, CreatedAt: createdAt},
+	}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		body         string
+		withUser     bool
+		expectedBody interface{}
+		prepareMock  func(*mockAccessTokenService)
+	}{
+		{
+			name:         "token is created",
+			expectedCode: http.StatusCreated,
+			method:       http.MethodPost,
+			body:         `{"name":"script","scopes":["read"],"expiresAt":"2030-10-01T12:00:00Z"}`,
+			withUser:     true,
+			expectedBody: &created,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("Create", mock.Anything, userContext, createRequest).Return(created, nil)
+			},
+		},
+		{
+			name:         "create with expiration in the past",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"name":"script","scopes":["read"],"expiresAt":"2030-10-01T12:00:00Z"}`,
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("Create", mock.Anything, userContext, createRequest).
+					Return(authentication.PersonalAccessTokenResponse{}, authentication.ErrAccessTokenExpirationIncorrect)
+			},
+		},
+		{
+			name:         "create with unknown scope",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPost,
+			body:         `{"name":"script","scopes":["admin"]}`,
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+			},
+		},
+		{
+			name:         "create server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPost,
+			body:         `{"name":"script","scopes":["read"],"expiresAt":"2030-10-01T12:00:00Z"}`,
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("Create", mock.Anything, userContext, createRequest).
+					Return(authentication.PersonalAccessTokenResponse{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "tokens are listed",
+			expectedCode: http.StatusOK,
+			method:       http.MethodGet,
+			withUser:     true,
+			expectedBody: &listed,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("List", mock.Anything, userContext).Return(listed, nil)
+			},
+		},
+		{
+			name:         "list server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("List", mock.Anything, userContext).
+					Return([]authentication.PersonalAccessTokenResponse(nil), errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodGet,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPut,
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			accessTokenService := new(mockAccessTokenService)
+			router := main.NewRouter(
+				accessTokenService,
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/users/me/tokens", bytes.NewBufferString(test.body))
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(accessTokenService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			accessTokenService.AssertExpectations(t)
+			if test.expectedBody != nil {
+				expected, err := json.Marshal(test.expectedBody)
+				require.NoError(t, err)
+				assert.JSONEq(t, string(expected), recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRevokeAccessToken(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		path         string
+		withUser     bool
+		prepareMock  func(*mockAccessTokenService)
+	}{
+		{
+			name:         "token is revoked",
+			expectedCode: http.StatusNoContent,
+			method:       http.MethodDelete,
+			path:         "/users/me/tokens/3",
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("Revoke", mock.Anything, userContext, uint(3)).Return(nil)
+			},
+		},
+		{
+			name:         "token not found",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodDelete,
+			path:         "/users/me/tokens/3",
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("Revoke", mock.Anything, userContext, uint(3)).
+					Return(expenses.ErrAccessTokenNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodDelete,
+			path:         "/users/me/tokens/3",
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+				accessTokenService.On("Revoke", mock.Anything, userContext, uint(3)).Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "incorrect token ID",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodDelete,
+			path:         "/users/me/tokens/abc",
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodDelete,
+			path:         "/users/me/tokens/3",
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			path:         "/users/me/tokens/3",
+			withUser:     true,
+			prepareMock: func(accessTokenService *mockAccessTokenService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			accessTokenService := new(mockAccessTokenService)
+			router := main.NewRouter(
+				accessTokenService,
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(accessTokenService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			accessTokenService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateExternal(t *testing.T) {
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		prepareMock  func(*mockExternalAuthenticator)
+	}{
+		{
+			name:         "redirects to identity provider",
+			expectedCode: http.StatusFound,
+			method:       http.MethodGet,
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("AuthorizationURL", mock.Anything).
+					Return("https://idp.example.com/authorize?state=state", nil)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodGet,
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("AuthorizationURL", mock.Anything).Return("", errors.New("expected"))
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPost,
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			externalAuthenticator := new(mockExternalAuthenticator)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				externalAuthenticator,
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/authenticate/oidc", nil)
+			recorder := httptest.NewRecorder()
+			test.prepareMock(externalAuthenticator)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			if test.expectedCode == http.StatusFound {
+				assert.Equal(t, "https://idp.example.com/authorize?state=state", recorder.Header().Get("Location"))
+			}
+			externalAuthenticator.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAuthenticateExternalDisabled(t *testing.T) {
+	// given
+	router := main.NewRouter(
+		new(mockAccessTokenService),
+		new(mockAccountService),
+		new(mockAuthenticator),
+		new(mockAuthorizer),
+		new(mockBalanceService),
+		new(mockEmailVerifier),
+		new(mockExpensesService),
+		nil,
+		new(mockGroupService),
+		authentication.RequestLimiters{},
+		new(mockPasswordService),
+		new(mockProfileService),
+		new(mockPublicKeyProvider),
+		new(mockSessionService),
+		new(mockTwoFactorService),
+		new(mockUserService),
+	)
+	for _, target := range []string{"/authenticate/oidc", "/authenticate/oidc/callback?code=code&state=state"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		recorder := httptest.NewRecorder()
+
+		// when
+		router.ServeHTTP(recorder, req)
+
+		// then
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	}
+}
+
+func TestAuthenticateExternalCallback(t *testing.T) {
+	tokens := authentication.TokenResponse{AccessToken: "access", RefreshToken: "refresh"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		method       string
+		target       string
+		prepareMock  func(*mockExternalAuthenticator)
+	}{
+		{
+			name:         "user is authenticated",
+			expectedCode: http.StatusOK,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").Return(tokens, nil)
+			},
+		},
+		{
+			name:         "identity provider returned an error",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?error=access_denied&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+			},
+		},
+		{
+			name:         "no code",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+			},
+		},
+		{
+			name:         "no state",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+			},
+		},
+		{
+			name:         "invalid state",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").
+					Return(authentication.TokenResponse{}, authentication.ErrInvalidOIDCState)
+			},
+		},
+		{
+			name:         "code rejected",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").
+					Return(authentication.TokenResponse{}, authentication.ErrOIDCCodeRejected)
+			},
+		},
+		{
+			name:         "invalid ID token",
+			expectedCode: http.StatusUnauthorized,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").
+					Return(authentication.TokenResponse{}, authentication.ErrInvalidIDToken)
+			},
+		},
+		{
+			name:         "email not verified",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").
+					Return(authentication.TokenResponse{}, authentication.ErrOIDCEmailNotVerified)
+			},
+		},
+		{
+			name:         "user doesn't exist",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").
+					Return(authentication.TokenResponse{}, authentication.ErrOIDCUserNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodGet,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+				externalAuthenticator.On("Callback", mock.Anything, "code", "state").
+					Return(authentication.TokenResponse{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPost,
+			target:       "/authenticate/oidc/callback?code=code&state=state",
+			prepareMock: func(externalAuthenticator *mockExternalAuthenticator) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			externalAuthenticator := new(mockExternalAuthenticator)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				externalAuthenticator,
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, test.target, nil)
+			recorder := httptest.NewRecorder()
+			test.prepareMock(externalAuthenticator)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			if test.expectedCode == http.StatusOK {
+				assert.JSONEq(t, `{"accessToken":"access","refreshToken":"refresh"}`, recorder.Body.String())
+			}
+			externalAuthenticator.AssertExpectations(t)
+		})
+	}
+}
+
+func TestProfile(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	profile := expenses.ProfileResponse{ID: 1, Email: "user@mail.com", DisplayName: "Alice", Currency: "EUR"}
+	displayName, currency := "Alice", "EUR"
+	updateRequest := expenses.UpdateProfileRequest{DisplayName: &displayName, Currency: &currency}
+	tests := []struct {
+		name         string
+		expectedCode int
+		expectedBody string
+		method       string
+		body         string
+		withUser     bool
+		prepareMock  func(*mockProfileService)
+	}{
+		{
+			name:         "profile is returned",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"email":"user@mail.com","displayName":"Alice","currency":"EUR","verified":false}`,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(profileService *mockProfileService) {
+				profileService.On("Get", mock.Anything, uint(1)).Return(profile, nil)
+			},
+		},
+		{
+			name:         "profile is updated",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"id":1,"email":"user@mail.com","displayName":"Alice","currency":"EUR","verified":false}`,
+			method:       http.MethodPatch,
+			body:         `{"displayName":"Alice","currency":"eur"}`,
+			withUser:     true,
+			prepareMock: func(profileService *mockProfileService) {
+				profileService.On("Update", mock.Anything, uint(1), updateRequest).Return(profile, nil)
+			},
+		},
+		{
+			name:         "incorrect values",
+			expectedCode: http.StatusBadRequest,
+			method:       http.MethodPatch,
+			body:         `{"currency":"euro"}`,
+			withUser:     true,
+			prepareMock: func(profileService *mockProfileService) {
+			},
+		},
+		{
+			name:         "user not found",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(profileService *mockProfileService) {
+				profileService.On("Get", mock.Anything, uint(1)).
+					Return(expenses.ProfileResponse{}, expenses.ErrUserNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodPatch,
+			body:         `{"displayName":"Alice","currency":"eur"}`,
+			withUser:     true,
+			prepareMock: func(profileService *mockProfileService) {
+				profileService.On("Update", mock.Anything, uint(1), updateRequest).
+					Return(expenses.ProfileResponse{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodGet,
+			prepareMock: func(profileService *mockProfileService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(profileService *mockProfileService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			profileService := new(mockProfileService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				new(mockAccountService),
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				profileService,
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/users/me", bytes.NewBufferString(test.body))
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(profileService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, recorder.Body.String())
+			}
+			profileService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	tests := []struct {
+		name         string
+		expectedCode int
+		body         string
+		withUser     bool
+		prepareMock  func(*mockAccountService)
+	}{
+		{
+			name:         "account is deleted",
+			expectedCode: http.StatusNoContent,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Delete", mock.Anything, userContext, expenses.Password("password")).Return(nil)
+			},
+		},
+		{
+			name:         "incorrect password",
+			expectedCode: http.StatusForbidden,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Delete", mock.Anything, userContext, expenses.Password("password")).
+					Return(authentication.ErrCurrentPasswordIncorrect)
+			},
+		},
+		{
+			name:         "no password long after sign in",
+			expectedCode: http.StatusForbidden,
+			body:         `{}`,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Delete", mock.Anything, userContext, expenses.Password("")).
+					Return(authentication.ErrReauthenticationRequired)
+			},
+		},
+		{
+			name:         "incorrect body",
+			expectedCode: http.StatusBadRequest,
+			body:         `{"password":42}`,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+			},
+		},
+		{
+			name:         "user not found",
+			expectedCode: http.StatusNotFound,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Delete", mock.Anything, userContext, expenses.Password("password")).
+					Return(expenses.ErrUserNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			body:         `{"password":"password"}`,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Delete", mock.Anything, userContext, expenses.Password("password")).
+					Return(errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			body:         `{"password":"password"}`,
+			prepareMock: func(accountService *mockAccountService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			accountService := new(mockAccountService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				accountService,
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(http.MethodDelete, "/users/me", bytes.NewBufferString(test.body))
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(accountService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			accountService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	userContext := authentication.UserContext{UserID: 1, GroupID: 2, SessionID: "session"}
+	exportedAt := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	export := expenses.AccountExport{
+		ExportedAt: exportedAt,
+		Profile:    expenses.ProfileResponse{ID: 1, Email: "user@mail.com"},
+		Expenses: []expenses.ExpenseResponse{
+			{UserID: 1, Amount: 10, Timestamp: exportedAt, Shares: expenses.ExpenseShares{1: 50, 2: 50}},
+		},
+		Shares:      []expenses.ExpenseResponse{},
+		Settlements: expenses.Balance{2: 5},
+	}
+	tests := []struct {
+		name         string
+		expectedCode int
+		expectedBody string
+		method       string
+		withUser     bool
+		prepareMock  func(*mockAccountService)
+	}{
+		{
+			name:         "account is exported",
+			expectedCode: http.StatusOK,
+			expectedBody: `{
+				"exportedAt":"2021-01-02T03:04:05Z",
+				"profile":{"id":1,"email":"user@mail.com","verified":false},
+				"expenses":[{"userId":1,"amount":10,"timestamp":"2021-01-02T03:04:05Z","shares":{"1":50,"2":50}}],
+				"shares":[],
+				"settlements":{"2":5}
+			}`,
+			method:   http.MethodGet,
+			withUser: true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Export", mock.Anything, uint(1)).Return(export, nil)
+			},
+		},
+		{
+			name:         "user not found",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Export", mock.Anything, uint(1)).
+					Return(expenses.AccountExport{}, expenses.ErrUserNotFound)
+			},
+		},
+		{
+			name:         "server error",
+			expectedCode: http.StatusInternalServerError,
+			method:       http.MethodGet,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+				accountService.On("Export", mock.Anything, uint(1)).
+					Return(expenses.AccountExport{}, errors.New("expected"))
+			},
+		},
+		{
+			name:         "no user in context",
+			expectedCode: http.StatusForbidden,
+			method:       http.MethodGet,
+			prepareMock: func(accountService *mockAccountService) {
+			},
+		},
+		{
+			name:         "incorrect HTTP method",
+			expectedCode: http.StatusNotFound,
+			method:       http.MethodPost,
+			withUser:     true,
+			prepareMock: func(accountService *mockAccountService) {
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			accountService := new(mockAccountService)
+			router := main.NewRouter(
+				new(mockAccessTokenService),
+				accountService,
+				new(mockAuthenticator),
+				new(mockAuthorizer),
+				new(mockBalanceService),
+				new(mockEmailVerifier),
+				new(mockExpensesService),
+				new(mockExternalAuthenticator),
+				new(mockGroupService),
+				authentication.RequestLimiters{},
+				new(mockPasswordService),
+				new(mockProfileService),
+				new(mockPublicKeyProvider),
+				new(mockSessionService),
+				new(mockTwoFactorService),
+				new(mockUserService),
+			)
+			req := httptest.NewRequest(test.method, "/users/me/export", nil)
+			if test.withUser {
+				req = req.WithContext(context.WithValue(req.Context(), "user", userContext))
+			}
+			recorder := httptest.NewRecorder()
+			test.prepareMock(accountService)
+
+			// when
+			router.ServeHTTP(recorder, req)
+
+			// then
+			assert.Equal(t, test.expectedCode, recorder.Code)
+			if test.expectedBody != "" {
+				assert.JSONEq(t, test.expectedBody, recorder.Body.String())
+				assert.Contains(t, recorder.Header().Get("Content-Disposition"), "attachment")
+			}
+			accountService.AssertExpectations(t)
+		})
+	}
+}
@@ -18,8 +18,9 @@ const (
 	pgDb       = "expenses"
 	pgPort     = "5432/tcp"
 
-	deleteAllUsersQuery  = "DELETE FROM users"
-	deleteAllGroupsQuery = "DELETE FROM groups"
+	deleteAllExpensesQuery = "DELETE FROM expenses"
+	deleteAllUsersQuery    = "DELETE FROM users"
+	deleteAllGroupsQuery   = "DELETE FROM groups"
 
 	redisImage    = "redis:6.0.9-alpine3.12"
 	redisPassword = "password"
@@ -81,6 +82,8 @@ func cleanUpDB(t *testing.T, ctx context.Context) {
 	if err != nil {
 		panic(err)
 	}
+	_, err = pgdb.Exec(ctx, deleteAllExpensesQuery)
+	require.NoError(t, err)
 	_, err = pgdb.Exec(ctx, deleteAllGroupsQuery)
 	require.NoError(t, err)
 	_, err = pgdb.Exec(ctx, deleteAllUsersQuery)
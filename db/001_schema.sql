@@ -1,11 +1,73 @@
 CREATE TABLE IF NOT EXISTS users
 (
-    id       BIGSERIAL PRIMARY KEY, /* it is a serial just for simplicity */
-    email    VARCHAR(320) NOT NULL UNIQUE,
-    password VARCHAR(200) NOT NULL
+    id           BIGSERIAL PRIMARY KEY, /* it is a serial just for simplicity */
+    email        VARCHAR(320) UNIQUE,   /* placeholder members have neither email nor password */
+    password     VARCHAR(200),
+    display_name VARCHAR(100),
+    claim_code   VARCHAR(64),           /* sha256 of the code that allows to claim a placeholder */
+    verified     BOOLEAN NOT NULL DEFAULT FALSE, /* the user has confirmed the email */
+    CONSTRAINT users_member_kind_check CHECK (
+            (email IS NOT NULL AND password IS NOT NULL AND claim_code IS NULL)
+            OR (email IS NULL AND password IS NULL AND display_name IS NOT NULL)
+        )
 );
 
+/* placeholder members, for databases created before them */
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(100);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS claim_code VARCHAR(64);
+ALTER TABLE users ALTER COLUMN email DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN password DROP NOT NULL;
+DO
+$$
+    BEGIN
+        IF NOT EXISTS(SELECT 1 FROM pg_constraint WHERE conname = 'users_member_kind_check') THEN
+            ALTER TABLE users
+                ADD CONSTRAINT users_member_kind_check CHECK (
+                        (email IS NOT NULL AND password IS NOT NULL AND claim_code IS NULL)
+                        OR (email IS NULL AND password IS NULL AND display_name IS NOT NULL)
+                    );
+        END IF;
+    END
+$$;
+
+/* for databases created before email verification */
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+/* profile, for databases created before profiles */
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(2048);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale VARCHAR(35);  /* BCP 47 language tag */
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency CHAR(3);    /* preferred ISO 4217 currency */
+
 CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx on users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS users_claim_code_idx on users (claim_code);
+
+CREATE TABLE IF NOT EXISTS two_factor
+(
+    user_id   BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret    VARCHAR(64) NOT NULL,           /* base32 TOTP secret */
+    enabled   BOOLEAN     NOT NULL DEFAULT FALSE, /* false until the first code is confirmed */
+    last_step BIGINT      NOT NULL DEFAULT 0  /* last used time step, so that a code can't be used twice */
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id BIGINT      NOT NULL REFERENCES two_factor (user_id) ON DELETE CASCADE,
+    code    VARCHAR(64) NOT NULL, /* sha256 of the recovery code */
+    PRIMARY KEY (user_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS personal_access_tokens
+(
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT       NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       VARCHAR(100) NOT NULL,
+    token      VARCHAR(64)  NOT NULL UNIQUE, /* sha256 of the token */
+    scopes     TEXT[]       NOT NULL,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT current_timestamp,
+    expires_at TIMESTAMPTZ  /* tokens without expiration live until they are revoked */
+);
+
+CREATE INDEX IF NOT EXISTS personal_access_tokens_user_id_idx on personal_access_tokens (user_id);
 
 CREATE TABLE IF NOT EXISTS groups
 (
@@ -22,7 +84,7 @@ CREATE TABLE IF NOT EXISTS users_groups
 CREATE TABLE IF NOT EXISTS expenses
 (
     id        BIGSERIAL PRIMARY KEY,
-    user_id   BIGINT    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_id   BIGINT    NOT NULL REFERENCES users (id) ON DELETE RESTRICT, /* users are anonymised, not deleted */
     amount    REAL      NOT NULL,
     /* its recommended not to call columns as reserved words, did so in accordance with the description */
     timestamp TIMESTAMP NOT NULL DEFAULT current_timestamp
@@ -33,8 +95,26 @@ CREATE INDEX IF NOT EXISTS expenses_user_id_idx on expenses (user_id);
 CREATE TABLE IF NOT EXISTS expenses_shares
 (
     expense_id BIGINT   NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
-    user_id    BIGINT   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_id    BIGINT   NOT NULL REFERENCES users (id) ON DELETE RESTRICT,
     percent    SMALLINT NOT NULL
 );
 
 CREATE INDEX IF NOT EXISTS expenses_shares_user_id_idx on expenses_shares (user_id);
+
+/* for databases created before account deletion, deleting a user used to delete expenses other members depend on */
+DO
+$$
+    BEGIN
+        IF EXISTS(SELECT 1 FROM pg_constraint WHERE conname = 'expenses_user_id_fkey' AND confdeltype = 'c') THEN
+            ALTER TABLE expenses
+                DROP CONSTRAINT expenses_user_id_fkey,
+                ADD CONSTRAINT expenses_user_id_fkey FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE RESTRICT;
+        END IF;
+        IF EXISTS(SELECT 1 FROM pg_constraint WHERE conname = 'expenses_shares_user_id_fkey' AND confdeltype = 'c') THEN
+            ALTER TABLE expenses_shares
+                DROP CONSTRAINT expenses_shares_user_id_fkey,
+                ADD CONSTRAINT expenses_shares_user_id_fkey FOREIGN KEY (user_id)
+                    REFERENCES users (id) ON DELETE RESTRICT;
+        END IF;
+    END
+$$;
@@ -0,0 +1,19 @@
+package expenses
+
+import "time"
+
+// ExMemberDisplayName replaces the display name of users that have deleted their accounts. Their expenses stay in
+// the group, so that balances of other members don't change.
+const ExMemberDisplayName = "ex-member"
+
+// AccountExport contains personal data of the user in a machine-readable form
+type AccountExport struct {
+	ExportedAt time.Time       `json:"exportedAt"`
+	Profile    ProfileResponse `json:"profile"`
+	// Expenses paid by the user
+	Expenses []ExpenseResponse `json:"expenses"`
+	// Shares of the user in expenses paid by other members
+	Shares []ExpenseResponse `json:"shares"`
+	// Settlements shows how much the user has to pay or receive to settle up with every other member of the group
+	Settlements Balance `json:"settlements"`
+}
@@ -0,0 +1,93 @@
+package expenses
+
+import (
+	"context"
+	"github.com/jackc/pgtype/pgxtype"
+)
+
+// AccountRepository gives access to all personal data of a user at once
+type AccountRepository interface {
+	// FindExpenses returns expenses paid by the user and expenses where the user has a share, with all their shares
+	FindExpenses(ctx context.Context, db pgxtype.Querier, userID uint) ([]ExpenseResponse, error)
+	// Anonymise turns the user into an ex-member placeholder. Returns ErrUserNotFound if there is no such user.
+	Anonymise(ctx context.Context, db pgxtype.Querier, userID uint) error
+}
+
+const (
+	findAccountExpensesQuery = "SELECT e.id, e.user_id, e.amount, e.timestamp, es.user_id, es.percent " +
+		"FROM expenses as e " +
+		"JOIN expenses_shares as es ON es.expense_id = e.id " +
+		"WHERE e.user_id = $1 OR e.id IN (SELECT expense_id FROM expenses_shares WHERE user_id = $1) " +
+		"ORDER BY e.timestamp, e.id"
+	anonymiseUserQuery = "UPDATE users SET email = NULL, password = NULL, claim_code = NULL, verified = FALSE, " +
+		"display_name = $2, avatar_url = NULL, locale = NULL, currency = NULL " +
+		"WHERE id = $1 AND email IS NOT NULL"
+	deleteAllAccessTokensQuery = "DELETE FROM personal_access_tokens WHERE user_id = $1"
+)
+
+// PgAccountRepository is an AccountRepository that works with postgresql
+type PgAccountRepository struct {
+}
+
+// NewPgAccountRepository creates new PgAccountRepository
+func NewPgAccountRepository() *PgAccountRepository {
+	return &PgAccountRepository{}
+}
+
+// FindExpenses returns expenses ordered by time they were created
+func (r *PgAccountRepository) FindExpenses(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+) ([]ExpenseResponse, error) {
+	rows, err := db.Query(ctx, findAccountExpensesQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make([]ExpenseResponse, 0)
+	lastExpenseID := uint(0)
+	for rows.Next() {
+		var expenseID, shareUserID uint
+		var expense ExpenseResponse
+		var percent Percent
+		if err := rows.Scan(
+			&expenseID,
+			&expense.UserID,
+			&expense.Amount,
+			&expense.Timestamp,
+			&shareUserID,
+			&percent,
+		); err != nil {
+			return nil, err
+		}
+		if expenseID != lastExpenseID {
+			expense.Shares = make(ExpenseShares)
+			result = append(result, expense)
+			lastExpenseID = expenseID
+		}
+		result[len(result)-1].Shares[shareUserID] = percent
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Anonymise removes email, password and profile of the user, the name is replaced with ExMemberDisplayName.
+// The user stays in the group with all expenses and shares, two-factor authentication and personal access tokens
+// are deleted. Should be executed in a transaction.
+func (r *PgAccountRepository) Anonymise(ctx context.Context, db pgxtype.Querier, userID uint) error {
+	commandTag, err := db.Exec(ctx, anonymiseUserQuery, userID, ExMemberDisplayName)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	if _, err = db.Exec(ctx, deleteTwoFactorQuery, userID); err != nil {
+		return err
+	}
+	_, err = db.Exec(ctx, deleteAllAccessTokensQuery, userID)
+	return err
+}
@@ -0,0 +1,94 @@
+package expenses_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/expenses"
+	"testing"
+)
+
+func TestPgAccountRepositoryFindExpenses(t *testing.T) {
+	// given
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	userRepo := expenses.NewPgUserRepository()
+	repo := expenses.NewPgRepository()
+	user1, err := userRepo.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "mail@mail.com", Password: "128c76xz"})
+	require.NoError(t, err)
+	user2, err := userRepo.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "mail2@mail.com", Password: "128c76xz"})
+	require.NoError(t, err)
+	user3, err := userRepo.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "mail3@mail.com", Password: "128c76xz"})
+	require.NoError(t, err)
+	paid, err := repo.Create(ctx, pgdb, expenses.NewExpense{UserID: user1.ID, Amount: 10})
+	require.NoError(t, err)
+	paidShares := expenses.ExpenseShares{user1.ID: 50, user2.ID: 50}
+	require.NoError(t, repo.CreateShares(ctx, pgdb, expenses.CreateExpenseShares{ExpenseID: paid.ID, Shares: paidShares}))
+	shared, err := repo.Create(ctx, pgdb, expenses.NewExpense{UserID: user2.ID, Amount: 20})
+	require.NoError(t, err)
+	sharedShares := expenses.ExpenseShares{user1.ID: 30, user2.ID: 70}
+	require.NoError(t, repo.
+		CreateShares(ctx, pgdb, expenses.CreateExpenseShares{ExpenseID: shared.ID, Shares: sharedShares}))
+	other, err := repo.Create(ctx, pgdb, expenses.NewExpense{UserID: user2.ID, Amount: 30})
+	require.NoError(t, err)
+	otherShares := expenses.ExpenseShares{user3.ID: 100}
+	require.NoError(t, repo.
+		CreateShares(ctx, pgdb, expenses.CreateExpenseShares{ExpenseID: other.ID, Shares: otherShares}))
+
+	// when
+	found, err := expenses.NewPgAccountRepository().FindExpenses(ctx, pgdb, user1.ID)
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, user1.ID, found[0].UserID)
+	assert.Equal(t, float32(10), found[0].Amount)
+	assert.Equal(t, paidShares, found[0].Shares)
+	assert.Equal(t, user2.ID, found[1].UserID)
+	assert.Equal(t, float32(20), found[1].Amount)
+	assert.Equal(t, sharedShares, found[1].Shares)
+}
+
+func TestPgAccountRepositoryAnonymise(t *testing.T) {
+	// given
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	userRepo := expenses.NewPgUserRepository()
+	groupRepo := expenses.NewPgGroupRepository()
+	user1, err := userRepo.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "mail@mail.com", Password: "128c76xz"})
+	require.NoError(t, err)
+	user2, err := userRepo.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "mail2@mail.com", Password: "128c76xz"})
+	require.NoError(t, err)
+	group, err := groupRepo.Create(ctx, pgdb, "group")
+	require.NoError(t, err)
+	require.NoError(t, groupRepo.AddUserToGroup(ctx, pgdb, user1.ID, group.ID))
+	require.NoError(t, groupRepo.AddUserToGroup(ctx, pgdb, user2.ID, group.ID))
+	expense, err := expenses.NewPgRepository().Create(ctx, pgdb, expenses.NewExpense{UserID: user1.ID, Amount: 10})
+	require.NoError(t, err)
+	shares := expenses.ExpenseShares{user1.ID: 50, user2.ID: 50}
+	require.NoError(t, expenses.NewPgRepository().
+		CreateShares(ctx, pgdb, expenses.CreateExpenseShares{ExpenseID: expense.ID, Shares: shares}))
+	balanceBefore, err := expenses.NewPgBalanceRepository().Get(ctx, pgdb, user2.ID)
+	require.NoError(t, err)
+
+	// when
+	err = expenses.NewPgAccountRepository().Anonymise(ctx, pgdb, user1.ID)
+
+	// then
+	require.NoError(t, err)
+	_, err = userRepo.FindByEmail(ctx, pgdb, "mail@mail.com")
+	assert.Equal(t, expenses.ErrUserNotFound, err)
+	_, err = expenses.NewPgProfileRepository().Find(ctx, pgdb, user1.ID)
+	assert.Equal(t, expenses.ErrUserNotFound, err)
+	groupResponse, err := groupRepo.FindByIDWithUsers(ctx, pgdb, group.ID)
+	require.NoError(t, err)
+	assert.Contains(t, groupResponse.Users, expenses.UserResponse{
+		ID:          user1.ID,
+		DisplayName: expenses.ExMemberDisplayName,
+		Placeholder: true,
+	})
+	balanceAfter, err := expenses.NewPgBalanceRepository().Get(ctx, pgdb, user2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, balanceBefore, balanceAfter)
+	assert.Equal(t, expenses.ErrUserNotFound, expenses.NewPgAccountRepository().Anonymise(ctx, pgdb, user1.ID))
+}
@@ -2,11 +2,16 @@ package expenses
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-redis/redis"
 	"time"
 )
 
+var (
+	ErrBalanceNotCached = errors.New("balance is not cached")
+)
+
 // BalanceCache provides operation to Set key-value in cache, Get value by key, Remove n key-values by provided keys
 type BalanceCache interface {
 	BalanceCacheGetterSetter
@@ -15,8 +20,11 @@ type BalanceCache interface {
 
 // BalanceCacheGetterSetter provides get and set operations on cache
 type BalanceCacheGetterSetter interface {
-	// Get value by key. If value is not present - error is returned.
+	// Get value by key. If value is not present - ErrBalanceNotCached is returned.
 	Get(key BalanceCacheKey) (Balance, error)
+	// GetWithTTL returns the value together with how long it stays cached, so that it can be refreshed before it
+	// expires. If value is not present - ErrBalanceNotCached is returned.
+	GetWithTTL(key BalanceCacheKey) (Balance, time.Duration, error)
 	// Set key-value
 	Set(key BalanceCacheKey, balance Balance) error
 }
@@ -39,9 +47,12 @@ func NewRedisBalanceCache(redisClient redis.UniversalClient, cacheDuration time.
 	return &RedisBalanceCache{redisClient: redisClient, cacheDuration: cacheDuration}
 }
 
-// Get value from cache by key, if nothing is found redis.Nil error will be returned
+// Get value from cache by key, if nothing is found ErrBalanceNotCached will be returned
 func (r *RedisBalanceCache) Get(key BalanceCacheKey) (Balance, error) {
 	result, err := r.redisClient.Get(key.AsKey()).Result() // no refresh of expire
+	if err == redis.Nil {
+		return nil, ErrBalanceNotCached
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -52,6 +63,28 @@ func (r *RedisBalanceCache) Get(key BalanceCacheKey) (Balance, error) {
 	return balance, nil
 }
 
+// GetWithTTL reads the value and its TTL in one round trip, TTL is negative if the key doesn't expire
+func (r *RedisBalanceCache) GetWithTTL(key BalanceCacheKey) (Balance, time.Duration, error) {
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := r.redisClient.Pipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key.AsKey())
+		ttl = pipe.PTTL(key.AsKey())
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, 0, ErrBalanceNotCached
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	balance := make(Balance)
+	if err = json.Unmarshal([]byte(get.Val()), &balance); err != nil {
+		return nil, 0, err
+	}
+	return balance, ttl.Val(), nil
+}
+
 // Set key-value
 func (r *RedisBalanceCache) Set(key BalanceCacheKey, balance Balance) error {
 	data, err := json.Marshal(&balance)
@@ -8,47 +8,254 @@ import (
 	"time"
 )
 
-func TestRedisBalanceCacheGetSetRemove(t *testing.T) {
-	// given
-	defer clearRedis()
-	cache := expenses.NewRedisBalanceCache(redisClient, time.Minute)
-	key1 := expenses.BalanceCacheKey(1)
-	key2 := expenses.BalanceCacheKey(2)
-	balance1 := expenses.Balance{
-		1: 20,
-		3: -10.0,
+// balanceCaches create an empty BalanceCache of every implementation, so that all of them pass the same tests
+var balanceCaches = []struct {
+	name     string
+	newCache func(t *testing.T, cacheDuration time.Duration) expenses.BalanceCache
+}{
+	{
+		name: "redis",
+		newCache: func(t *testing.T, cacheDuration time.Duration) expenses.BalanceCache {
+			clearRedis()
+			return expenses.NewRedisBalanceCache(redisClient, cacheDuration)
+		},
+	},
+	{
+		name: "memory",
+		newCache: func(t *testing.T, cacheDuration time.Duration) expenses.BalanceCache {
+			cache := expenses.NewMemoryBalanceCache(100, cacheDuration, time.Minute)
+			t.Cleanup(cache.Stop)
+			return cache
+		},
+	},
+	{
+		name: "tiered",
+		newCache: func(t *testing.T, cacheDuration time.Duration) expenses.BalanceCache {
+			clearRedis()
+			return newTestTieredBalanceCache(t, cacheDuration)
+		},
+	},
+}
+
+func TestBalanceCacheGetSetRemove(t *testing.T) {
+	for _, implementation := range balanceCaches {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			defer clearRedis()
+			cache := implementation.newCache(t, time.Minute)
+			key1 := expenses.BalanceCacheKey(1)
+			key2 := expenses.BalanceCacheKey(2)
+			balance1 := expenses.Balance{
+				1: 20,
+				3: -10.0,
+			}
+			balance2 := expenses.Balance{
+				2: 100.0,
+			}
+			// when and then - set and retrieve
+			require.NoError(t, cache.Set(key1, balance1))
+			require.NoError(t, cache.Set(key2, balance2))
+			foundBalance1, err := cache.Get(key1)
+			require.NoError(t, err)
+			assert.Equal(t, balance1, foundBalance1)
+			foundBalance2, err := cache.Get(key2)
+			require.NoError(t, err)
+			assert.Equal(t, balance2, foundBalance2)
+			// reset
+			newBalance1 := balance2
+			require.NoError(t, cache.Set(key1, newBalance1))
+			foundBalance1, err = cache.Get(key1)
+			require.NoError(t, err)
+			assert.Equal(t, newBalance1, foundBalance1)
+			// delete all
+			require.NoError(t, cache.Remove(key1, key2))
+			foundBalance1, err = cache.Get(key1)
+			assert.Equal(t, expenses.ErrBalanceNotCached, err)
+			foundBalance2, err = cache.Get(key2)
+			assert.Equal(t, expenses.ErrBalanceNotCached, err)
+		})
 	}
-	balance2 := expenses.Balance{
-		2: 100.0,
+}
+
+func TestBalanceCacheTimeout(t *testing.T) {
+	for _, implementation := range balanceCaches {
+		t.Run(implementation.name, func(t *testing.T) {
+			cache := implementation.newCache(t, 100*time.Millisecond)
+			key := expenses.BalanceCacheKey(10)
+			require.NoError(t, cache.Set(key, expenses.Balance{1: 2}))
+			time.Sleep(100 * time.Millisecond)
+			_, err := cache.Get(key)
+			require.Error(t, err)
+		})
 	}
-	// when and then - set and retrieve
-	require.NoError(t, cache.Set(key1, balance1))
-	require.NoError(t, cache.Set(key2, balance2))
-	foundBalance1, err := cache.Get(key1)
+}
+
+func TestBalanceCacheReturnsCopies(t *testing.T) {
+	for _, implementation := range balanceCaches {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			cache := implementation.newCache(t, time.Minute)
+			key := expenses.BalanceCacheKey(1)
+			balance := expenses.Balance{2: 10}
+			require.NoError(t, cache.Set(key, balance))
+
+			// when
+			balance[2] = 20
+			found, err := cache.Get(key)
+			require.NoError(t, err)
+			found[3] = 30
+
+			// then
+			found, err = cache.Get(key)
+			require.NoError(t, err)
+			assert.Equal(t, expenses.Balance{2: 10}, found)
+		})
+	}
+}
+
+func TestBalanceCacheGetWithTTL(t *testing.T) {
+	for _, implementation := range balanceCaches {
+		t.Run(implementation.name, func(t *testing.T) {
+			// given
+			cache := implementation.newCache(t, time.Minute)
+			key := expenses.BalanceCacheKey(1)
+			balance := expenses.Balance{2: 10}
+			require.NoError(t, cache.Set(key, balance))
+
+			// when
+			found, ttl, err := cache.GetWithTTL(key)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, balance, found)
+			assert.True(t, ttl > 0 && ttl <= time.Minute, "ttl is %s", ttl)
+			_, _, err = cache.GetWithTTL(2)
+			assert.Equal(t, expenses.ErrBalanceNotCached, err)
+		})
+	}
+}
+
+func TestMemoryBalanceCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	// given
+	cache := expenses.NewMemoryBalanceCache(2, time.Minute, time.Minute)
+	defer cache.Stop()
+	require.NoError(t, cache.Set(1, expenses.Balance{2: 1}))
+	require.NoError(t, cache.Set(2, expenses.Balance{1: 2}))
+	_, err := cache.Get(1)
 	require.NoError(t, err)
-	assert.Equal(t, balance1, foundBalance1)
-	foundBalance2, err := cache.Get(key2)
+
+	// when
+	require.NoError(t, cache.Set(3, expenses.Balance{1: 3}))
+
+	// then
+	_, err = cache.Get(2)
+	assert.Equal(t, expenses.ErrBalanceNotCached, err)
+	for _, key := range []expenses.BalanceCacheKey{1, 3} {
+		_, err = cache.Get(key)
+		assert.NoError(t, err)
+	}
+}
+
+func TestMemoryBalanceCacheJanitorRemovesExpired(t *testing.T) {
+	// given
+	cache := expenses.NewMemoryBalanceCache(2, 10*time.Millisecond, 10*time.Millisecond)
+	defer cache.Stop()
+	require.NoError(t, cache.Set(1, expenses.Balance{2: 1}))
+	require.NoError(t, cache.Set(2, expenses.Balance{1: 2}))
+
+	// when
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, cache.Set(3, expenses.Balance{1: 3}))
+	require.NoError(t, cache.Set(4, expenses.Balance{1: 4}))
+
+	// then
+	for _, key := range []expenses.BalanceCacheKey{3, 4} {
+		_, err := cache.Get(key)
+		assert.NoError(t, err, "expired balances don't take space of new ones")
+	}
+}
+
+func TestTieredBalanceCacheServesLocalCopy(t *testing.T) {
+	// given
+	clearRedis()
+	defer clearRedis()
+	cache := newTestTieredBalanceCache(t, time.Minute)
+	key := expenses.BalanceCacheKey(1)
+
+	// when and then - local copies are dropped once the subscription is established, so it is retried until then
+	assert.Eventually(t, func() bool {
+		require.NoError(t, redisClient.Set(key.AsKey(), `{"2":10}`, time.Minute).Err())
+		_, err := cache.Get(key)
+		require.NoError(t, err)
+		require.NoError(t, redisClient.Set(key.AsKey(), `{"2":20}`, time.Minute).Err())
+		found, ttl, err := cache.GetWithTTL(key)
+		require.NoError(t, err)
+		assert.True(t, ttl > 0 && ttl <= time.Minute, "ttl of redis is reported, it is %s", ttl)
+		return found[2] == 10
+	}, time.Second, 10*time.Millisecond, "local copy doesn't need redis")
+}
+
+func TestTieredBalanceCacheInvalidatesOtherInstances(t *testing.T) {
+	// given
+	clearRedis()
+	defer clearRedis()
+	first := newTestTieredBalanceCache(t, time.Minute)
+	second := newTestTieredBalanceCache(t, time.Minute)
+	require.NoError(t, first.Set(1, expenses.Balance{2: 10}))
+	require.NoError(t, first.Set(2, expenses.Balance{1: -10}))
+	for _, key := range []expenses.BalanceCacheKey{1, 2} {
+		_, err := second.Get(key)
+		require.NoError(t, err)
+	}
+
+	// when
+	require.NoError(t, first.Set(1, expenses.Balance{2: 20}))
+	require.NoError(t, first.Remove(2))
+
+	// then
+	assert.Eventually(t, func() bool {
+		found, err := second.Get(1)
+		return err == nil && found[2] == 20
+	}, time.Second, 10*time.Millisecond, "updated balance is read from redis")
+	assert.Eventually(t, func() bool {
+		_, err := second.Get(2)
+		return err == expenses.ErrBalanceNotCached
+	}, time.Second, 10*time.Millisecond, "removed balance is not served locally")
+}
+
+func TestTieredBalanceCacheKeepsLocalCopiesShortly(t *testing.T) {
+	// given
+	clearRedis()
+	defer clearRedis()
+	cache := expenses.NewTieredBalanceCache(
+		expenses.NewRedisBalanceCache(redisClient, time.Minute),
+		100,
+		10*time.Millisecond,
+		time.Minute,
+	)
+	defer cache.Stop()
+	key := expenses.BalanceCacheKey(1)
+	require.NoError(t, redisClient.Set(key.AsKey(), `{"2":10}`, time.Minute).Err())
+	_, err := cache.Get(key)
 	require.NoError(t, err)
-	assert.Equal(t, balance2, foundBalance2)
-	// reset
-	newBalance1 := balance2
-	require.NoError(t, cache.Set(key1, newBalance1))
-	foundBalance1, err = cache.Get(key1)
+
+	// when
+	require.NoError(t, redisClient.Set(key.AsKey(), `{"2":20}`, time.Minute).Err())
+	time.Sleep(20 * time.Millisecond)
+	found, err := cache.Get(key)
+
+	// then
 	require.NoError(t, err)
-	assert.Equal(t, newBalance1, foundBalance1)
-	// delete all
-	require.NoError(t, cache.Remove(key1, key2))
-	foundBalance1, err = cache.Get(key1)
-	require.Error(t, err)
-	foundBalance2, err = cache.Get(key2)
-	require.Error(t, err)
-}
-
-func TestRedisBalanceCacheTimeout(t *testing.T) {
-	cache := expenses.NewRedisBalanceCache(redisClient, 100*time.Millisecond)
-	key := expenses.BalanceCacheKey(10)
-	require.NoError(t, cache.Set(key, expenses.Balance{1: 2}))
-	time.Sleep(100 * time.Millisecond)
-	_, err := cache.Get(key)
-	require.Error(t, err)
+	assert.Equal(t, expenses.Balance{2: 20}, found)
+}
+
+func newTestTieredBalanceCache(t *testing.T, cacheDuration time.Duration) *expenses.TieredBalanceCache {
+	cache := expenses.NewTieredBalanceCache(
+		expenses.NewRedisBalanceCache(redisClient, cacheDuration),
+		100,
+		time.Minute,
+		time.Minute,
+	)
+	t.Cleanup(cache.Stop)
+	return cache
 }
@@ -4,8 +4,19 @@ import (
 	"context"
 	"go-spend/db"
 	"go-spend/log"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// initialComputeTime is assumed until the service computes a balance for the first time
+const initialComputeTime = 50 * time.Millisecond
+
+// sharedComputeTimeout limits a computation shared by concurrent callers, as it doesn't run on a context of any of them
+const sharedComputeTimeout = 10 * time.Second
+
 // BalanceService provides means to fetch balance for current user. At the moment just delegates to repository but can
 // be used, for example, when we need to add cache.
 type BalanceService interface {
@@ -13,11 +24,35 @@ type BalanceService interface {
 	Get(ctx context.Context, userID uint) (Balance, error)
 }
 
-// DefaultBalanceService is default implementation of BalanceService
+// BalanceRefresher recomputes balances and writes them to the cache
+type BalanceRefresher interface {
+	// Refresh balances of users with userIDs
+	Refresh(ctx context.Context, userIDs ...uint) error
+}
+
+// DefaultBalanceService is default implementation of BalanceService. Concurrent misses of the same balance are
+// coalesced, so that only one of them queries the DB and the others wait for its result. Cached balances are refreshed
+// before they expire with a probability that grows as the expiration gets closer (XFetch), so that popular balances
+// don't expire for everyone at once. The longer a balance takes to compute the earlier it is refreshed,
+// earlyRefreshBeta scales that, zero disables early refresh.
 type DefaultBalanceService struct {
+	// computeTime in nanoseconds of the last computed balance, accessed atomically, so it goes first to be aligned
+	computeTime       int64
 	db                db.TxQuerier
 	balanceCache      BalanceCacheGetterSetter
 	balanceRepository BalanceRepository
+	earlyRefreshBeta  float64
+	mutex             sync.Mutex
+	calls             map[BalanceCacheKey]*balanceCall
+}
+
+// balanceCall is an in-flight computation of a balance, done is closed when it is finished. A stale call was
+// overtaken by Refresh, its balance is returned to the callers that are already waiting, but it is not cached.
+type balanceCall struct {
+	done    chan struct{}
+	balance Balance
+	err     error
+	stale   bool
 }
 
 // NewDefaultBalanceService creates new instance of DefaultBalanceService
@@ -26,23 +61,122 @@ func NewDefaultBalanceService(
 	// it could have been done using a decorator pattern as well, but this service already does nothing else
 	balanceCache BalanceCacheGetterSetter,
 	balanceRepository BalanceRepository,
+	earlyRefreshBeta float64,
 ) *DefaultBalanceService {
-	return &DefaultBalanceService{db: db, balanceCache: balanceCache, balanceRepository: balanceRepository}
+	return &DefaultBalanceService{
+		db:                db,
+		balanceCache:      balanceCache,
+		balanceRepository: balanceRepository,
+		earlyRefreshBeta:  earlyRefreshBeta,
+		computeTime:       int64(initialComputeTime),
+		calls:             make(map[BalanceCacheKey]*balanceCall),
+	}
 }
 
-// Get Balance from a DB for provided user
+// Get Balance from a DB for provided user. A cached balance is returned if it can't be refreshed early.
 func (d *DefaultBalanceService) Get(ctx context.Context, userID uint) (Balance, error) {
 	cacheKey := BalanceCacheKey(userID)
-	balance, err := d.balanceCache.Get(cacheKey)
+	balance, ttl, err := d.balanceCache.GetWithTTL(cacheKey)
 	if err == nil {
-		return balance, nil // return what found if there was no error
+		if !d.shouldRefreshEarly(ttl) {
+			return balance, nil // return what found if there was no error
+		}
+		refreshed, err := d.load(ctx, userID)
+		if err != nil {
+			log.Warn("could not refresh balance of user %d early - %s", userID, err)
+			return balance, nil
+		}
+		return refreshed, nil
+	}
+	return d.load(ctx, userID)
+}
+
+// Refresh recomputes balances and writes them to the cache. In-flight computations are not joined, as they could
+// have started before the change that caused the refresh, they are marked stale, so that they can't overwrite the
+// refreshed balance when they finish later.
+func (d *DefaultBalanceService) Refresh(ctx context.Context, userIDs ...uint) error {
+	for _, userID := range userIDs {
+		cacheKey := BalanceCacheKey(userID)
+		d.mutex.Lock()
+		if call, ok := d.calls[cacheKey]; ok {
+			call.stale = true
+			delete(d.calls, cacheKey)
+		}
+		d.mutex.Unlock()
+		balance, err := d.compute(ctx, userID)
+		if err != nil {
+			return err
+		}
+		d.set(userID, balance)
+	}
+	return nil
+}
+
+// load computes the balance once for all concurrent callers. The computation runs on its own context, so callers stop
+// waiting when their context is done, but the computation continues for the others.
+func (d *DefaultBalanceService) load(ctx context.Context, userID uint) (Balance, error) {
+	cacheKey := BalanceCacheKey(userID)
+	d.mutex.Lock()
+	call, ok := d.calls[cacheKey]
+	if !ok {
+		call = &balanceCall{done: make(chan struct{})}
+		d.calls[cacheKey] = call
+		go d.computeShared(userID, call)
+	}
+	d.mutex.Unlock()
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return copyBalance(call.balance), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	balance, err = d.balanceRepository.Get(ctx, d.db, userID)
+}
+
+// computeShared finishes the call, it is detached from contexts of the callers. The balance is cached under the mutex,
+// so that Refresh either marks the call stale before or sets its balance after.
+func (d *DefaultBalanceService) computeShared(userID uint, call *balanceCall) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedComputeTimeout)
+	defer cancel()
+	call.balance, call.err = d.compute(ctx, userID)
+	cacheKey := BalanceCacheKey(userID)
+	d.mutex.Lock()
+	if d.calls[cacheKey] == call {
+		delete(d.calls, cacheKey)
+	}
+	if call.err == nil && !call.stale {
+		d.set(userID, call.balance)
+	}
+	d.mutex.Unlock()
+	close(call.done)
+}
+
+// compute the balance in the DB
+func (d *DefaultBalanceService) compute(ctx context.Context, userID uint) (Balance, error) {
+	started := time.Now()
+	balance, err := d.balanceRepository.Get(ctx, d.db, userID)
 	if err != nil {
 		return nil, err
 	}
-	if err = d.balanceCache.Set(cacheKey, balance); err != nil {
+	atomic.StoreInt64(&d.computeTime, int64(time.Since(started)))
+	return balance, nil
+}
+
+// set the balance to the cache, failures are only logged as the balance can be computed again
+func (d *DefaultBalanceService) set(userID uint, balance Balance) {
+	if err := d.balanceCache.Set(BalanceCacheKey(userID), balance); err != nil {
 		log.Warn("could not set key to cache - %s", err)
 	}
-	return balance, nil
+}
+
+// shouldRefreshEarly decides whether to refresh a balance that stays cached for ttl. Balances without expiration are
+// never refreshed.
+func (d *DefaultBalanceService) shouldRefreshEarly(ttl time.Duration) bool {
+	if d.earlyRefreshBeta <= 0 || ttl < 0 {
+		return false
+	}
+	computeTime := float64(atomic.LoadInt64(&d.computeTime))
+	return -computeTime*d.earlyRefreshBeta*math.Log(rand.Float64()) >= float64(ttl)
 }
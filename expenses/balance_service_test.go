@@ -9,7 +9,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"go-spend/db"
 	"go-spend/expenses"
+	"sync"
 	"testing"
+	"time"
 )
 
 type mockBalanceRepository struct {
@@ -30,6 +32,13 @@ func (m *mockBalanceCacheGetterSetter) Get(key expenses.BalanceCacheKey) (expens
 	return args.Get(0).(expenses.Balance), args.Error(1)
 }
 
+func (m *mockBalanceCacheGetterSetter) GetWithTTL(
+	key expenses.BalanceCacheKey,
+) (expenses.Balance, time.Duration, error) {
+	args := m.Called(key)
+	return args.Get(0).(expenses.Balance), args.Get(1).(time.Duration), args.Error(2)
+}
+
 func (m *mockBalanceCacheGetterSetter) Set(key expenses.BalanceCacheKey, balance expenses.Balance) error {
 	args := m.Called(key, balance)
 	return args.Error(0)
@@ -37,7 +46,7 @@ func (m *mockBalanceCacheGetterSetter) Set(key expenses.BalanceCacheKey, balance
 
 func TestNewDefaultBalanceService(t *testing.T) {
 	cache := new(mockBalanceCacheGetterSetter)
-	assert.NotNil(t, expenses.NewDefaultBalanceService(new(mockTxQuerier), cache, new(mockBalanceRepository)))
+	assert.NotNil(t, expenses.NewDefaultBalanceService(new(mockTxQuerier), cache, new(mockBalanceRepository), 1))
 }
 
 func TestDefaultBalanceServiceReturnsBalanceFromRepo(t *testing.T) {
@@ -46,13 +55,13 @@ func TestDefaultBalanceServiceReturnsBalanceFromRepo(t *testing.T) {
 	balanceRepository := new(mockBalanceRepository)
 	querier := new(mockTxQuerier)
 	cache := new(mockBalanceCacheGetterSetter)
-	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
 	balance := expenses.Balance{
 		1: 10.0,
 		2: -20.0,
 	}
-	cache.On("Get", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, redis.Nil)
-	balanceRepository.On("Get", ctx, querier, uint(1)).Return(balance, nil)
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, time.Duration(0), redis.Nil)
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).Return(balance, nil)
 	cache.On("Set", expenses.BalanceCacheKey(1), balance).Return(nil)
 
 	// when
@@ -69,9 +78,9 @@ func TestDefaultBalanceServiceReturnsError(t *testing.T) {
 	balanceRepository := new(mockBalanceRepository)
 	querier := new(mockTxQuerier)
 	cache := new(mockBalanceCacheGetterSetter)
-	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository)
-	cache.On("Get", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, redis.Nil)
-	balanceRepository.On("Get", ctx, querier, uint(1)).Return(expenses.Balance{}, errors.New("expected"))
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, time.Duration(0), redis.Nil)
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).Return(expenses.Balance{}, errors.New("expected"))
 
 	// when
 	_, err := balanceService.Get(ctx, 1)
@@ -86,12 +95,12 @@ func TestDefaultBalanceServiceGetFromCache(t *testing.T) {
 	balanceRepository := new(mockBalanceRepository)
 	querier := new(mockTxQuerier)
 	cache := new(mockBalanceCacheGetterSetter)
-	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
 	balance := expenses.Balance{
 		1: 10.0,
 		2: -20.0,
 	}
-	cache.On("Get", expenses.BalanceCacheKey(1)).Return(balance, nil)
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(balance, time.Minute, nil)
 
 	// when
 	result, err := balanceService.Get(ctx, 1)
@@ -107,13 +116,13 @@ func TestDefaultBalanceServiceReturnsBalanceFromRepoEvenIfSetToCacheFails(t *tes
 	balanceRepository := new(mockBalanceRepository)
 	querier := new(mockTxQuerier)
 	cache := new(mockBalanceCacheGetterSetter)
-	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
 	balance := expenses.Balance{
 		1: 10.0,
 		2: -20.0,
 	}
-	cache.On("Get", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, redis.Nil)
-	balanceRepository.On("Get", ctx, querier, uint(1)).Return(balance, nil)
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, time.Duration(0), redis.Nil)
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).Return(balance, nil)
 	cache.On("Set", expenses.BalanceCacheKey(1), balance).Return(errors.New("expected"))
 
 	// when
@@ -123,3 +132,187 @@ func TestDefaultBalanceServiceReturnsBalanceFromRepoEvenIfSetToCacheFails(t *tes
 	require.NoError(t, err)
 	assert.Equal(t, balance, result)
 }
+
+func TestDefaultBalanceServiceCoalescesConcurrentMisses(t *testing.T) {
+	// given
+	ctx := context.Background()
+	balanceRepository := new(mockBalanceRepository)
+	querier := new(mockTxQuerier)
+	cache := new(mockBalanceCacheGetterSetter)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
+	balance := expenses.Balance{2: 10.0}
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, time.Duration(0), redis.Nil)
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).
+		WaitUntil(time.After(100*time.Millisecond)).
+		Return(balance, nil)
+	cache.On("Set", expenses.BalanceCacheKey(1), balance).Return(nil)
+
+	// when
+	results := make([]expenses.Balance, 10)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := balanceService.Get(ctx, 1)
+			assert.NoError(t, err)
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+
+	// then
+	balanceRepository.AssertNumberOfCalls(t, "Get", 1)
+	cache.AssertNumberOfCalls(t, "Set", 1)
+	for _, result := range results {
+		assert.Equal(t, balance, result)
+	}
+}
+
+func TestDefaultBalanceServiceKeepsComputingWhenCallerIsCanceled(t *testing.T) {
+	// given
+	balanceRepository := new(mockBalanceRepository)
+	querier := new(mockTxQuerier)
+	cache := new(mockBalanceCacheGetterSetter)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
+	balance := expenses.Balance{2: 10.0}
+	queryCtx := make(chan context.Context, 1)
+	release := make(chan struct{})
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, time.Duration(0), redis.Nil)
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).
+		Run(func(args mock.Arguments) {
+			queryCtx <- args.Get(0).(context.Context)
+			<-release
+		}).
+		Return(balance, nil)
+	cached := make(chan struct{})
+	cache.On("Set", expenses.BalanceCacheKey(1), balance).
+		Run(func(mock.Arguments) { close(cached) }).
+		Return(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	callerErr := make(chan error)
+	go func() {
+		_, err := balanceService.Get(ctx, 1)
+		callerErr <- err
+	}()
+	computation := <-queryCtx
+
+	// when
+	cancel()
+
+	// then
+	assert.Equal(t, context.Canceled, <-callerErr)
+	assert.NoError(t, computation.Err(), "the query shouldn't be canceled with the caller")
+	close(release)
+	select {
+	case <-cached:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the balance wasn't computed and cached")
+	}
+}
+
+func TestDefaultBalanceServiceDoesNotCacheLoadOvertakenByRefresh(t *testing.T) {
+	// given
+	balanceRepository := new(mockBalanceRepository)
+	querier := new(mockTxQuerier)
+	cache := new(mockBalanceCacheGetterSetter)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
+	stale := expenses.Balance{2: 10.0}
+	fresh := expenses.Balance{2: 20.0}
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(expenses.Balance{}, time.Duration(0), redis.Nil)
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).
+		Run(func(mock.Arguments) {
+			close(loading)
+			<-release
+		}).
+		Return(stale, nil).
+		Once()
+	balanceRepository.On("Get", mock.Anything, querier, uint(1)).Return(fresh, nil).Once()
+	cache.On("Set", expenses.BalanceCacheKey(1), fresh).Return(nil)
+	loaded := make(chan expenses.Balance)
+	go func() {
+		balance, _ := balanceService.Get(context.Background(), 1)
+		loaded <- balance
+	}()
+	<-loading
+
+	// when
+	err := balanceService.Refresh(context.Background(), 1)
+	close(release)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, stale, <-loaded, "callers that were waiting get the balance they waited for")
+	cache.AssertNotCalled(t, "Set", expenses.BalanceCacheKey(1), stale)
+	cache.AssertNumberOfCalls(t, "Set", 1)
+}
+
+func TestDefaultBalanceServiceRefreshesEarly(t *testing.T) {
+	cached := expenses.Balance{2: 10.0}
+	refreshed := expenses.Balance{2: 20.0}
+	tests := []struct {
+		name          string
+		beta          float64
+		ttl           time.Duration
+		refreshResult error
+		expected      expenses.Balance
+	}{
+		{name: "disabled", beta: 0, ttl: time.Millisecond, expected: cached},
+		{name: "far from expiration", beta: 1, ttl: time.Hour, expected: cached},
+		{name: "close to expiration", beta: 1e9, ttl: time.Minute, expected: refreshed},
+		{name: "without expiration", beta: 1e9, ttl: -1, expected: cached},
+		{
+			name:          "refresh fails",
+			beta:          1e9,
+			ttl:           time.Minute,
+			refreshResult: errors.New("expected"),
+			expected:      cached,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			balanceRepository := new(mockBalanceRepository)
+			querier := new(mockTxQuerier)
+			cache := new(mockBalanceCacheGetterSetter)
+			balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, test.beta)
+			cache.On("GetWithTTL", expenses.BalanceCacheKey(1)).Return(cached, test.ttl, nil)
+			balanceRepository.On("Get", mock.Anything, querier, uint(1)).Return(refreshed, test.refreshResult)
+			cache.On("Set", expenses.BalanceCacheKey(1), refreshed).Return(nil)
+
+			// when
+			result, err := balanceService.Get(ctx, 1)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestDefaultBalanceServiceRefresh(t *testing.T) {
+	// given
+	ctx := context.Background()
+	balanceRepository := new(mockBalanceRepository)
+	querier := new(mockTxQuerier)
+	cache := new(mockBalanceCacheGetterSetter)
+	balanceService := expenses.NewDefaultBalanceService(querier, cache, balanceRepository, 0)
+	balance1 := expenses.Balance{2: 10.0}
+	balance2 := expenses.Balance{1: -10.0}
+	balanceRepository.On("Get", ctx, querier, uint(1)).Return(balance1, nil)
+	balanceRepository.On("Get", ctx, querier, uint(2)).Return(balance2, nil)
+	balanceRepository.On("Get", ctx, querier, uint(3)).Return(expenses.Balance{}, errors.New("expected"))
+	cache.On("Set", expenses.BalanceCacheKey(1), balance1).Return(nil)
+	cache.On("Set", expenses.BalanceCacheKey(2), balance2).Return(nil)
+
+	// when
+	err := balanceService.Refresh(ctx, 1, 2)
+
+	// then
+	require.NoError(t, err)
+	cache.AssertExpectations(t)
+	require.Error(t, balanceService.Refresh(ctx, 3))
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"io/ioutil"
+	"net"
+	"sync"
 	"testing"
 )
 
@@ -21,14 +23,61 @@ const (
 	pgDb       = "expenses"
 	pgPort     = "5432/tcp"
 
-	deleteAllUsersQuery  = "DELETE FROM users"
-	deleteAllGroupsQuery = "DELETE FROM groups"
+	deleteAllExpensesQuery = "DELETE FROM expenses"
+	deleteAllUsersQuery    = "DELETE FROM users"
+	deleteAllGroupsQuery   = "DELETE FROM groups"
 )
 
-var pgdb = createPGContainerAndGetDbUrl(context.Background())
+var (
+	pgContainer lazyPGContainer
+	// pgdb starts the container with its first connection, so that tests that don't use the DB, like the ones of memory
+	// implementations, run without Docker
+	pgdb = newLazyPGPool()
+)
+
+// newLazyPGPool returns a pool that connects to the PG container, the container is started by the first connection
+func newLazyPGPool() *pgxpool.Pool {
+	config, err := pgxpool.ParseConfig(
+		fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", pgUser, pgPassword, pgDb),
+	)
+	if err != nil {
+		panic(err)
+	}
+	config.LazyConnect = true
+	config.ConnConfig.DialFunc = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		endpoint, err := pgContainer.start()
+		if err != nil {
+			return nil, err
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", endpoint)
+	}
+	db, err := pgxpool.ConnectConfig(context.Background(), config)
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// lazyPGContainer is started once, when it is needed for the first time
+type lazyPGContainer struct {
+	mutex    sync.Mutex
+	endpoint string
+	err      error
+}
 
-// Creates PG container, applies necessary schema. If there is any error - it will panic
-func createPGContainerAndGetDbUrl(ctx context.Context) *pgxpool.Pool {
+// start the container if it is not started yet and return its endpoint
+func (c *lazyPGContainer) start() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.endpoint == "" && c.err == nil {
+		c.endpoint, c.err = createPGContainer(context.Background())
+	}
+	return c.endpoint, c.err
+}
+
+// Creates PG container, applies necessary schema and returns its endpoint
+func createPGContainer(ctx context.Context) (string, error) {
 	postgres, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
 			Image:        pgImage,
@@ -47,32 +96,32 @@ func createPGContainerAndGetDbUrl(ctx context.Context) *pgxpool.Pool {
 		Started: true,
 	})
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	endpoint, err := postgres.Endpoint(ctx, "")
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-
 	url := fmt.Sprintf("postgresql://%s:%s@%s/%s", pgUser, pgPassword, endpoint, pgDb)
-
-	db, err := pgxpool.Connect(ctx, url)
+	conn, err := pgx.Connect(ctx, url)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
+	defer conn.Close(ctx)
 	schema, err := ioutil.ReadFile("../db/001_schema.sql")
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	_, err = db.Exec(ctx, string(schema))
-	if err != nil {
-		panic(err)
+	if _, err = conn.Exec(ctx, string(schema)); err != nil {
+		return "", err
 	}
-	return db
+	return endpoint, nil
 }
 
 func cleanUpDB(t *testing.T, ctx context.Context) {
-	_, err := pgdb.Exec(ctx, deleteAllGroupsQuery)
+	_, err := pgdb.Exec(ctx, deleteAllExpensesQuery)
+	require.NoError(t, err)
+	_, err = pgdb.Exec(ctx, deleteAllGroupsQuery)
 	require.NoError(t, err)
 	_, err = pgdb.Exec(ctx, deleteAllUsersQuery)
 	require.NoError(t, err)
@@ -130,3 +130,48 @@ func (c *CacheRemovingService) cleanCache(expenseResponse ExpenseResponse) {
 		log.Warn("couldn't clear cache for keys - err", err)
 	}
 }
+
+// CacheUpdatingService is an expenses Service that recomputes Balance caches for involved users after successful
+// storage of new expense for them, so that the next requests of their balances don't have to wait for the DB.
+// Caches that couldn't be recomputed are removed.
+type CacheUpdatingService struct {
+	delegate            Service
+	balanceRefresher    BalanceRefresher
+	balanceCacheCleaner BalanceCacheCleaner
+}
+
+// NewCacheUpdatingService creates a new instance of CacheUpdatingService
+func NewCacheUpdatingService(
+	delegate Service,
+	balanceRefresher BalanceRefresher,
+	balanceCacheCleaner BalanceCacheCleaner,
+) *CacheUpdatingService {
+	return &CacheUpdatingService{
+		delegate:            delegate,
+		balanceRefresher:    balanceRefresher,
+		balanceCacheCleaner: balanceCacheCleaner,
+	}
+}
+
+// Create delegates creation and refreshes caches after successful creation
+func (c *CacheUpdatingService) Create(ctx context.Context, newExpense CreateExpenseContext) (ExpenseResponse, error) {
+	expenseResponse, err := c.delegate.Create(ctx, newExpense)
+	if err != nil {
+		return ExpenseResponse{}, err
+	}
+	userIDs := make([]uint, 0, len(expenseResponse.Shares))
+	for userID := range expenseResponse.Shares {
+		userIDs = append(userIDs, userID)
+	}
+	if err = c.balanceRefresher.Refresh(ctx, userIDs...); err != nil {
+		log.Warn("couldn't refresh cache for users %v, removing it - %s", userIDs, err)
+		keys := make([]BalanceCacheKey, len(userIDs))
+		for i, userID := range userIDs {
+			keys[i] = BalanceCacheKey(userID)
+		}
+		if err = c.balanceCacheCleaner.Remove(keys...); err != nil {
+			log.Warn("couldn't clear cache for users %v - %s", userIDs, err)
+		}
+	}
+	return expenseResponse, nil
+}
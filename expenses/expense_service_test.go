@@ -51,6 +51,15 @@ func (m *mockBalanceCacheCleaner) Remove(keys ...expenses.BalanceCacheKey) error
 	return args.Error(0)
 }
 
+type mockBalanceRefresher struct {
+	mock.Mock
+}
+
+func (m *mockBalanceRefresher) Refresh(ctx context.Context, userIDs ...uint) error {
+	args := m.Called(ctx, userIDs)
+	return args.Error(0)
+}
+
 // integration tests
 func TestDefaultServiceCreateExpense(t *testing.T) {
 	// given
@@ -349,6 +358,70 @@ func TestCacheRemovingServiceErrorFromDelegateReturned(t *testing.T) {
 	require.EqualError(t, err, "zzzz")
 }
 
+func TestCacheUpdatingService(t *testing.T) {
+	tests := []struct {
+		name          string
+		refreshResult error
+		removed       bool
+	}{
+		{
+			name:          "refreshed",
+			refreshResult: nil,
+			removed:       false,
+		},
+		{
+			name:          "removed after failed refresh",
+			refreshResult: errors.New("mmmmm"),
+			removed:       true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// given
+			ctx := context.Background()
+			refresher := new(mockBalanceRefresher)
+			cacheCleaner := new(mockBalanceCacheCleaner)
+			delegate := new(mockExpensesService)
+			service := expenses.NewCacheUpdatingService(delegate, refresher, cacheCleaner)
+			expenseContext := expenses.CreateExpenseContext{UserID: 1}
+			response := expenses.ExpenseResponse{UserID: 1, Shares: expenses.ExpenseShares{1: 100}}
+			delegate.On("Create", ctx, expenseContext).Return(response, nil)
+			refresher.On("Refresh", ctx, []uint{1}).Return(test.refreshResult)
+			cacheCleaner.On("Remove", []expenses.BalanceCacheKey{1}).Return(nil)
+
+			// when
+			result, err := service.Create(ctx, expenseContext)
+
+			// then
+			require.NoError(t, err)
+			assert.Equal(t, response, result)
+			refresher.AssertExpectations(t)
+			if test.removed {
+				cacheCleaner.AssertExpectations(t)
+			} else {
+				cacheCleaner.AssertNotCalled(t, "Remove", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCacheUpdatingServiceErrorFromDelegateReturned(t *testing.T) {
+	// given
+	ctx := context.Background()
+	refresher := new(mockBalanceRefresher)
+	delegate := new(mockExpensesService)
+	service := expenses.NewCacheUpdatingService(delegate, refresher, new(mockBalanceCacheCleaner))
+	expenseContext := expenses.CreateExpenseContext{UserID: 1}
+	delegate.On("Create", ctx, expenseContext).Return(expenses.ExpenseResponse{}, errors.New("zzzz"))
+
+	// when
+	_, err := service.Create(ctx, expenseContext)
+
+	// then
+	require.EqualError(t, err, "zzzz")
+	refresher.AssertNotCalled(t, "Refresh", mock.Anything, mock.Anything)
+}
+
 func createProperUser(
 	ctx context.Context,
 	t *testing.T,
@@ -12,12 +12,27 @@ type Group struct {
 	Name util.NonEmptyString
 }
 
+// GroupResponse contains a group with all its members, use SeenBy before it is returned to a member
 type GroupResponse struct {
 	ID    uint                `json:"id"`
 	Name  util.NonEmptyString `json:"name"`
 	Users []UserResponse      `json:"users"`
 }
 
+// SeenBy returns the group as the member with userID sees it: emails of other members are omitted, they are known by
+// display names
+func (g GroupResponse) SeenBy(userID uint) GroupResponse {
+	users := make([]UserResponse, len(g.Users))
+	for i, user := range g.Users {
+		if user.ID != userID {
+			user.Email = ""
+		}
+		users[i] = user
+	}
+	g.Users = users
+	return g
+}
+
 // CreateGroupRequest is a JSON request to create a Group
 type CreateGroupRequest struct {
 	Name util.NonEmptyString `json:"name"`
@@ -56,3 +71,55 @@ type AddToGroupRequest struct {
 	UserID  uint `json:"userId"`
 	GroupID uint `json:"groupId"`
 }
+
+// CreatePlaceholderRequest is a JSON request to add a placeholder member to the group of the current user
+type CreatePlaceholderRequest struct {
+	Name util.NonEmptyString `json:"name"`
+}
+
+// UnmarshalJSON transforms the request JSON data and validates it.
+func (c *CreatePlaceholderRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	type createPlaceholderRequest struct {
+		Name string `json:"name"`
+	}
+	var req createPlaceholderRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var err error
+	if err = decoder.Decode(&req); err != nil {
+		return err
+	}
+	if len(req.Name) > maxDisplayNameLength {
+		return ErrDisplayNameTooLong
+	}
+	c.Name, err = util.NewNonEmptyString(req.Name)
+	return err
+}
+
+// CreatePlaceholderContext contains necessary info to create a placeholder member in a group
+type CreatePlaceholderContext struct {
+	Name    util.NonEmptyString
+	GroupID uint
+}
+
+// PlaceholderResponse contains info about created placeholder member. ClaimCode is shown only once and should be
+// passed to the person that placeholder represents.
+type PlaceholderResponse struct {
+	ID        uint                `json:"id"`
+	Name      util.NonEmptyString `json:"name"`
+	ClaimCode string              `json:"claimCode"`
+}
+
+// ClaimPlaceholderRequest is a JSON request of an existing user to take over a placeholder member
+type ClaimPlaceholderRequest struct {
+	ClaimCode string `json:"claimCode"`
+}
+
+// ClaimPlaceholderContext contains necessary info to merge placeholder member into an existing user
+type ClaimPlaceholderContext struct {
+	UserID    uint
+	ClaimCode string
+}
@@ -16,7 +16,7 @@ type GroupRepository interface {
 	Create(ctx context.Context, db pgxtype.Querier, group util.NonEmptyString) (Group, error)
 	// Find Group by its ID
 	FindByID(ctx context.Context, db pgxtype.Querier, id uint) (Group, error)
-	// Find Group by its ID with Users in this group
+	// Find Group by its ID with Users in this group, placeholder members included
 	FindByIDWithUsers(ctx context.Context, db pgxtype.Querier, id uint) (GroupResponse, error)
 	// Find Group by one of its members ids. As we allow only one group per user that should return 0-1 results.
 	FindByUserID(ctx context.Context, db pgxtype.Querier, userID uint) (Group, error)
@@ -29,11 +29,13 @@ const (
 	createGroupQuery            = "INSERT INTO groups (name) VALUES ($1) RETURNING id"
 	addUserToGroup              = "INSERT INTO users_groups (user_id, group_id) VALUES ($1, $2)"
 	findGroupByIDQuery          = "SELECT g.id, g.name FROM groups as g WHERE g.id = $1"
-	findGroupByIDWithUsersQuery = "SELECT g.id, g.name, u.id, u.email " +
+	findGroupByIDWithUsersQuery = "SELECT g.id, g.name, u.id, COALESCE(u.email, ''), " +
+		"COALESCE(u.display_name, ''), u.email IS NULL " +
 		"FROM groups as g " +
 		"JOIN users_groups as ug on g.id = ug.group_id " +
 		"JOIN users as u on ug.user_id = u.id " +
-		"WHERE g.id = $1"
+		"WHERE g.id = $1 " +
+		"ORDER BY u.id"
 	findGroupByUserIDQuery = "SELECT g.id, g.name " +
 		"FROM groups as g " +
 		"JOIN users_groups as ug ON g.id = ug.group_id " +
@@ -104,7 +106,8 @@ func (p *PgGroupRepository) FindByIDWithUsers(ctx context.Context, db pgxtype.Qu
 	rowsFound := 0
 	for ; rows.Next(); rowsFound++ {
 		var user UserResponse
-		if err = rows.Scan(&group.ID, &group.Name, &user.ID, &user.Email); err != nil {
+		err = rows.Scan(&group.ID, &group.Name, &user.ID, &user.Email, &user.DisplayName, &user.Placeholder)
+		if err != nil {
 			return GroupResponse{}, err
 		}
 		group.Users = append(group.Users, user)
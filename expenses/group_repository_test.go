@@ -207,3 +207,28 @@ func TestFindGroupByUserIDNotFound(t *testing.T) {
 	_, err = groupRepository.FindByUserID(ctx, pgdb, user.ID)
 	assert.EqualError(t, err, expenses.ErrGroupNotFound.Error())
 }
+
+func TestFindWithUsersByIDWithPlaceholder(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	userRepository := expenses.NewPgUserRepository()
+	groupRepository := expenses.NewPgGroupRepository()
+
+	// create user, placeholder and group, add both to the group
+	user, err := userRepository.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "some@mail.ru", Password: "12xczc"})
+	require.NoError(t, err)
+	placeholder, err := userRepository.CreatePlaceholder(ctx, pgdb, "Bob", "claim-code")
+	require.NoError(t, err)
+	group, err := groupRepository.Create(ctx, pgdb, "myGroup")
+	require.NoError(t, err)
+	require.NoError(t, groupRepository.AddUserToGroup(ctx, pgdb, user.ID, group.ID))
+	require.NoError(t, groupRepository.AddUserToGroup(ctx, pgdb, placeholder.ID, group.ID))
+
+	// Find group with users and check result
+	found, err := groupRepository.FindByIDWithUsers(ctx, pgdb, group.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(found.Users))
+	assert.Equal(t, expenses.UserResponse{ID: user.ID, Email: user.Email}, found.Users[0])
+	assert.Equal(t, expenses.UserResponse{ID: placeholder.ID, DisplayName: "Bob", Placeholder: true}, found.Users[1])
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"github.com/jackc/pgtype/pgxtype"
 	"go-spend/db"
+	"go-spend/log"
+	"go-spend/util/uuid"
 )
 
 // Perform operations with groups of Users
@@ -14,6 +16,10 @@ type GroupService interface {
 	FindByID(ctx context.Context, id uint) (GroupResponse, error)
 	// AddUserToGroup adds user to an existing group
 	AddUserToGroup(ctx context.Context, addRequest AddToGroupRequest) error
+	// CreatePlaceholder adds a member without an account to an existing group
+	CreatePlaceholder(ctx context.Context, request CreatePlaceholderContext) (PlaceholderResponse, error)
+	// ClaimPlaceholder merges placeholder member into an existing user, the user joins the group of the placeholder
+	ClaimPlaceholder(ctx context.Context, request ClaimPlaceholderContext) (GroupResponse, error)
 }
 
 // DefaultGroupService is default implementation of GroupService. If fetches data through UserRepository and
@@ -53,20 +59,16 @@ func (d *DefaultGroupService) Create(ctx context.Context, request CreateGroupCon
 			return err
 		}
 		resp = GroupResponse{
-			ID:   group.ID,
-			Name: group.Name,
-			Users: []UserResponse{
-				{
-					ID:    creator.ID,
-					Email: creator.Email,
-				},
-			},
+			ID:    group.ID,
+			Name:  group.Name,
+			Users: []UserResponse{NewUserResponse(creator)},
 		}
 		return nil
 	})
 	return resp, err
 }
 
+// FindByID returns emails of all members, see GroupResponse.SeenBy
 func (d *DefaultGroupService) FindByID(ctx context.Context, id uint) (GroupResponse, error) {
 	return d.groupRepository.FindByIDWithUsers(ctx, d.db, id)
 }
@@ -74,3 +76,122 @@ func (d *DefaultGroupService) FindByID(ctx context.Context, id uint) (GroupRespo
 func (d *DefaultGroupService) AddUserToGroup(ctx context.Context, addRequest AddToGroupRequest) error {
 	return d.groupRepository.AddUserToGroup(ctx, d.db, addRequest.UserID, addRequest.GroupID)
 }
+
+// CreatePlaceholder creates a placeholder member and adds it to the group. Returned claim code is not stored anywhere in
+// plain form, so it can't be retrieved later.
+// If group doesn't exist - returns ErrUserOrGroupNotFound
+func (d *DefaultGroupService) CreatePlaceholder(
+	ctx context.Context,
+	request CreatePlaceholderContext,
+) (PlaceholderResponse, error) {
+	claimCode, err := uuid.NewV4()
+	if err != nil {
+		return PlaceholderResponse{}, err
+	}
+	var resp PlaceholderResponse
+	err = db.WithTx(ctx, d.db, func(tx pgxtype.Querier) error {
+		placeholder, err := d.userRepository.CreatePlaceholder(ctx, tx, string(request.Name), claimCode.String())
+		if err != nil {
+			return err
+		}
+		if err = d.groupRepository.AddUserToGroup(ctx, tx, placeholder.ID, request.GroupID); err != nil {
+			return err
+		}
+		resp = PlaceholderResponse{ID: placeholder.ID, Name: request.Name, ClaimCode: claimCode.String()}
+		return nil
+	})
+	return resp, err
+}
+
+// ClaimPlaceholder moves all expenses and shares of the placeholder to the user, removes the placeholder and adds the
+// user to the group of the placeholder.
+// If user is in a group already - returns ErrUserIsInAnotherGroup
+// If there is no placeholder with such claim code - returns ErrPlaceholderNotFound
+func (d *DefaultGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request ClaimPlaceholderContext,
+) (GroupResponse, error) {
+	var resp GroupResponse
+	err := db.WithTx(ctx, d.db, func(tx pgxtype.Querier) error {
+		user, err := d.userRepository.FindById(ctx, tx, request.UserID)
+		if err != nil {
+			return err
+		}
+		if user.GroupID != 0 {
+			return ErrUserIsInAnotherGroup
+		}
+		placeholder, err := d.userRepository.FindPlaceholderByClaimCode(ctx, tx, request.ClaimCode)
+		if err != nil {
+			return err
+		}
+		if placeholder.GroupID == 0 { // the group was removed, nothing to claim
+			return ErrPlaceholderNotFound
+		}
+		if err = d.userRepository.MergePlaceholder(ctx, tx, placeholder.ID, user.ID); err != nil {
+			return err
+		}
+		if err = d.groupRepository.AddUserToGroup(ctx, tx, user.ID, placeholder.GroupID); err != nil {
+			return err
+		}
+		group, err := d.groupRepository.FindByIDWithUsers(ctx, tx, placeholder.GroupID)
+		if err != nil {
+			return err
+		}
+		resp = group.SeenBy(user.ID)
+		return nil
+	})
+	return resp, err
+}
+
+// CacheRemovingGroupService is a GroupService that removes Balance caches of group members after a placeholder was
+// claimed, as balances of all of them now reference a different user.
+type CacheRemovingGroupService struct {
+	delegate            GroupService
+	balanceCacheCleaner BalanceCacheCleaner
+}
+
+// NewCacheRemovingGroupService creates a new instance of CacheRemovingGroupService
+func NewCacheRemovingGroupService(
+	delegate GroupService,
+	balanceCacheCleaner BalanceCacheCleaner,
+) *CacheRemovingGroupService {
+	return &CacheRemovingGroupService{delegate: delegate, balanceCacheCleaner: balanceCacheCleaner}
+}
+
+func (c *CacheRemovingGroupService) Create(ctx context.Context, request CreateGroupContext) (GroupResponse, error) {
+	return c.delegate.Create(ctx, request)
+}
+
+func (c *CacheRemovingGroupService) FindByID(ctx context.Context, id uint) (GroupResponse, error) {
+	return c.delegate.FindByID(ctx, id)
+}
+
+func (c *CacheRemovingGroupService) AddUserToGroup(ctx context.Context, addRequest AddToGroupRequest) error {
+	return c.delegate.AddUserToGroup(ctx, addRequest)
+}
+
+func (c *CacheRemovingGroupService) CreatePlaceholder(
+	ctx context.Context,
+	request CreatePlaceholderContext,
+) (PlaceholderResponse, error) {
+	return c.delegate.CreatePlaceholder(ctx, request)
+}
+
+// ClaimPlaceholder delegates the claim and performs cache clean-up for all members of the group after it
+func (c *CacheRemovingGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request ClaimPlaceholderContext,
+) (GroupResponse, error) {
+	group, err := c.delegate.ClaimPlaceholder(ctx, request)
+	if err != nil {
+		return GroupResponse{}, err
+	}
+	keys := make([]BalanceCacheKey, len(group.Users))
+	for i, user := range group.Users {
+		keys[i] = BalanceCacheKey(user.ID)
+	}
+	if err = c.balanceCacheCleaner.Remove(keys...); err != nil {
+		log.Warn("couldn't clear cache for members of group %d - %s", group.ID, err)
+	}
+	return group, nil
+}
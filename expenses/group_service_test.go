@@ -46,6 +46,37 @@ func (m *mockGroupRepository) AddUserToGroup(ctx context.Context, db pgxtype.Que
 	return args.Error(0)
 }
 
+type mockGroupService struct {
+	mock.Mock
+}
+
+func (m *mockGroupService) Create(_ context.Context, _ expenses.CreateGroupContext) (expenses.GroupResponse, error) {
+	panic("implement me")
+}
+
+func (m *mockGroupService) FindByID(_ context.Context, _ uint) (expenses.GroupResponse, error) {
+	panic("implement me")
+}
+
+func (m *mockGroupService) AddUserToGroup(_ context.Context, _ expenses.AddToGroupRequest) error {
+	panic("implement me")
+}
+
+func (m *mockGroupService) CreatePlaceholder(
+	_ context.Context,
+	_ expenses.CreatePlaceholderContext,
+) (expenses.PlaceholderResponse, error) {
+	panic("implement me")
+}
+
+func (m *mockGroupService) ClaimPlaceholder(
+	ctx context.Context,
+	request expenses.ClaimPlaceholderContext,
+) (expenses.GroupResponse, error) {
+	args := m.Called(ctx, request)
+	return args.Get(0).(expenses.GroupResponse), args.Error(1)
+}
+
 type mockTx struct {
 	mock.Mock
 }
@@ -125,6 +156,51 @@ func (m *mockUserRepository) FindByEmail(
 	return args.Get(0).(expenses.User), args.Error(1)
 }
 
+func (m *mockUserRepository) CreatePlaceholder(
+	ctx context.Context,
+	db pgxtype.Querier,
+	name string,
+	claimCode string,
+) (expenses.User, error) {
+	args := m.Called(ctx, db, name, claimCode)
+	return args.Get(0).(expenses.User), args.Error(1)
+}
+
+func (m *mockUserRepository) ClaimPlaceholder(
+	_ context.Context,
+	_ pgxtype.Querier,
+	_ expenses.CreateUserRequest,
+) (expenses.User, error) {
+	panic("implement me")
+}
+
+func (m *mockUserRepository) FindPlaceholderByClaimCode(
+	ctx context.Context,
+	db pgxtype.Querier,
+	claimCode string,
+) (expenses.User, error) {
+	args := m.Called(ctx, db, claimCode)
+	return args.Get(0).(expenses.User), args.Error(1)
+}
+
+func (m *mockUserRepository) MergePlaceholder(
+	ctx context.Context,
+	db pgxtype.Querier,
+	placeholderID uint,
+	userID uint,
+) error {
+	args := m.Called(ctx, db, placeholderID, userID)
+	return args.Error(0)
+}
+
+func (m *mockUserRepository) UpdatePassword(_ context.Context, _ pgxtype.Querier, _ uint, _ expenses.Password) error {
+	panic("implement me")
+}
+
+func (m *mockUserRepository) MarkVerified(_ context.Context, _ pgxtype.Querier, _ uint, _ expenses.Email) error {
+	panic("implement me")
+}
+
 func TestNewDefaultGroupService(t *testing.T) {
 	groupService := expenses.NewDefaultGroupService(pgdb, expenses.NewPgUserRepository(), expenses.NewPgGroupRepository())
 	require.NotNil(t, groupService)
@@ -333,3 +409,220 @@ func TestDefaultGroupServiceAddUserToGroupErrorPropagated(t *testing.T) {
 	// then
 	require.Error(t, err)
 }
+
+func TestCreatePlaceholder(t *testing.T) {
+	// given
+	ctx := context.Background()
+
+	db := new(mockTxQuerier)
+	userRepository := new(mockUserRepository)
+	groupRepository := new(mockGroupRepository)
+	tx := new(mockTx)
+	groupService := expenses.NewDefaultGroupService(db, userRepository, groupRepository)
+	db.On("Begin", ctx).Return(tx, nil)
+	request := expenses.CreatePlaceholderContext{Name: "Bob", GroupID: 3}
+	placeholder := expenses.User{ID: 10, DisplayName: "Bob"}
+	userRepository.On("CreatePlaceholder", ctx, tx, "Bob", mock.AnythingOfType("string")).Return(placeholder, nil)
+	groupRepository.On("AddUserToGroup", ctx, tx, placeholder.ID, request.GroupID).Return(nil)
+	tx.On("Commit", ctx).Return(nil)
+
+	// when
+	created, err := groupService.CreatePlaceholder(ctx, request)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, placeholder.ID, created.ID)
+	assert.Equal(t, request.Name, created.Name)
+	assert.NotEmpty(t, created.ClaimCode)
+	userRepository.AssertCalled(t, "CreatePlaceholder", ctx, tx, "Bob", created.ClaimCode)
+}
+
+func TestCreatePlaceholderGroupNotFound(t *testing.T) {
+	// given
+	ctx := context.Background()
+
+	db := new(mockTxQuerier)
+	userRepository := new(mockUserRepository)
+	groupRepository := new(mockGroupRepository)
+	tx := new(mockTx)
+	groupService := expenses.NewDefaultGroupService(db, userRepository, groupRepository)
+	db.On("Begin", ctx).Return(tx, nil)
+	request := expenses.CreatePlaceholderContext{Name: "Bob", GroupID: 3}
+	placeholder := expenses.User{ID: 10, DisplayName: "Bob"}
+	userRepository.On("CreatePlaceholder", ctx, tx, "Bob", mock.AnythingOfType("string")).Return(placeholder, nil)
+	groupRepository.On("AddUserToGroup", ctx, tx, placeholder.ID, request.GroupID).
+		Return(expenses.ErrUserOrGroupNotFound)
+
+	// when
+	created, err := groupService.CreatePlaceholder(ctx, request)
+
+	// then
+	require.EqualError(t, err, expenses.ErrUserOrGroupNotFound.Error())
+	assert.Zero(t, created)
+}
+
+func TestClaimPlaceholderUserInAnotherGroup(t *testing.T) {
+	// given
+	ctx := context.Background()
+
+	db := new(mockTxQuerier)
+	userRepository := new(mockUserRepository)
+	groupRepository := new(mockGroupRepository)
+	tx := new(mockTx)
+	groupService := expenses.NewDefaultGroupService(db, userRepository, groupRepository)
+	db.On("Begin", ctx).Return(tx, nil)
+	userRepository.On("FindById", ctx, tx, uint(1)).Return(expenses.User{ID: 1, GroupID: 2}, nil)
+
+	// when
+	_, err := groupService.ClaimPlaceholder(ctx, expenses.ClaimPlaceholderContext{UserID: 1, ClaimCode: "code"})
+
+	// then
+	require.EqualError(t, err, expenses.ErrUserIsInAnotherGroup.Error())
+	userRepository.AssertNotCalled(t, "MergePlaceholder", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestClaimPlaceholderNotFound(t *testing.T) {
+	// given
+	ctx := context.Background()
+
+	db := new(mockTxQuerier)
+	userRepository := new(mockUserRepository)
+	groupRepository := new(mockGroupRepository)
+	tx := new(mockTx)
+	groupService := expenses.NewDefaultGroupService(db, userRepository, groupRepository)
+	db.On("Begin", ctx).Return(tx, nil)
+	userRepository.On("FindById", ctx, tx, uint(1)).Return(expenses.User{ID: 1}, nil)
+	userRepository.On("FindPlaceholderByClaimCode", ctx, tx, "code").
+		Return(expenses.User{}, expenses.ErrPlaceholderNotFound)
+
+	// when
+	_, err := groupService.ClaimPlaceholder(ctx, expenses.ClaimPlaceholderContext{UserID: 1, ClaimCode: "code"})
+
+	// then
+	require.EqualError(t, err, expenses.ErrPlaceholderNotFound.Error())
+}
+
+func TestClaimPlaceholderOmitsEmailsOfOtherMembers(t *testing.T) {
+	// given
+	ctx := context.Background()
+
+	db := new(mockTxQuerier)
+	userRepository := new(mockUserRepository)
+	groupRepository := new(mockGroupRepository)
+	tx := new(mockTx)
+	groupService := expenses.NewDefaultGroupService(db, userRepository, groupRepository)
+	db.On("Begin", ctx).Return(tx, nil)
+	tx.On("Commit", ctx).Return(nil)
+	userRepository.On("FindById", ctx, tx, uint(1)).Return(expenses.User{ID: 1}, nil)
+	userRepository.On("FindPlaceholderByClaimCode", ctx, tx, "code").
+		Return(expenses.User{ID: 3, GroupID: 2, DisplayName: "Bob"}, nil)
+	userRepository.On("MergePlaceholder", ctx, tx, uint(3), uint(1)).Return(nil)
+	groupRepository.On("AddUserToGroup", ctx, tx, uint(1), uint(2)).Return(nil)
+	groupRepository.On("FindByIDWithUsers", ctx, tx, uint(2)).Return(expenses.GroupResponse{
+		ID:   2,
+		Name: "group",
+		Users: []expenses.UserResponse{
+			{ID: 1, Email: "bob@test.com"},
+			{ID: 2, Email: "alice@test.com", DisplayName: "Alice"},
+			{ID: 4, Email: "carol@test.com"},
+		},
+	}, nil)
+
+	// when
+	group, err := groupService.ClaimPlaceholder(ctx, expenses.ClaimPlaceholderContext{UserID: 1, ClaimCode: "code"})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []expenses.UserResponse{
+		{ID: 1, Email: "bob@test.com"},
+		{ID: 2, DisplayName: "Alice"},
+		{ID: 4},
+	}, group.Users)
+}
+
+// This is an integration test as expenses of the placeholder should be moved to the user
+func TestClaimPlaceholderMovesExpenses(t *testing.T) {
+	// given
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	userRepository := expenses.NewPgUserRepository()
+	groupRepository := expenses.NewPgGroupRepository()
+	expensesRepository := expenses.NewPgRepository()
+	balanceRepository := expenses.NewPgBalanceRepository()
+	groupService := expenses.NewDefaultGroupService(pgdb, userRepository, groupRepository)
+
+	user1 := createProperUser(ctx, t, "1", userRepository)
+	user2 := createProperUser(ctx, t, "2", userRepository)
+	group, err := groupService.Create(ctx, expenses.CreateGroupContext{Name: "group", CreatorID: user1.ID})
+	require.NoError(t, err)
+	placeholder, err := groupService.CreatePlaceholder(ctx, expenses.CreatePlaceholderContext{
+		Name:    "Bob",
+		GroupID: group.ID,
+	})
+	require.NoError(t, err)
+	payForCoffee(t, expensesRepository, ctx, user1.ID, placeholder.ID)
+
+	// when
+	claimedGroup, err := groupService.ClaimPlaceholder(ctx, expenses.ClaimPlaceholderContext{
+		UserID:    user2.ID,
+		ClaimCode: placeholder.ClaimCode,
+	})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, group.ID, claimedGroup.ID)
+	require.Equal(t, 2, len(claimedGroup.Users))
+	assert.Equal(t, user2.ID, claimedGroup.Users[1].ID)
+	balance, err := balanceRepository.Get(ctx, pgdb, user1.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, coffeePrice*0.5, balance[user2.ID], 0.01)
+	_, placeholderOwes := balance[placeholder.ID]
+	assert.False(t, placeholderOwes)
+	// code can't be used twice
+	_, err = groupService.ClaimPlaceholder(ctx, expenses.ClaimPlaceholderContext{
+		UserID:    user2.ID,
+		ClaimCode: placeholder.ClaimCode,
+	})
+	assert.Error(t, err)
+}
+
+func TestCacheRemovingGroupServiceClaimPlaceholder(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	cleaner := new(mockBalanceCacheCleaner)
+	service := expenses.NewCacheRemovingGroupService(delegate, cleaner)
+	request := expenses.ClaimPlaceholderContext{UserID: 2, ClaimCode: "code"}
+	group := expenses.GroupResponse{
+		ID:    1,
+		Name:  "group",
+		Users: []expenses.UserResponse{{ID: 1}, {ID: 2}, {ID: 5, Placeholder: true}},
+	}
+	delegate.On("ClaimPlaceholder", ctx, request).Return(group, nil)
+	cleaner.On("Remove", []expenses.BalanceCacheKey{1, 2, 5}).Return(nil)
+
+	// when
+	result, err := service.ClaimPlaceholder(ctx, request)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, group, result)
+	cleaner.AssertExpectations(t)
+}
+
+func TestCacheRemovingGroupServiceClaimPlaceholderError(t *testing.T) {
+	// given
+	ctx := context.Background()
+	delegate := new(mockGroupService)
+	cleaner := new(mockBalanceCacheCleaner)
+	service := expenses.NewCacheRemovingGroupService(delegate, cleaner)
+	request := expenses.ClaimPlaceholderContext{UserID: 2, ClaimCode: "code"}
+	delegate.On("ClaimPlaceholder", ctx, request).Return(expenses.GroupResponse{}, expenses.ErrPlaceholderNotFound)
+
+	// when
+	_, err := service.ClaimPlaceholder(ctx, request)
+
+	// then
+	require.EqualError(t, err, expenses.ErrPlaceholderNotFound.Error())
+	cleaner.AssertNotCalled(t, "Remove", mock.Anything)
+}
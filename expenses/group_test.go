@@ -52,3 +52,37 @@ func TestCreateGroupRequestUnmarshalJSONErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestCreatePlaceholderRequestUnmarshalJSON(t *testing.T) {
+	var result expenses.CreatePlaceholderRequest
+	err := json.Unmarshal([]byte(`{"name":"Bob"}`), &result)
+	require.NoError(t, err)
+	assert.Equal(t, util.NonEmptyString("Bob"), result.Name)
+}
+
+func TestCreatePlaceholderRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"name":"name", "email":"some@mail.com"}`,
+		},
+		{
+			name: "empty name",
+			json: `{"name":""}`,
+		},
+		{
+			name: "too long name",
+			json: `{"name":"` + strings.Repeat("a", 101) + `"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req expenses.CreatePlaceholderRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+		})
+	}
+}
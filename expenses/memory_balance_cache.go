@@ -0,0 +1,140 @@
+package expenses
+
+import (
+	"container/list"
+	"go-spend/util"
+	"sync"
+	"time"
+)
+
+// MemoryBalanceCache is a BalanceCache that keeps balances in memory of the instance, for deployments without redis.
+// Only the last size used balances are kept, expired balances are removed by a janitor goroutine until Stop.
+// It can't be used by more than one instance, as expenses added via another instance wouldn't clear it.
+type MemoryBalanceCache struct {
+	mutex         sync.Mutex
+	size          int
+	cacheDuration time.Duration
+	// recent has the most recently used balance at the front
+	recent   *list.List
+	balances map[BalanceCacheKey]*list.Element
+	stop     func()
+}
+
+type memoryBalance struct {
+	key     BalanceCacheKey
+	balance Balance
+	// expiresAt is reported to callers, keptUntil is when the balance is forgotten, not later than expiresAt
+	expiresAt time.Time
+	keptUntil time.Time
+}
+
+// NewMemoryBalanceCache creates new instance of MemoryBalanceCache. Balances are kept for cacheDuration, expired ones
+// are removed every janitorInterval.
+func NewMemoryBalanceCache(size int, cacheDuration time.Duration, janitorInterval time.Duration) *MemoryBalanceCache {
+	c := &MemoryBalanceCache{
+		size:          size,
+		cacheDuration: cacheDuration,
+		recent:        list.New(),
+		balances:      make(map[BalanceCacheKey]*list.Element),
+	}
+	c.stop = util.StartJanitor(janitorInterval, c.removeExpired)
+	return c
+}
+
+// Get returns a copy of the balance, ErrBalanceNotCached if it is not cached or expired
+func (c *MemoryBalanceCache) Get(key BalanceCacheKey) (Balance, error) {
+	balance, _, err := c.GetWithTTL(key)
+	return balance, err
+}
+
+// GetWithTTL returns a copy of the balance and how long it stays cached
+func (c *MemoryBalanceCache) GetWithTTL(key BalanceCacheKey) (Balance, time.Duration, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.balances[key]
+	if !ok {
+		return nil, 0, ErrBalanceNotCached
+	}
+	cached := element.Value.(*memoryBalance)
+	now := time.Now()
+	if !now.Before(cached.keptUntil) {
+		c.remove(element)
+		return nil, 0, ErrBalanceNotCached
+	}
+	c.recent.MoveToFront(element)
+	return copyBalance(cached.balance), cached.expiresAt.Sub(now), nil
+}
+
+// Set a copy of the balance, the least recently used balance is evicted if the cache is full
+func (c *MemoryBalanceCache) Set(key BalanceCacheKey, balance Balance) error {
+	expiresAt := time.Now().Add(c.cacheDuration)
+	c.set(key, balance, expiresAt, expiresAt)
+	return nil
+}
+
+// set a copy of the balance that is kept until keptUntil but reported to expire at expiresAt, so that a copy of
+// a balance from another cache can be kept shorter than the original
+func (c *MemoryBalanceCache) set(key BalanceCacheKey, balance Balance, expiresAt time.Time, keptUntil time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached := &memoryBalance{key: key, balance: copyBalance(balance), expiresAt: expiresAt, keptUntil: keptUntil}
+	if element, ok := c.balances[key]; ok {
+		element.Value = cached
+		c.recent.MoveToFront(element)
+		return
+	}
+	c.balances[key] = c.recent.PushFront(cached)
+	for c.recent.Len() > c.size {
+		c.remove(c.recent.Back())
+	}
+}
+
+// Remove n key-values by provided keys
+func (c *MemoryBalanceCache) Remove(keys ...BalanceCacheKey) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range keys {
+		if element, ok := c.balances[key]; ok {
+			c.remove(element)
+		}
+	}
+	return nil
+}
+
+// clear removes all balances
+func (c *MemoryBalanceCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.recent.Init()
+	c.balances = make(map[BalanceCacheKey]*list.Element)
+}
+
+// Stop the janitor
+func (c *MemoryBalanceCache) Stop() {
+	c.stop()
+}
+
+func (c *MemoryBalanceCache) removeExpired() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	for _, element := range c.balances {
+		if !now.Before(element.Value.(*memoryBalance).keptUntil) {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *MemoryBalanceCache) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.balances, element.Value.(*memoryBalance).key)
+}
+
+// copyBalance so that callers can't change a cached balance, like they can't with redis
+func copyBalance(balance Balance) Balance {
+	copied := make(Balance, len(balance))
+	for userID, amount := range balance {
+		copied[userID] = amount
+	}
+	return copied
+}
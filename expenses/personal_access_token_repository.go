@@ -0,0 +1,147 @@
+package expenses
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+const (
+	createAccessTokenQuery = "INSERT INTO personal_access_tokens (user_id, name, token, scopes, expires_at) " +
+		"VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at"
+	findActiveAccessTokenQuery = "SELECT t.id, t.user_id, t.name, t.scopes, t.created_at, t.expires_at, " +
+		"COALESCE(ug.group_id, 0) " +
+		"FROM personal_access_tokens as t " +
+		"LEFT JOIN users_groups as ug ON t.user_id = ug.user_id " +
+		"WHERE t.token = $1 AND (t.expires_at IS NULL OR t.expires_at > current_timestamp)"
+	listAccessTokensQuery = "SELECT id, user_id, name, scopes, created_at, expires_at " +
+		"FROM personal_access_tokens WHERE user_id = $1 ORDER BY id"
+	deleteAccessTokenQuery = "DELETE FROM personal_access_tokens WHERE id = $1 AND user_id = $2"
+)
+
+var (
+	ErrAccessTokenNotFound = errors.New("personal access token not found")
+)
+
+// PersonalAccessToken is a long-lived token that a user creates for scripts and integrations. The token itself is
+// never stored, only its hash.
+type PersonalAccessToken struct {
+	ID        uint
+	UserID    uint
+	Name      string
+	Scopes    []string
+	CreatedAt time.Time
+	// ExpiresAt is nil for tokens that live until they are revoked
+	ExpiresAt *time.Time
+	// GroupID is the current group of the owner, it is set only by FindActive
+	GroupID uint
+}
+
+// PersonalAccessTokenRepository stores personal access tokens of users
+type PersonalAccessTokenRepository interface {
+	// Stores the token with the hash of the secret. ID and CreatedAt are set by the storage
+	Create(ctx context.Context, db pgxtype.Querier, token PersonalAccessToken, secret string) (PersonalAccessToken, error)
+	// Find a token that is not expired by its secret
+	FindActive(ctx context.Context, db pgxtype.Querier, secret string) (PersonalAccessToken, error)
+	// List all tokens of the user including expired ones, the oldest first
+	List(ctx context.Context, db pgxtype.Querier, userID uint) ([]PersonalAccessToken, error)
+	// Removes a token of the user
+	Delete(ctx context.Context, db pgxtype.Querier, userID uint, tokenID uint) error
+}
+
+// PgPersonalAccessTokenRepository is an implementation of PersonalAccessTokenRepository that works with postgresql
+type PgPersonalAccessTokenRepository struct {
+}
+
+// NewPgPersonalAccessTokenRepository creates new instance of PgPersonalAccessTokenRepository
+func NewPgPersonalAccessTokenRepository() *PgPersonalAccessTokenRepository {
+	return &PgPersonalAccessTokenRepository{}
+}
+
+func (r *PgPersonalAccessTokenRepository) Create(
+	ctx context.Context,
+	db pgxtype.Querier,
+	token PersonalAccessToken,
+	secret string,
+) (PersonalAccessToken, error) {
+	// secrets of tokens have 256 random bits
+	err := db.QueryRow(
+		ctx,
+		createAccessTokenQuery,
+		token.UserID,
+		token.Name,
+		hashSecret(secret),
+		token.Scopes,
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+	return token, nil
+}
+
+// FindActive returns ErrAccessTokenNotFound if there is no such token or it has expired
+func (r *PgPersonalAccessTokenRepository) FindActive(
+	ctx context.Context,
+	db pgxtype.Querier,
+	secret string,
+) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := db.QueryRow(ctx, findActiveAccessTokenQuery, hashSecret(secret)).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Scopes,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.GroupID,
+	)
+	if err == pgx.ErrNoRows {
+		return PersonalAccessToken{}, ErrAccessTokenNotFound
+	}
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+	return token, nil
+}
+
+func (r *PgPersonalAccessTokenRepository) List(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+) ([]PersonalAccessToken, error) {
+	rows, err := db.Query(ctx, listAccessTokensQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []PersonalAccessToken
+	for rows.Next() {
+		var token PersonalAccessToken
+		err = rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Scopes, &token.CreatedAt, &token.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Delete returns ErrAccessTokenNotFound if the user doesn't have such token
+func (r *PgPersonalAccessTokenRepository) Delete(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	tokenID uint,
+) error {
+	commandTag, err := db.Exec(ctx, deleteAccessTokenQuery, tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
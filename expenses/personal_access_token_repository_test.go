@@ -0,0 +1,109 @@
+package expenses_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/expenses"
+	"testing"
+	"time"
+)
+
+func createAccessTokenUser(t *testing.T, ctx context.Context, email expenses.Email) expenses.User {
+	user, err := expenses.NewPgUserRepository().
+		Create(ctx, pgdb, expenses.CreateUserRequest{Email: email, Password: "password"})
+	require.NoError(t, err)
+	return user
+}
+
+func TestCreateAndFindAccessToken(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createAccessTokenUser(t, ctx, "expenses@mail.com")
+	group, err := expenses.NewPgGroupRepository().Create(ctx, pgdb, "group")
+	require.NoError(t, err)
+	require.NoError(t, expenses.NewPgGroupRepository().AddUserToGroup(ctx, pgdb, user.ID, group.ID))
+	repository := expenses.NewPgPersonalAccessTokenRepository()
+
+	created, err := repository.Create(ctx, pgdb, expenses.PersonalAccessToken{
+		UserID: user.ID,
+		Name:   "script",
+		Scopes: []string{"read", "expenses:write"},
+	}, "gsp_secret")
+
+	require.NoError(t, err)
+	assert.NotZero(t, created.ID)
+	assert.NotZero(t, created.CreatedAt)
+	found, err := repository.FindActive(ctx, pgdb, "gsp_secret")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, found.ID)
+	assert.Equal(t, user.ID, found.UserID)
+	assert.Equal(t, group.ID, found.GroupID)
+	assert.Equal(t, "script", found.Name)
+	assert.Equal(t, []string{"read", "expenses:write"}, found.Scopes)
+	assert.Nil(t, found.ExpiresAt)
+}
+
+func TestFindAccessTokenNotFound(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	_, err := expenses.NewPgPersonalAccessTokenRepository().FindActive(ctx, pgdb, "gsp_secret")
+
+	assert.Equal(t, expenses.ErrAccessTokenNotFound, err)
+}
+
+func TestFindExpiredAccessToken(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createAccessTokenUser(t, ctx, "expenses@mail.com")
+	repository := expenses.NewPgPersonalAccessTokenRepository()
+	expiresAt := time.Now().Add(-time.Minute)
+	_, err := repository.Create(ctx, pgdb, expenses.PersonalAccessToken{
+		UserID:    user.ID,
+		Name:      "script",
+		Scopes:    []string{"read"},
+		ExpiresAt: &expiresAt,
+	}, "gsp_secret")
+	require.NoError(t, err)
+
+	_, err = repository.FindActive(ctx, pgdb, "gsp_secret")
+
+	assert.Equal(t, expenses.ErrAccessTokenNotFound, err)
+}
+
+func TestListAndDeleteAccessTokens(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createAccessTokenUser(t, ctx, "expenses@mail.com")
+	another := createAccessTokenUser(t, ctx, "another@mail.com")
+	repository := expenses.NewPgPersonalAccessTokenRepository()
+	first, err := repository.Create(
+		ctx,
+		pgdb,
+		expenses.PersonalAccessToken{UserID: user.ID, Name: "first", Scopes: []string{"read"}},
+		"gsp_first",
+	)
+	require.NoError(t, err)
+	second, err := repository.Create(
+		ctx,
+		pgdb,
+		expenses.PersonalAccessToken{UserID: user.ID, Name: "second", Scopes: []string{"read"}},
+		"gsp_second",
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, expenses.ErrAccessTokenNotFound, repository.Delete(ctx, pgdb, another.ID, first.ID))
+	require.NoError(t, repository.Delete(ctx, pgdb, user.ID, first.ID))
+
+	tokens, err := repository.List(ctx, pgdb, user.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, second.ID, tokens[0].ID)
+	assert.Equal(t, "second", tokens[0].Name)
+	_, err = repository.FindActive(ctx, pgdb, "gsp_first")
+	assert.Equal(t, expenses.ErrAccessTokenNotFound, err)
+	tokens, err = repository.List(ctx, pgdb, another.ID)
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+}
@@ -0,0 +1,107 @@
+package expenses
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxAvatarURLLength = 2048
+	maxLocaleLength    = 35
+)
+
+var (
+	// localeRegexp is a simplified BCP 47 language tag, e.g. en, en-GB or zh-Hant-TW
+	localeRegexp   = regexp.MustCompile("^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$")
+	currencyRegexp = regexp.MustCompile("^[A-Z]{3}$")
+
+	ErrIncorrectAvatarURL = errors.New("avatar URL should be an absolute http or https URL")
+	ErrIncorrectLocale    = errors.New("locale should be a language tag like en or en-GB")
+	ErrIncorrectCurrency  = errors.New("currency should be a three letter ISO 4217 code")
+)
+
+// ProfileResponse contains information a user shares about themselves. Display name is shown to other members of
+// the group instead of the email.
+type ProfileResponse struct {
+	ID          uint   `json:"id"`
+	Email       Email  `json:"email"`
+	DisplayName string `json:"displayName,omitempty"`
+	AvatarURL   string `json:"avatarUrl,omitempty"`
+	Locale      string `json:"locale,omitempty"`
+	Currency    string `json:"currency,omitempty"`
+	Verified    bool   `json:"verified"`
+}
+
+// UpdateProfileRequest changes only the fields that are present, nil fields are left as they are. Empty string
+// removes the value.
+type UpdateProfileRequest struct {
+	DisplayName *string
+	AvatarURL   *string
+	Locale      *string
+	Currency    *string
+}
+
+// UnmarshalJSON unmarshalls incoming JSON request and validates it. Locale is kept as it was sent, currency is
+// converted to upper case.
+func (r *UpdateProfileRequest) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	type updateRequest struct {
+		DisplayName *string `json:"displayName"`
+		AvatarURL   *string `json:"avatarUrl"`
+		Locale      *string `json:"locale"`
+		Currency    *string `json:"currency"`
+	}
+	var req updateRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return err
+	}
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		if len(displayName) > maxDisplayNameLength {
+			return ErrDisplayNameTooLong
+		}
+		r.DisplayName = &displayName
+	}
+	if req.AvatarURL != nil {
+		if err := validateAvatarURL(*req.AvatarURL); err != nil {
+			return err
+		}
+		r.AvatarURL = req.AvatarURL
+	}
+	if req.Locale != nil {
+		if *req.Locale != "" && (len(*req.Locale) > maxLocaleLength || !localeRegexp.MatchString(*req.Locale)) {
+			return ErrIncorrectLocale
+		}
+		r.Locale = req.Locale
+	}
+	if req.Currency != nil {
+		currency := strings.ToUpper(*req.Currency)
+		if currency != "" && !currencyRegexp.MatchString(currency) {
+			return ErrIncorrectCurrency
+		}
+		r.Currency = &currency
+	}
+	return nil
+}
+
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	if len(avatarURL) > maxAvatarURLLength {
+		return ErrIncorrectAvatarURL
+	}
+	parsed, err := url.Parse(avatarURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ErrIncorrectAvatarURL
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package expenses
+
+import (
+	"context"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/jackc/pgx/v4"
+)
+
+// ProfileRepository stores profiles of users, placeholder members don't have profiles
+type ProfileRepository interface {
+	// Find profile of the user. Returns ErrUserNotFound if there is no such user.
+	Find(ctx context.Context, db pgxtype.Querier, userID uint) (ProfileResponse, error)
+	// Update fields of the profile that are present in the request and return the updated profile. Returns
+	// ErrUserNotFound if there is no such user.
+	Update(ctx context.Context, db pgxtype.Querier, userID uint, request UpdateProfileRequest) (ProfileResponse, error)
+}
+
+const (
+	profileColumns = "id, email, COALESCE(display_name, ''), COALESCE(avatar_url, ''), COALESCE(locale, ''), " +
+		"COALESCE(currency, ''), verified"
+	findProfileQuery   = "SELECT " + profileColumns + " FROM users WHERE id = $1 AND email IS NOT NULL"
+	updateProfileQuery = "UPDATE users SET " +
+		"display_name = NULLIF(COALESCE($2, display_name), ''), " +
+		"avatar_url = NULLIF(COALESCE($3, avatar_url), ''), " +
+		"locale = NULLIF(COALESCE($4, locale), ''), " +
+		"currency = NULLIF(COALESCE($5, currency), '') " +
+		"WHERE id = $1 AND email IS NOT NULL " +
+		"RETURNING " + profileColumns
+)
+
+// PgProfileRepository is a ProfileRepository that keeps profiles in users table of postgresql
+type PgProfileRepository struct {
+}
+
+// NewPgProfileRepository creates new PgProfileRepository
+func NewPgProfileRepository() *PgProfileRepository {
+	return &PgProfileRepository{}
+}
+
+func (r *PgProfileRepository) Find(ctx context.Context, db pgxtype.Querier, userID uint) (ProfileResponse, error) {
+	return scanProfile(db.QueryRow(ctx, findProfileQuery, userID))
+}
+
+// Update sets the fields with NULL instead of empty strings, so that a removed value looks like it was never set
+func (r *PgProfileRepository) Update(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	request UpdateProfileRequest,
+) (ProfileResponse, error) {
+	row := db.QueryRow(
+		ctx,
+		updateProfileQuery,
+		userID,
+		request.DisplayName,
+		request.AvatarURL,
+		request.Locale,
+		request.Currency,
+	)
+	return scanProfile(row)
+}
+
+func scanProfile(row pgx.Row) (ProfileResponse, error) {
+	var profile ProfileResponse
+	err := row.Scan(
+		&profile.ID,
+		&profile.Email,
+		&profile.DisplayName,
+		&profile.AvatarURL,
+		&profile.Locale,
+		&profile.Currency,
+		&profile.Verified,
+	)
+	if err == pgx.ErrNoRows {
+		return ProfileResponse{}, ErrUserNotFound
+	}
+	if err != nil {
+		return ProfileResponse{}, err
+	}
+	return profile, nil
+}
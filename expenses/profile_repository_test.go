@@ -0,0 +1,76 @@
+package expenses_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/expenses"
+	"testing"
+)
+
+func TestFindProfile(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user, err := expenses.NewPgUserRepository().
+		Create(ctx, pgdb, expenses.CreateUserRequest{Email: "expenses@mail.com", Password: "password"})
+	require.NoError(t, err)
+
+	profile, err := expenses.NewPgProfileRepository().Find(ctx, pgdb, user.ID)
+
+	require.NoError(t, err)
+	assert.Equal(t, expenses.ProfileResponse{ID: user.ID, Email: "expenses@mail.com"}, profile)
+}
+
+func TestFindProfileOfNonExistentUserOrPlaceholder(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	placeholder, err := expenses.NewPgUserRepository().CreatePlaceholder(ctx, pgdb, "Bob", "code")
+	require.NoError(t, err)
+	repository := expenses.NewPgProfileRepository()
+
+	_, err = repository.Find(ctx, pgdb, placeholder.ID+1)
+	assert.Equal(t, expenses.ErrUserNotFound, err)
+	_, err = repository.Find(ctx, pgdb, placeholder.ID)
+	assert.Equal(t, expenses.ErrUserNotFound, err)
+	_, err = repository.Update(ctx, pgdb, placeholder.ID, expenses.UpdateProfileRequest{})
+	assert.Equal(t, expenses.ErrUserNotFound, err)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user, err := expenses.NewPgUserRepository().
+		Create(ctx, pgdb, expenses.CreateUserRequest{Email: "expenses@mail.com", Password: "password"})
+	require.NoError(t, err)
+	repository := expenses.NewPgProfileRepository()
+	displayName, avatarURL, locale, currency := "Alice", "https://cdn.com/a.png", "en-GB", "EUR"
+	_, err = repository.Update(ctx, pgdb, user.ID, expenses.UpdateProfileRequest{
+		DisplayName: &displayName,
+		AvatarURL:   &avatarURL,
+		Locale:      &locale,
+		Currency:    &currency,
+	})
+	require.NoError(t, err)
+
+	removed := ""
+	updated, err := repository.Update(ctx, pgdb, user.ID, expenses.UpdateProfileRequest{AvatarURL: &removed})
+
+	require.NoError(t, err)
+	expected := expenses.ProfileResponse{
+		ID:          user.ID,
+		Email:       "expenses@mail.com",
+		DisplayName: "Alice",
+		Locale:      "en-GB",
+		Currency:    "EUR",
+	}
+	assert.Equal(t, expected, updated)
+	found, err := repository.Find(ctx, pgdb, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expected, found)
+	group, err := expenses.NewPgGroupRepository().Create(ctx, pgdb, "group")
+	require.NoError(t, err)
+	require.NoError(t, expenses.NewPgGroupRepository().AddUserToGroup(ctx, pgdb, user.ID, group.ID))
+	groupResponse, err := expenses.NewPgGroupRepository().FindByIDWithUsers(ctx, pgdb, group.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", groupResponse.Users[0].DisplayName)
+}
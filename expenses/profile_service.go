@@ -0,0 +1,39 @@
+package expenses
+
+import (
+	"context"
+	"github.com/jackc/pgtype/pgxtype"
+)
+
+// ProfileService lets users describe themselves to other members of their groups
+type ProfileService interface {
+	// Get profile of the user
+	Get(ctx context.Context, userID uint) (ProfileResponse, error)
+	// Update fields of the profile that are present in the request
+	Update(ctx context.Context, userID uint, request UpdateProfileRequest) (ProfileResponse, error)
+}
+
+// DefaultProfileService is default implementation of ProfileService that works through ProfileRepository
+type DefaultProfileService struct {
+	db         pgxtype.Querier
+	repository ProfileRepository
+}
+
+// NewDefaultProfileService creates new instance of DefaultProfileService
+func NewDefaultProfileService(db pgxtype.Querier, repository ProfileRepository) *DefaultProfileService {
+	return &DefaultProfileService{db: db, repository: repository}
+}
+
+// Get returns ErrUserNotFound if there is no such user
+func (s *DefaultProfileService) Get(ctx context.Context, userID uint) (ProfileResponse, error) {
+	return s.repository.Find(ctx, s.db, userID)
+}
+
+// Update returns ErrUserNotFound if there is no such user
+func (s *DefaultProfileService) Update(
+	ctx context.Context,
+	userID uint,
+	request UpdateProfileRequest,
+) (ProfileResponse, error) {
+	return s.repository.Update(ctx, s.db, userID, request)
+}
@@ -0,0 +1,68 @@
+package expenses_test
+
+import (
+	"context"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-spend/expenses"
+	"testing"
+)
+
+type mockProfileRepository struct {
+	mock.Mock
+}
+
+func (m *mockProfileRepository) Find(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+) (expenses.ProfileResponse, error) {
+	args := m.Called(ctx, db, userID)
+	return args.Get(0).(expenses.ProfileResponse), args.Error(1)
+}
+
+func (m *mockProfileRepository) Update(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	request expenses.UpdateProfileRequest,
+) (expenses.ProfileResponse, error) {
+	args := m.Called(ctx, db, userID, request)
+	return args.Get(0).(expenses.ProfileResponse), args.Error(1)
+}
+
+func TestProfileServiceGet(t *testing.T) {
+	// given
+	ctx := context.Background()
+	repository := new(mockProfileRepository)
+	service := expenses.NewDefaultProfileService(pgdb, repository)
+	expected := expenses.ProfileResponse{ID: 1, Email: "expenses@mail.com", DisplayName: "Alice"}
+	repository.On("Find", ctx, pgdb, uint(1)).Return(expected, nil)
+
+	// when
+	profile, err := service.Get(ctx, 1)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, expected, profile)
+}
+
+func TestProfileServiceUpdate(t *testing.T) {
+	// given
+	ctx := context.Background()
+	repository := new(mockProfileRepository)
+	service := expenses.NewDefaultProfileService(pgdb, repository)
+	displayName := "Alice"
+	request := expenses.UpdateProfileRequest{DisplayName: &displayName}
+	expected := expenses.ProfileResponse{ID: 1, Email: "expenses@mail.com", DisplayName: "Alice"}
+	repository.On("Update", ctx, pgdb, uint(1), request).Return(expected, nil)
+
+	// when
+	profile, err := service.Update(ctx, 1, request)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, expected, profile)
+}
@@ -0,0 +1,80 @@
+package expenses_test
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/expenses"
+	"strings"
+	"testing"
+)
+
+func TestUpdateProfileRequestUnmarshalJSON(t *testing.T) {
+	updateJSON := `{"displayName": " Alice ", "avatarUrl": "https://cdn.com/a.png", "locale": "en-GB", "currency": "eur"}`
+	var req expenses.UpdateProfileRequest
+	err := json.Unmarshal([]byte(updateJSON), &req)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", *req.DisplayName)
+	assert.Equal(t, "https://cdn.com/a.png", *req.AvatarURL)
+	assert.Equal(t, "en-GB", *req.Locale)
+	assert.Equal(t, "EUR", *req.Currency)
+}
+
+func TestUpdateProfileRequestUnmarshalJSONOnlyPresentFields(t *testing.T) {
+	updateJSON := `{"locale": "", "currency": null}`
+	var req expenses.UpdateProfileRequest
+	err := json.Unmarshal([]byte(updateJSON), &req)
+	require.NoError(t, err)
+	assert.Nil(t, req.DisplayName)
+	assert.Nil(t, req.AvatarURL)
+	assert.Equal(t, "", *req.Locale)
+	assert.Nil(t, req.Currency)
+}
+
+func TestUpdateProfileRequestUnmarshalJSONErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		json        string
+		expectedErr error
+	}{
+		{
+			name: "unexpected fields",
+			json: `{"email": "mail@mail.com"}`,
+		},
+		{
+			name:        "too long display name",
+			json:        `{"displayName": "` + strings.Repeat("a", 101) + `"}`,
+			expectedErr: expenses.ErrDisplayNameTooLong,
+		},
+		{
+			name:        "relative avatar URL",
+			json:        `{"avatarUrl": "/a.png"}`,
+			expectedErr: expenses.ErrIncorrectAvatarURL,
+		},
+		{
+			name:        "avatar URL with another scheme",
+			json:        `{"avatarUrl": "javascript:alert(1)"}`,
+			expectedErr: expenses.ErrIncorrectAvatarURL,
+		},
+		{
+			name:        "incorrect locale",
+			json:        `{"locale": "english"}`,
+			expectedErr: expenses.ErrIncorrectLocale,
+		},
+		{
+			name:        "incorrect currency",
+			json:        `{"currency": "euro"}`,
+			expectedErr: expenses.ErrIncorrectCurrency,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req expenses.UpdateProfileRequest
+			err := json.Unmarshal([]byte(test.json), &req)
+			require.Error(t, err)
+			if test.expectedErr != nil {
+				assert.Equal(t, test.expectedErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package expenses
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// hashSecret is for random secrets generated by the application. A plain sha256 without a salt withstands an offline
+// brute force only when a secret has around 128 random bits or more, weaker secrets need a slow hash like passwords.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,204 @@
+package expenses
+
+import (
+	"github.com/go-redis/redis"
+	"go-spend/log"
+	"go-spend/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const balanceInvalidationChannel = "balance_invalidations"
+
+// resubscribeDelay after the subscription to invalidations failed
+const resubscribeDelay = time.Second
+
+// TieredBalanceCache is a BalanceCache that keeps local copies of balances from RedisBalanceCache in memory of the
+// instance for a short time, so that most requests don't need a round trip to redis. Set and Remove are broadcast to
+// all instances via redis pub/sub, so that they drop their local copies.
+//
+// Every invalidation of a key stamps it with a version. A balance read from redis is copied locally only if its key
+// wasn't invalidated since the read started, otherwise a read that raced with an invalidation would bring the old
+// balance back. All local copies are dropped when the subscription is (re)established, as invalidations could have
+// been missed in the meantime, and the local duration limits staleness if they are missed unnoticed.
+type TieredBalanceCache struct {
+	remote *RedisBalanceCache
+	local  *MemoryBalanceCache
+	// localDuration is how long a local copy is kept, not longer than the balance stays in redis
+	localDuration time.Duration
+	pubSub        *redis.PubSub
+	mutex         sync.Mutex
+	// version is incremented on every invalidation
+	version uint64
+	// invalidated keeps versions of invalidated keys, clearedAt is the version of the last drop of all local copies
+	invalidated map[BalanceCacheKey]uint64
+	clearedAt   uint64
+	// forgetUpTo is the version before the previous janitor run, invalidations up to it are older than any read
+	forgetUpTo  uint64
+	stopped     chan struct{}
+	stopJanitor func()
+	stopOnce    sync.Once
+}
+
+// NewTieredBalanceCache creates new instance of TieredBalanceCache and subscribes to invalidations. Up to localSize
+// balances are copied for localDuration, expired copies and old versions are removed every janitorInterval.
+func NewTieredBalanceCache(
+	remote *RedisBalanceCache,
+	localSize int,
+	localDuration time.Duration,
+	janitorInterval time.Duration,
+) *TieredBalanceCache {
+	c := &TieredBalanceCache{
+		remote:        remote,
+		local:         NewMemoryBalanceCache(localSize, localDuration, janitorInterval),
+		localDuration: localDuration,
+		pubSub:        remote.redisClient.Subscribe(balanceInvalidationChannel),
+		invalidated:   make(map[BalanceCacheKey]uint64),
+		stopped:       make(chan struct{}),
+	}
+	c.stopJanitor = util.StartJanitor(janitorInterval, c.forgetVersions)
+	go c.receiveInvalidations()
+	return c
+}
+
+// Get value by key, if nothing is found ErrBalanceNotCached will be returned
+func (c *TieredBalanceCache) Get(key BalanceCacheKey) (Balance, error) {
+	balance, _, err := c.GetWithTTL(key)
+	return balance, err
+}
+
+// GetWithTTL returns the local copy if there is one, otherwise reads the balance from redis and copies it. TTL is the
+// one of redis in both cases.
+func (c *TieredBalanceCache) GetWithTTL(key BalanceCacheKey) (Balance, time.Duration, error) {
+	if balance, ttl, err := c.local.GetWithTTL(key); err == nil {
+		return balance, ttl, nil
+	}
+	c.mutex.Lock()
+	readVersion := c.version
+	c.mutex.Unlock()
+	balance, ttl, err := c.remote.GetWithTTL(key)
+	if err != nil || ttl <= 0 {
+		// balances without expiration are not copied, it would be unknown what TTL to report
+		return balance, ttl, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.invalidated[key] <= readVersion && c.clearedAt <= readVersion {
+		now := time.Now()
+		keptUntil := now.Add(c.localDuration)
+		expiresAt := now.Add(ttl)
+		if ttl < c.localDuration {
+			keptUntil = expiresAt
+		}
+		c.local.set(key, balance, expiresAt, keptUntil)
+	}
+	return balance, ttl, nil
+}
+
+// Set the balance in redis and broadcast it, so that instances drop their old local copies
+func (c *TieredBalanceCache) Set(key BalanceCacheKey, balance Balance) error {
+	if err := c.remote.Set(key, balance); err != nil {
+		return err
+	}
+	return c.broadcast(key)
+}
+
+// Remove n key-values from redis and broadcast it, so that instances drop their local copies
+func (c *TieredBalanceCache) Remove(keys ...BalanceCacheKey) error {
+	if err := c.remote.Remove(keys...); err != nil {
+		return err
+	}
+	return c.broadcast(keys...)
+}
+
+// Stop receiving invalidations and the janitors
+func (c *TieredBalanceCache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+		if err := c.pubSub.Close(); err != nil {
+			log.Warn("couldn't unsubscribe from balance invalidations - %s", err)
+		}
+		c.stopJanitor()
+		c.local.Stop()
+	})
+}
+
+// broadcast invalidates keys locally right away, as the message reaches this instance only later
+func (c *TieredBalanceCache) broadcast(keys ...BalanceCacheKey) error {
+	c.invalidate(keys)
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = strconv.FormatUint(uint64(key), 10)
+	}
+	return c.remote.redisClient.Publish(balanceInvalidationChannel, strings.Join(values, ",")).Err()
+}
+
+func (c *TieredBalanceCache) receiveInvalidations() {
+	for {
+		message, err := c.pubSub.Receive()
+		select {
+		case <-c.stopped:
+			return
+		default:
+		}
+		switch message := message.(type) {
+		case *redis.Subscription:
+			c.clear()
+		case *redis.Message:
+			c.invalidate(parseBalanceCacheKeys(message.Payload))
+		}
+		if err != nil {
+			log.Warn("couldn't receive balance invalidations - %s", err)
+			c.clear()
+			time.Sleep(resubscribeDelay)
+		}
+	}
+}
+
+func (c *TieredBalanceCache) invalidate(keys []BalanceCacheKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.version++
+	for _, key := range keys {
+		c.invalidated[key] = c.version
+	}
+	if err := c.local.Remove(keys...); err != nil {
+		log.Warn("couldn't remove local copies of balances - %s", err)
+	}
+}
+
+func (c *TieredBalanceCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.version++
+	c.clearedAt = c.version
+	c.local.clear()
+}
+
+// forgetVersions of invalidations made before the previous run, no read started before them is still in progress
+func (c *TieredBalanceCache) forgetVersions() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, version := range c.invalidated {
+		if version <= c.forgetUpTo {
+			delete(c.invalidated, key)
+		}
+	}
+	c.forgetUpTo = c.version
+}
+
+// parseBalanceCacheKeys skips values that are not keys, they can only come from someone else publishing to the channel
+func parseBalanceCacheKeys(payload string) []BalanceCacheKey {
+	var keys []BalanceCacheKey
+	for _, value := range strings.Split(payload, ",") {
+		key, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			log.Warn("couldn't parse invalidated balance key %q - %s", value, err)
+			continue
+		}
+		keys = append(keys, BalanceCacheKey(key))
+	}
+	return keys
+}
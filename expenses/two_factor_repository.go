@@ -0,0 +1,158 @@
+package expenses
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgtype/pgxtype"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	savePendingTwoFactorQuery = "INSERT INTO two_factor (user_id, secret) VALUES ($1, $2) " +
+		"ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0 " +
+		"WHERE two_factor.enabled = FALSE"
+	findTwoFactorQuery       = "SELECT user_id, secret, enabled, last_step FROM two_factor WHERE user_id = $1"
+	enableTwoFactorQuery     = "UPDATE two_factor SET enabled = TRUE WHERE user_id = $1 AND enabled = FALSE"
+	deleteRecoveryCodesQuery = "DELETE FROM recovery_codes WHERE user_id = $1"
+	insertRecoveryCodeQuery  = "INSERT INTO recovery_codes (user_id, code) VALUES ($1, $2)"
+	useTwoFactorStepQuery    = "UPDATE two_factor SET last_step = $2 WHERE user_id = $1 AND last_step < $2"
+	useRecoveryCodeQuery     = "DELETE FROM recovery_codes WHERE user_id = $1 AND code = $2"
+	deleteTwoFactorQuery     = "DELETE FROM two_factor WHERE user_id = $1"
+)
+
+var (
+	ErrTwoFactorNotFound       = errors.New("two-factor authentication is not set up")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorStepUsed       = errors.New("code of this time step was already used")
+	ErrRecoveryCodeNotFound    = errors.New("recovery code not found")
+)
+
+// TwoFactor is a TOTP secret of a user. Secret is not Enabled until the user confirms it with the first code.
+type TwoFactor struct {
+	UserID  uint
+	Secret  string
+	Enabled bool
+	// LastStep is the time step of the last accepted code
+	LastStep int64
+}
+
+// TwoFactorRepository stores TOTP secrets and recovery codes of users
+type TwoFactorRepository interface {
+	// Stores a secret that is not enabled yet, replacing a previous pending one
+	SavePending(ctx context.Context, db pgxtype.Querier, userID uint, secret string) error
+	// Find the secret of the user
+	Find(ctx context.Context, db pgxtype.Querier, userID uint) (TwoFactor, error)
+	// Enables the pending secret and replaces recovery codes of the user
+	Enable(ctx context.Context, db pgxtype.Querier, userID uint, recoveryCodes []string) error
+	// Remembers that a code of the time step was used
+	UseStep(ctx context.Context, db pgxtype.Querier, userID uint, step int64) error
+	// Removes the recovery code of the user
+	UseRecoveryCode(ctx context.Context, db pgxtype.Querier, userID uint, recoveryCode string) error
+	// Removes the secret and recovery codes of the user
+	Delete(ctx context.Context, db pgxtype.Querier, userID uint) error
+}
+
+// PgTwoFactorRepository is an implementation of TwoFactorRepository that works with postgresql. Recovery codes are
+// stored as hashes.
+type PgTwoFactorRepository struct {
+}
+
+// NewPgTwoFactorRepository creates new instance of PgTwoFactorRepository
+func NewPgTwoFactorRepository() *PgTwoFactorRepository {
+	return &PgTwoFactorRepository{}
+}
+
+// SavePending returns ErrTwoFactorAlreadyEnabled if the user has already enabled two-factor authentication
+func (r *PgTwoFactorRepository) SavePending(ctx context.Context, db pgxtype.Querier, userID uint, secret string) error {
+	commandTag, err := db.Exec(ctx, savePendingTwoFactorQuery, userID, secret)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrTwoFactorAlreadyEnabled
+	}
+	return nil
+}
+
+// Find returns ErrTwoFactorNotFound if the user has neither enabled nor pending secret
+func (r *PgTwoFactorRepository) Find(ctx context.Context, db pgxtype.Querier, userID uint) (TwoFactor, error) {
+	var twoFactor TwoFactor
+	err := db.QueryRow(ctx, findTwoFactorQuery, userID).
+		Scan(&twoFactor.UserID, &twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep)
+	if err == pgx.ErrNoRows {
+		return TwoFactor{}, ErrTwoFactorNotFound
+	}
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	return twoFactor, nil
+}
+
+// Enable returns ErrTwoFactorNotFound if the user doesn't have a pending secret. Should be called in a transaction,
+// so that the user doesn't end up without recovery codes.
+func (r *PgTwoFactorRepository) Enable(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	recoveryCodes []string,
+) error {
+	commandTag, err := db.Exec(ctx, enableTwoFactorQuery, userID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrTwoFactorNotFound
+	}
+	if _, err = db.Exec(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		return err
+	}
+	// recovery codes have 160 random bits
+	for _, recoveryCode := range recoveryCodes {
+		if _, err = db.Exec(ctx, insertRecoveryCodeQuery, userID, hashSecret(recoveryCode)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseStep returns ErrTwoFactorStepUsed if a code of the same or a later time step was already used, so that
+// an intercepted code can't be replayed
+func (r *PgTwoFactorRepository) UseStep(ctx context.Context, db pgxtype.Querier, userID uint, step int64) error {
+	commandTag, err := db.Exec(ctx, useTwoFactorStepQuery, userID, step)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrTwoFactorStepUsed
+	}
+	return nil
+}
+
+// UseRecoveryCode returns ErrRecoveryCodeNotFound if the user doesn't have such recovery code or it was already used
+func (r *PgTwoFactorRepository) UseRecoveryCode(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	recoveryCode string,
+) error {
+	commandTag, err := db.Exec(ctx, useRecoveryCodeQuery, userID, hashSecret(recoveryCode))
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+// Delete returns ErrTwoFactorNotFound if the user has neither enabled nor pending secret
+func (r *PgTwoFactorRepository) Delete(ctx context.Context, db pgxtype.Querier, userID uint) error {
+	commandTag, err := db.Exec(ctx, deleteTwoFactorQuery, userID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrTwoFactorNotFound
+	}
+	return nil
+}
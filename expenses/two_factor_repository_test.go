@@ -0,0 +1,110 @@
+package expenses_test
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/expenses"
+	"testing"
+)
+
+func createTwoFactorUser(t *testing.T, ctx context.Context) expenses.User {
+	user, err := expenses.NewPgUserRepository().
+		Create(ctx, pgdb, expenses.CreateUserRequest{Email: "expenses@mail.com", Password: "password"})
+	require.NoError(t, err)
+	return user
+}
+
+func TestSavePendingTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+	repository := expenses.NewPgTwoFactorRepository()
+
+	require.NoError(t, repository.SavePending(ctx, pgdb, user.ID, "FIRST"))
+	require.NoError(t, repository.SavePending(ctx, pgdb, user.ID, "SECOND"))
+
+	found, err := repository.Find(ctx, pgdb, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.TwoFactor{UserID: user.ID, Secret: "SECOND"}, found)
+}
+
+func TestCantReplaceEnabledTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+	repository := expenses.NewPgTwoFactorRepository()
+	require.NoError(t, repository.SavePending(ctx, pgdb, user.ID, "FIRST"))
+	require.NoError(t, repository.Enable(ctx, pgdb, user.ID, []string{"code"}))
+
+	err := repository.SavePending(ctx, pgdb, user.ID, "SECOND")
+
+	assert.Equal(t, expenses.ErrTwoFactorAlreadyEnabled, err)
+	found, err := repository.Find(ctx, pgdb, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.TwoFactor{UserID: user.ID, Secret: "FIRST", Enabled: true}, found)
+}
+
+func TestFindTwoFactorNotFound(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+
+	found, err := expenses.NewPgTwoFactorRepository().Find(ctx, pgdb, user.ID)
+
+	assert.Equal(t, expenses.ErrTwoFactorNotFound, err)
+	assert.Zero(t, found)
+}
+
+func TestEnableTwoFactorWithoutPendingSecret(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+
+	err := expenses.NewPgTwoFactorRepository().Enable(ctx, pgdb, user.ID, []string{"code"})
+
+	assert.Equal(t, expenses.ErrTwoFactorNotFound, err)
+}
+
+func TestUseTwoFactorStep(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+	repository := expenses.NewPgTwoFactorRepository()
+	require.NoError(t, repository.SavePending(ctx, pgdb, user.ID, "SECRET"))
+
+	require.NoError(t, repository.UseStep(ctx, pgdb, user.ID, 100))
+	assert.Equal(t, expenses.ErrTwoFactorStepUsed, repository.UseStep(ctx, pgdb, user.ID, 100))
+	assert.Equal(t, expenses.ErrTwoFactorStepUsed, repository.UseStep(ctx, pgdb, user.ID, 99))
+	require.NoError(t, repository.UseStep(ctx, pgdb, user.ID, 101))
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+	repository := expenses.NewPgTwoFactorRepository()
+	require.NoError(t, repository.SavePending(ctx, pgdb, user.ID, "SECRET"))
+	require.NoError(t, repository.Enable(ctx, pgdb, user.ID, []string{"first", "second"}))
+
+	require.NoError(t, repository.UseRecoveryCode(ctx, pgdb, user.ID, "first"))
+	assert.Equal(t, expenses.ErrRecoveryCodeNotFound, repository.UseRecoveryCode(ctx, pgdb, user.ID, "first"))
+	assert.Equal(t, expenses.ErrRecoveryCodeNotFound, repository.UseRecoveryCode(ctx, pgdb, user.ID, "third"))
+	require.NoError(t, repository.UseRecoveryCode(ctx, pgdb, user.ID, "second"))
+}
+
+func TestDeleteTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+	user := createTwoFactorUser(t, ctx)
+	repository := expenses.NewPgTwoFactorRepository()
+	require.NoError(t, repository.SavePending(ctx, pgdb, user.ID, "SECRET"))
+	require.NoError(t, repository.Enable(ctx, pgdb, user.ID, []string{"code"}))
+
+	require.NoError(t, repository.Delete(ctx, pgdb, user.ID))
+
+	_, err := repository.Find(ctx, pgdb, user.ID)
+	assert.Equal(t, expenses.ErrTwoFactorNotFound, err)
+	assert.Equal(t, expenses.ErrRecoveryCodeNotFound, repository.UseRecoveryCode(ctx, pgdb, user.ID, "code"))
+	assert.Equal(t, expenses.ErrTwoFactorNotFound, repository.Delete(ctx, pgdb, user.ID))
+}
@@ -3,20 +3,38 @@ package expenses
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+)
+
+const maxDisplayNameLength = 100
+
+var (
+	ErrDisplayNameTooLong = errors.New("display name is too long")
 )
 
 // Internal user type, will not be shared outside of the application. Every User can only be in one group.
+// Placeholder members have only a display name - they can't authenticate and exist only to take part in expenses.
 type User struct {
-	ID       uint
-	Email    Email
-	Password Password
-	GroupID  uint
+	ID          uint
+	Email       Email
+	Password    Password
+	GroupID     uint
+	DisplayName string
+	// Verified is true when the user has confirmed the email
+	Verified bool
+}
+
+// IsPlaceholder returns true if the user doesn't have an account and only represents someone in a group
+func (u *User) IsPlaceholder() bool {
+	return u.Email == ""
 }
 
-// CreateUserRequest contains information for User registration
+// CreateUserRequest contains information for User registration. If ClaimCode is provided - the user takes over the
+// placeholder member with that code instead of starting from scratch.
 type CreateUserRequest struct {
-	Email    Email    `json:"email"`
-	Password Password `json:"password"`
+	Email     Email    `json:"email"`
+	Password  Password `json:"password"`
+	ClaimCode string   `json:"claimCode,omitempty"`
 }
 
 // UnmarshalJSON unmarshalls incoming JSON request and validates it.
@@ -25,8 +43,9 @@ func (r *CreateUserRequest) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 	type createRequest struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		ClaimCode string `json:"claimCode"`
 	}
 	var req createRequest
 	decoder := json.NewDecoder(bytes.NewBuffer(data))
@@ -40,11 +59,24 @@ func (r *CreateUserRequest) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	r.Password, err = ValidPassword(req.Password)
+	r.ClaimCode = req.ClaimCode
 	return err
 }
 
 // contains information returned when the User information is requested
 type UserResponse struct {
-	ID    uint  `json:"id"`
-	Email Email `json:"email"`
+	ID          uint   `json:"id"`
+	Email       Email  `json:"email,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Placeholder bool   `json:"placeholder,omitempty"`
+}
+
+// NewUserResponse creates UserResponse from the internal User representation
+func NewUserResponse(user User) UserResponse {
+	return UserResponse{
+		ID:          user.ID,
+		Email:       user.Email,
+		DisplayName: user.DisplayName,
+		Placeholder: user.IsPlaceholder(),
+	}
 }
@@ -17,23 +17,54 @@ type UserRepository interface {
 	FindById(ctx context.Context, db pgxtype.Querier, id uint) (User, error)
 	// Find User by email
 	FindByEmail(ctx context.Context, db pgxtype.Querier, email Email) (User, error)
+	// Creates a placeholder member that can be later claimed by a real user with the claimCode
+	CreatePlaceholder(ctx context.Context, db pgxtype.Querier, name string, claimCode string) (User, error)
+	// Turns placeholder member with request.ClaimCode into a real user with request.Email and request.Password
+	ClaimPlaceholder(ctx context.Context, db pgxtype.Querier, request CreateUserRequest) (User, error)
+	// Find placeholder member by its claim code
+	FindPlaceholderByClaimCode(ctx context.Context, db pgxtype.Querier, claimCode string) (User, error)
+	// Moves all expenses and shares of a placeholder to the user and removes the placeholder
+	MergePlaceholder(ctx context.Context, db pgxtype.Querier, placeholderID uint, userID uint) error
+	// Replaces encoded password of the user
+	UpdatePassword(ctx context.Context, db pgxtype.Querier, userID uint, password Password) error
+	// Marks email of the user as verified if the user still has that email
+	MarkVerified(ctx context.Context, db pgxtype.Querier, userID uint, email Email) error
 }
 
 const (
 	createUserQuery   = "INSERT INTO users (email, password) VALUES ($1, $2) RETURNING ID"
-	findUserByIdQuery = "SELECT u.id, u.email, u.password, COALESCE(ug.group_id, 0) " +
+	findUserByIdQuery = "SELECT u.id, COALESCE(u.email, ''), COALESCE(u.password, ''), " +
+		"COALESCE(u.display_name, ''), COALESCE(ug.group_id, 0), u.verified " +
 		"FROM users as u " +
 		"LEFT JOIN users_groups as ug ON u.id = ug.user_id " +
 		"WHERE u.id = $1"
-	findUserByEmailQuery = "SELECT u.id, u.email, u.password, COALESCE(ug.group_id, 0) " +
+	findUserByEmailQuery = "SELECT u.id, COALESCE(u.email, ''), COALESCE(u.password, ''), " +
+		"COALESCE(u.display_name, ''), COALESCE(ug.group_id, 0), u.verified " +
 		"FROM users as u " +
 		"LEFT JOIN users_groups as ug ON u.id = ug.user_id " +
 		"WHERE u.email = $1"
+	createPlaceholderQuery = "INSERT INTO users (display_name, claim_code) VALUES ($1, $2) RETURNING id"
+	claimPlaceholderQuery  = "WITH claimed AS (" +
+		"UPDATE users SET email = $1, password = $2, claim_code = NULL WHERE claim_code = $3 " +
+		"RETURNING id, display_name) " +
+		"SELECT c.id, COALESCE(c.display_name, ''), COALESCE(ug.group_id, 0) " +
+		"FROM claimed as c " +
+		"LEFT JOIN users_groups as ug ON c.id = ug.user_id"
+	findPlaceholderByClaimCodeQuery = "SELECT u.id, COALESCE(u.display_name, ''), COALESCE(ug.group_id, 0) " +
+		"FROM users as u " +
+		"LEFT JOIN users_groups as ug ON u.id = ug.user_id " +
+		"WHERE u.claim_code = $1"
+	moveExpensesQuery       = "UPDATE expenses SET user_id = $2 WHERE user_id = $1"
+	moveExpensesSharesQuery = "UPDATE expenses_shares SET user_id = $2 WHERE user_id = $1"
+	deletePlaceholderQuery  = "DELETE FROM users WHERE id = $1 AND email IS NULL"
+	updatePasswordQuery     = "UPDATE users SET password = $2 WHERE id = $1 AND email IS NOT NULL"
+	markVerifiedQuery       = "UPDATE users SET verified = TRUE WHERE id = $1 AND email = $2"
 )
 
 var (
-	ErrEmailAlreadyExists = errors.New("user with such email already exists")
-	ErrUserNotFound       = errors.New("user not found")
+	ErrEmailAlreadyExists  = errors.New("user with such email already exists")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrPlaceholderNotFound = errors.New("placeholder not found")
 )
 
 // Implementation of UserRepository that works with postgresql
@@ -60,7 +91,7 @@ func (r *PgUserRepository) Create(ctx context.Context, db pgxtype.Querier, user
 func (r *PgUserRepository) FindById(ctx context.Context, db pgxtype.Querier, id uint) (User, error) {
 	var user User
 	row := db.QueryRow(ctx, findUserByIdQuery, id)
-	if err := row.Scan(&user.ID, &user.Email, &user.Password, &user.GroupID); err != nil {
+	if err := row.Scan(&user.ID, &user.Email, &user.Password, &user.DisplayName, &user.GroupID, &user.Verified); err != nil {
 		if err == pgx.ErrNoRows {
 			return User{}, ErrUserNotFound
 		}
@@ -73,7 +104,7 @@ func (r *PgUserRepository) FindById(ctx context.Context, db pgxtype.Querier, id
 func (r *PgUserRepository) FindByEmail(ctx context.Context, db pgxtype.Querier, email Email) (User, error) {
 	var user User
 	row := db.QueryRow(ctx, findUserByEmailQuery, email)
-	if err := row.Scan(&user.ID, &user.Email, &user.Password, &user.GroupID); err != nil {
+	if err := row.Scan(&user.ID, &user.Email, &user.Password, &user.DisplayName, &user.GroupID, &user.Verified); err != nil {
 		if err == pgx.ErrNoRows {
 			return User{}, ErrUserNotFound
 		}
@@ -81,3 +112,110 @@ func (r *PgUserRepository) FindByEmail(ctx context.Context, db pgxtype.Querier,
 	}
 	return user, nil
 }
+
+// CreatePlaceholder stores a member without email and password. Only a hash of the claimCode is stored.
+func (r *PgUserRepository) CreatePlaceholder(
+	ctx context.Context,
+	db pgxtype.Querier,
+	name string,
+	claimCode string,
+) (User, error) {
+	var id uint
+	// claim codes are UUIDs v4 with 122 random bits
+	if err := db.QueryRow(ctx, createPlaceholderQuery, name, hashSecret(claimCode)).Scan(&id); err != nil {
+		return User{}, err
+	}
+	return User{ID: id, DisplayName: name}, nil
+}
+
+// ClaimPlaceholder sets email and password of the placeholder with request.ClaimCode. All expenses and group membership
+// of the placeholder stay in place as it is the same user from now on. Returns ErrPlaceholderNotFound if there is no
+// placeholder with such code.
+func (r *PgUserRepository) ClaimPlaceholder(
+	ctx context.Context,
+	db pgxtype.Querier,
+	request CreateUserRequest,
+) (User, error) {
+	user := User{Email: request.Email, Password: request.Password}
+	row := db.QueryRow(ctx, claimPlaceholderQuery, request.Email, request.Password, hashSecret(request.ClaimCode))
+	if err := row.Scan(&user.ID, &user.DisplayName, &user.GroupID); err != nil {
+		if err == pgx.ErrNoRows {
+			return User{}, ErrPlaceholderNotFound
+		}
+		if pfError, ok := err.(*pgconn.PgError); ok && pfError.Code == pg.UniqueViolation {
+			return User{}, ErrEmailAlreadyExists
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// FindPlaceholderByClaimCode looks up placeholder in DB. Returns ErrPlaceholderNotFound if wasn't found.
+func (r *PgUserRepository) FindPlaceholderByClaimCode(
+	ctx context.Context,
+	db pgxtype.Querier,
+	claimCode string,
+) (User, error) {
+	var user User
+	row := db.QueryRow(ctx, findPlaceholderByClaimCodeQuery, hashSecret(claimCode))
+	if err := row.Scan(&user.ID, &user.DisplayName, &user.GroupID); err != nil {
+		if err == pgx.ErrNoRows {
+			return User{}, ErrPlaceholderNotFound
+		}
+		return User{}, err
+	}
+	return user, nil
+}
+
+// MergePlaceholder moves expenses and shares of the placeholder to the user and deletes the placeholder. Should be
+// executed in a transaction.
+func (r *PgUserRepository) MergePlaceholder(
+	ctx context.Context,
+	db pgxtype.Querier,
+	placeholderID uint,
+	userID uint,
+) error {
+	if _, err := db.Exec(ctx, moveExpensesQuery, placeholderID, userID); err != nil {
+		return err
+	}
+	if _, err := db.Exec(ctx, moveExpensesSharesQuery, placeholderID, userID); err != nil {
+		return err
+	}
+	commandTag, err := db.Exec(ctx, deletePlaceholderQuery, placeholderID)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrPlaceholderNotFound
+	}
+	return nil
+}
+
+// UpdatePassword sets new encoded password. Returns ErrUserNotFound if there is no such user or it is a placeholder.
+func (r *PgUserRepository) UpdatePassword(
+	ctx context.Context,
+	db pgxtype.Querier,
+	userID uint,
+	password Password,
+) error {
+	commandTag, err := db.Exec(ctx, updatePasswordQuery, userID, password)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// MarkVerified sets verified flag of the user. Returns ErrUserNotFound if there is no such user with the email.
+func (r *PgUserRepository) MarkVerified(ctx context.Context, db pgxtype.Querier, userID uint, email Email) error {
+	commandTag, err := db.Exec(ctx, markVerifiedQuery, userID, email)
+	if err != nil {
+		return err
+	}
+	if commandTag.RowsAffected() != 1 {
+		return ErrUserNotFound
+	}
+	return nil
+}
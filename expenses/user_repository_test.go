@@ -93,6 +93,62 @@ func TestFindByEmailNonExistentUser(t *testing.T) {
 	assert.Zero(t, foundUser)
 }
 
+func TestUpdatePassword(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	repository := expenses.NewPgUserRepository()
+	created, err := repository.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "expenses@mail.com", Password: "old"})
+	require.NoError(t, err)
+
+	require.NoError(t, repository.UpdatePassword(ctx, pgdb, created.ID, "new"))
+	found, err := repository.FindById(ctx, pgdb, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Password("new"), found.Password)
+}
+
+func TestUpdatePasswordOfNonExistentUserOrPlaceholder(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	repository := expenses.NewPgUserRepository()
+	placeholder, err := repository.CreatePlaceholder(ctx, pgdb, "Bob", "claim-code")
+	require.NoError(t, err)
+
+	assert.EqualError(t, repository.UpdatePassword(ctx, pgdb, 1000000, "new"), expenses.ErrUserNotFound.Error())
+	assert.EqualError(t, repository.UpdatePassword(ctx, pgdb, placeholder.ID, "new"), expenses.ErrUserNotFound.Error())
+}
+
+func TestMarkVerified(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	repository := expenses.NewPgUserRepository()
+	created, err := repository.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "expenses@mail.com", Password: "pass"})
+	require.NoError(t, err)
+	found, err := repository.FindByEmail(ctx, pgdb, created.Email)
+	require.NoError(t, err)
+	assert.False(t, found.Verified)
+
+	require.NoError(t, repository.MarkVerified(ctx, pgdb, created.ID, created.Email))
+	found, err = repository.FindById(ctx, pgdb, created.ID)
+	require.NoError(t, err)
+	assert.True(t, found.Verified)
+}
+
+func TestMarkVerifiedWithAnotherEmail(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	repository := expenses.NewPgUserRepository()
+	created, err := repository.Create(ctx, pgdb, expenses.CreateUserRequest{Email: "expenses@mail.com", Password: "pass"})
+	require.NoError(t, err)
+
+	err = repository.MarkVerified(ctx, pgdb, created.ID, "other@mail.com")
+	assert.EqualError(t, err, expenses.ErrUserNotFound.Error())
+	assert.EqualError(t, repository.MarkVerified(ctx, pgdb, 1000000, created.Email), expenses.ErrUserNotFound.Error())
+}
+
 func createLongEmail() expenses.Email {
 	suffix := "@email.com"
 	builder := strings.Builder{}
@@ -102,3 +158,52 @@ func createLongEmail() expenses.Email {
 	builder.WriteString(suffix)
 	return expenses.Email(builder.String())
 }
+
+func TestClaimPlaceholder(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	repository := expenses.NewPgUserRepository()
+	placeholder, err := repository.CreatePlaceholder(ctx, pgdb, "Bob", "claim-code")
+	require.NoError(t, err)
+	found, err := repository.FindPlaceholderByClaimCode(ctx, pgdb, "claim-code")
+	require.NoError(t, err)
+	assert.Equal(t, placeholder, found)
+
+	claimed, err := repository.ClaimPlaceholder(ctx, pgdb, expenses.CreateUserRequest{
+		Email:     "bob@mail.com",
+		Password:  "password",
+		ClaimCode: "claim-code",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, placeholder.ID, claimed.ID)
+	assert.Equal(t, "Bob", claimed.DisplayName)
+	foundByEmail, err := repository.FindByEmail(ctx, pgdb, "bob@mail.com")
+	require.NoError(t, err)
+	assert.Equal(t, claimed, foundByEmail)
+	assert.False(t, foundByEmail.IsPlaceholder())
+
+	// can't claim twice
+	_, err = repository.ClaimPlaceholder(ctx, pgdb, expenses.CreateUserRequest{
+		Email:     "another@mail.com",
+		Password:  "password",
+		ClaimCode: "claim-code",
+	})
+	assert.EqualError(t, err, expenses.ErrPlaceholderNotFound.Error())
+}
+
+func TestClaimPlaceholderWithExistingEmail(t *testing.T) {
+	ctx := context.Background()
+	cleanUpDB(t, ctx)
+
+	repository := expenses.NewPgUserRepository()
+	user := expenses.CreateUserRequest{Email: "bob@mail.com", Password: "password"}
+	_, err := repository.Create(ctx, pgdb, user)
+	require.NoError(t, err)
+	_, err = repository.CreatePlaceholder(ctx, pgdb, "Bob", "claim-code")
+	require.NoError(t, err)
+
+	user.ClaimCode = "claim-code"
+	_, err = repository.ClaimPlaceholder(ctx, pgdb, user)
+	assert.EqualError(t, err, expenses.ErrEmailAlreadyExists.Error())
+}
@@ -18,6 +18,15 @@ func TestCreateUserRequestUnmarshalJSON(t *testing.T) {
 	assert.Equal(t, expenses.Password("anewpassword"), req.Password)
 }
 
+func TestCreateUserRequestUnmarshalJSONWithClaimCode(t *testing.T) {
+	createJSON := `{"email": "some@mail.ru", "password":"anewpassword", "claimCode": "code"}`
+	var req expenses.CreateUserRequest
+	err := json.Unmarshal([]byte(createJSON), &req)
+	require.NoError(t, err)
+	assert.Equal(t, expenses.Email("some@mail.ru"), req.Email)
+	assert.Equal(t, "code", req.ClaimCode)
+}
+
 func TestCreateUserRequestUnmarshalJSONNull(t *testing.T) {
 	createJSON := `null`
 	var req expenses.CreateUserRequest
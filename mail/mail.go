@@ -0,0 +1,97 @@
+// Package mail sends emails to users through a pluggable Mailer
+package mail
+
+import (
+	"fmt"
+	"go-spend/log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users
+type Mailer interface {
+	Send(message Message) error
+}
+
+// SMTPMailer sends messages through an SMTP server. PLAIN authentication is used if username is not empty.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates new instance of SMTPMailer. addr is in format host:port
+func NewSMTPMailer(addr string, from string, username string, password string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		host := strings.Split(addr, ":")[0]
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: addr, from: from, auth: auth}
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{message.To}, format(m.from, message))
+}
+
+// FileMailer appends messages to a file, so that emails can be read locally without a mail server
+type FileMailer struct {
+	mutex sync.Mutex
+	path  string
+	from  string
+}
+
+// NewFileMailer creates new instance of FileMailer
+func NewFileMailer(path string, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+func (m *FileMailer) Send(message Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(format(m.from, message), '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LogMailer only logs messages. Should be used only for development as messages often contain secrets
+type LogMailer struct {
+}
+
+// NewLogMailer creates new instance of LogMailer
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (*LogMailer) Send(message Message) error {
+	log.Info("mail to %s with subject %q:\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// format message according to RFC 5322
+func format(from string, message Message) []byte {
+	return []byte(fmt.Sprintf(
+		"From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from,
+		message.To,
+		message.Subject,
+		time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(message.Body, "\n", "\r\n"),
+	))
+}
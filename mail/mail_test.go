@@ -0,0 +1,142 @@
+package mail_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-spend/mail"
+	"io/ioutil"
+	inner "log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMessage = mail.Message{To: "user@mail.com", Subject: "Hello", Body: "first line\nsecond line"}
+
+func TestFileMailer(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "mails.txt")
+	mailer := mail.NewFileMailer(path, "go-spend@mail.com")
+
+	// when
+	require.NoError(t, mailer.Send(testMessage))
+	require.NoError(t, mailer.Send(mail.Message{To: "other@mail.com", Subject: "Bye", Body: "body"}))
+
+	// then
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "From: go-spend@mail.com\r\nTo: user@mail.com\r\nSubject: Hello\r\n")
+	assert.Contains(t, string(content), "\r\n\r\nfirst line\r\nsecond line\r\n")
+	assert.Contains(t, string(content), "To: other@mail.com\r\nSubject: Bye\r\n")
+}
+
+func TestFileMailerFailsWithIncorrectPath(t *testing.T) {
+	mailer := mail.NewFileMailer(filepath.Join(t.TempDir(), "missing", "mails.txt"), "go-spend@mail.com")
+
+	assert.Error(t, mailer.Send(testMessage))
+}
+
+func TestLogMailer(t *testing.T) {
+	// given
+	var buffer bytes.Buffer
+	inner.SetOutput(&buffer)
+	defer inner.SetOutput(os.Stderr)
+
+	// when
+	err := mail.NewLogMailer().Send(testMessage)
+
+	// then
+	require.NoError(t, err)
+	assert.Contains(t, buffer.String(), `mail to user@mail.com with subject "Hello":`)
+	assert.Contains(t, buffer.String(), "first line\nsecond line")
+}
+
+func TestSMTPMailer(t *testing.T) {
+	// given
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	received := make(chan smtpSession, 1)
+	go serveSMTP(listener, received)
+	mailer := mail.NewSMTPMailer(listener.Addr().String(), "go-spend@mail.com", "", "")
+
+	// when
+	err = mailer.Send(testMessage)
+
+	// then
+	require.NoError(t, err)
+	session := <-received
+	assert.Equal(t, "<go-spend@mail.com>", session.from)
+	assert.Equal(t, "<user@mail.com>", session.to)
+	assert.Contains(t, session.data, "Subject: Hello\r\n")
+	assert.Contains(t, session.data, "first line\r\nsecond line")
+}
+
+func TestSMTPMailerFailsWithoutServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	assert.Error(t, mail.NewSMTPMailer(addr, "go-spend@mail.com", "", "").Send(testMessage))
+}
+
+type smtpSession struct {
+	from string
+	to   string
+	data string
+}
+
+// serveSMTP handles a single session with the minimal set of commands used by net/smtp
+func serveSMTP(listener net.Listener, received chan<- smtpSession) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	var session smtpSession
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			session.from = strings.TrimPrefix(command, "MAIL FROM:")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			session.to = strings.TrimPrefix(command, "RCPT TO:")
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			session.data = data.String()
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			received <- session
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
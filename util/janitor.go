@@ -0,0 +1,28 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// StartJanitor calls clean every interval in a separate goroutine until the returned function is called, e.g. to remove
+// expired entries of in-memory storages. Stop can be called more than once.
+func StartJanitor(interval time.Duration, clean func()) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				clean()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
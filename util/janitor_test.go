@@ -0,0 +1,28 @@
+package util_test
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go-spend/util"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartJanitor(t *testing.T) {
+	// given
+	var cleaned int32
+	stop := util.StartJanitor(10*time.Millisecond, func() {
+		atomic.AddInt32(&cleaned, 1)
+	})
+
+	// when
+	time.Sleep(55 * time.Millisecond)
+	stop()
+	stop()
+	cleanedBeforeStop := atomic.LoadInt32(&cleaned)
+	time.Sleep(30 * time.Millisecond)
+
+	// then
+	assert.True(t, cleanedBeforeStop >= 3, "cleaned %d times", cleanedBeforeStop)
+	assert.True(t, atomic.LoadInt32(&cleaned)-cleanedBeforeStop <= 1, "janitor stops after stop is called")
+}